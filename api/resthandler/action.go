@@ -17,13 +17,17 @@ package resthandler
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/pkg/action"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -39,15 +43,25 @@ type ActionRestHandler interface {
 	RunAction(c *gin.Context)
 }
 
+type RunActionRequest struct {
+	Version int                    `json:"version"`
+	Params  map[string]interface{} `json:"params"`
+}
+
 type ActionRestHandlerImpl struct {
 	logger        *zap.SugaredLogger
 	actionService action.ActionService
+	appService    app.AppService
+	auditService  audit.AuditService
 }
 
-func NewActionRestHandlerImpl(logger *zap.SugaredLogger, actionService action.ActionService) *ActionRestHandlerImpl {
+func NewActionRestHandlerImpl(logger *zap.SugaredLogger, actionService action.ActionService, appService app.AppService,
+	auditService audit.AuditService) *ActionRestHandlerImpl {
 	return &ActionRestHandlerImpl{
 		logger:        logger,
 		actionService: actionService,
+		appService:    appService,
+		auditService:  auditService,
 	}
 }
 
@@ -71,6 +85,14 @@ func (impl ActionRestHandlerImpl) CreateAction(c *gin.Context) {
 		})
 		return
 	}
+	editable, err := impl.appService.IsAppEditableByUser(app, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the actions can only be changed by the editors of the app",
+		})
+		return
+	}
 	var act action.ActionDto
 	if err := json.NewDecoder(c.Request.Body).Decode(&act); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -101,6 +123,14 @@ func (impl ActionRestHandlerImpl) CreateAction(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_CREATE,
+		TargetType: audit.TARGET_ACTION,
+		TargetID:   res.ID,
+		TargetName: res.DisplayName,
+		AppID:      app,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
@@ -125,6 +155,14 @@ func (impl ActionRestHandlerImpl) UpdateAction(c *gin.Context) {
 		})
 		return
 	}
+	editable, err := impl.appService.IsAppEditableByUser(app, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the actions can only be changed by the editors of the app",
+		})
+		return
+	}
 	var act action.ActionDto
 	if err := json.NewDecoder(c.Request.Body).Decode(&act); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -141,6 +179,21 @@ func (impl ActionRestHandlerImpl) UpdateAction(c *gin.Context) {
 		return
 	}
 
+	before, err := impl.actionService.GetAction(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "update action error: " + err.Error(),
+		})
+		return
+	}
+	if before.App != app {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "update action error: the action is not found in the app",
+		})
+		return
+	}
 	act.ID = id
 	act.UpdatedBy = user
 	act.App = app
@@ -155,15 +208,57 @@ func (impl ActionRestHandlerImpl) UpdateAction(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_UPDATE,
+		TargetType: audit.TARGET_ACTION,
+		TargetID:   id,
+		TargetName: res.DisplayName,
+		AppID:      app,
+		Before:     before,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
 func (impl ActionRestHandlerImpl) DeleteAction(c *gin.Context) {
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	app, errA := strconv.Atoi(c.Param("app"))
 	id, err := strconv.Atoi(c.Param("action"))
-	if err != nil {
+	if errA != nil || err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
-			"errorMessage": "parse url error" + err.Error(),
+			"errorMessage": "parse url error",
+		})
+		return
+	}
+	editable, err := impl.appService.IsAppEditableByUser(app, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the actions can only be changed by the editors of the app",
+		})
+		return
+	}
+	before, err := impl.actionService.GetAction(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "delete action error: " + err.Error(),
+		})
+		return
+	}
+	if before.App != app {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "delete action error: the action is not found in the app",
 		})
 		return
 	}
@@ -174,6 +269,14 @@ func (impl ActionRestHandlerImpl) DeleteAction(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DELETE,
+		TargetType: audit.TARGET_ACTION,
+		TargetID:   id,
+		TargetName: before.DisplayName,
+		AppID:      app,
+		Before:     before,
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"actionId": id,
 	})
@@ -219,8 +322,34 @@ func (impl ActionRestHandlerImpl) FindActions(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// PreviewAction runs the template sent by the client, so only the users who can edit the app may preview.
 func (impl ActionRestHandlerImpl) PreviewAction(c *gin.Context) {
 	c.Header("Timing-Allow-Origin", "*")
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	appID, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url error",
+		})
+		return
+	}
+	editable, err := impl.appService.IsAppEditableByUser(appID, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "preview action is only allowed for the editors of the app",
+		})
+		return
+	}
 	var act action.ActionDto
 	if err := json.NewDecoder(c.Request.Body).Decode(&act); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -229,6 +358,14 @@ func (impl ActionRestHandlerImpl) PreviewAction(c *gin.Context) {
 		})
 		return
 	}
+	act.Environment = c.Query("environment")
+	if act.Environment != "" && !repository.IsValidChannel(act.Environment) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: unknown environment " + act.Environment,
+		})
+		return
+	}
 	res, err := impl.actionService.RunAction(act)
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "Error 1064:") {
@@ -258,17 +395,80 @@ func (impl ActionRestHandlerImpl) PreviewAction(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// RunAction runs the stored action of the app version, the client only supplies the declared parameters.
 func (impl ActionRestHandlerImpl) RunAction(c *gin.Context) {
 	c.Header("Timing-Allow-Origin", "*")
-	var act action.ActionDto
-	if err := json.NewDecoder(c.Request.Body).Decode(&act); err != nil {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	appID, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url error",
+		})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("action"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url error",
+		})
+		return
+	}
+	var payload RunActionRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil && err != io.EOF {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
 			"errorMessage": "parse request body error" + err.Error(),
 		})
 		return
 	}
-	res, err := impl.actionService.RunAction(act)
+	if !requireAppRole(c, impl.appService, appID, user, app.ROLE_VIEWER) {
+		return
+	}
+	// the edit version is not released yet, the viewers run the released versions only
+	if payload.Version == repository.APP_EDIT_VERSION {
+		editable, err := impl.appService.IsAppEditableByUser(appID, user)
+		if err != nil || !editable {
+			c.JSON(http.StatusForbidden, gin.H{
+				"errorCode":    403,
+				"errorMessage": "the edit version can only be run by the editors of the app",
+			})
+			return
+		}
+	}
+	environment := c.Query("environment")
+	if environment != "" && !repository.IsValidChannel(environment) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: unknown environment " + environment,
+		})
+		return
+	}
+	res, err := impl.actionService.RunStoredAction(id, appID, payload.Version, payload.Params, environment)
+	if errors.Is(err, action.ErrActionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "run action error: " + err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, action.ErrInvalidParameters) || errors.Is(err, action.ErrActionNotRunnable) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "run action error: " + err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "Error 1064:") {
 			lineNumber, _ := strconv.Atoi(err.Error()[len(err.Error())-1:])
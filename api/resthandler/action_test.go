@@ -0,0 +1,179 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resthandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/illa-family/builder-backend/pkg/action"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// editors are the users who can edit the app by app id, the viewers can only view it.
+type editors struct {
+	app.AppService
+	users   map[int][]int
+	viewers map[int][]int
+}
+
+func (e editors) IsAppEditableByUser(appID, userID int) (bool, error) {
+	for _, user := range e.users[appID] {
+		if user == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e editors) IsAppViewableByUser(appID, userID int) (bool, error) {
+	for _, user := range e.viewers[appID] {
+		if user == userID {
+			return true, nil
+		}
+	}
+	return e.IsAppEditableByUser(appID, userID)
+}
+
+// memoryActions counts the changed actions, the action 2 belongs to the app 9.
+type memoryActions struct {
+	action.ActionService
+	changed int
+}
+
+func (m *memoryActions) ValidateActionOptions(actionType string, options map[string]interface{}) error {
+	return nil
+}
+
+func (m *memoryActions) GetAction(id int) (action.ActionDto, error) {
+	if id == 2 {
+		return action.ActionDto{ID: id, App: 9}, nil
+	}
+	return action.ActionDto{ID: id, App: 1}, nil
+}
+
+func (m *memoryActions) CreateAction(act action.ActionDto) (action.ActionDto, error) {
+	m.changed++
+	return act, nil
+}
+
+func (m *memoryActions) UpdateAction(act action.ActionDto) (action.ActionDto, error) {
+	m.changed++
+	return act, nil
+}
+
+func (m *memoryActions) DeleteAction(id int) error {
+	m.changed++
+	return nil
+}
+
+func (m *memoryActions) RunAction(act action.ActionDto) (interface{}, error) {
+	m.changed++
+	return map[string]interface{}{}, nil
+}
+
+func (m *memoryActions) RunStoredAction(id, app, version int, values map[string]interface{}, environment string) (interface{}, error) {
+	return map[string]interface{}{"version": version}, nil
+}
+
+type discardedAuditLogs struct {
+	audit.AuditService
+}
+
+func (discardedAuditLogs) Record(entry audit.Entry) error {
+	return nil
+}
+
+func TestActionsAreChangedByEditorsOnly(t *testing.T) {
+	actions := &memoryActions{}
+	handler := NewActionRestHandlerImpl(zap.NewNop().Sugar(), actions, editors{users: map[int][]int{1: {10}, 9: {10}}},
+		discardedAuditLogs{})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	group := engine.Group("/api/v1/apps/:app", func(c *gin.Context) {
+		if c.GetHeader("X-User") == "editor" {
+			c.Set("userID", 10)
+		} else {
+			c.Set("userID", 20)
+		}
+	})
+	group.POST("/actions", handler.CreateAction)
+	group.PUT("/actions/:action", handler.UpdateAction)
+	group.DELETE("/actions/:action", handler.DeleteAction)
+	group.POST("/actions/preview", handler.PreviewAction)
+	call := func(user, method, path string) int {
+		body := `{"displayName": "query1", "actionType": "transformer", "content": {}, "transformer": {}, "triggerMode": "manually"}`
+		req := httptest.NewRequest(method, "/api/v1/apps/1"+path, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for _, route := range [][2]string{
+		{http.MethodPost, "/actions"},
+		{http.MethodPut, "/actions/1"},
+		{http.MethodDelete, "/actions/1"},
+		{http.MethodPost, "/actions/preview"},
+	} {
+		assert.Equal(t, http.StatusForbidden, call("viewer", route[0], route[1]), "%s %s", route[0], route[1])
+	}
+	assert.Equal(t, 0, actions.changed)
+
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodPost, "/actions"))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodPut, "/actions/1"))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodDelete, "/actions/1"))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodPost, "/actions/preview"))
+	assert.Equal(t, 4, actions.changed)
+
+	// the action of another app is not reachable through this app
+	assert.Equal(t, http.StatusNotFound, call("editor", http.MethodPut, "/actions/2"))
+	assert.Equal(t, http.StatusNotFound, call("editor", http.MethodDelete, "/actions/2"))
+	assert.Equal(t, 4, actions.changed)
+}
+
+func TestActionsAreRunByViewersOnReleasedVersions(t *testing.T) {
+	handler := NewActionRestHandlerImpl(zap.NewNop().Sugar(), &memoryActions{},
+		editors{users: map[int][]int{1: {10}}, viewers: map[int][]int{1: {30}}}, discardedAuditLogs{})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	group := engine.Group("/api/v1/apps/:app", func(c *gin.Context) {
+		c.Set("userID", map[string]int{"editor": 10, "viewer": 30}[c.GetHeader("X-User")])
+	})
+	group.POST("/actions/:action/run", handler.RunAction)
+	call := func(user string, version int) int {
+		body := `{"version": ` + strconv.Itoa(version) + `}`
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/apps/1/actions/1/run", strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, call("other", 1))
+	assert.Equal(t, http.StatusForbidden, call("other", 0))
+	assert.Equal(t, http.StatusOK, call("viewer", 1))
+	assert.Equal(t, http.StatusForbidden, call("viewer", 0))
+	assert.Equal(t, http.StatusOK, call("editor", 1))
+	assert.Equal(t, http.StatusOK, call("editor", 0))
+}
@@ -16,12 +16,18 @@ package resthandler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/bundle"
 	"go.uber.org/zap"
 )
 
@@ -29,6 +35,16 @@ type AppRequest struct {
 	Name string `json:"appName" validate:"required"`
 }
 
+type ReleaseRequest struct {
+	Notes   string `json:"notes" validate:"max=2000"`
+	Channel string `json:"channel" validate:"omitempty,oneof=dev staging production"`
+}
+
+type PromoteRequest struct {
+	From string `json:"from" validate:"required,oneof=dev staging production"`
+	To   string `json:"to" validate:"required,oneof=dev staging production"`
+}
+
 type AppRestHandler interface {
 	CreateApp(c *gin.Context)
 	DeleteApp(c *gin.Context)
@@ -37,17 +53,36 @@ type AppRestHandler interface {
 	GetMegaData(c *gin.Context)
 	DuplicateApp(c *gin.Context)
 	ReleaseApp(c *gin.Context)
+	GetAppVersions(c *gin.Context)
+	DiffAppVersions(c *gin.Context)
+	RestoreAppVersion(c *gin.Context)
+	SetReleaseVersion(c *gin.Context)
+	GetAppChannels(c *gin.Context)
+	GetChannelMegaData(c *gin.Context)
+	PromoteChannel(c *gin.Context)
+	ExportApp(c *gin.Context)
+	ImportApp(c *gin.Context)
+	GetPublishSetting(c *gin.Context)
+	SetPublishSetting(c *gin.Context)
+	GetAppMembers(c *gin.Context)
+	SetAppMember(c *gin.Context)
+	RemoveAppMember(c *gin.Context)
 }
 
 type AppRestHandlerImpl struct {
-	logger     *zap.SugaredLogger
-	appService app.AppService
+	logger        *zap.SugaredLogger
+	appService    app.AppService
+	bundleService bundle.BundleService
+	auditService  audit.AuditService
 }
 
-func NewAppRestHandlerImpl(logger *zap.SugaredLogger, appService app.AppService) *AppRestHandlerImpl {
+func NewAppRestHandlerImpl(logger *zap.SugaredLogger, appService app.AppService, bundleService bundle.BundleService,
+	auditService audit.AuditService) *AppRestHandlerImpl {
 	return &AppRestHandlerImpl{
-		logger:     logger,
-		appService: appService,
+		logger:        logger,
+		appService:    appService,
+		bundleService: bundleService,
+		auditService:  auditService,
 	}
 }
 
@@ -94,10 +129,28 @@ func (impl AppRestHandlerImpl) CreateApp(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_CREATE,
+		TargetType: audit.TARGET_APP,
+		TargetID:   res.ID,
+		TargetName: res.Name,
+		AppID:      res.ID,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
 func (impl AppRestHandlerImpl) DeleteApp(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
 	// Parse URL param to `app ID`
 	id, err := strconv.Atoi(c.Param("app"))
 	if err != nil {
@@ -107,6 +160,18 @@ func (impl AppRestHandlerImpl) DeleteApp(c *gin.Context) {
 		})
 		return
 	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	// Keep the deleted app for the audit log
+	before, err := impl.appService.FetchAppByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "delete app error: " + err.Error(),
+		})
+		return
+	}
 	// Call `app service` delete app
 	if err := impl.appService.DeleteApp(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -115,6 +180,14 @@ func (impl AppRestHandlerImpl) DeleteApp(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DELETE,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: before.Name,
+		AppID:      id,
+		Before:     before,
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"appId": id,
 	})
@@ -161,6 +234,9 @@ func (impl AppRestHandlerImpl) RenameApp(c *gin.Context) {
 		})
 		return
 	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
 	// Call `app service` update app
 	appDTO, err := impl.appService.FetchAppByID(id)
 	if err != nil {
@@ -170,6 +246,7 @@ func (impl AppRestHandlerImpl) RenameApp(c *gin.Context) {
 		})
 		return
 	}
+	before := appDTO
 	appDTO.Name = payload.Name
 	appDTO.UpdatedBy = user
 	res, err := impl.appService.UpdateApp(appDTO)
@@ -180,6 +257,15 @@ func (impl AppRestHandlerImpl) RenameApp(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_UPDATE,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: res.Name,
+		AppID:      id,
+		Before:     before,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
@@ -197,6 +283,16 @@ func (impl AppRestHandlerImpl) GetAllApps(c *gin.Context) {
 }
 
 func (impl AppRestHandlerImpl) GetMegaData(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
 	// Parse URL param to `app ID`
 	id, err := strconv.Atoi(c.Param("app"))
 	if err != nil {
@@ -206,6 +302,9 @@ func (impl AppRestHandlerImpl) GetMegaData(c *gin.Context) {
 		})
 		return
 	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_VIEWER) {
+		return
+	}
 	// Parse URL param to `version`
 	version, err := strconv.Atoi(c.Param("version"))
 	if err != nil {
@@ -247,6 +346,9 @@ func (impl AppRestHandlerImpl) DuplicateApp(c *gin.Context) {
 		})
 		return
 	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
 	// Parse request body
 	var payload AppRequest
 	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
@@ -276,10 +378,29 @@ func (impl AppRestHandlerImpl) DuplicateApp(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DUPLICATE,
+		TargetType: audit.TARGET_APP,
+		TargetID:   res.ID,
+		TargetName: res.Name,
+		AppID:      res.ID,
+		Before:     gin.H{"appId": id},
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
 func (impl AppRestHandlerImpl) ReleaseApp(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
 	// Parse URL param to `app ID`
 	id, err := strconv.Atoi(c.Param("app"))
 	if err != nil {
@@ -289,8 +410,35 @@ func (impl AppRestHandlerImpl) ReleaseApp(c *gin.Context) {
 		})
 		return
 	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	// Parse request body, the release notes and channel are optional
+	var payload ReleaseRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
 	// Call `app service` to release app
-	version, err := impl.appService.ReleaseApp(id)
+	version, err := impl.appService.ReleaseApp(id, user, payload.Notes, payload.Channel)
+	if errors.Is(err, app.ErrAppNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "release app error: " + err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errorCode":    500,
@@ -298,7 +446,749 @@ func (impl AppRestHandlerImpl) ReleaseApp(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DEPLOY,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		AppID:      id,
+		After:      gin.H{"releaseVersion": version, "notes": payload.Notes, "channel": payload.Channel},
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"version": version,
 	})
 }
+
+func (impl AppRestHandlerImpl) GetAppVersions(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_VIEWER) {
+		return
+	}
+	res, err := impl.appService.ListVersions(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get app versions error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// DiffAppVersions compares `version` with the `base` query version, which defaults to the previous version,
+// or to the live release for the edit version.
+func (impl AppRestHandlerImpl) DiffAppVersions(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID` and `version`
+	id, errA := strconv.Atoi(c.Param("app"))
+	version, errV := strconv.Atoi(c.Param("version"))
+	if errA != nil || errV != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error",
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_VIEWER) {
+		return
+	}
+	base := version - 1
+	if version == 0 {
+		// compare the edit version with the live release
+		appDTO, err := impl.appService.FetchAppByID(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errorCode":    500,
+				"errorMessage": "diff app versions error: " + err.Error(),
+			})
+			return
+		}
+		base = appDTO.ReleaseVersion
+	}
+	if rawBase := c.Query("base"); rawBase != "" {
+		var err error
+		if base, err = strconv.Atoi(rawBase); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errorCode":    400,
+				"errorMessage": "parse url query error: " + err.Error(),
+			})
+			return
+		}
+	}
+	res, err := impl.appService.DiffVersions(id, base, version)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "diff app versions error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// RestoreAppVersion rolls the edit version back to a released version.
+func (impl AppRestHandlerImpl) RestoreAppVersion(c *gin.Context) {
+	user, id, version, ok := parseAppVersionRequest(c)
+	if !ok {
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	res, err := impl.appService.RestoreEditVersion(id, version, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "restore app version error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_ROLLBACK,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: res.Name,
+		AppID:      id,
+		After:      gin.H{"restoredVersion": version},
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+// SetReleaseVersion moves the live release pointer to a released version.
+func (impl AppRestHandlerImpl) SetReleaseVersion(c *gin.Context) {
+	user, id, version, ok := parseAppVersionRequest(c)
+	if !ok {
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	before, err := impl.appService.FetchAppByID(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "release app version error: " + err.Error(),
+		})
+		return
+	}
+	res, err := impl.appService.SetReleaseVersion(id, version, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "release app version error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_ROLLBACK,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: res.Name,
+		AppID:      id,
+		Before:     gin.H{"releaseVersion": before.ReleaseVersion},
+		After:      gin.H{"releaseVersion": res.ReleaseVersion},
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+// requireAppRole answers 403 and returns false when the user does not have the role on the app, the
+// owner has every role and the editors can view the app.
+func requireAppRole(c *gin.Context, appService app.AppService, appID, userID int, role string) bool {
+	allowed, err := appService.IsAppEditableByUser(appID, userID)
+	if role == app.ROLE_VIEWER {
+		allowed, err = appService.IsAppViewableByUser(appID, userID)
+	}
+	if err != nil || !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the app can not be accessed by the user",
+		})
+		return false
+	}
+	return true
+}
+
+func parseAppVersionRequest(c *gin.Context) (int, int, int, bool) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return 0, 0, 0, false
+	}
+	// Parse URL param to `app ID` and `version`
+	id, errA := strconv.Atoi(c.Param("app"))
+	version, errV := strconv.Atoi(c.Param("version"))
+	if errA != nil || errV != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error",
+		})
+		return 0, 0, 0, false
+	}
+	return user, id, version, true
+}
+
+func (impl AppRestHandlerImpl) GetAppChannels(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_VIEWER) {
+		return
+	}
+	res, err := impl.appService.ListChannels(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get app channels error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GetChannelMegaData fetches the mega data of the version released to the channel.
+func (impl AppRestHandlerImpl) GetChannelMegaData(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_VIEWER) {
+		return
+	}
+	version, err := impl.appService.ChannelVersion(id, c.Param("channel"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "get app channel error: " + err.Error(),
+		})
+		return
+	}
+	res, err := impl.appService.GetMegaData(id, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get app mega data error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl AppRestHandlerImpl) PromoteChannel(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	// Parse request body
+	var payload PromoteRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	before, err := impl.appService.ListChannels(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "promote app channel error: " + err.Error(),
+		})
+		return
+	}
+	res, err := impl.appService.PromoteChannel(id, payload.From, payload.To, user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "promote app channel error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_PROMOTE,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: payload.To,
+		AppID:      id,
+		Before:     before,
+		After:      res,
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+// ExportApp downloads a version of the app as bundle, `format=zip` packs it as a zip archive.
+func (impl AppRestHandlerImpl) ExportApp(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	if !requireAppRole(c, impl.appService, id, user, app.ROLE_EDITOR) {
+		return
+	}
+	version, err := strconv.Atoi(c.DefaultQuery("version", strconv.Itoa(repository.APP_EDIT_VERSION)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: " + err.Error(),
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: unsupported format " + format,
+		})
+		return
+	}
+
+	res, err := impl.bundleService.Export(id, version)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, app.ErrAppNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "export app error: " + err.Error(),
+		})
+		return
+	}
+	filename := "app-" + strconv.Itoa(id) + "-bundle." + format
+	c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	if format == "json" {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	archive, err := bundle.EncodeZip(res)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "export app error: " + err.Error(),
+		})
+		return
+	}
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// ImportApp recreates an exported json or zip bundle as a new app. The optional `name` query renames the app,
+// and the optional `resources` query is a json object mapping the resource refs of the bundle to resource ids.
+func (impl AppRestHandlerImpl) ImportApp(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	resourceMap := map[string]int{}
+	if resources := c.Query("resources"); resources != "" {
+		if err := json.Unmarshal([]byte(resources), &resourceMap); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errorCode":    400,
+				"errorMessage": "parse url query error: " + err.Error(),
+			})
+			return
+		}
+	}
+	// Parse request body
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, bundle.MAX_BUNDLE_SIZE))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	payload, err := bundle.Decode(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	res, err := impl.bundleService.Import(payload, c.Query("name"), resourceMap, user)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, bundle.ErrInvalidBundle) || errors.Is(err, bundle.ErrUnsupportedSchema) ||
+			errors.Is(err, bundle.ErrResourceMismatch) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "import app error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_IMPORT,
+		TargetType: audit.TARGET_APP,
+		TargetID:   res.App.ID,
+		TargetName: res.App.Name,
+		AppID:      res.App.ID,
+		After:      res,
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl AppRestHandlerImpl) GetPublishSetting(c *gin.Context) {
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	res, err := impl.appService.GetPublishSetting(id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, app.ErrAppNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "get publish setting error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl AppRestHandlerImpl) SetPublishSetting(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	// Parse request body
+	var payload app.PublishDto
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	editable, err := impl.appService.IsAppEditableByUser(id, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the app can not be published by the user",
+		})
+		return
+	}
+
+	before, err := impl.appService.GetPublishSetting(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "set publish setting error: " + err.Error(),
+		})
+		return
+	}
+	payload.AppID = id
+	payload.UpdatedBy = user
+	res, err := impl.appService.SetPublishSetting(payload)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, app.ErrInvalidPublishSetting) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, app.ErrSlugTaken) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "set publish setting error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_PUBLISH,
+		TargetType: audit.TARGET_APP,
+		TargetID:   id,
+		TargetName: res.Slug,
+		AppID:      id,
+		Before:     before,
+		After:      res,
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+type MemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=editor viewer"`
+}
+
+func (impl AppRestHandlerImpl) GetAppMembers(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	editable, err := impl.appService.IsAppEditableByUser(id, user)
+	if err != nil || !editable {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the members of the app can not be listed by the user",
+		})
+		return
+	}
+	res, err := impl.appService.ListMembers(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get app members error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// SetAppMember grants a registered user a role on the app, only the owner of the app manages its members.
+func (impl AppRestHandlerImpl) SetAppMember(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	// Parse request body
+	var payload MemberRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	// Validate request body
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "validate request body error: " + err.Error(),
+		})
+		return
+	}
+	owned, err := impl.appService.IsAppOwnedByUser(id, user)
+	if err != nil || !owned {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the members of the app can only be managed by its owner",
+		})
+		return
+	}
+	res, err := impl.appService.SetMember(id, payload.Email, payload.Role, user)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, app.ErrMemberNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, app.ErrOwnerRoleFixed) || errors.Is(err, app.ErrInvalidRole) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "set app member error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl AppRestHandlerImpl) RemoveAppMember(c *gin.Context) {
+	// Get User from auth middleware
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+	// Parse URL param to `app ID` and `user ID`
+	id, err := strconv.Atoi(c.Param("app"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	owned, err := impl.appService.IsAppOwnedByUser(id, user)
+	if err != nil || !owned {
+		c.JSON(http.StatusForbidden, gin.H{
+			"errorCode":    403,
+			"errorMessage": "the members of the app can only be managed by its owner",
+		})
+		return
+	}
+	if err := impl.appService.RemoveMember(id, memberID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "remove app member error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"userId": memberID,
+	})
+}
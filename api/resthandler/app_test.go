@@ -0,0 +1,108 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resthandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/bundle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// changedApps counts the changes of the apps.
+type changedApps struct {
+	editors
+	changed int
+}
+
+func (a *changedApps) FetchAppByID(appID int) (app.AppDto, error) {
+	return app.AppDto{ID: appID, Name: "orders"}, nil
+}
+
+func (a *changedApps) DeleteApp(appID int) error {
+	a.changed++
+	return nil
+}
+
+func (a *changedApps) UpdateApp(appDto app.AppDto) (app.AppDto, error) {
+	a.changed++
+	return appDto, nil
+}
+
+func (a *changedApps) ReleaseApp(appID, userID int, notes, channel string) (int, error) {
+	a.changed++
+	return 1, nil
+}
+
+func (a *changedApps) GetMegaData(appID, version int) (app.Editor, error) {
+	return app.Editor{}, nil
+}
+
+// exportedBundles exports an empty bundle of each app.
+type exportedBundles struct {
+	bundle.BundleService
+}
+
+func (b exportedBundles) Export(appID, version int) (*bundle.Bundle, error) {
+	return &bundle.Bundle{SchemaVersion: bundle.SCHEMA_VERSION}, nil
+}
+
+func TestAppsAreChangedByEditorsOnly(t *testing.T) {
+	apps := &changedApps{editors: editors{users: map[int][]int{1: {10}}, viewers: map[int][]int{1: {30}}}}
+	handler := NewAppRestHandlerImpl(zap.NewNop().Sugar(), apps, exportedBundles{}, discardedAuditLogs{})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	group := engine.Group("/api/v1/apps", func(c *gin.Context) {
+		c.Set("userID", map[string]int{"editor": 10, "viewer": 30}[c.GetHeader("X-User")])
+	})
+	group.DELETE(":app", handler.DeleteApp)
+	group.PUT(":app", handler.RenameApp)
+	group.POST(":app/deploy", handler.ReleaseApp)
+	group.GET(":app/versions/:version", handler.GetMegaData)
+	group.GET(":app/export", handler.ExportApp)
+	call := func(user, method, path string) int {
+		req := httptest.NewRequest(method, "/api/v1/apps/1"+path, strings.NewReader(`{"appName": "orders"}`))
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	for _, user := range []string{"viewer", "other"} {
+		assert.Equal(t, http.StatusForbidden, call(user, http.MethodDelete, ""), user)
+		assert.Equal(t, http.StatusForbidden, call(user, http.MethodPut, ""), user)
+		assert.Equal(t, http.StatusForbidden, call(user, http.MethodPost, "/deploy"), user)
+		// the bundle holds the resources of the actions
+		assert.Equal(t, http.StatusForbidden, call(user, http.MethodGet, "/export"), user)
+	}
+	assert.Equal(t, 0, apps.changed)
+
+	// the viewers can read the app, the others can not
+	assert.Equal(t, http.StatusOK, call("viewer", http.MethodGet, "/versions/0"))
+	assert.Equal(t, http.StatusForbidden, call("other", http.MethodGet, "/versions/0"))
+
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodGet, "/export"))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodPut, ""))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodPost, "/deploy"))
+	assert.Equal(t, http.StatusOK, call("editor", http.MethodDelete, ""))
+	assert.Equal(t, 3, apps.changed)
+}
@@ -0,0 +1,149 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resthandler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/audit"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type AuditRestHandler interface {
+	FindAuditLogs(c *gin.Context)
+}
+
+type AuditRestHandlerImpl struct {
+	logger       *zap.SugaredLogger
+	auditService audit.AuditService
+}
+
+func NewAuditRestHandlerImpl(logger *zap.SugaredLogger, auditService audit.AuditService) *AuditRestHandlerImpl {
+	return &AuditRestHandlerImpl{
+		logger:       logger,
+		auditService: auditService,
+	}
+}
+
+// FindAuditLogs lists the audit logs matched by the query, `format=csv` or `format=json` downloads them as a file.
+func (impl AuditRestHandlerImpl) FindAuditLogs(c *gin.Context) {
+	filter, err := parseAuditLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: " + err.Error(),
+		})
+		return
+	}
+	format := c.Query("format")
+	if format != "" && format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url query error: unsupported format " + format,
+		})
+		return
+	}
+	if format == "" && filter.Limit > audit.MAX_QUERY_LIMIT {
+		filter.Limit = audit.MAX_QUERY_LIMIT
+	}
+	if format != "" && c.Query("limit") == "" {
+		filter.Limit = audit.MAX_EXPORT_LIMIT
+	}
+
+	auditLogs, total, err := impl.auditService.Query(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get audit logs error: " + err.Error(),
+		})
+		return
+	}
+
+	filename := "illa-audit-" + time.Now().UTC().Format("20060102150405")
+	switch format {
+	case "csv":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := audit.WriteCSV(c.Writer, auditLogs); err != nil {
+			impl.logger.Errorw("write audit logs csv error", "err", err)
+		}
+	case "json":
+		c.Header("Content-Disposition", "attachment; filename="+filename+".json")
+		c.JSON(http.StatusOK, auditLogs)
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"auditLogs": auditLogs,
+			"total":     total,
+		})
+	}
+}
+
+func parseAuditLogFilter(c *gin.Context) (repository.AuditLogFilter, error) {
+	filter := repository.AuditLogFilter{
+		Source:     c.Query("source"),
+		Operation:  c.Query("operation"),
+		TargetType: c.Query("targetType"),
+	}
+	ints := map[string]*int{
+		"actorId":  &filter.ActorID,
+		"targetId": &filter.TargetID,
+		"appId":    &filter.AppID,
+		"limit":    &filter.Limit,
+		"offset":   &filter.Offset,
+	}
+	for key, field := range ints {
+		raw := c.Query(key)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			return filter, &strconv.NumError{Func: "Atoi", Num: key + "=" + raw, Err: strconv.ErrSyntax}
+		}
+		*field = value
+	}
+	times := map[string]**time.Time{
+		"from": &filter.From,
+		"to":   &filter.To,
+	}
+	for key, field := range times {
+		raw := c.Query(key)
+		if raw == "" {
+			continue
+		}
+		value, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, err
+		}
+		*field = &value
+	}
+	return filter, nil
+}
+
+// recordAudit appends a REST change to the audit log, a failed record never fails the request.
+func recordAudit(c *gin.Context, logger *zap.SugaredLogger, auditService audit.AuditService, entry audit.Entry) {
+	entry.ActorID = c.GetInt("userID")
+	entry.ActorIP = c.ClientIP()
+	entry.Source = audit.SOURCE_REST
+	if err := auditService.Record(entry); err != nil {
+		logger.Errorw("record audit log error", "err", err, "operation", entry.Operation, "target", entry.TargetType, "targetId", entry.TargetID)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resthandler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/action"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+type PublicSignInRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// PublicRestHandler serves the released version of published apps without sign in.
+type PublicRestHandler interface {
+	GetPublicApp(c *gin.Context)
+	SignInPublicApp(c *gin.Context)
+	RunPublicAction(c *gin.Context)
+}
+
+type PublicRestHandlerImpl struct {
+	logger           *zap.SugaredLogger
+	appService       app.AppService
+	actionService    action.ActionService
+	passwordAttempts *ratelimit.AttemptCounter
+}
+
+func NewPublicRestHandlerImpl(logger *zap.SugaredLogger, appService app.AppService, actionService action.ActionService,
+	store ratelimit.Store) *PublicRestHandlerImpl {
+	return &PublicRestHandlerImpl{
+		logger:           logger,
+		appService:       appService,
+		actionService:    actionService,
+		passwordAttempts: ratelimit.NewAttemptCounter(store, "public-app", SIGNIN_MAX_FAILURES, SIGNIN_LOCKOUT_WINDOW),
+	}
+}
+
+func (impl PublicRestHandlerImpl) GetPublicApp(c *gin.Context) {
+	publicApp, ok := impl.authorize(c)
+	if !ok {
+		return
+	}
+	res, err := impl.appService.GetPublicMegaData(publicApp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get public app error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl PublicRestHandlerImpl) SignInPublicApp(c *gin.Context) {
+	setEmbedHeaders(c, nil)
+	var payload PublicSignInRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	slug := c.Param("slug")
+	// lock out the visitor of this app only, other visitors can still sign in
+	lockoutKey := slug + ":" + c.ClientIP()
+	blocked, retryAfter, err := impl.passwordAttempts.Blocked(lockoutKey)
+	if err != nil {
+		impl.logger.Errorw("check attempts error", "err", err)
+	} else if blocked {
+		ratelimit.AbortWithTooManyRequests(c, retryAfter)
+		return
+	}
+	token, err := impl.appService.SignInPublicApp(slug, payload.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, app.ErrPublicAppNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"errorCode":    404,
+				"errorMessage": "app not found",
+			})
+		case errors.Is(err, app.ErrPublicPasswordRequired):
+			if err := impl.passwordAttempts.Fail(lockoutKey); err != nil {
+				impl.logger.Errorw("record failed attempt error", "err", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"errorCode":    401,
+				"errorMessage": "invalid password",
+			})
+		case errors.Is(err, app.ErrInvalidPublishSetting):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errorCode":    400,
+				"errorMessage": "sign in public app error: " + err.Error(),
+			})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errorCode":    500,
+				"errorMessage": "sign in public app error: " + err.Error(),
+			})
+		}
+		return
+	}
+	_ = impl.passwordAttempts.Reset(lockoutKey)
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"expiresIn": int(app.PUBLIC_TOKEN_TTL.Seconds()),
+	})
+}
+
+// RunPublicAction runs a stored action of the released version with the production resource options,
+// the client only supplies the declared parameters.
+func (impl PublicRestHandlerImpl) RunPublicAction(c *gin.Context) {
+	c.Header("Timing-Allow-Origin", "*")
+	publicApp, ok := impl.authorize(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("action"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+	var payload RunActionRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	res, err := impl.actionService.RunStoredAction(id, publicApp.AppID, publicApp.Version, payload.Params,
+		repository.CHANNEL_PRODUCTION)
+	if errors.Is(err, action.ErrActionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "action not found",
+		})
+		return
+	}
+	if errors.Is(err, action.ErrInvalidParameters) || errors.Is(err, action.ErrActionNotRunnable) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "run action error: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		// the error may contain the resource options, keep it in the server log
+		impl.logger.Errorw("run public action error", "app", publicApp.AppID, "action", id, "err", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "run action error",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// authorize resolves the slug of the request and writes the error response when the visitor can not see the app.
+func (impl PublicRestHandlerImpl) authorize(c *gin.Context) (app.PublicApp, bool) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	publicApp, err := impl.appService.AuthorizePublicApp(c.Param("slug"), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, app.ErrPublicAppNotFound):
+			setEmbedHeaders(c, nil)
+			c.JSON(http.StatusNotFound, gin.H{
+				"errorCode":    404,
+				"errorMessage": "app not found",
+			})
+		case errors.Is(err, app.ErrPublicPasswordRequired):
+			setEmbedHeaders(c, &publicApp)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"errorCode":    401,
+				"errorMessage": "password required",
+			})
+		default:
+			setEmbedHeaders(c, nil)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errorCode":    500,
+				"errorMessage": "get public app error: " + err.Error(),
+			})
+		}
+		return app.PublicApp{}, false
+	}
+	setEmbedHeaders(c, &publicApp)
+	return publicApp, true
+}
+
+// setEmbedHeaders allows the configured origins to frame the app, and no origin when embedding is off.
+func setEmbedHeaders(c *gin.Context, publicApp *app.PublicApp) {
+	if publicApp == nil || !publicApp.AllowEmbed {
+		c.Header("X-Frame-Options", "DENY")
+		c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	} else if len(publicApp.EmbedOrigins) == 0 {
+		c.Header("Content-Security-Policy", "frame-ancestors *")
+	} else {
+		c.Header("Content-Security-Policy", "frame-ancestors "+strings.Join(publicApp.EmbedOrigins, " "))
+	}
+	c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+	if publicApp != nil && publicApp.PasswordProtected {
+		c.Header("Cache-Control", "private, no-store")
+	}
+}
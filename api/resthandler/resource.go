@@ -16,11 +16,13 @@ package resthandler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/illa-family/builder-backend/pkg/audit"
 	"github.com/illa-family/builder-backend/pkg/resource"
 
 	"github.com/gin-gonic/gin"
@@ -34,17 +36,22 @@ type ResourceRestHandler interface {
 	UpdateResource(c *gin.Context)
 	DeleteResource(c *gin.Context)
 	TestConnection(c *gin.Context)
+	GetResourceEnvironments(c *gin.Context)
+	SetResourceEnvironment(c *gin.Context)
+	DeleteResourceEnvironment(c *gin.Context)
 }
 
 type ResourceRestHandlerImpl struct {
 	logger          *zap.SugaredLogger
 	resourceService resource.ResourceService
+	auditService    audit.AuditService
 }
 
-func NewResourceRestHandlerImpl(logger *zap.SugaredLogger, resourceService resource.ResourceService) *ResourceRestHandlerImpl {
+func NewResourceRestHandlerImpl(logger *zap.SugaredLogger, resourceService resource.ResourceService, auditService audit.AuditService) *ResourceRestHandlerImpl {
 	return &ResourceRestHandlerImpl{
 		logger:          logger,
 		resourceService: resourceService,
+		auditService:    auditService,
 	}
 }
 
@@ -110,6 +117,13 @@ func (impl ResourceRestHandlerImpl) CreateResource(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_CREATE,
+		TargetType: audit.TARGET_RESOURCE,
+		TargetID:   res.ID,
+		TargetName: res.Name,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
@@ -181,6 +195,14 @@ func (impl ResourceRestHandlerImpl) UpdateResource(c *gin.Context) {
 		return
 	}
 
+	before, err := impl.resourceService.GetResource(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "update resource error: " + err.Error(),
+		})
+		return
+	}
 	rsc.ID = id
 	rsc.UpdatedBy = user
 	rsc.UpdatedAt = time.Now().UTC()
@@ -192,6 +214,14 @@ func (impl ResourceRestHandlerImpl) UpdateResource(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_UPDATE,
+		TargetType: audit.TARGET_RESOURCE,
+		TargetID:   id,
+		TargetName: res.Name,
+		Before:     before,
+		After:      res,
+	})
 	c.JSON(http.StatusOK, res)
 }
 
@@ -205,6 +235,14 @@ func (impl ResourceRestHandlerImpl) DeleteResource(c *gin.Context) {
 		return
 	}
 
+	before, err := impl.resourceService.GetResource(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "delete resource error: " + err.Error(),
+		})
+		return
+	}
 	if err := impl.resourceService.DeleteResource(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errorCode":    500,
@@ -212,6 +250,13 @@ func (impl ResourceRestHandlerImpl) DeleteResource(c *gin.Context) {
 		})
 		return
 	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DELETE,
+		TargetType: audit.TARGET_RESOURCE,
+		TargetID:   id,
+		TargetName: before.Name,
+		Before:     before,
+	})
 	c.JSON(http.StatusOK, gin.H{
 		"resourceId": id,
 	})
@@ -251,3 +296,127 @@ func (impl ResourceRestHandlerImpl) TestConnection(c *gin.Context) {
 		"message": "test connection successfully",
 	})
 }
+
+func (impl ResourceRestHandlerImpl) GetResourceEnvironments(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("resource"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+
+	res, err := impl.resourceService.GetResourceEnvironments(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get resource environments error: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl ResourceRestHandlerImpl) SetResourceEnvironment(c *gin.Context) {
+	// get user as modifier
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("resource"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+
+	var env resource.ResourceEnvironmentDto
+	if err := json.NewDecoder(c.Request.Body).Decode(&env); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(env); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	before, err := impl.resourceService.GetResourceEnvironments(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "set resource environment error: " + err.Error(),
+		})
+		return
+	}
+	env.ResourceID = id
+	env.Environment = c.Param("environment")
+	env.UpdatedBy = user
+	res, err := impl.resourceService.SetResourceEnvironment(env)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "set resource environment error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_UPDATE,
+		TargetType: audit.TARGET_RESOURCE,
+		TargetID:   id,
+		TargetName: res.Environment,
+		Before:     before,
+		After:      res,
+	})
+	c.JSON(http.StatusOK, res)
+}
+
+func (impl ResourceRestHandlerImpl) DeleteResourceEnvironment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("resource"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse url param error: " + err.Error(),
+		})
+		return
+	}
+
+	environment := c.Param("environment")
+	if err := impl.resourceService.DeleteResourceEnvironment(id, environment); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, resource.ErrEnvironmentNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"errorCode":    status,
+			"errorMessage": "delete resource environment error: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, impl.logger, impl.auditService, audit.Entry{
+		Operation:  audit.OPERATION_DELETE,
+		TargetType: audit.TARGET_RESOURCE,
+		TargetID:   id,
+		TargetName: environment,
+		Before:     gin.H{"environment": environment},
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"resourceId":  id,
+		"environment": environment,
+	})
+}
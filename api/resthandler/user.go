@@ -18,8 +18,12 @@ package resthandler
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/illa-family/builder-backend/pkg/oidc"
+	"github.com/illa-family/builder-backend/pkg/ratelimit"
 	"github.com/illa-family/builder-backend/pkg/user"
 
 	"github.com/gin-gonic/gin"
@@ -29,8 +33,9 @@ import (
 )
 
 type VerificationRequest struct {
-	Email string `json:"email" validate:"required"`
-	Usage string `json:"usage" validate:"oneof=signup forgetpwd"`
+	Email    string `json:"email" validate:"required"`
+	Usage    string `json:"usage" validate:"oneof=signup forgetpwd"`
+	Language string `json:"language" validate:"omitempty,oneof=zh-CN en-US"`
 }
 
 type Username struct {
@@ -61,6 +66,35 @@ type SignInRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+type SignInWithTOTPRequest struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type SignOutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type OIDCSignInRequest struct {
+	Code       string `json:"code" validate:"required"`
+	State      string `json:"state" validate:"required"`
+	StateToken string `json:"stateToken" validate:"required"`
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name" validate:"required,max=64"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=read write deploy audit"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
 type ForgetPasswordRequest struct {
 	Email             string `json:"email" validate:"required"`
 	NewPassword       string `json:"newPassword" validate:"required"`
@@ -68,26 +102,60 @@ type ForgetPasswordRequest struct {
 	VerificationToken string `json:"verificationToken" validate:"required"`
 }
 
+const (
+	// the account is locked for a while after too many failed sign ins
+	SIGNIN_MAX_FAILURES   = 5
+	SIGNIN_LOCKOUT_WINDOW = time.Minute * 15
+	// the verification token is useless after too many wrong codes
+	VERIFICATION_MAX_ATTEMPTS = 5
+	VERIFICATION_WINDOW       = time.Minute * 5
+)
+
 type UserRestHandler interface {
 	GetVerificationCode(c *gin.Context)
 	SignUp(c *gin.Context)
 	SignIn(c *gin.Context)
+	SignInWithTOTP(c *gin.Context)
+	GetOIDCAuthorization(c *gin.Context)
+	SignInWithOIDC(c *gin.Context)
 	ForgetPassword(c *gin.Context)
+	RefreshToken(c *gin.Context)
+	SignOut(c *gin.Context)
 	UpdateUsername(c *gin.Context)
 	UpdatePassword(c *gin.Context)
 	UpdateLanguage(c *gin.Context)
 	GetUserInfo(c *gin.Context)
+	ListAPITokens(c *gin.Context)
+	CreateAPIToken(c *gin.Context)
+	RevokeAPIToken(c *gin.Context)
+	EnrollTOTP(c *gin.Context)
+	EnableTOTP(c *gin.Context)
+	DisableTOTP(c *gin.Context)
 }
 
 type UserRestHandlerImpl struct {
-	logger      *zap.SugaredLogger
-	userService user.UserService
+	logger          *zap.SugaredLogger
+	userService     user.UserService
+	tokenService    user.TokenService
+	oidcProvider    *oidc.Provider
+	apiTokenService user.APITokenService
+	// failed attempts are counted by email, by verification token and by user
+	signInLockout        *ratelimit.AttemptCounter
+	verificationAttempts *ratelimit.AttemptCounter
+	secondFactorAttempts *ratelimit.AttemptCounter
 }
 
-func NewUserRestHandlerImpl(logger *zap.SugaredLogger, userService user.UserService) *UserRestHandlerImpl {
+func NewUserRestHandlerImpl(logger *zap.SugaredLogger, userService user.UserService, tokenService user.TokenService,
+	oidcProvider *oidc.Provider, apiTokenService user.APITokenService, store ratelimit.Store) *UserRestHandlerImpl {
 	return &UserRestHandlerImpl{
-		logger:      logger,
-		userService: userService,
+		logger:               logger,
+		userService:          userService,
+		tokenService:         tokenService,
+		oidcProvider:         oidcProvider,
+		apiTokenService:      apiTokenService,
+		signInLockout:        ratelimit.NewAttemptCounter(store, "signin", SIGNIN_MAX_FAILURES, SIGNIN_LOCKOUT_WINDOW),
+		verificationAttempts: ratelimit.NewAttemptCounter(store, "verification", VERIFICATION_MAX_ATTEMPTS, VERIFICATION_WINDOW),
+		secondFactorAttempts: ratelimit.NewAttemptCounter(store, "totp", SIGNIN_MAX_FAILURES, SIGNIN_LOCKOUT_WINDOW),
 	}
 }
 
@@ -111,7 +179,7 @@ func (impl UserRestHandlerImpl) GetVerificationCode(c *gin.Context) {
 		return
 	}
 
-	vToken, err := impl.userService.GenerateVerificationCode(payload.Email, payload.Usage)
+	vToken, err := impl.userService.GenerateVerificationCode(payload.Email, payload.Usage, payload.Language)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"errorCode":    500,
@@ -156,9 +224,13 @@ func (impl UserRestHandlerImpl) SignUp(c *gin.Context) {
 	}
 
 	// validate verification code
+	if !impl.checkAttempts(c, impl.verificationAttempts, ratelimit.HashKey(payload.VerificationToken)) {
+		return
+	}
 	validCode, err := impl.userService.ValidateVerificationCode(payload.VerificationCode, payload.VerificationToken,
 		payload.Email, "signup")
 	if err != nil || !validCode {
+		impl.failAttempt(impl.verificationAttempts, ratelimit.HashKey(payload.VerificationToken))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
 			"errorMessage": "validate verification code error: " + err.Error(),
@@ -184,6 +256,11 @@ func (impl UserRestHandlerImpl) SignUp(c *gin.Context) {
 		return
 	}
 
+	// the welcome mail is not essential to sign up
+	if err := impl.userService.SendWelcomeMail(userDto); err != nil {
+		impl.logger.Errorw("send welcome mail error", "err", err)
+	}
+
 	c.JSON(http.StatusOK, userDto)
 }
 
@@ -208,9 +285,16 @@ func (impl UserRestHandlerImpl) SignIn(c *gin.Context) {
 		return
 	}
 
+	// the account is locked after too many failures
+	lockoutKey := ratelimit.NormalizeEmail(payload.Email)
+	if !impl.checkAttempts(c, impl.signInLockout, lockoutKey) {
+		return
+	}
+
 	// fetch user by email
 	userDto, err := impl.userService.FindUserByEmail(payload.Email)
 	if err != nil || userDto.ID == 0 {
+		impl.failAttempt(impl.signInLockout, lockoutKey)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
 			"errorMessage": "invalid email or password",
@@ -221,20 +305,273 @@ func (impl UserRestHandlerImpl) SignIn(c *gin.Context) {
 	// validate password with password digest
 	err = bcrypt.CompareHashAndPassword([]byte(userDto.Password), []byte(payload.Password))
 	if err != nil {
+		impl.failAttempt(impl.signInLockout, lockoutKey)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
 			"errorMessage": "invalid email or password",
 		})
 		return
 	}
+	_ = impl.signInLockout.Reset(lockoutKey)
+
+	impl.finishSignIn(c, userDto)
+}
+
+// finishSignIn starts a session for the user whose first factor is verified, or asks for the
+// second step when two-factor authentication enabled.
+func (impl UserRestHandlerImpl) finishSignIn(c *gin.Context, userDto user.UserDto) {
+	if userDto.TOTPEnabled {
+		challengeToken, err := user.CreateChallengeToken(userDto.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errorCode":    500,
+				"errorMessage": "sign in error: " + err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"totpRequired":   true,
+			"challengeToken": challengeToken,
+		})
+		return
+	}
+
+	// generate access token and refresh token
+	tokenPair, err := impl.tokenService.IssueTokens(userDto.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "sign in error: " + err.Error(),
+		})
+		return
+	}
+	c.Header("illa-token", tokenPair.AccessToken)
+	c.Header("illa-refresh-token", tokenPair.RefreshToken)
+
+	c.JSON(http.StatusOK, userDto)
+}
+
+func (impl UserRestHandlerImpl) SignInWithTOTP(c *gin.Context) {
+	// get request body
+	var payload SignInWithTOTPRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// the challenge token proves the password was verified
+	userID, err := user.ExtractUserIDFromChallengeToken(payload.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "invalid challenge token",
+		})
+		return
+	}
+	secondFactorKey := strconv.Itoa(userID)
+	if !impl.checkAttempts(c, impl.secondFactorAttempts, secondFactorKey) {
+		return
+	}
+	valid, err := impl.userService.VerifySecondFactor(userID, payload.Code)
+	if err != nil || !valid {
+		impl.failAttempt(impl.secondFactorAttempts, secondFactorKey)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "invalid verification code",
+		})
+		return
+	}
+	userDto, err := impl.userService.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get user error: " + err.Error(),
+		})
+		return
+	}
 
 	// generate access token and refresh token
-	accessToken, _ := impl.userService.GetToken(userDto.ID)
-	c.Header("illa-token", accessToken)
+	tokenPair, err := impl.tokenService.IssueTokens(userDto.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "sign in error: " + err.Error(),
+		})
+		return
+	}
+	c.Header("illa-token", tokenPair.AccessToken)
+	c.Header("illa-refresh-token", tokenPair.RefreshToken)
 
 	c.JSON(http.StatusOK, userDto)
 }
 
+func (impl UserRestHandlerImpl) GetOIDCAuthorization(c *gin.Context) {
+	if !impl.oidcProvider.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "OIDC sign in is not enabled",
+		})
+		return
+	}
+
+	// build authorization url with state, nonce and PKCE code challenge
+	authorizationRequest, err := impl.oidcProvider.NewAuthorizationRequest()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "OIDC authorization error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, authorizationRequest)
+}
+
+func (impl UserRestHandlerImpl) SignInWithOIDC(c *gin.Context) {
+	if !impl.oidcProvider.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{
+			"errorCode":    404,
+			"errorMessage": "OIDC sign in is not enabled",
+		})
+		return
+	}
+
+	// get request body
+	var payload OIDCSignInRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// exchange authorization code and validate id token
+	claims, err := impl.oidcProvider.Exchange(payload.Code, payload.State, payload.StateToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "OIDC sign in error: " + err.Error(),
+		})
+		return
+	}
+	if claims.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "OIDC sign in error: no email in ID token",
+		})
+		return
+	}
+	if impl.oidcProvider.RequireVerifiedEmail() && (claims.EmailVerified == nil || !*claims.EmailVerified) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "OIDC sign in error: email not verified",
+		})
+		return
+	}
+
+	// link or provision user by email
+	userDto, err := impl.userService.LinkOrProvisionUser(claims.Email, claims.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "OIDC sign in error: " + err.Error(),
+		})
+		return
+	}
+
+	// the identity provider verified the first factor only
+	impl.finishSignIn(c, userDto)
+}
+
+func (impl UserRestHandlerImpl) RefreshToken(c *gin.Context) {
+	// get request body
+	var payload RefreshTokenRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// rotate refresh token
+	tokenPair, err := impl.tokenService.RefreshTokens(payload.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "refresh token error: " + err.Error(),
+		})
+		return
+	}
+	c.Header("illa-token", tokenPair.AccessToken)
+	c.Header("illa-refresh-token", tokenPair.RefreshToken)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "refresh token successfully",
+	})
+}
+
+func (impl UserRestHandlerImpl) SignOut(c *gin.Context) {
+	// the request body is optional
+	var payload SignOutRequest
+	if c.Request.ContentLength > 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errorCode":    400,
+				"errorMessage": "parse request body error: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// revoke current session
+	if err := impl.tokenService.SignOut(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "), payload.RefreshToken); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "sign out error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "sign out successfully",
+	})
+}
+
 func (impl UserRestHandlerImpl) ForgetPassword(c *gin.Context) {
 	// get request body
 	var payload ForgetPasswordRequest
@@ -267,9 +604,13 @@ func (impl UserRestHandlerImpl) ForgetPassword(c *gin.Context) {
 	}
 
 	// validate verification code
+	if !impl.checkAttempts(c, impl.verificationAttempts, ratelimit.HashKey(payload.VerificationToken)) {
+		return
+	}
 	validCode, err := impl.userService.ValidateVerificationCode(payload.VerificationCode, payload.VerificationToken,
 		payload.Email, "forgetpwd")
 	if !validCode || err != nil {
+		impl.failAttempt(impl.verificationAttempts, ratelimit.HashKey(payload.VerificationToken))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"errorCode":    400,
 			"errorMessage": "validate verification code error: " + err.Error(),
@@ -295,6 +636,19 @@ func (impl UserRestHandlerImpl) ForgetPassword(c *gin.Context) {
 		return
 	}
 
+	// sign out all sessions with the old password
+	if err := impl.tokenService.RevokeAllSessions(userDto.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "forget password error: " + err.Error(),
+		})
+		return
+	}
+
+	if err := impl.userService.SendPasswordChangedMail(userDto); err != nil {
+		impl.logger.Errorw("send password changed mail error", "err", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "reset password successfully",
 	})
@@ -421,6 +775,29 @@ func (impl UserRestHandlerImpl) UpdatePassword(c *gin.Context) {
 		return
 	}
 
+	// sign out all sessions, and start a new one for current client
+	if err := impl.tokenService.RevokeAllSessions(userDto.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "update password error: " + err.Error(),
+		})
+		return
+	}
+	tokenPair, err := impl.tokenService.IssueTokens(userDto.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "update password error: " + err.Error(),
+		})
+		return
+	}
+	c.Header("illa-token", tokenPair.AccessToken)
+	c.Header("illa-refresh-token", tokenPair.RefreshToken)
+
+	if err := impl.userService.SendPasswordChangedMail(userDto); err != nil {
+		impl.logger.Errorw("send password changed mail error", "err", err)
+	}
+
 	c.JSON(http.StatusOK, userDto)
 }
 
@@ -498,3 +875,247 @@ func (impl UserRestHandlerImpl) GetUserInfo(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, userDto)
 }
+
+func (impl UserRestHandlerImpl) ListAPITokens(c *gin.Context) {
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	apiTokens, err := impl.apiTokenService.ListAPITokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"errorCode":    500,
+			"errorMessage": "get API tokens error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiTokens)
+}
+
+func (impl UserRestHandlerImpl) CreateAPIToken(c *gin.Context) {
+	// get request body
+	var payload CreateAPITokenRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	// the plaintext token is only returned in this response
+	apiToken, err := impl.apiTokenService.CreateAPIToken(user, payload.Name, payload.Scopes, payload.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "create API token error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, apiToken)
+}
+
+func (impl UserRestHandlerImpl) RevokeAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request parameter error: " + err.Error(),
+		})
+		return
+	}
+
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	if err := impl.apiTokenService.RevokeAPIToken(user, id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "revoke API token error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokenId": id,
+	})
+}
+
+func (impl UserRestHandlerImpl) EnrollTOTP(c *gin.Context) {
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	enrollment, err := impl.userService.EnrollTOTP(user)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "enroll two-factor authentication error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (impl UserRestHandlerImpl) EnableTOTP(c *gin.Context) {
+	// get request body
+	var payload TOTPCodeRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	// the recovery codes are only returned in this response
+	recoveryCodes, err := impl.userService.EnableTOTP(user, payload.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "enable two-factor authentication error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+func (impl UserRestHandlerImpl) DisableTOTP(c *gin.Context) {
+	// get request body
+	var payload TOTPCodeRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// validate payload required fields
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "parse request body error: " + err.Error(),
+		})
+		return
+	}
+
+	// get user by id
+	userID, okGet := c.Get("userID")
+	user, okReflect := userID.(int)
+	if !(okGet && okReflect) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"errorCode":    401,
+			"errorMessage": "unauthorized",
+		})
+		return
+	}
+
+	secondFactorKey := strconv.Itoa(user)
+	if !impl.checkAttempts(c, impl.secondFactorAttempts, secondFactorKey) {
+		return
+	}
+	if err := impl.userService.DisableTOTP(user, payload.Code); err != nil {
+		impl.failAttempt(impl.secondFactorAttempts, secondFactorKey)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errorCode":    400,
+			"errorMessage": "disable two-factor authentication error: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// checkAttempts responds 429 when the key is blocked by the attempt counter.
+func (impl UserRestHandlerImpl) checkAttempts(c *gin.Context, counter *ratelimit.AttemptCounter, key string) bool {
+	blocked, retryAfter, err := counter.Blocked(key)
+	if err != nil {
+		impl.logger.Errorw("check attempts error", "err", err)
+		return true
+	}
+	if blocked {
+		ratelimit.AbortWithTooManyRequests(c, retryAfter)
+		return false
+	}
+	return true
+}
+
+func (impl UserRestHandlerImpl) failAttempt(counter *ratelimit.AttemptCounter, key string) {
+	if err := counter.Fail(key); err != nil {
+		impl.logger.Errorw("record failed attempt error", "err", err)
+	}
+}
@@ -40,4 +40,18 @@ func (impl AppRouterImpl) InitAppRouter(appRouter *gin.RouterGroup) {
 	appRouter.GET(":app/versions/:version", impl.appRestHandler.GetMegaData)
 	appRouter.POST(":app/duplication", impl.appRestHandler.DuplicateApp)
 	appRouter.POST(":app/deploy", impl.appRestHandler.ReleaseApp)
+	appRouter.GET(":app/versions", impl.appRestHandler.GetAppVersions)
+	appRouter.GET(":app/versions/:version/diff", impl.appRestHandler.DiffAppVersions)
+	appRouter.POST(":app/versions/:version/restore", impl.appRestHandler.RestoreAppVersion)
+	appRouter.POST(":app/versions/:version/release", impl.appRestHandler.SetReleaseVersion)
+	appRouter.GET(":app/channels", impl.appRestHandler.GetAppChannels)
+	appRouter.GET(":app/channels/:channel", impl.appRestHandler.GetChannelMegaData)
+	appRouter.POST(":app/promote", impl.appRestHandler.PromoteChannel)
+	appRouter.GET(":app/export", impl.appRestHandler.ExportApp)
+	appRouter.POST("import", impl.appRestHandler.ImportApp)
+	appRouter.GET(":app/publish", impl.appRestHandler.GetPublishSetting)
+	appRouter.PUT(":app/publish", impl.appRestHandler.SetPublishSetting)
+	appRouter.GET(":app/members", impl.appRestHandler.GetAppMembers)
+	appRouter.PUT(":app/members", impl.appRestHandler.SetAppMember)
+	appRouter.DELETE(":app/members/:user", impl.appRestHandler.RemoveAppMember)
 }
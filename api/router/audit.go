@@ -0,0 +1,37 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"github.com/illa-family/builder-backend/api/resthandler"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditRouter interface {
+	InitAuditRouter(auditRouter *gin.RouterGroup)
+}
+
+type AuditRouterImpl struct {
+	auditRestHandler resthandler.AuditRestHandler
+}
+
+func NewAuditRouterImpl(auditRestHandler resthandler.AuditRestHandler) *AuditRouterImpl {
+	return &AuditRouterImpl{auditRestHandler: auditRestHandler}
+}
+
+func (impl AuditRouterImpl) InitAuditRouter(auditRouter *gin.RouterGroup) {
+	auditRouter.GET("", impl.auditRestHandler.FindAuditLogs)
+}
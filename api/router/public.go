@@ -0,0 +1,39 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"github.com/illa-family/builder-backend/api/resthandler"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PublicRouter interface {
+	InitPublicRouter(publicRouter *gin.RouterGroup)
+}
+
+type PublicRouterImpl struct {
+	publicRestHandler resthandler.PublicRestHandler
+}
+
+func NewPublicRouterImpl(publicRestHandler resthandler.PublicRestHandler) *PublicRouterImpl {
+	return &PublicRouterImpl{publicRestHandler: publicRestHandler}
+}
+
+func (impl PublicRouterImpl) InitPublicRouter(publicRouter *gin.RouterGroup) {
+	publicRouter.GET(":slug", impl.publicRestHandler.GetPublicApp)
+	publicRouter.POST(":slug/auth", impl.publicRestHandler.SignInPublicApp)
+	publicRouter.POST(":slug/actions/:action/run", impl.publicRestHandler.RunPublicAction)
+}
@@ -39,4 +39,7 @@ func (impl ResourceRouterImpl) InitResourceRouter(resourceRouter *gin.RouterGrou
 	resourceRouter.PUT("/:resource", impl.resourceRestHandler.UpdateResource)
 	resourceRouter.DELETE("/:resource", impl.resourceRestHandler.DeleteResource)
 	resourceRouter.POST("/testConnection", impl.resourceRestHandler.TestConnection)
+	resourceRouter.GET("/:resource/environments", impl.resourceRestHandler.GetResourceEnvironments)
+	resourceRouter.PUT("/:resource/environments/:environment", impl.resourceRestHandler.SetResourceEnvironment)
+	resourceRouter.DELETE("/:resource/environments/:environment", impl.resourceRestHandler.DeleteResourceEnvironment)
 }
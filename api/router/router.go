@@ -22,24 +22,35 @@ import (
 )
 
 type RESTRouter struct {
-	logger         *zap.SugaredLogger
-	Router         *gin.RouterGroup
-	UserRouter     UserRouter
-	AppRouter      AppRouter
-	RoomRouter     RoomRouter
-	ActionRouter   ActionRouter
-	ResourceRouter ResourceRouter
+	logger          *zap.SugaredLogger
+	Router          *gin.RouterGroup
+	UserRouter      UserRouter
+	AppRouter       AppRouter
+	RoomRouter      RoomRouter
+	ActionRouter    ActionRouter
+	ResourceRouter  ResourceRouter
+	AuditRouter     AuditRouter
+	PublicRouter    PublicRouter
+	TokenService    user.TokenService
+	APITokenService user.APITokenService
+	UserService     user.UserService
 }
 
 func NewRESTRouter(logger *zap.SugaredLogger, userRouter UserRouter, appRouter AppRouter, roomRouter RoomRouter,
-	actionRouter ActionRouter, resourceRouter ResourceRouter) *RESTRouter {
+	actionRouter ActionRouter, resourceRouter ResourceRouter, auditRouter AuditRouter, publicRouter PublicRouter,
+	tokenService user.TokenService, apiTokenService user.APITokenService, userService user.UserService) *RESTRouter {
 	return &RESTRouter{
-		logger:         logger,
-		UserRouter:     userRouter,
-		AppRouter:      appRouter,
-		RoomRouter:     roomRouter,
-		ActionRouter:   actionRouter,
-		ResourceRouter: resourceRouter,
+		logger:          logger,
+		UserRouter:      userRouter,
+		AppRouter:       appRouter,
+		RoomRouter:      roomRouter,
+		ActionRouter:    actionRouter,
+		ResourceRouter:  resourceRouter,
+		AuditRouter:     auditRouter,
+		PublicRouter:    publicRouter,
+		TokenService:    tokenService,
+		APITokenService: apiTokenService,
+		UserService:     userService,
 	}
 }
 
@@ -52,12 +63,15 @@ func (r RESTRouter) InitRouter(router *gin.RouterGroup) {
 	roomRouter := v1.Group("/room")
 	actionRouter := v1.Group("/apps/:app")
 	resourceRouter := v1.Group("/resources")
+	auditRouter := v1.Group("/audit")
+	publicRouter := v1.Group("/public/apps") // published apps are served without sign in
 
-	userRouter.Use(user.JWTAuth())
-	appRouter.Use(user.JWTAuth())
-	roomRouter.Use(user.JWTAuth())
-	actionRouter.Use(user.JWTAuth())
-	resourceRouter.Use(user.JWTAuth())
+	userRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService), user.SessionOnly())
+	appRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService))
+	roomRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService))
+	actionRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService))
+	resourceRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService))
+	auditRouter.Use(user.JWTAuth(r.TokenService, r.APITokenService), user.AdminOnly(r.UserService))
 
 	r.UserRouter.InitAuthRouter(authRouter)
 	r.UserRouter.InitUserRouter(userRouter)
@@ -65,4 +79,6 @@ func (r RESTRouter) InitRouter(router *gin.RouterGroup) {
 	r.RoomRouter.InitRoomRouter(roomRouter)
 	r.ActionRouter.InitActionRouter(actionRouter)
 	r.ResourceRouter.InitResourceRouter(resourceRouter)
+	r.AuditRouter.InitAuditRouter(auditRouter)
+	r.PublicRouter.InitPublicRouter(publicRouter)
 }
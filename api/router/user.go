@@ -15,8 +15,11 @@
 package router
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/illa-family/builder-backend/api/resthandler"
+	"github.com/illa-family/builder-backend/pkg/ratelimit"
 )
 
 type UserRouter interface {
@@ -26,22 +29,49 @@ type UserRouter interface {
 
 type UserRouterImpl struct {
 	userRestHandler resthandler.UserRestHandler
+	store           ratelimit.Store
+}
+
+func NewUserRouterImpl(userRestHandler resthandler.UserRestHandler, store ratelimit.Store) *UserRouterImpl {
+	return &UserRouterImpl{userRestHandler: userRestHandler, store: store}
+}
+
+func (impl UserRouterImpl) limitByIP(name string, limit int, window time.Duration) gin.HandlerFunc {
+	return ratelimit.Limit(ratelimit.NewLimiter(impl.store, name+":ip", limit, window), ratelimit.ByIP)
+}
+
+func (impl UserRouterImpl) limitByEmail(name string, limit int, window time.Duration) gin.HandlerFunc {
+	return ratelimit.Limit(ratelimit.NewLimiter(impl.store, name+":email", limit, window), ratelimit.ByJSONField("email"))
 }
 
-func NewUserRouterImpl(userRestHandler resthandler.UserRestHandler) *UserRouterImpl {
-	return &UserRouterImpl{userRestHandler: userRestHandler}
+func (impl UserRouterImpl) limitByUser(name string, limit int, window time.Duration) gin.HandlerFunc {
+	return ratelimit.Limit(ratelimit.NewLimiter(impl.store, name+":user", limit, window), ratelimit.ByUser)
 }
 
 func (impl UserRouterImpl) InitAuthRouter(authRouter *gin.RouterGroup) {
-	authRouter.POST("/verification", impl.userRestHandler.GetVerificationCode)
-	authRouter.POST("/signup", impl.userRestHandler.SignUp)
-	authRouter.POST("/signin", impl.userRestHandler.SignIn)
-	authRouter.POST("/forgetPassword", impl.userRestHandler.ForgetPassword)
+	authRouter.POST("/verification", impl.limitByIP("verification", 10, time.Minute*10),
+		impl.limitByEmail("verification", 3, time.Minute*10), impl.userRestHandler.GetVerificationCode)
+	authRouter.POST("/signup", impl.limitByIP("signup", 10, time.Minute*10), impl.userRestHandler.SignUp)
+	authRouter.POST("/signin", impl.limitByIP("signin", 20, time.Minute),
+		impl.limitByEmail("signin", 10, time.Minute), impl.userRestHandler.SignIn)
+	authRouter.POST("/signin/totp", impl.limitByIP("signin-totp", 20, time.Minute), impl.userRestHandler.SignInWithTOTP)
+	authRouter.GET("/oidc/authorize", impl.limitByIP("oidc", 30, time.Minute), impl.userRestHandler.GetOIDCAuthorization)
+	authRouter.POST("/oidc/signin", impl.limitByIP("oidc-signin", 20, time.Minute), impl.userRestHandler.SignInWithOIDC)
+	authRouter.POST("/forgetPassword", impl.limitByIP("forget-password", 10, time.Minute*10),
+		impl.limitByEmail("forget-password", 5, time.Minute*10), impl.userRestHandler.ForgetPassword)
+	authRouter.POST("/refresh", impl.limitByIP("refresh", 60, time.Minute), impl.userRestHandler.RefreshToken)
+	authRouter.POST("/logout", impl.userRestHandler.SignOut)
 }
 
 func (impl UserRouterImpl) InitUserRouter(userRouter *gin.RouterGroup) {
-	userRouter.PATCH("/password", impl.userRestHandler.UpdatePassword)
+	userRouter.PATCH("/password", impl.limitByUser("password", 5, time.Minute*10), impl.userRestHandler.UpdatePassword)
 	userRouter.PATCH("/nickname", impl.userRestHandler.UpdateUsername)
 	userRouter.PATCH("/language", impl.userRestHandler.UpdateLanguage)
 	userRouter.GET("", impl.userRestHandler.GetUserInfo)
+	userRouter.GET("/tokens", impl.userRestHandler.ListAPITokens)
+	userRouter.POST("/tokens", impl.userRestHandler.CreateAPIToken)
+	userRouter.DELETE("/tokens/:token", impl.userRestHandler.RevokeAPIToken)
+	userRouter.POST("/totp", impl.userRestHandler.EnrollTOTP)
+	userRouter.POST("/totp/verify", impl.limitByUser("totp-verify", 10, time.Minute*10), impl.userRestHandler.EnableTOTP)
+	userRouter.DELETE("/totp", impl.userRestHandler.DisableTOTP)
 }
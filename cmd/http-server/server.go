@@ -16,9 +16,11 @@ package main
 
 import (
 	"os"
+	"strings"
 
 	"github.com/illa-family/builder-backend/api/router"
 	"github.com/illa-family/builder-backend/pkg/cors"
+	"github.com/illa-family/builder-backend/pkg/smtp"
 
 	"github.com/caarlos0/env"
 	"github.com/gin-gonic/gin"
@@ -29,13 +31,16 @@ type Config struct {
 	ILLA_SERVER_HOST string `env:"ILLA_SERVER_HOST" envDefault:"0.0.0.0"`
 	ILLA_SERVER_PORT string `env:"ILLA_SERVER_PORT" envDefault:"8999"`
 	ILLA_SERVER_MODE string `env:"ILLA_SERVER_MODE" envDefault:"debug"`
+	// the comma separated proxies whose X-Forwarded-For is trusted for the client IP, none by default
+	ILLA_TRUSTED_PROXIES string `env:"ILLA_TRUSTED_PROXIES" envDefault:""`
 }
 
 type Server struct {
-	engine     *gin.Engine
-	restRouter *router.RESTRouter
-	logger     *zap.SugaredLogger
-	cfg        *Config
+	engine       *gin.Engine
+	restRouter   *router.RESTRouter
+	outboxWorker *smtp.OutboxWorker
+	logger       *zap.SugaredLogger
+	cfg          *Config
 }
 
 func GetAppConfig() (*Config, error) {
@@ -47,12 +52,24 @@ func GetAppConfig() (*Config, error) {
 	return cfg, nil
 }
 
-func NewServer(cfg *Config, engine *gin.Engine, restRouter *router.RESTRouter, logger *zap.SugaredLogger) *Server {
+func (cfg *Config) TrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(cfg.ILLA_TRUSTED_PROXIES, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+func NewServer(cfg *Config, engine *gin.Engine, restRouter *router.RESTRouter, logger *zap.SugaredLogger,
+	outboxWorker *smtp.OutboxWorker) *Server {
 	return &Server{
-		engine:     engine,
-		cfg:        cfg,
-		restRouter: restRouter,
-		logger:     logger,
+		engine:       engine,
+		cfg:          cfg,
+		restRouter:   restRouter,
+		outboxWorker: outboxWorker,
+		logger:       logger,
 	}
 }
 
@@ -60,9 +77,17 @@ func (server *Server) Start() {
 	server.logger.Infow("Starting server")
 
 	gin.SetMode(server.cfg.ILLA_SERVER_MODE)
+	// the client IP is the remote address unless the request came through a trusted proxy
+	if err := server.engine.SetTrustedProxies(server.cfg.TrustedProxies()); err != nil {
+		server.logger.Errorw("Error in trusted proxies", "err", err)
+		os.Exit(2)
+	}
 	server.engine.Use(cors.Cors())
 	server.restRouter.InitRouter(server.engine.Group("/api"))
 
+	// deliver the queued mails in background
+	go server.outboxWorker.Run()
+
 	err := server.engine.Run(server.cfg.ILLA_SERVER_HOST + ":" + server.cfg.ILLA_SERVER_PORT)
 	if err != nil {
 		server.logger.Errorw("Error in startup", "err", err)
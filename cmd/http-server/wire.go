@@ -22,6 +22,8 @@ import (
 	"github.com/illa-family/builder-backend/cmd/http-server/wireset"
 	"github.com/illa-family/builder-backend/internal/util"
 	"github.com/illa-family/builder-backend/pkg/db"
+	"github.com/illa-family/builder-backend/pkg/oidc"
+	"github.com/illa-family/builder-backend/pkg/ratelimit"
 	"github.com/illa-family/builder-backend/pkg/smtp"
 
 	"github.com/gin-gonic/gin"
@@ -32,12 +34,16 @@ func Initialize() (*Server, error) {
 	wire.Build(
 		db.DbWireSet,
 		smtp.SMTPWireSet,
+		oidc.OIDCWireSet,
+		ratelimit.RateLimitWireSet,
 		util.NewSugardLogger,
 		wireset.ResourceWireSet,
 		wireset.AppWireSet,
 		wireset.ActionWireSet,
 		wireset.RoomWireSet,
 		wireset.UserWireSet,
+		wireset.AuditWireSet,
+		wireset.PublicWireSet,
 		router.NewRESTRouter,
 		GetAppConfig,
 		gin.New,
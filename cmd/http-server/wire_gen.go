@@ -14,10 +14,15 @@ import (
 	"github.com/illa-family/builder-backend/internal/util"
 	"github.com/illa-family/builder-backend/pkg/action"
 	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/bundle"
 	"github.com/illa-family/builder-backend/pkg/db"
+	"github.com/illa-family/builder-backend/pkg/oidc"
+	"github.com/illa-family/builder-backend/pkg/ratelimit"
 	"github.com/illa-family/builder-backend/pkg/resource"
 	"github.com/illa-family/builder-backend/pkg/room"
 	"github.com/illa-family/builder-backend/pkg/smtp"
+	"github.com/illa-family/builder-backend/pkg/state"
 	"github.com/illa-family/builder-backend/pkg/user"
 )
 
@@ -43,29 +48,60 @@ func Initialize() (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	smtpServer := smtp.NewSMTPServer(smtpConfig)
+	mailOutboxRepositoryImpl := repository.NewMailOutboxRepositoryImpl(sugaredLogger, gormDB)
+	smtpServer := smtp.NewSMTPServer(smtpConfig, mailOutboxRepositoryImpl)
 	userServiceImpl := user.NewUserServiceImpl(userRepositoryImpl, sugaredLogger, smtpServer)
-	userRestHandlerImpl := resthandler.NewUserRestHandlerImpl(sugaredLogger, userServiceImpl)
-	userRouterImpl := router.NewUserRouterImpl(userRestHandlerImpl)
+	refreshTokenRepositoryImpl := repository.NewRefreshTokenRepositoryImpl(sugaredLogger, gormDB)
+	revokedTokenRepositoryImpl := repository.NewRevokedTokenRepositoryImpl(sugaredLogger, gormDB)
+	apiTokenRepositoryImpl := repository.NewAPITokenRepositoryImpl(sugaredLogger, gormDB)
+	tokenServiceImpl := user.NewTokenServiceImpl(sugaredLogger, refreshTokenRepositoryImpl, revokedTokenRepositoryImpl, apiTokenRepositoryImpl)
+	oidcConfig, err := oidc.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	provider := oidc.NewProvider(oidcConfig)
+	apiTokenServiceImpl := user.NewAPITokenServiceImpl(sugaredLogger, apiTokenRepositoryImpl)
+	memoryStore := ratelimit.NewMemoryStore()
+	userRestHandlerImpl := resthandler.NewUserRestHandlerImpl(sugaredLogger, userServiceImpl, tokenServiceImpl, provider, apiTokenServiceImpl, memoryStore)
+	userRouterImpl := router.NewUserRouterImpl(userRestHandlerImpl, memoryStore)
 	appRepositoryImpl := repository.NewAppRepositoryImpl(sugaredLogger, gormDB)
 	kvStateRepositoryImpl := repository.NewKVStateRepositoryImpl(sugaredLogger, gormDB)
 	treeStateRepositoryImpl := repository.NewTreeStateRepositoryImpl(sugaredLogger, gormDB)
 	setStateRepositoryImpl := repository.NewSetStateRepositoryImpl(sugaredLogger, gormDB)
 	actionRepositoryImpl := repository.NewActionRepositoryImpl(sugaredLogger, gormDB)
-	appServiceImpl := app.NewAppServiceImpl(sugaredLogger, appRepositoryImpl, userRepositoryImpl, kvStateRepositoryImpl, treeStateRepositoryImpl, setStateRepositoryImpl, actionRepositoryImpl)
-	appRestHandlerImpl := resthandler.NewAppRestHandlerImpl(sugaredLogger, appServiceImpl)
+	appVersionRepositoryImpl := repository.NewAppVersionRepositoryImpl(sugaredLogger, gormDB)
+	appChannelRepositoryImpl := repository.NewAppChannelRepositoryImpl(sugaredLogger, gormDB)
+	appPublishRepositoryImpl := repository.NewAppPublishRepositoryImpl(sugaredLogger, gormDB)
+	appMemberRepositoryImpl := repository.NewAppMemberRepositoryImpl(sugaredLogger, gormDB)
+	unitOfWorkImpl := repository.NewUnitOfWorkImpl(sugaredLogger, gormDB)
+	appServiceImpl := app.NewAppServiceImpl(sugaredLogger, appRepositoryImpl, userRepositoryImpl, kvStateRepositoryImpl, treeStateRepositoryImpl, setStateRepositoryImpl, actionRepositoryImpl, appVersionRepositoryImpl, appChannelRepositoryImpl, appPublishRepositoryImpl, appMemberRepositoryImpl, unitOfWorkImpl, smtpServer)
+	auditLogRepositoryImpl := repository.NewAuditLogRepositoryImpl(sugaredLogger, gormDB)
+	auditServiceImpl := audit.NewAuditServiceImpl(sugaredLogger, auditLogRepositoryImpl)
+	resourceRepositoryImpl := repository.NewResourceRepositoryImpl(sugaredLogger, gormDB)
+	resourceEnvironmentRepositoryImpl := repository.NewResourceEnvironmentRepositoryImpl(sugaredLogger, gormDB)
+	resourceServiceImpl := resource.NewResourceServiceImpl(sugaredLogger, resourceRepositoryImpl, resourceEnvironmentRepositoryImpl)
+	treeStateServiceImpl := state.NewTreeStateServiceImpl(sugaredLogger, treeStateRepositoryImpl)
+	bundleServiceImpl := bundle.NewBundleServiceImpl(sugaredLogger, appServiceImpl, resourceServiceImpl, treeStateServiceImpl)
+	appRestHandlerImpl := resthandler.NewAppRestHandlerImpl(sugaredLogger, appServiceImpl, bundleServiceImpl, auditServiceImpl)
 	appRouterImpl := router.NewAppRouterImpl(appRestHandlerImpl)
 	roomServiceImpl := room.NewRoomServiceImpl(sugaredLogger)
 	roomRestHandlerImpl := resthandler.NewRoomRestHandlerImpl(sugaredLogger, roomServiceImpl)
 	roomRouterImpl := router.NewRoomRouterImpl(roomRestHandlerImpl)
-	resourceRepositoryImpl := repository.NewResourceRepositoryImpl(sugaredLogger, gormDB)
-	actionServiceImpl := action.NewActionServiceImpl(sugaredLogger, actionRepositoryImpl, resourceRepositoryImpl)
-	actionRestHandlerImpl := resthandler.NewActionRestHandlerImpl(sugaredLogger, actionServiceImpl)
+	actionServiceImpl := action.NewActionServiceImpl(sugaredLogger, actionRepositoryImpl, resourceRepositoryImpl, resourceEnvironmentRepositoryImpl)
+	actionRestHandlerImpl := resthandler.NewActionRestHandlerImpl(sugaredLogger, actionServiceImpl, appServiceImpl, auditServiceImpl)
 	actionRouterImpl := router.NewActionRouterImpl(actionRestHandlerImpl)
-	resourceServiceImpl := resource.NewResourceServiceImpl(sugaredLogger, resourceRepositoryImpl)
-	resourceRestHandlerImpl := resthandler.NewResourceRestHandlerImpl(sugaredLogger, resourceServiceImpl)
+	resourceRestHandlerImpl := resthandler.NewResourceRestHandlerImpl(sugaredLogger, resourceServiceImpl, auditServiceImpl)
 	resourceRouterImpl := router.NewResourceRouterImpl(resourceRestHandlerImpl)
-	restRouter := router.NewRESTRouter(sugaredLogger, userRouterImpl, appRouterImpl, roomRouterImpl, actionRouterImpl, resourceRouterImpl)
-	server := NewServer(config, engine, restRouter, sugaredLogger)
+	auditRestHandlerImpl := resthandler.NewAuditRestHandlerImpl(sugaredLogger, auditServiceImpl)
+	auditRouterImpl := router.NewAuditRouterImpl(auditRestHandlerImpl)
+	publicRestHandlerImpl := resthandler.NewPublicRestHandlerImpl(sugaredLogger, appServiceImpl, actionServiceImpl, memoryStore)
+	publicRouterImpl := router.NewPublicRouterImpl(publicRestHandlerImpl)
+	restRouter := router.NewRESTRouter(sugaredLogger, userRouterImpl, appRouterImpl, roomRouterImpl, actionRouterImpl, resourceRouterImpl, auditRouterImpl, publicRouterImpl, tokenServiceImpl, apiTokenServiceImpl, userServiceImpl)
+	mailer, err := smtp.NewMailer(smtpConfig, sugaredLogger)
+	if err != nil {
+		return nil, err
+	}
+	outboxWorker := smtp.NewOutboxWorker(sugaredLogger, mailOutboxRepositoryImpl, mailer)
+	server := NewServer(config, engine, restRouter, sugaredLogger, outboxWorker)
 	return server, nil
 }
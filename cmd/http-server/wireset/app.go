@@ -19,6 +19,8 @@ import (
 	"github.com/illa-family/builder-backend/api/router"
 	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/bundle"
+	"github.com/illa-family/builder-backend/pkg/state"
 
 	"github.com/google/wire"
 )
@@ -32,8 +34,22 @@ var AppWireSet = wire.NewSet(
 	wire.Bind(new(repository.SetStateRepository), new(*repository.SetStateRepositoryImpl)),
 	repository.NewAppRepositoryImpl,
 	wire.Bind(new(repository.AppRepository), new(*repository.AppRepositoryImpl)),
+	repository.NewAppVersionRepositoryImpl,
+	wire.Bind(new(repository.AppVersionRepository), new(*repository.AppVersionRepositoryImpl)),
+	repository.NewAppChannelRepositoryImpl,
+	wire.Bind(new(repository.AppChannelRepository), new(*repository.AppChannelRepositoryImpl)),
+	repository.NewAppPublishRepositoryImpl,
+	wire.Bind(new(repository.AppPublishRepository), new(*repository.AppPublishRepositoryImpl)),
+	repository.NewAppMemberRepositoryImpl,
+	wire.Bind(new(repository.AppMemberRepository), new(*repository.AppMemberRepositoryImpl)),
+	repository.NewUnitOfWorkImpl,
+	wire.Bind(new(repository.UnitOfWork), new(*repository.UnitOfWorkImpl)),
 	app.NewAppServiceImpl,
 	wire.Bind(new(app.AppService), new(*app.AppServiceImpl)),
+	state.NewTreeStateServiceImpl,
+	wire.Bind(new(bundle.ComponentTreeValidator), new(*state.TreeStateServiceImpl)),
+	bundle.NewBundleServiceImpl,
+	wire.Bind(new(bundle.BundleService), new(*bundle.BundleServiceImpl)),
 	resthandler.NewAppRestHandlerImpl,
 	wire.Bind(new(resthandler.AppRestHandler), new(*resthandler.AppRestHandlerImpl)),
 	router.NewAppRouterImpl,
@@ -0,0 +1,35 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireset
+
+import (
+	"github.com/illa-family/builder-backend/api/resthandler"
+	"github.com/illa-family/builder-backend/api/router"
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/audit"
+
+	"github.com/google/wire"
+)
+
+var AuditWireSet = wire.NewSet(
+	repository.NewAuditLogRepositoryImpl,
+	wire.Bind(new(repository.AuditLogRepository), new(*repository.AuditLogRepositoryImpl)),
+	audit.NewAuditServiceImpl,
+	wire.Bind(new(audit.AuditService), new(*audit.AuditServiceImpl)),
+	resthandler.NewAuditRestHandlerImpl,
+	wire.Bind(new(resthandler.AuditRestHandler), new(*resthandler.AuditRestHandlerImpl)),
+	router.NewAuditRouterImpl,
+	wire.Bind(new(router.AuditRouter), new(*router.AuditRouterImpl)),
+)
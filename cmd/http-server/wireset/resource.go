@@ -26,6 +26,8 @@ import (
 var ResourceWireSet = wire.NewSet(
 	repository.NewResourceRepositoryImpl,
 	wire.Bind(new(repository.ResourceRepository), new(*repository.ResourceRepositoryImpl)),
+	repository.NewResourceEnvironmentRepositoryImpl,
+	wire.Bind(new(repository.ResourceEnvironmentRepository), new(*repository.ResourceEnvironmentRepositoryImpl)),
 	resource.NewResourceServiceImpl,
 	wire.Bind(new(resource.ResourceService), new(*resource.ResourceServiceImpl)),
 	resthandler.NewResourceRestHandlerImpl,
@@ -26,6 +26,16 @@ import (
 var UserWireSet = wire.NewSet(
 	repository.NewUserRepositoryImpl,
 	wire.Bind(new(repository.UserRepository), new(*repository.UserRepositoryImpl)),
+	repository.NewRefreshTokenRepositoryImpl,
+	wire.Bind(new(repository.RefreshTokenRepository), new(*repository.RefreshTokenRepositoryImpl)),
+	repository.NewRevokedTokenRepositoryImpl,
+	wire.Bind(new(repository.RevokedTokenRepository), new(*repository.RevokedTokenRepositoryImpl)),
+	repository.NewAPITokenRepositoryImpl,
+	wire.Bind(new(repository.APITokenRepository), new(*repository.APITokenRepositoryImpl)),
+	user.NewAPITokenServiceImpl,
+	wire.Bind(new(user.APITokenService), new(*user.APITokenServiceImpl)),
+	user.NewTokenServiceImpl,
+	wire.Bind(new(user.TokenService), new(*user.TokenServiceImpl)),
 	user.NewUserServiceImpl,
 	wire.Bind(new(user.UserService), new(*user.UserServiceImpl)),
 	resthandler.NewUserRestHandlerImpl,
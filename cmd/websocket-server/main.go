@@ -20,15 +20,20 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/internal/util"
 	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/backplane"
 	"github.com/illa-family/builder-backend/pkg/db"
 	"github.com/illa-family/builder-backend/pkg/resource"
+	"github.com/illa-family/builder-backend/pkg/smtp"
 	"github.com/illa-family/builder-backend/pkg/state"
+	"github.com/illa-family/builder-backend/pkg/user"
 	filter "github.com/illa-family/builder-backend/pkg/websocket-filter"
 
 	gws "github.com/gorilla/websocket"
@@ -42,6 +47,12 @@ var kvssi *state.KVStateServiceImpl
 var sssi *state.SetStateServiceImpl
 var asi *app.AppServiceImpl
 var rsi *resource.ResourceServiceImpl
+var tsi *user.TokenServiceImpl
+var usi *user.UserServiceImpl
+var ausi *audit.AuditServiceImpl
+var bp backplane.Backplane
+var seq ws.Sequencer
+var uow repository.UnitOfWork
 
 func initEnv() error {
 	sugaredLogger := util.NewSugardLogger()
@@ -61,29 +72,78 @@ func initEnv() error {
 	resourceRepositoryImpl := repository.NewResourceRepositoryImpl(sugaredLogger, gormDB)
 	userRepositoryImpl := repository.NewUserRepositoryImpl(gormDB, sugaredLogger)
 	actionRepositoryImpl := repository.NewActionRepositoryImpl(sugaredLogger, gormDB)
+	appVersionRepositoryImpl := repository.NewAppVersionRepositoryImpl(sugaredLogger, gormDB)
+	appChannelRepositoryImpl := repository.NewAppChannelRepositoryImpl(sugaredLogger, gormDB)
+	appPublishRepositoryImpl := repository.NewAppPublishRepositoryImpl(sugaredLogger, gormDB)
+	appMemberRepositoryImpl := repository.NewAppMemberRepositoryImpl(sugaredLogger, gormDB)
+	resourceEnvironmentRepositoryImpl := repository.NewResourceEnvironmentRepositoryImpl(sugaredLogger, gormDB)
+	unitOfWorkImpl := repository.NewUnitOfWorkImpl(sugaredLogger, gormDB)
+	uow = unitOfWorkImpl
+	refreshTokenRepositoryImpl := repository.NewRefreshTokenRepositoryImpl(sugaredLogger, gormDB)
+	revokedTokenRepositoryImpl := repository.NewRevokedTokenRepositoryImpl(sugaredLogger, gormDB)
+	apiTokenRepositoryImpl := repository.NewAPITokenRepositoryImpl(sugaredLogger, gormDB)
+	auditLogRepositoryImpl := repository.NewAuditLogRepositoryImpl(sugaredLogger, gormDB)
+	smtpConfig, err := smtp.GetConfig()
+	if err != nil {
+		return err
+	}
+	mailOutboxRepositoryImpl := repository.NewMailOutboxRepositoryImpl(sugaredLogger, gormDB)
+	smtpServer := smtp.NewSMTPServer(smtpConfig, mailOutboxRepositoryImpl)
 	// init service
 	tssi = state.NewTreeStateServiceImpl(sugaredLogger, treestateRepositoryImpl)
 	kvssi = state.NewKVStateServiceImpl(sugaredLogger, kvstateRepositoryImpl)
 	sssi = state.NewSetStateServiceImpl(sugaredLogger, setstateRepositoryImpl)
-	asi = app.NewAppServiceImpl(sugaredLogger, appRepositoryImpl, userRepositoryImpl, kvstateRepositoryImpl, treestateRepositoryImpl, setstateRepositoryImpl, actionRepositoryImpl)
-	rsi = resource.NewResourceServiceImpl(sugaredLogger, resourceRepositoryImpl)
+	asi = app.NewAppServiceImpl(sugaredLogger, appRepositoryImpl, userRepositoryImpl, kvstateRepositoryImpl, treestateRepositoryImpl, setstateRepositoryImpl, actionRepositoryImpl, appVersionRepositoryImpl, appChannelRepositoryImpl, appPublishRepositoryImpl, appMemberRepositoryImpl, unitOfWorkImpl, smtpServer)
+	rsi = resource.NewResourceServiceImpl(sugaredLogger, resourceRepositoryImpl, resourceEnvironmentRepositoryImpl)
+	tsi = user.NewTokenServiceImpl(sugaredLogger, refreshTokenRepositoryImpl, revokedTokenRepositoryImpl, apiTokenRepositoryImpl)
+	usi = user.NewUserServiceImpl(userRepositoryImpl, sugaredLogger, smtpServer)
+	ausi = audit.NewAuditServiceImpl(sugaredLogger, auditLogRepositoryImpl)
+	// init backplane, the hubs of all instances are connected by it
+	backplaneConfig, err := backplane.GetConfig()
+	if err != nil {
+		return err
+	}
+	websocketEnvelopeRepositoryImpl := repository.NewWebsocketEnvelopeRepositoryImpl(sugaredLogger, gormDB)
+	seq = repository.NewWebsocketSequenceRepositoryImpl(sugaredLogger, gormDB)
+	bp, err = backplane.NewBackplane(backplaneConfig, sugaredLogger, dbConfig.DSN(), websocketEnvelopeRepositoryImpl)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 var dashboardHub *ws.Hub
 var appHub *ws.Hub
 
-func InitHub(asi *app.AppServiceImpl, rsi *resource.ResourceServiceImpl, tssi *state.TreeStateServiceImpl, kvssi *state.KVStateServiceImpl, sssi *state.SetStateServiceImpl) {
+// the topics of the hubs on the backplane
+const (
+	TOPIC_DASHBOARD = "dashboard"
+	TOPIC_APP       = "app"
+)
+
+func InitHub(asi *app.AppServiceImpl, rsi *resource.ResourceServiceImpl, tssi *state.TreeStateServiceImpl, kvssi *state.KVStateServiceImpl, sssi *state.SetStateServiceImpl, tsi *user.TokenServiceImpl, usi *user.UserServiceImpl, ausi *audit.AuditServiceImpl, bp backplane.Backplane, seq ws.Sequencer, uow repository.UnitOfWork) {
 	dashboardHub = ws.NewHub()
 	dashboardHub.SetAppServiceImpl(asi)
+	dashboardHub.SetTokenServiceImpl(tsi)
+	dashboardHub.SetUserServiceImpl(usi)
+	dashboardHub.SetAuditServiceImpl(ausi)
+	dashboardHub.SetBackplane(TOPIC_DASHBOARD, bp)
+	dashboardHub.SetSequencer(seq)
 	go filter.Run(dashboardHub)
 
 	// init APP websocket hub
 	appHub = ws.NewHub()
+	appHub.SetAppServiceImpl(asi)
 	appHub.SetResourceServiceImpl(rsi)
 	appHub.SetTreeStateServiceImpl(tssi)
 	appHub.SetKVStateServiceImpl(kvssi)
 	appHub.SetSetStateServiceImpl(sssi)
+	appHub.SetTokenServiceImpl(tsi)
+	appHub.SetUserServiceImpl(usi)
+	appHub.SetAuditServiceImpl(ausi)
+	appHub.SetBackplane(TOPIC_APP, bp)
+	appHub.SetSequencer(seq)
+	appHub.SetUnitOfWork(uow)
 	go filter.Run(appHub)
 }
 
@@ -113,6 +173,7 @@ func ServeWebsocket(hub *ws.Hub, w http.ResponseWriter, r *http.Request, instanc
 	}
 	client := ws.NewClient(hub, conn, instanceID, appID)
 	client.Hub.Register <- client
+	client.WatchAuthDeadline()
 
 	// Allow collection of memory referenced by the caller by doing all work in
 	// new goroutines.
@@ -120,13 +181,47 @@ func ServeWebsocket(hub *ws.Hub, w http.ResponseWriter, r *http.Request, instanc
 	go client.ReadPump()
 }
 
+// ServePresence lists the users editing the app on all server instances, only the editors of the app can see it.
+func ServePresence(hub *ws.Hub, w http.ResponseWriter, r *http.Request, appID int) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// the wildcard does not cover Authorization, the preflight has to list it
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errorCode": status, "errorMessage": message})
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	userID, err := hub.TokenServiceImpl.ValidateAccessToken(token)
+	if err != nil {
+		writeError(http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	editable, err := hub.AppServiceImpl.IsAppEditableByUser(appID, userID)
+	if err != nil {
+		writeError(http.StatusInternalServerError, "check app permission error: "+err.Error())
+		return
+	}
+	if !editable {
+		writeError(http.StatusForbidden, "you have no permission to edit this app")
+		return
+	}
+	json.NewEncoder(w).Encode(hub.Presence.UsersInApp(appID))
+}
+
 func main() {
 	addr := flag.String("addr", "127.0.0.1:8080", "websocket server serve address")
 	flag.Parse()
 
 	// init
-	initEnv()
-	InitHub(asi, rsi, tssi, kvssi, sssi)
+	if err := initEnv(); err != nil {
+		log.Fatalf("[START] init websocket service error: %v", err)
+	}
+	InitHub(asi, rsi, tssi, kvssi, sssi, tsi, usi, ausi, bp, seq, uow)
 
 	// listen and serve
 	r := mux.NewRouter()
@@ -150,6 +245,15 @@ func main() {
 		log.Printf("[Connected] /room/%s/app/%d", instanceID, appID)
 		ServeWebsocket(appHub, w, r, instanceID, appID)
 	})
+	// handle http://{ip:port}/room/{instanceID}/app/{appID}/presence
+	r.HandleFunc("/room/{instanceID}/app/{appID}/presence", func(w http.ResponseWriter, r *http.Request) {
+		appID, err := strconv.Atoi(mux.Vars(r)["appID"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ServePresence(appHub, w, r, appID)
+	}).Methods(http.MethodGet, http.MethodOptions)
 	srv := &http.Server{
 		Handler:      r,
 		Addr:         *addr,
@@ -33,6 +33,7 @@ type Action struct {
 	TriggerMode string    `gorm:"column:trigger_mode;type:varchar;size:16;not null"`
 	Transformer db.JSONB  `gorm:"column:transformer;type:jsonb"`
 	Template    db.JSONB  `gorm:"column:template;type:jsonb"`
+	Parameters  db.JSONB  `gorm:"column:parameters;type:jsonb"` // the inputs a run by id accepts, keyed by name
 	CreatedAt   time.Time `gorm:"column:created_at;type:timestamp;not null"`
 	CreatedBy   int       `gorm:"column:created_by;type:bigint;not null"`
 	UpdatedAt   time.Time `gorm:"column:updated_at;type:timestamp;not null"`
@@ -46,6 +47,7 @@ type ActionRepository interface {
 	RetrieveByID(id int) (*Action, error)
 	RetrieveActionsByAppVersion(app, version int) ([]*Action, error)
 	DeleteActionsByApp(appID int) error
+	DeleteActionsByAppVersion(appID, version int) error
 }
 
 type ActionRepositoryImpl struct {
@@ -82,6 +84,7 @@ func (impl *ActionRepositoryImpl) Update(action *Action) error {
 		TriggerMode: action.TriggerMode,
 		Transformer: action.Transformer,
 		Template:    action.Template,
+		Parameters:  action.Parameters,
 		UpdatedBy:   action.UpdatedBy,
 		UpdatedAt:   action.UpdatedAt,
 	}).Error; err != nil {
@@ -112,3 +115,10 @@ func (impl *ActionRepositoryImpl) DeleteActionsByApp(appID int) error {
 	}
 	return nil
 }
+
+func (impl *ActionRepositoryImpl) DeleteActionsByAppVersion(appID, version int) error {
+	if err := impl.db.Where("app_ref_id = ? AND version = ?", appID, version).Delete(&Action{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// APIToken is a personal token for automation, only the digest of the token is stored.
+type APIToken struct {
+	ID          int        `gorm:"column:id;type:bigserial;primary_key"`
+	UserID      int        `gorm:"column:user_id;type:bigint;not null"`
+	Name        string     `gorm:"column:name;type:varchar;size:64;not null"`
+	TokenDigest string     `gorm:"column:token_digest;type:varchar;size:64;not null;unique"`
+	TokenHint   string     `gorm:"column:token_hint;type:varchar;size:16;not null"`
+	Scopes      string     `gorm:"column:scopes;type:varchar;size:64;not null"`
+	Revoked     bool       `gorm:"column:revoked;type:boolean;default:false;not null"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at;type:timestamp"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at;type:timestamp"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:timestamp;not null"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type APITokenRepository interface {
+	Create(apiToken *APIToken) (int, error)
+	RetrieveByDigest(digest string) (*APIToken, error)
+	RetrieveActiveByUser(userID int) ([]*APIToken, error)
+	RevokeByID(userID, id int) (int64, error)
+	RevokeAllByUser(userID int) error
+	UpdateLastUsedAt(id int, lastUsedAt time.Time) error
+}
+
+type APITokenRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAPITokenRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *APITokenRepositoryImpl {
+	return &APITokenRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *APITokenRepositoryImpl) Create(apiToken *APIToken) (int, error) {
+	if err := impl.db.Create(apiToken).Error; err != nil {
+		return 0, err
+	}
+	return apiToken.ID, nil
+}
+
+func (impl *APITokenRepositoryImpl) RetrieveByDigest(digest string) (*APIToken, error) {
+	apiToken := &APIToken{}
+	if err := impl.db.Where("token_digest = ?", digest).First(apiToken).Error; err != nil {
+		return &APIToken{}, err
+	}
+	return apiToken, nil
+}
+
+func (impl *APITokenRepositoryImpl) RetrieveActiveByUser(userID int) ([]*APIToken, error) {
+	var apiTokens []*APIToken
+	if err := impl.db.Where("user_id = ? AND revoked = ?", userID, false).Order("id").Find(&apiTokens).Error; err != nil {
+		return nil, err
+	}
+	return apiTokens, nil
+}
+
+// RevokeByID revokes the token owned by the user, returns the number of revoked tokens.
+func (impl *APITokenRepositoryImpl) RevokeByID(userID, id int) (int64, error) {
+	result := impl.db.Model(&APIToken{}).Where("id = ? AND user_id = ? AND revoked = ?", id, userID, false).Updates(map[string]interface{}{
+		"revoked":    true,
+		"updated_at": time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func (impl *APITokenRepositoryImpl) RevokeAllByUser(userID int) error {
+	if err := impl.db.Model(&APIToken{}).Where("user_id = ? AND revoked = ?", userID, false).Updates(map[string]interface{}{
+		"revoked":    true,
+		"updated_at": time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *APITokenRepositoryImpl) UpdateLastUsedAt(id int, lastUsedAt time.Time) error {
+	if err := impl.db.Model(&APIToken{}).Where("id = ?", id).UpdateColumn("last_used_at", lastUsedAt).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const APP_EDIT_VERSION = 0 // the editable version app ID always be 0
@@ -40,6 +41,7 @@ type AppRepository interface {
 	Update(app *App) error
 	RetrieveAll() ([]*App, error)
 	RetrieveAppByID(appID int) (*App, error)
+	RetrieveAppByIDForUpdate(appID int) (*App, error)
 }
 
 type AppRepositoryImpl struct {
@@ -96,3 +98,12 @@ func (impl *AppRepositoryImpl) RetrieveAppByID(id int) (*App, error) {
 	}
 	return app, nil
 }
+
+// RetrieveAppByIDForUpdate locks the app row until the transaction ends, so concurrent releases are serialized.
+func (impl *AppRepositoryImpl) RetrieveAppByIDForUpdate(id int) (*App, error) {
+	var app *App
+	if err := impl.db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Find(&app).Error; err != nil {
+		return nil, err
+	}
+	return app, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A release channel points to a released version of an app, its name is also the environment
+// the resources of the app run in.
+const (
+	CHANNEL_DEV        = "dev"
+	CHANNEL_STAGING    = "staging"
+	CHANNEL_PRODUCTION = "production" // the production channel is App.ReleaseVersion
+)
+
+// CHANNELS are ordered, a release is promoted from a channel to a later one.
+var CHANNELS = []string{CHANNEL_DEV, CHANNEL_STAGING, CHANNEL_PRODUCTION}
+
+func ChannelRank(channel string) int {
+	for i, name := range CHANNELS {
+		if name == channel {
+			return i
+		}
+	}
+	return -1
+}
+
+func IsValidChannel(channel string) bool {
+	return ChannelRank(channel) >= 0
+}
+
+type AppChannel struct {
+	ID        int       `gorm:"column:id;type:bigserial;primary_key"`
+	AppRefID  int       `gorm:"column:app_ref_id;type:bigint;not null;uniqueIndex:idx_app_channels_app_name"`
+	Name      string    `gorm:"column:name;type:varchar;size:32;not null;uniqueIndex:idx_app_channels_app_name"`
+	Version   int       `gorm:"column:version;type:bigint;not null"`
+	UpdatedBy int       `gorm:"column:updated_by;type:bigint;not null"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type AppChannelRepository interface {
+	Upsert(appChannel *AppChannel) error
+	RetrieveByApp(appID int) ([]*AppChannel, error)
+	DeleteByApp(appID int) error
+}
+
+type AppChannelRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAppChannelRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *AppChannelRepositoryImpl {
+	return &AppChannelRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *AppChannelRepositoryImpl) Upsert(appChannel *AppChannel) error {
+	if err := impl.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "app_ref_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"version", "updated_by", "updated_at"}),
+	}).Create(appChannel).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *AppChannelRepositoryImpl) RetrieveByApp(appID int) ([]*AppChannel, error) {
+	var appChannels []*AppChannel
+	if err := impl.db.Where("app_ref_id = ?", appID).Find(&appChannels).Error; err != nil {
+		return nil, err
+	}
+	return appChannels, nil
+}
+
+func (impl *AppChannelRepositoryImpl) DeleteByApp(appID int) error {
+	if err := impl.db.Where("app_ref_id = ?", appID).Delete(&AppChannel{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
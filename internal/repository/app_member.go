@@ -0,0 +1,105 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the roles of the members of an app, the creator of an app owns it without a member record.
+const (
+	APP_ROLE_VIEWER = 1
+	APP_ROLE_EDITOR = 2
+)
+
+// AppMember grants a user a role on an app.
+type AppMember struct {
+	ID        int       `gorm:"column:id;type:bigserial;primary_key"`
+	AppRefID  int       `gorm:"column:app_ref_id;type:bigint;not null;uniqueIndex:idx_app_members_app_user"`
+	UserID    int       `gorm:"column:user_id;type:bigint;not null;uniqueIndex:idx_app_members_app_user;index"`
+	Role      int       `gorm:"column:role;type:smallint;not null"`
+	CreatedBy int       `gorm:"column:created_by;type:bigint;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamp;not null"`
+	UpdatedBy int       `gorm:"column:updated_by;type:bigint;not null"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type AppMemberRepository interface {
+	Upsert(appMember *AppMember) error
+	RetrieveByAppAndUser(appID, userID int) (*AppMember, error)
+	RetrieveByApp(appID int) ([]*AppMember, error)
+	Delete(appID, userID int) error
+	DeleteByApp(appID int) error
+}
+
+type AppMemberRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAppMemberRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *AppMemberRepositoryImpl {
+	return &AppMemberRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *AppMemberRepositoryImpl) Upsert(appMember *AppMember) error {
+	if err := impl.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "app_ref_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_by", "updated_at"}),
+	}).Create(appMember).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrieveByAppAndUser returns nil when the user is not a member of the app.
+func (impl *AppMemberRepositoryImpl) RetrieveByAppAndUser(appID, userID int) (*AppMember, error) {
+	var appMembers []*AppMember
+	if err := impl.db.Where("app_ref_id = ? AND user_id = ?", appID, userID).Limit(1).Find(&appMembers).Error; err != nil {
+		return nil, err
+	}
+	if len(appMembers) == 0 {
+		return nil, nil
+	}
+	return appMembers[0], nil
+}
+
+func (impl *AppMemberRepositoryImpl) RetrieveByApp(appID int) ([]*AppMember, error) {
+	var appMembers []*AppMember
+	if err := impl.db.Where("app_ref_id = ?", appID).Order("id").Find(&appMembers).Error; err != nil {
+		return nil, err
+	}
+	return appMembers, nil
+}
+
+func (impl *AppMemberRepositoryImpl) Delete(appID, userID int) error {
+	if err := impl.db.Where("app_ref_id = ? AND user_id = ?", appID, userID).Delete(&AppMember{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *AppMemberRepositoryImpl) DeleteByApp(appID int) error {
+	if err := impl.db.Where("app_ref_id = ?", appID).Delete(&AppMember{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
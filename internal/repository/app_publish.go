@@ -0,0 +1,99 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AppPublish exposes the released version of an app at a public slug.
+type AppPublish struct {
+	ID           int       `gorm:"column:id;type:bigserial;primary_key"`
+	AppRefID     int       `gorm:"column:app_ref_id;type:bigint;not null;uniqueIndex"`
+	Slug         string    `gorm:"column:slug;type:varchar;size:64;not null;uniqueIndex"`
+	Enabled      bool      `gorm:"column:enabled;type:boolean;not null"`
+	PasswordHash string    `gorm:"column:password_hash;type:varchar;size:255;not null"` // empty when not password protected
+	AllowEmbed   bool      `gorm:"column:allow_embed;type:boolean;not null"`
+	EmbedOrigins string    `gorm:"column:embed_origins;type:text;not null"` // space separated, empty allows every origin
+	CreatedBy    int       `gorm:"column:created_by;type:bigint;not null"`
+	CreatedAt    time.Time `gorm:"column:created_at;type:timestamp;not null"`
+	UpdatedBy    int       `gorm:"column:updated_by;type:bigint;not null"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type AppPublishRepository interface {
+	Upsert(appPublish *AppPublish) error
+	RetrieveByApp(appID int) (*AppPublish, error)
+	RetrieveBySlug(slug string) (*AppPublish, error)
+	DeleteByApp(appID int) error
+}
+
+type AppPublishRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAppPublishRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *AppPublishRepositoryImpl {
+	return &AppPublishRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *AppPublishRepositoryImpl) Upsert(appPublish *AppPublish) error {
+	if err := impl.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "app_ref_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"slug", "enabled", "password_hash", "allow_embed", "embed_origins",
+			"updated_by", "updated_at"}),
+	}).Create(appPublish).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// RetrieveByApp returns nil when the app was never published.
+func (impl *AppPublishRepositoryImpl) RetrieveByApp(appID int) (*AppPublish, error) {
+	var appPublishes []*AppPublish
+	if err := impl.db.Where("app_ref_id = ?", appID).Limit(1).Find(&appPublishes).Error; err != nil {
+		return nil, err
+	}
+	if len(appPublishes) == 0 {
+		return nil, nil
+	}
+	return appPublishes[0], nil
+}
+
+// RetrieveBySlug returns nil when no app uses the slug.
+func (impl *AppPublishRepositoryImpl) RetrieveBySlug(slug string) (*AppPublish, error) {
+	var appPublishes []*AppPublish
+	if err := impl.db.Where("slug = ?", slug).Limit(1).Find(&appPublishes).Error; err != nil {
+		return nil, err
+	}
+	if len(appPublishes) == 0 {
+		return nil, nil
+	}
+	return appPublishes[0], nil
+}
+
+func (impl *AppPublishRepositoryImpl) DeleteByApp(appID int) error {
+	if err := impl.db.Where("app_ref_id = ?", appID).Delete(&AppPublish{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
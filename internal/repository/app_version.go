@@ -0,0 +1,73 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AppVersion records who released a version of an app and why.
+type AppVersion struct {
+	ID        int       `gorm:"column:id;type:bigserial;primary_key"`
+	AppRefID  int       `gorm:"column:app_ref_id;type:bigint;not null;uniqueIndex:idx_app_versions_app_version"`
+	Version   int       `gorm:"column:version;type:bigint;not null;uniqueIndex:idx_app_versions_app_version"`
+	Notes     string    `gorm:"column:notes;type:text;default:'';not null"`
+	CreatedBy int       `gorm:"column:created_by;type:bigint;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamp;not null"`
+}
+
+type AppVersionRepository interface {
+	Create(appVersion *AppVersion) (int, error)
+	RetrieveByApp(appID int) ([]*AppVersion, error)
+	DeleteByApp(appID int) error
+}
+
+type AppVersionRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAppVersionRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *AppVersionRepositoryImpl {
+	return &AppVersionRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *AppVersionRepositoryImpl) Create(appVersion *AppVersion) (int, error) {
+	if err := impl.db.Create(appVersion).Error; err != nil {
+		return 0, err
+	}
+	return appVersion.ID, nil
+}
+
+// RetrieveByApp returns the released versions of the app from newest to oldest.
+func (impl *AppVersionRepositoryImpl) RetrieveByApp(appID int) ([]*AppVersion, error) {
+	var appVersions []*AppVersion
+	if err := impl.db.Where("app_ref_id = ?", appID).Order("version DESC").Find(&appVersions).Error; err != nil {
+		return nil, err
+	}
+	return appVersions, nil
+}
+
+func (impl *AppVersionRepositoryImpl) DeleteByApp(appID int) error {
+	if err := impl.db.Where("app_ref_id = ?", appID).Delete(&AppVersion{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
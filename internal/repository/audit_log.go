@@ -0,0 +1,114 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// AuditLog is append-only, the repository never updates or deletes a record.
+type AuditLog struct {
+	ID         int       `gorm:"column:id;type:bigserial;primary_key"`
+	ActorID    int       `gorm:"column:actor_id;type:bigint;not null;index"`
+	ActorIP    string    `gorm:"column:actor_ip;type:varchar;size:64;default:'';not null"`
+	Source     string    `gorm:"column:source;type:varchar;size:16;not null"`
+	Operation  string    `gorm:"column:operation;type:varchar;size:32;not null"`
+	TargetType string    `gorm:"column:target_type;type:varchar;size:32;not null;index:idx_audit_logs_target"`
+	TargetID   int       `gorm:"column:target_id;type:bigint;default:0;not null;index:idx_audit_logs_target"`
+	TargetName string    `gorm:"column:target_name;type:varchar;size:255;default:'';not null"`
+	AppID      int       `gorm:"column:app_id;type:bigint;default:0;not null;index"`
+	Before     *string   `gorm:"column:before;type:jsonb"` // NULL when there is no snapshot
+	After      *string   `gorm:"column:after;type:jsonb"`
+	Changes    string    `gorm:"column:changes;type:text;default:'';not null"`
+	CreatedAt  time.Time `gorm:"column:created_at;type:timestamp;not null;index"`
+}
+
+type AuditLogFilter struct {
+	ActorID    int
+	Source     string
+	Operation  string
+	TargetType string
+	TargetID   int
+	AppID      int
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+	Offset     int
+}
+
+type AuditLogRepository interface {
+	Create(auditLog *AuditLog) (int, error)
+	RetrieveByFilter(filter *AuditLogFilter) ([]*AuditLog, int64, error)
+}
+
+type AuditLogRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewAuditLogRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *AuditLogRepositoryImpl {
+	return &AuditLogRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *AuditLogRepositoryImpl) Create(auditLog *AuditLog) (int, error) {
+	if err := impl.db.Create(auditLog).Error; err != nil {
+		return 0, err
+	}
+	return auditLog.ID, nil
+}
+
+// RetrieveByFilter returns the matched records from newest to oldest, and the total count.
+func (impl *AuditLogRepositoryImpl) RetrieveByFilter(filter *AuditLogFilter) ([]*AuditLog, int64, error) {
+	query := impl.db.Model(&AuditLog{})
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.Operation != "" {
+		query = query.Where("operation = ?", filter.Operation)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.AppID != 0 {
+		query = query.Where("app_id = ?", filter.AppID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var auditLogs []*AuditLog
+	if err := query.Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&auditLogs).Error; err != nil {
+		return nil, 0, err
+	}
+	return auditLogs, total, nil
+}
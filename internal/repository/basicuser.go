@@ -28,6 +28,11 @@ type User struct {
 	Email          string    `gorm:"column:email;type:varchar;size:255;not null"`
 	Language       int       `gorm:"column:language;type:smallint;not null"`
 	IsSubscribed   bool      `gorm:"column:is_subscribed;type:boolean;default:false;not null"`
+	TOTPSecret     string    `gorm:"column:totp_secret;type:varchar;size:64;default:'';not null"`
+	TOTPEnabled    bool      `gorm:"column:totp_enabled;type:boolean;default:false;not null"`
+	RecoveryCodes  string    `gorm:"column:recovery_codes;type:text;default:'';not null"`
+	TOTPLastStep   int64     `gorm:"column:totp_last_step;type:bigint;default:0;not null"`
+	IsAdmin        bool      `gorm:"column:is_admin;type:boolean;default:false;not null"`
 	CreatedAt      time.Time `gorm:"column:created_at;type:timestamp"`
 	UpdatedAt      time.Time `gorm:"column:updated_at;type:timestamp"`
 }
@@ -37,6 +42,9 @@ type UserRepository interface {
 	UpdateUser(user *User) error
 	FetchUserByEmail(email string) (*User, error)
 	RetrieveByID(id int) (*User, error)
+	UpdateTOTP(id int, secret string, enabled bool, recoveryCodes string) error
+	AcceptTOTPStep(id int, step int64) (int64, error)
+	ConsumeRecoveryCodes(id int, before, after string) (int64, error)
 }
 
 type UserRepositoryImpl struct {
@@ -82,3 +90,40 @@ func (impl *UserRepositoryImpl) RetrieveByID(id int) (*User, error) {
 	}
 	return user, nil
 }
+
+// UpdateTOTP updates the two-factor fields, the zero values are written as well.
+func (impl *UserRepositoryImpl) UpdateTOTP(id int, secret string, enabled bool, recoveryCodes string) error {
+	if err := impl.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_enabled":   enabled,
+		"recovery_codes": recoveryCodes,
+		"totp_last_step": 0,
+		"updated_at":     time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// AcceptTOTPStep records the time step of the accepted code, it returns 0 when a code of this step or
+// a later one was already accepted.
+func (impl *UserRepositoryImpl) AcceptTOTPStep(id int, step int64) (int64, error) {
+	result := impl.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", id, step).Update("totp_last_step", step)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// ConsumeRecoveryCodes replaces the recovery codes only if they were not changed since they were read,
+// it returns 0 when another request changed them meanwhile.
+func (impl *UserRepositoryImpl) ConsumeRecoveryCodes(id int, before, after string) (int64, error) {
+	result := impl.db.Model(&User{}).Where("id = ? AND recovery_codes = ?", id, before).Updates(map[string]interface{}{
+		"recovery_codes": after,
+		"updated_at":     time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
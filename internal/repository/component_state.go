@@ -22,6 +22,11 @@ import (
 	"strconv"
 )
 
+// the container types of the components, a dot panel is a canvas the components are laid out on, and a
+// scale square is a component laid out on a canvas.
+const COMPONENT_CONTAINER_TYPE_DOT_PANEL = "EDITOR_DOT_PANEL"
+const COMPONENT_CONTAINER_TYPE_SCALE_SQUARE = "EDITOR_SCALE_SQUARE"
+
 type ComponentNode struct {
 	DisplayName    string                 `json:"displayName"`
 	ParentNode     string                 `json:"parentNode"`
@@ -28,6 +28,7 @@ type KVState struct {
 	Version   int       `json:"version"    gorm:"column:version;type:bigint"`
 	Key       string    `json:"key" 	   gorm:"column:key;type:text"`
 	Value     string    `json:"value" 	   gorm:"column:value;type:jsonb"`
+	Revision  int       `json:"revision"   gorm:"column:revision;type:bigint;not null;default:0"`
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;type:timestamp"`
 	CreatedBy int       `json:"created_by" gorm:"column:created_by;type:bigint"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at;type:timestamp"`
@@ -46,6 +47,7 @@ type KVStateRepository interface {
 	RetrieveAllTypeKVStatesByApp(apprefid int, version int) ([]*KVState, error)
 	DeleteAllTypeKVStatesByApp(apprefid int) error
 	DeleteAllKVStatesByAppVersionAndType(apprefid int, version int, stateType int) error
+	DeleteAllTypeKVStatesByAppVersion(apprefid int, version int) error
 }
 
 type KVStateRepositoryImpl struct {
@@ -74,19 +76,27 @@ func (impl *KVStateRepositoryImpl) Delete(kvstateID int) error {
 	return nil
 }
 
+// Update writes the k-v state if it was not changed since it was read at kvstate.Revision,
+// the revision is increased on every update.
 func (impl *KVStateRepositoryImpl) Update(kvstate *KVState) error {
-	if err := impl.db.Model(kvstate).Updates(KVState{
+	result := impl.db.Model(kvstate).Where("revision = ?", kvstate.Revision).Updates(KVState{
 		ID:        kvstate.ID,
 		StateType: kvstate.StateType,
 		AppRefID:  kvstate.AppRefID,
 		Version:   kvstate.Version,
 		Key:       kvstate.Key,
 		Value:     kvstate.Value,
+		Revision:  kvstate.Revision + 1,
 		UpdatedAt: kvstate.UpdatedAt,
 		UpdatedBy: kvstate.UpdatedBy,
-	}).Error; err != nil {
-		return err
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRevisionConflict
 	}
+	kvstate.Revision++
 	return nil
 }
 
@@ -151,3 +161,10 @@ func (impl *KVStateRepositoryImpl) DeleteAllKVStatesByAppVersionAndType(apprefid
 	}
 	return nil
 }
+
+func (impl *KVStateRepositoryImpl) DeleteAllTypeKVStatesByAppVersion(apprefid int, version int) error {
+	if err := impl.db.Where("app_ref_id = ? AND version = ?", apprefid, version).Delete(&KVState{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	MAIL_STATUS_PENDING = "pending"
+	MAIL_STATUS_SENT    = "sent"
+	MAIL_STATUS_FAILED  = "failed"
+)
+
+// MailOutbox keeps the encoded mail until it is delivered by the outbox worker.
+type MailOutbox struct {
+	ID            int        `gorm:"column:id;type:bigserial;primary_key"`
+	Sender        string     `gorm:"column:sender;type:varchar;size:255;not null"`
+	Recipient     string     `gorm:"column:recipient;type:varchar;size:255;not null"`
+	Kind          string     `gorm:"column:kind;type:varchar;size:32;not null"`
+	Message       string     `gorm:"column:message;type:text;not null"`
+	Status        string     `gorm:"column:status;type:varchar;size:16;not null;index"`
+	Attempts      int        `gorm:"column:attempts;type:integer;default:0;not null"`
+	LastError     string     `gorm:"column:last_error;type:text;default:'';not null"`
+	NextAttemptAt time.Time  `gorm:"column:next_attempt_at;type:timestamp;not null;index"`
+	SentAt        *time.Time `gorm:"column:sent_at;type:timestamp"`
+	CreatedAt     time.Time  `gorm:"column:created_at;type:timestamp;not null"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type MailOutboxRepository interface {
+	Create(mail *MailOutbox) (int, error)
+	RetrieveDue(now time.Time, limit int) ([]*MailOutbox, error)
+	Claim(id int, now, leaseUntil time.Time) (bool, error)
+	MarkSent(id int, sentAt time.Time) error
+	MarkRetry(id, attempts int, nextAttemptAt time.Time, lastError string) error
+	MarkFailed(id, attempts int, lastError string) error
+}
+
+type MailOutboxRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewMailOutboxRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *MailOutboxRepositoryImpl {
+	return &MailOutboxRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *MailOutboxRepositoryImpl) Create(mail *MailOutbox) (int, error) {
+	if err := impl.db.Create(mail).Error; err != nil {
+		return 0, err
+	}
+	return mail.ID, nil
+}
+
+func (impl *MailOutboxRepositoryImpl) RetrieveDue(now time.Time, limit int) ([]*MailOutbox, error) {
+	var mails []*MailOutbox
+	if err := impl.db.Where("status = ? AND next_attempt_at <= ?", MAIL_STATUS_PENDING, now).Order("next_attempt_at").Limit(limit).Find(&mails).Error; err != nil {
+		return nil, err
+	}
+	return mails, nil
+}
+
+// Claim leases the mail to the caller, the mail is retried by others when the lease expired.
+func (impl *MailOutboxRepositoryImpl) Claim(id int, now, leaseUntil time.Time) (bool, error) {
+	result := impl.db.Model(&MailOutbox{}).Where("id = ? AND status = ? AND next_attempt_at <= ?", id, MAIL_STATUS_PENDING, now).Update("next_attempt_at", leaseUntil)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (impl *MailOutboxRepositoryImpl) MarkSent(id int, sentAt time.Time) error {
+	if err := impl.db.Model(&MailOutbox{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     MAIL_STATUS_SENT,
+		"sent_at":    sentAt,
+		"updated_at": time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *MailOutboxRepositoryImpl) MarkRetry(id, attempts int, nextAttemptAt time.Time, lastError string) error {
+	if err := impl.db.Model(&MailOutbox{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+		"updated_at":      time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *MailOutboxRepositoryImpl) MarkFailed(id, attempts int, lastError string) error {
+	if err := impl.db.Model(&MailOutbox{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     MAIL_STATUS_FAILED,
+		"attempts":   attempts,
+		"last_error": lastError,
+		"updated_at": time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RefreshToken storage the digest of refresh token, all refresh tokens rotated from
+// one sign in share the same SessionID.
+type RefreshToken struct {
+	ID          int       `gorm:"column:id;type:bigserial;primary_key"`
+	UserID      int       `gorm:"column:user_id;type:bigint;not null"`
+	SessionID   string    `gorm:"column:session_id;type:varchar;size:36;not null"`
+	TokenDigest string    `gorm:"column:token_digest;type:varchar;size:64;not null;unique"`
+	Revoked     bool      `gorm:"column:revoked;type:boolean;default:false;not null"`
+	ExpiresAt   time.Time `gorm:"column:expires_at;type:timestamp;not null"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:timestamp;not null"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;type:timestamp;not null"`
+}
+
+type RefreshTokenRepository interface {
+	Create(refreshToken *RefreshToken) (int, error)
+	RetrieveByDigest(digest string) (*RefreshToken, error)
+	RetrieveActiveSessionsByUser(userID int) ([]string, error)
+	RevokeByID(id int) (int64, error)
+	RevokeBySession(sessionID string) error
+	RevokeAllByUser(userID int) error
+}
+
+type RefreshTokenRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewRefreshTokenRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *RefreshTokenRepositoryImpl {
+	return &RefreshTokenRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *RefreshTokenRepositoryImpl) Create(refreshToken *RefreshToken) (int, error) {
+	if err := impl.db.Create(refreshToken).Error; err != nil {
+		return 0, err
+	}
+	return refreshToken.ID, nil
+}
+
+func (impl *RefreshTokenRepositoryImpl) RetrieveByDigest(digest string) (*RefreshToken, error) {
+	refreshToken := &RefreshToken{}
+	if err := impl.db.Where("token_digest = ?", digest).First(refreshToken).Error; err != nil {
+		return &RefreshToken{}, err
+	}
+	return refreshToken, nil
+}
+
+func (impl *RefreshTokenRepositoryImpl) RetrieveActiveSessionsByUser(userID int) ([]string, error) {
+	var sessionIDs []string
+	if err := impl.db.Model(&RefreshToken{}).Where("user_id = ? AND revoked = ?", userID, false).Distinct().Pluck("session_id", &sessionIDs).Error; err != nil {
+		return nil, err
+	}
+	return sessionIDs, nil
+}
+
+// RevokeByID revokes the token if it is not revoked yet, returns the number of revoked tokens.
+func (impl *RefreshTokenRepositoryImpl) RevokeByID(id int) (int64, error) {
+	result := impl.db.Model(&RefreshToken{}).Where("id = ? AND revoked = ?", id, false).Updates(map[string]interface{}{
+		"revoked":    true,
+		"updated_at": time.Now().UTC(),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func (impl *RefreshTokenRepositoryImpl) RevokeBySession(sessionID string) error {
+	if err := impl.db.Model(&RefreshToken{}).Where("session_id = ? AND revoked = ?", sessionID, false).Updates(map[string]interface{}{
+		"revoked":    true,
+		"updated_at": time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *RefreshTokenRepositoryImpl) RevokeAllByUser(userID int) error {
+	if err := impl.db.Model(&RefreshToken{}).Where("user_id = ? AND revoked = ?", userID, false).Updates(map[string]interface{}{
+		"revoked":    true,
+		"updated_at": time.Now().UTC(),
+	}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"github.com/illa-family/builder-backend/pkg/db"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ResourceEnvironment overrides the options of a resource when an app runs in the environment.
+type ResourceEnvironment struct {
+	ID            int       `gorm:"column:id;type:bigserial;primary_key"`
+	ResourceRefID int       `gorm:"column:resource_ref_id;type:bigint;not null;uniqueIndex:idx_resource_environments_resource_env"`
+	Environment   string    `gorm:"column:environment;type:varchar;size:32;not null;uniqueIndex:idx_resource_environments_resource_env"`
+	Options       db.JSONB  `gorm:"column:options;type:jsonb"`
+	CreatedAt     time.Time `gorm:"column:created_at;type:timestamp;not null"`
+	CreatedBy     int       `gorm:"column:created_by;type:bigint;not null"`
+	UpdatedAt     time.Time `gorm:"column:updated_at;type:timestamp;not null"`
+	UpdatedBy     int       `gorm:"column:updated_by;type:bigint;not null"`
+}
+
+// Override returns the resource options with the top-level fields of the environment replaced.
+func (env *ResourceEnvironment) Override(options db.JSONB) db.JSONB {
+	merged := make(db.JSONB, len(options)+len(env.Options))
+	for k, v := range options {
+		merged[k] = v
+	}
+	for k, v := range env.Options {
+		merged[k] = v
+	}
+	return merged
+}
+
+type ResourceEnvironmentRepository interface {
+	Upsert(env *ResourceEnvironment) error
+	RetrieveByResource(resourceID int) ([]*ResourceEnvironment, error)
+	RetrieveByResourceAndEnvironment(resourceID int, environment string) (*ResourceEnvironment, error)
+	Delete(resourceID int, environment string) (int64, error)
+	DeleteByResource(resourceID int) error
+}
+
+type ResourceEnvironmentRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewResourceEnvironmentRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *ResourceEnvironmentRepositoryImpl {
+	return &ResourceEnvironmentRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *ResourceEnvironmentRepositoryImpl) Upsert(env *ResourceEnvironment) error {
+	if err := impl.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "resource_ref_id"}, {Name: "environment"}},
+		DoUpdates: clause.AssignmentColumns([]string{"options", "updated_by", "updated_at"}),
+	}).Create(env).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *ResourceEnvironmentRepositoryImpl) RetrieveByResource(resourceID int) ([]*ResourceEnvironment, error) {
+	var envs []*ResourceEnvironment
+	if err := impl.db.Where("resource_ref_id = ?", resourceID).Order("id").Find(&envs).Error; err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// RetrieveByResourceAndEnvironment returns nil when the resource is not overridden in the environment.
+func (impl *ResourceEnvironmentRepositoryImpl) RetrieveByResourceAndEnvironment(resourceID int, environment string) (*ResourceEnvironment, error) {
+	var envs []*ResourceEnvironment
+	if err := impl.db.Where("resource_ref_id = ? AND environment = ?", resourceID, environment).Limit(1).Find(&envs).Error; err != nil {
+		return nil, err
+	}
+	if len(envs) == 0 {
+		return nil, nil
+	}
+	return envs[0], nil
+}
+
+func (impl *ResourceEnvironmentRepositoryImpl) Delete(resourceID int, environment string) (int64, error) {
+	result := impl.db.Where("resource_ref_id = ? AND environment = ?", resourceID, environment).Delete(&ResourceEnvironment{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func (impl *ResourceEnvironmentRepositoryImpl) DeleteByResource(resourceID int) error {
+	if err := impl.db.Where("resource_ref_id = ?", resourceID).Delete(&ResourceEnvironment{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
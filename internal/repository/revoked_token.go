@@ -0,0 +1,75 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RevokedToken is the revocation list of access tokens, the TokenID can be
+// the token ID (jti) of an access token or the session ID of a sign in.
+type RevokedToken struct {
+	ID        int       `gorm:"column:id;type:bigserial;primary_key"`
+	TokenID   string    `gorm:"column:token_id;type:varchar;size:36;not null"`
+	UserID    int       `gorm:"column:user_id;type:bigint;not null"`
+	ExpiresAt time.Time `gorm:"column:expires_at;type:timestamp;not null"` // the record can be purged after this time
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamp;not null"`
+}
+
+type RevokedTokenRepository interface {
+	Create(revokedToken *RevokedToken) error
+	IsRevoked(tokenIDs []string) (bool, error)
+	DeleteExpired() error
+}
+
+type RevokedTokenRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewRevokedTokenRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *RevokedTokenRepositoryImpl {
+	return &RevokedTokenRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *RevokedTokenRepositoryImpl) Create(revokedToken *RevokedToken) error {
+	if err := impl.db.Create(revokedToken).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *RevokedTokenRepositoryImpl) IsRevoked(tokenIDs []string) (bool, error) {
+	if len(tokenIDs) == 0 {
+		return false, nil
+	}
+	var count int64
+	if err := impl.db.Model(&RevokedToken{}).Where("token_id IN ?", tokenIDs).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (impl *RevokedTokenRepositoryImpl) DeleteExpired() error {
+	if err := impl.db.Where("expires_at < ?", time.Now().UTC()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ type SetState struct {
 	AppRefID  int       `json:"app_ref_id" gorm:"column:app_ref_id;type:bigint"`
 	Version   int       `json:"version"    gorm:"column:version;type:bigint"`
 	Value     string    `json:"value" 	   gorm:"column:value;type:text"`
+	Revision  int       `json:"revision"   gorm:"column:revision;type:bigint;not null;default:0"`
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;type:timestamp"`
 	CreatedBy int       `json:"created_by" gorm:"column:created_by;type:bigint"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at;type:timestamp"`
@@ -45,6 +46,7 @@ type SetStateRepository interface {
 	RetrieveByValue(setState *SetState) (*SetState, error)
 	RetrieveSetStatesByApp(apprefid int, statetype int, version int) ([]*SetState, error)
 	DeleteAllTypeSetStatesByApp(apprefid int) error
+	DeleteAllTypeSetStatesByAppVersion(apprefid int, version int) error
 }
 
 type SetStateRepositoryImpl struct {
@@ -80,29 +82,41 @@ func (impl *SetStateRepositoryImpl) DeleteByValue(setState *SetState) error {
 	return nil
 }
 
+// Update writes the set state if it was not changed since it was read at setState.Revision,
+// the revision is increased on every update.
 func (impl *SetStateRepositoryImpl) Update(setState *SetState) error {
-	if err := impl.db.Model(setState).Updates(SetState{
+	result := impl.db.Model(setState).Where("revision = ?", setState.Revision).Updates(SetState{
 		ID:        setState.ID,
 		StateType: setState.StateType,
 		AppRefID:  setState.AppRefID,
 		Version:   setState.Version,
 		Value:     setState.Value,
+		Revision:  setState.Revision + 1,
 		UpdatedAt: setState.UpdatedAt,
 		UpdatedBy: setState.UpdatedBy,
-	}).Error; err != nil {
-		return err
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRevisionConflict
 	}
+	setState.Revision++
 	return nil
 }
 
 func (impl *SetStateRepositoryImpl) UpdateByValue(beforeSetState *SetState, afterSetState *SetState) error {
-	if err := impl.db.Model(afterSetState).Where(
+	if err := impl.db.Model(&SetState{}).Where(
 		"app_ref_id = ? AND state_type = ? AND version = ? AND value = ?",
 		beforeSetState.AppRefID,
 		beforeSetState.StateType,
 		beforeSetState.Version,
 		beforeSetState.Value,
-	).Updates(afterSetState).Error; err != nil {
+	).Updates(map[string]interface{}{
+		"value":      afterSetState.Value,
+		"updated_at": afterSetState.UpdatedAt,
+		"revision":   gorm.Expr("revision + 1"),
+	}).Error; err != nil {
 		fmt.Printf("[DUMP] error: %v\n", err)
 		return err
 	}
@@ -153,3 +167,10 @@ func (impl *SetStateRepositoryImpl) DeleteAllTypeSetStatesByApp(apprefid int) er
 	}
 	return nil
 }
+
+func (impl *SetStateRepositoryImpl) DeleteAllTypeSetStatesByAppVersion(apprefid int, version int) error {
+	if err := impl.db.Where("app_ref_id = ? AND version = ?", apprefid, version).Delete(&SetState{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
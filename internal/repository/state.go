@@ -14,6 +14,8 @@
 
 package repository
 
+import "errors"
+
 // define StateType
 const STATE_TYPE_INVALIED = 0
 const TREE_STATE_TYPE_COMPONENTS = 1       // ComponentsState
@@ -21,3 +23,6 @@ const KV_STATE_TYPE_DEPENDENCIES = 2       // DependenciesState
 const KV_STATE_TYPE_DRAG_SHADOW = 3        // DragShadowState
 const KV_STATE_TYPE_DOTTED_LINE_SQUARE = 4 // DottedLineSquareState
 const SET_STATE_TYPE_DISPLAY_NAME = 5      // DisplayNameState
+
+// ErrRevisionConflict is returned when a state row was changed after it was read.
+var ErrRevisionConflict = errors.New("the state was changed by someone else, please reload it")
@@ -37,6 +37,7 @@ type TreeState struct {
 	Version            int       `json:"version" 					     gorm:"column:version;type:bigint"`
 	Name               string    `json:"name" 						     gorm:"column:name;type:text"`
 	Content            string    `json:"content"    					 gorm:"column:content;type:jsonb"`
+	Revision           int       `json:"revision" 					 gorm:"column:revision;type:bigint;not null;default:0"`
 	CreatedAt          time.Time `json:"created_at" 					 gorm:"column:created_at;type:timestamp"`
 	CreatedBy          int       `json:"created_by" 					 gorm:"column:created_by;type:bigint"`
 	UpdatedAt          time.Time `json:"updated_at" 					 gorm:"column:updated_at;type:timestamp"`
@@ -54,6 +55,7 @@ type TreeStateRepository interface {
 	RetrieveEditVersionByAppAndName(apprefid int, statetype int, name string) (*TreeState, error)
 	RetrieveAllTypeTreeStatesByApp(apprefid int, version int) ([]*TreeState, error)
 	DeleteAllTypeTreeStatesByApp(apprefid int) error
+	DeleteAllTypeTreeStatesByAppVersion(apprefid int, version int) error
 }
 
 type TreeStateRepositoryImpl struct {
@@ -132,9 +134,11 @@ func (impl *TreeStateRepositoryImpl) Delete(treestateID int) error {
 	return nil
 }
 
+// Update writes the tree state if it was not changed since it was read at treestate.Revision,
+// the revision is increased on every update.
 func (impl *TreeStateRepositoryImpl) Update(treestate *TreeState) error {
 	fmt.Printf("[UPDATE] %v \n", treestate)
-	if err := impl.db.Model(treestate).Updates(TreeState{
+	result := impl.db.Model(treestate).Where("revision = ?", treestate.Revision).Updates(TreeState{
 		ID:                 treestate.ID,
 		StateType:          treestate.StateType,
 		ParentNodeRefID:    treestate.ParentNodeRefID,
@@ -143,11 +147,17 @@ func (impl *TreeStateRepositoryImpl) Update(treestate *TreeState) error {
 		Version:            treestate.Version,
 		Name:               treestate.Name,
 		Content:            treestate.Content,
+		Revision:           treestate.Revision + 1,
 		UpdatedAt:          treestate.UpdatedAt,
 		UpdatedBy:          treestate.UpdatedBy,
-	}).Error; err != nil {
-		return err
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRevisionConflict
 	}
+	treestate.Revision++
 	return nil
 }
 
@@ -205,3 +215,10 @@ func (impl *TreeStateRepositoryImpl) DeleteAllTypeTreeStatesByApp(apprefid int)
 	}
 	return nil
 }
+
+func (impl *TreeStateRepositoryImpl) DeleteAllTypeTreeStatesByAppVersion(apprefid int, version int) error {
+	if err := impl.db.Where("app_ref_id = ? AND version = ?", apprefid, version).Delete(&TreeState{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Repositories are bound to one database transaction.
+type Repositories struct {
+	App        AppRepository
+	TreeState  TreeStateRepository
+	KVState    KVStateRepository
+	SetState   SetStateRepository
+	Action     ActionRepository
+	AppVersion AppVersionRepository
+	AppChannel AppChannelRepository
+	AppPublish AppPublishRepository
+	AppMember  AppMemberRepository
+}
+
+// UnitOfWork runs fn in one transaction, it is committed when fn returns nil and rolled back otherwise.
+type UnitOfWork interface {
+	Do(fn func(repositories *Repositories) error) error
+}
+
+type UnitOfWorkImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewUnitOfWorkImpl(logger *zap.SugaredLogger, db *gorm.DB) *UnitOfWorkImpl {
+	return &UnitOfWorkImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *UnitOfWorkImpl) Do(fn func(repositories *Repositories) error) error {
+	return impl.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&Repositories{
+			App:        NewAppRepositoryImpl(impl.logger, tx),
+			TreeState:  NewTreeStateRepositoryImpl(impl.logger, tx),
+			KVState:    NewKVStateRepositoryImpl(impl.logger, tx),
+			SetState:   NewSetStateRepositoryImpl(impl.logger, tx),
+			Action:     NewActionRepositoryImpl(impl.logger, tx),
+			AppVersion: NewAppVersionRepositoryImpl(impl.logger, tx),
+			AppChannel: NewAppChannelRepositoryImpl(impl.logger, tx),
+			AppPublish: NewAppPublishRepositoryImpl(impl.logger, tx),
+			AppMember:  NewAppMemberRepositoryImpl(impl.logger, tx),
+		})
+	})
+}
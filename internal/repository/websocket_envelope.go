@@ -0,0 +1,82 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// WebsocketEnvelope keeps a websocket message that is too large for a NOTIFY payload,
+// the notification only carries its id.
+type WebsocketEnvelope struct {
+	ID        int       `gorm:"column:id;type:bigserial;primary_key"`
+	Payload   string    `gorm:"column:payload;type:text;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamp;not null;index"`
+}
+
+type WebsocketEnvelopeRepository interface {
+	Notify(channel, payload string) error
+	Create(websocketEnvelope *WebsocketEnvelope) (int, error)
+	RetrieveByID(id int) (*WebsocketEnvelope, error)
+	DeleteBefore(createdAt time.Time) error
+}
+
+type WebsocketEnvelopeRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewWebsocketEnvelopeRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *WebsocketEnvelopeRepositoryImpl {
+	return &WebsocketEnvelopeRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (impl *WebsocketEnvelopeRepositoryImpl) Notify(channel, payload string) error {
+	if err := impl.db.Exec("SELECT pg_notify(?, ?)", channel, payload).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (impl *WebsocketEnvelopeRepositoryImpl) Create(websocketEnvelope *WebsocketEnvelope) (int, error) {
+	if err := impl.db.Create(websocketEnvelope).Error; err != nil {
+		return 0, err
+	}
+	return websocketEnvelope.ID, nil
+}
+
+// RetrieveByID returns nil when the envelope was already pruned.
+func (impl *WebsocketEnvelopeRepositoryImpl) RetrieveByID(id int) (*WebsocketEnvelope, error) {
+	var websocketEnvelopes []*WebsocketEnvelope
+	if err := impl.db.Where("id = ?", id).Limit(1).Find(&websocketEnvelopes).Error; err != nil {
+		return nil, err
+	}
+	if len(websocketEnvelopes) == 0 {
+		return nil, nil
+	}
+	return websocketEnvelopes[0], nil
+}
+
+func (impl *WebsocketEnvelopeRepositoryImpl) DeleteBefore(createdAt time.Time) error {
+	if err := impl.db.Where("created_at < ?", createdAt).Delete(&WebsocketEnvelope{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
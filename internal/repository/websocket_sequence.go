@@ -0,0 +1,56 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package repository
+
+import (
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// WebsocketSequence is the last sequence number given to a broadcast in the app, it is shared by
+// all server instances.
+type WebsocketSequence struct {
+	APPID    int   `gorm:"column:app_id;type:bigint;primary_key"`
+	Sequence int64 `gorm:"column:sequence;type:bigint;not null"`
+}
+
+type WebsocketSequenceRepository interface {
+	Next(appID int) (int64, error)
+}
+
+type WebsocketSequenceRepositoryImpl struct {
+	logger *zap.SugaredLogger
+	db     *gorm.DB
+}
+
+func NewWebsocketSequenceRepositoryImpl(logger *zap.SugaredLogger, db *gorm.DB) *WebsocketSequenceRepositoryImpl {
+	return &WebsocketSequenceRepositoryImpl{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// Next increases the sequence of the app and returns it, the first sequence is 1.
+func (impl *WebsocketSequenceRepositoryImpl) Next(appID int) (int64, error) {
+	var sequence int64
+	if err := impl.db.Raw(
+		"INSERT INTO websocket_sequences (app_id, sequence) VALUES (?, 1) "+
+			"ON CONFLICT (app_id) DO UPDATE SET sequence = websocket_sequences.sequence + 1 RETURNING sequence",
+		appID,
+	).Scan(&sequence).Error; err != nil {
+		return 0, err
+	}
+	return sequence, nil
+}
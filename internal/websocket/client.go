@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -36,8 +37,31 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 102400 // 100 KiB
+
+	// Outbound messages queued for the peer before it is a slow consumer.
+	CLIENT_QUEUE_SIZE = 256
+)
+
+// Time allowed for the peer to authenticate by SIGNAL_ENTER after connected.
+var authWait = 10 * time.Second
+
+// what happens to a message for a slow consumer, whose queue is full.
+const (
+	// the client is disconnected, it loads the state again when it reconnects
+	SLOW_CONSUMER_DISCONNECT = iota
+	// the message is dropped, only for the transient states which the next one replaces
+	SLOW_CONSUMER_DROP
 )
 
+func SlowConsumerPolicy(target int) int {
+	switch target {
+	case TARGET_DRAG_SHADOW, TARGET_DOTTED_LINE_SQUARE:
+		return SLOW_CONSUMER_DROP
+	default:
+		return SLOW_CONSUMER_DISCONNECT
+	}
+}
+
 const DEAULT_INSTANCE_ID = "SELF_HOST"
 const DEAULT_APP_ID = 0
 
@@ -57,6 +81,9 @@ type Client struct {
 
 	MappedUserID int
 
+	// shown to the other editors, loaded on enter
+	Nickname string
+
 	IsLoggedIn bool
 
 	Hub *Hub
@@ -64,7 +91,7 @@ type Client struct {
 	// The websocket connection.
 	Conn *websocket.Conn
 
-	// Buffered channel of outbound messages.
+	// Buffered channel of outbound messages, only the room of the client sends to and closes it.
 	Send chan []byte
 
 	// instanceID, SELF_HOST by default
@@ -72,6 +99,17 @@ type Client struct {
 
 	// appID, 0 by default
 	APPID int
+
+	disconnectOnce sync.Once
+
+	// the last broadcast replayed to the client on resume, owned by the room goroutine
+	resumed int64
+
+	// cursor throttling, owned by the room goroutine
+	cursorSentAt     time.Time
+	cursorPending    interface{}
+	hasPendingCursor bool
+	cursorTimer      *time.Timer
 }
 
 func (c *Client) GetAPPID() int {
@@ -85,12 +123,16 @@ func NewClient(hub *Hub, conn *websocket.Conn, instanceID string, appID int) *Cl
 		IsLoggedIn:   false,
 		Hub:          hub,
 		Conn:         conn,
-		Send:         make(chan []byte, 256),
+		Send:         make(chan []byte, CLIENT_QUEUE_SIZE),
 		InstanceID:   instanceID,
 		APPID:        appID}
 }
 
 func (c *Client) Feedback(message *Message, errorCode int, errorMessage error) {
+	c.FeedbackWithData(message, errorCode, errorMessage, nil)
+}
+
+func (c *Client) FeedbackWithData(message *Message, errorCode int, errorMessage error, data interface{}) {
 	m := ""
 	if errorMessage != nil {
 		m = errorMessage.Error()
@@ -99,10 +141,43 @@ func (c *Client) Feedback(message *Message, errorCode int, errorMessage error) {
 		ErrorCode:    errorCode,
 		ErrorMessage: m,
 		Broadcast:    message.Broadcast,
-		Data:         nil,
+		Data:         data,
 	}
 	feedbyte, _ := feedCurrentClient.Serialization()
-	c.Send <- feedbyte
+	c.Enqueue(feedbyte, SLOW_CONSUMER_DISCONNECT)
+}
+
+// Enqueue queues the message without blocking, it returns false when the queue of the client is full
+// and the message was handled by the policy.
+func (c *Client) Enqueue(message []byte, policy int) bool {
+	select {
+	case c.Send <- message:
+		return true
+	default:
+	}
+	if policy == SLOW_CONSUMER_DISCONNECT {
+		log.Printf("[websocket-server] client %s is too slow to receive, disconnect it", c.ID)
+		c.Disconnect()
+	}
+	return false
+}
+
+// Disconnect closes the connection, the pumps stop and unregister the client. It is safe to call from
+// any goroutine.
+func (c *Client) Disconnect() {
+	c.disconnectOnce.Do(func() {
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// WatchAuthDeadline asks the hub to check the client after authWait,
+// the hub will kick the client if it still not logged in.
+func (c *Client) WatchAuthDeadline() {
+	time.AfterFunc(authWait, func() {
+		c.Hub.AuthTimeout <- c
+	})
 }
 
 // readPump pumps messages from the websocket connection to the hub.
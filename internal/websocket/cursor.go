@@ -0,0 +1,91 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// the cursor of a client is relayed at most once in this interval, the latest update in between is
+// relayed at the end of it.
+const CURSOR_THROTTLE_INTERVAL = 50 * time.Millisecond
+
+// Cursor is the cursor and selection of an editor, it is only relayed to the others.
+type Cursor struct {
+	ClientID string      `json:"clientID"`
+	UserID   int         `json:"userID"`
+	Nickname string      `json:"nickname"`
+	Color    string      `json:"color"`
+	Cursor   interface{} `json:"cursor"`
+}
+
+// Cursor relays the cursor of the client to the other entered clients of the app.
+func (room *Room) Cursor(client *Client, cursor interface{}) {
+	wait := CURSOR_THROTTLE_INTERVAL - time.Since(client.cursorSentAt)
+	if wait <= 0 {
+		room.sendCursor(client, cursor)
+		return
+	}
+	client.cursorPending = cursor
+	client.hasPendingCursor = true
+	if client.cursorTimer == nil {
+		client.cursorTimer = time.AfterFunc(wait, func() {
+			select {
+			case room.Messages <- &RoomEvent{Kind: ROOM_EVENT_CURSOR_FLUSH, Client: client}:
+			default:
+				// the room is busy, the next update carries the cursor
+			}
+		})
+	}
+}
+
+func (room *Room) flushCursor(client *Client) {
+	client.cursorTimer = nil
+	if client.hasPendingCursor {
+		room.sendCursor(client, client.cursorPending)
+	}
+}
+
+func (room *Room) sendCursor(client *Client, cursor interface{}) {
+	client.cursorSentAt = time.Now()
+	client.cursorPending = nil
+	client.hasPendingCursor = false
+	if client.cursorTimer != nil {
+		client.cursorTimer.Stop()
+		client.cursorTimer = nil
+	}
+	feedback := Feedback{
+		ErrorCode: ERROR_CODE_BROADCAST,
+		Broadcast: &Broadcast{
+			Type: BROADCAST_TYPE_CURSOR,
+			Payload: Cursor{
+				ClientID: client.ID.String(),
+				UserID:   client.MappedUserID,
+				Nickname: client.Nickname,
+				Color:    PresenceColor(client.MappedUserID),
+				Cursor:   cursor,
+			},
+		},
+	}
+	feedbyte, _ := feedback.Serialization()
+	for clientID, other := range room.Clients {
+		if clientID == client.ID || !other.IsLoggedIn {
+			continue
+		}
+		other.Enqueue(feedbyte, SLOW_CONSUMER_DROP)
+	}
+	room.Hub.publish(ENVELOPE_BROADCAST, room.APPID, client.ID, json.RawMessage(feedbyte), true)
+}
@@ -36,12 +36,24 @@ const ERROR_CREATE_OR_UPDATE_STATE_OK = 0
 const ERROR_CREATE_OR_UPDATE_STATE_FAILED = 9
 const ERROR_CAN_NOT_MOVE_KVSTATE = 10
 const ERROR_CAN_NOT_MOVE_SETSTATE = 11
+const ERROR_CODE_PERMISSION_DENIED = 12
+const ERROR_CODE_LOCKED = 13
+const ERROR_CODE_REVISION_CONFLICT = 14
+const ERROR_CODE_ACK = 15
+const ERROR_CODE_RELOAD_REQUIRED = 16
+const ERROR_CODE_UNDO_FAILED = 17
+const ERROR_CODE_BATCH_FAILED = 18
+const ERROR_CODE_VALIDATION_FAILED = 19
 
 type Feedback struct {
 	ErrorCode    int         `json:"errorCode"`
 	ErrorMessage string      `json:"errorMessage"`
 	Broadcast    *Broadcast  `json:"broadcast"`
 	Data         interface{} `json:"data"`
+
+	// the sequence of the broadcast in the app and the revisions of the states it changed
+	Sequence  int64          `json:"sequence,omitempty"`
+	Revisions map[string]int `json:"revisions,omitempty"`
 }
 
 func (feed *Feedback) Serialization() ([]byte, error) {
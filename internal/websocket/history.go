@@ -0,0 +1,139 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// the steps a user can undo in an app.
+const HISTORY_SIZE = 100
+
+// the history of a user in an app is forgotten when the user did not change the app for this interval.
+const HISTORY_TTL = 24 * time.Hour
+
+// Operation is a change of the states in the form of a signal, the undo history is made of them and
+// they are broadcast when the history is applied.
+type Operation struct {
+	Signal  int           `json:"signal"`
+	Target  int           `json:"target"`
+	Payload []interface{} `json:"payload"`
+}
+
+type historyKey struct {
+	appID  int
+	userID int
+}
+
+type userHistory struct {
+	undo      [][]Operation
+	redo      [][]Operation
+	updatedAt time.Time
+}
+
+// HistoryTable keeps the steps each user can undo and redo in an app, a step is the operations which
+// revert one change. It is kept by this server instance and safe for concurrent use.
+type HistoryTable struct {
+	mutex sync.Mutex
+	users map[historyKey]*userHistory
+	now   func() time.Time
+}
+
+func NewHistoryTable() *HistoryTable {
+	return &HistoryTable{
+		users: make(map[historyKey]*userHistory),
+		now:   time.Now,
+	}
+}
+
+// Record appends the step which reverts a new change of the user, the steps to redo are dropped.
+func (t *HistoryTable) Record(appID, userID int, step []Operation) {
+	if len(step) == 0 {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	history := t.history(appID, userID)
+	history.undo = pushStep(history.undo, step)
+	history.redo = nil
+}
+
+// Next returns the step the user would undo, or redo, without taking it.
+func (t *HistoryTable) Next(appID, userID int, redo bool) ([]Operation, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	history, ok := t.users[historyKey{appID, userID}]
+	if !ok {
+		return nil, false
+	}
+	steps := history.undo
+	if redo {
+		steps = history.redo
+	}
+	if len(steps) == 0 {
+		return nil, false
+	}
+	return steps[len(steps)-1], true
+}
+
+// Applied takes the step returned by Next and keeps the step which reverts it on the other side,
+// a step which could not be applied is dropped with a nil inverse.
+func (t *HistoryTable) Applied(appID, userID int, redo bool, inverse []Operation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	history := t.history(appID, userID)
+	from, to := &history.undo, &history.redo
+	if redo {
+		from, to = to, from
+	}
+	if len(*from) > 0 {
+		*from = (*from)[:len(*from)-1]
+	}
+	if len(inverse) > 0 {
+		*to = pushStep(*to, inverse)
+	}
+}
+
+// Expire forgets the history of the users which did not change their apps in HISTORY_TTL.
+func (t *HistoryTable) Expire() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	for key, history := range t.users {
+		if now.Sub(history.updatedAt) > HISTORY_TTL {
+			delete(t.users, key)
+		}
+	}
+}
+
+func (t *HistoryTable) history(appID, userID int) *userHistory {
+	key := historyKey{appID, userID}
+	history, ok := t.users[key]
+	if !ok {
+		history = &userHistory{}
+		t.users[key] = history
+	}
+	history.updatedAt = t.now()
+	return history
+}
+
+func pushStep(steps [][]Operation, step []Operation) [][]Operation {
+	steps = append(steps, step)
+	if len(steps) > HISTORY_SIZE {
+		steps = steps[len(steps)-HISTORY_SIZE:]
+	}
+	return steps
+}
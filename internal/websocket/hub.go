@@ -15,17 +15,37 @@
 package ws
 
 import (
+	"encoding/json"
+	"log"
+
+	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/backplane"
 	"github.com/illa-family/builder-backend/pkg/resource"
 	"github.com/illa-family/builder-backend/pkg/state"
+	"github.com/illa-family/builder-backend/pkg/user"
 	uuid "github.com/satori/go.uuid"
 )
 
+// the kinds of the envelopes between the hubs of the server instances.
+const (
+	ENVELOPE_BROADCAST = "broadcast"
+	ENVELOPE_JOIN      = "join"
+	ENVELOPE_LEAVE     = "leave"
+	ENVELOPE_HEARTBEAT = "heartbeat"
+	ENVELOPE_LOCK      = "lock"
+	ENVELOPE_UNLOCK    = "unlock"
+)
+
 // clients hub, maintains active clients and broadcast messags.
 type Hub struct {
-	// registered clients map
+	// registered clients map, owned by the hub goroutine
 	Clients map[uuid.UUID]*Client
 
+	// rooms of the apps with registered clients, owned by the hub goroutine
+	Rooms map[int]*Room
+
 	// inbound messages from the clients.
 	// try ```hub.Broadcast <- []byte(message)```
 	Broadcast chan []byte
@@ -39,21 +59,60 @@ type Hub struct {
 	// unregister requests from the clients.
 	Unregister chan *Client
 
+	// auth deadline events from the clients.
+	AuthTimeout chan *Client
+
+	// the hubs of the other server instances are reached by the backplane,
+	// Inbound is nil when the hub runs alone.
+	Node      string
+	Topic     string
+	Backplane backplane.Backplane
+	Inbound   <-chan *backplane.Envelope
+
+	// entered clients of all server instances
+	Presence *PresenceTable
+
+	// component locks of all server instances
+	Locks *LockTable
+
+	// numbers the broadcasts of the apps
+	Sequencer Sequencer
+
+	// recent numbered broadcasts of the apps for the resuming clients
+	Replay *ReplayLog
+
+	// the steps the users can undo and redo
+	History *HistoryTable
+
+	// binds the state services to a transaction
+	UnitOfWork repository.UnitOfWork
+
 	// impl
 	TreeStateServiceImpl *state.TreeStateServiceImpl
 	KVStateServiceImpl   *state.KVStateServiceImpl
 	SetStateServiceImpl  *state.SetStateServiceImpl
 	AppServiceImpl       *app.AppServiceImpl
 	ResourceServiceImpl  *resource.ResourceServiceImpl
+	TokenServiceImpl     *user.TokenServiceImpl
+	UserServiceImpl      *user.UserServiceImpl
+	AuditServiceImpl     *audit.AuditServiceImpl
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		Clients:    make(map[uuid.UUID]*Client),
-		Broadcast:  make(chan []byte),
-		OnMessage:  make(chan *Message),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Clients:     make(map[uuid.UUID]*Client),
+		Rooms:       make(map[int]*Room),
+		Broadcast:   make(chan []byte),
+		OnMessage:   make(chan *Message),
+		Register:    make(chan *Client),
+		Unregister:  make(chan *Client),
+		AuthTimeout: make(chan *Client),
+		Node:        uuid.Must(uuid.NewV4(), nil).String(),
+		Presence:    NewPresenceTable(),
+		Locks:       NewLockTable(),
+		Sequencer:   NewLocalSequencer(),
+		Replay:      NewReplayLog(),
+		History:     NewHistoryTable(),
 	}
 }
 
@@ -77,26 +136,184 @@ func (hub *Hub) SetResourceServiceImpl(rsi *resource.ResourceServiceImpl) {
 	hub.ResourceServiceImpl = rsi
 }
 
-func (hub *Hub) BroadcastToOtherClients(message *Message, currentClient *Client) {
-	feedOtherClient := Feedback{
-		ErrorCode:    ERROR_CODE_BROADCAST,
-		ErrorMessage: "",
-		Broadcast:    message.Broadcast,
-		Data:         nil,
+func (hub *Hub) SetTokenServiceImpl(tsi *user.TokenServiceImpl) {
+	hub.TokenServiceImpl = tsi
+}
+
+func (hub *Hub) SetUserServiceImpl(usi *user.UserServiceImpl) {
+	hub.UserServiceImpl = usi
+}
+
+func (hub *Hub) SetAuditServiceImpl(asi *audit.AuditServiceImpl) {
+	hub.AuditServiceImpl = asi
+}
+
+func (hub *Hub) SetSequencer(sequencer Sequencer) {
+	hub.Sequencer = sequencer
+}
+
+// SetBackplane connects the hub to the hubs of the same topic on the other server instances.
+func (hub *Hub) SetBackplane(topic string, b backplane.Backplane) {
+	hub.Topic = topic
+	hub.Backplane = b
+	hub.Inbound = b.Subscribe(topic)
+}
+
+// Heartbeat resends the presence of this instance and forgets the instances which stopped sending theirs.
+func (hub *Hub) Heartbeat() {
+	presences := hub.Presence.OfNode(hub.Node)
+	hub.Presence.Replace(hub.Node, presences)
+	hub.publish(ENVELOPE_HEARTBEAT, DEAULT_APP_ID, uuid.Nil, presences, false)
+	hub.presenceChanged(hub.Presence.Expire()...)
+	hub.locksChanged(hub.Locks.Expire()...)
+	hub.Replay.Expire()
+	hub.History.Expire()
+}
+
+func (hub *Hub) SetUnitOfWork(unitOfWork repository.UnitOfWork) {
+	hub.UnitOfWork = unitOfWork
+}
+
+// Transaction runs fn with a copy of the hub whose state services are bound to one transaction.
+func (hub *Hub) Transaction(fn func(tx *Hub) error) error {
+	return hub.UnitOfWork.Do(func(repositories *repository.Repositories) error {
+		tx := *hub
+		tx.TreeStateServiceImpl = hub.TreeStateServiceImpl.WithRepository(repositories.TreeState)
+		tx.KVStateServiceImpl = hub.KVStateServiceImpl.WithRepository(repositories.KVState)
+		tx.SetStateServiceImpl = hub.SetStateServiceImpl.WithRepository(repositories.SetState)
+		return fn(&tx)
+	})
+}
+
+// Resync disconnects the clients of this instance after the backplane lost the broadcasts of the other
+// instances. The replay log has gaps, so the clients load the apps again when they reconnect.
+func (hub *Hub) Resync() {
+	log.Printf("[websocket-server] the backplane lost envelopes, disconnect %d clients to resync", len(hub.Clients))
+	hub.Replay.Reset()
+	for _, client := range hub.Clients {
+		client.Disconnect()
+	}
+}
+
+// presenceChanged asks the rooms of the apps to send the users in them again.
+func (hub *Hub) presenceChanged(appIDs ...int) {
+	for _, appID := range appIDs {
+		if room, ok := hub.Rooms[appID]; ok {
+			room.Events <- &RoomEvent{Kind: ROOM_EVENT_PRESENCE}
+		}
+	}
+}
+
+// locksChanged asks the rooms of the apps to send the locks in them again.
+func (hub *Hub) locksChanged(appIDs ...int) {
+	for _, appID := range appIDs {
+		if room, ok := hub.Rooms[appID]; ok {
+			room.Events <- &RoomEvent{Kind: ROOM_EVENT_LOCKS}
+		}
+	}
+}
+
+// OnEnvelope handles the envelope from the hubs of the other server instances.
+func (hub *Hub) OnEnvelope(envelope *backplane.Envelope) {
+	if envelope.Node == hub.Node {
+		return
 	}
-	feedbyte, _ := feedOtherClient.Serialization()
-	for clientid, client := range hub.Clients {
-		if clientid == currentClient.ID {
-			continue
+	switch envelope.Kind {
+	case backplane.KIND_RESYNC:
+		hub.Resync()
+	case ENVELOPE_BROADCAST:
+		// the numbered broadcasts are kept even without a room, a client may resume on this instance
+		var sequence int64
+		if !envelope.Droppable {
+			var feedback struct {
+				Sequence int64 `json:"sequence"`
+			}
+			if err := json.Unmarshal(envelope.Data, &feedback); err != nil {
+				log.Printf("[websocket-server] invalid broadcast from %s: %v", envelope.Node, err)
+				return
+			}
+			sequence = feedback.Sequence
+			hub.Replay.Append(envelope.APPID, sequence, envelope.Data)
 		}
-		if client.APPID != currentClient.APPID {
-			continue
+		if room, ok := hub.Rooms[envelope.APPID]; ok {
+			policy := SLOW_CONSUMER_DISCONNECT
+			if envelope.Droppable {
+				policy = SLOW_CONSUMER_DROP
+			}
+			room.Events <- &RoomEvent{Kind: ROOM_EVENT_REMOTE_BROADCAST, Data: envelope.Data, Policy: policy, Sequence: sequence}
+		}
+	case ENVELOPE_JOIN:
+		var presence Presence
+		if err := json.Unmarshal(envelope.Data, &presence); err != nil {
+			log.Printf("[websocket-server] invalid presence from %s: %v", envelope.Node, err)
+			return
+		}
+		presence.Node = envelope.Node
+		hub.Presence.Join(presence)
+		hub.presenceChanged(presence.APPID)
+	case ENVELOPE_LEAVE:
+		hub.Presence.Leave(envelope.Node, envelope.ClientID)
+		hub.presenceChanged(envelope.APPID)
+		hub.locksChanged(hub.Locks.ReleaseClient(envelope.Node, envelope.ClientID)...)
+	case ENVELOPE_LOCK:
+		var lock Lock
+		if err := json.Unmarshal(envelope.Data, &lock); err != nil {
+			log.Printf("[websocket-server] invalid lock from %s: %v", envelope.Node, err)
+			return
+		}
+		lock.Node = envelope.Node
+		lock.ClientID = envelope.ClientID
+		lock.APPID = envelope.APPID
+		if hub.Locks.Apply(lock) {
+			hub.locksChanged(lock.APPID)
+		}
+	case ENVELOPE_UNLOCK:
+		var lock Lock
+		if err := json.Unmarshal(envelope.Data, &lock); err != nil {
+			log.Printf("[websocket-server] invalid lock from %s: %v", envelope.Node, err)
+			return
+		}
+		if hub.Locks.Release(envelope.APPID, lock.DisplayName, envelope.Node, envelope.ClientID) {
+			hub.locksChanged(envelope.APPID)
+		}
+	case ENVELOPE_HEARTBEAT:
+		var presences []Presence
+		if err := json.Unmarshal(envelope.Data, &presences); err != nil {
+			log.Printf("[websocket-server] invalid presence from %s: %v", envelope.Node, err)
+			return
+		}
+		known := hub.Presence.Known(envelope.Node)
+		hub.presenceChanged(hub.Presence.Replace(envelope.Node, presences)...)
+		// answer a new instance at once instead of letting it wait for the next heartbeat
+		if !known {
+			hub.Heartbeat()
 		}
-		client.Send <- feedbyte
 	}
 }
 
-func KickClient(hub *Hub, client *Client) {
-	close(client.Send)
-	delete(hub.Clients, client.ID)
+func (hub *Hub) publish(kind string, appID int, clientID uuid.UUID, data interface{}, droppable bool) {
+	if hub.Backplane == nil {
+		return
+	}
+	envelope := &backplane.Envelope{
+		Topic:     hub.Topic,
+		Node:      hub.Node,
+		Kind:      kind,
+		APPID:     appID,
+		Droppable: droppable,
+	}
+	if clientID != uuid.Nil {
+		envelope.ClientID = clientID.String()
+	}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.Printf("[websocket-server] serialize envelope error: %v", err)
+			return
+		}
+		envelope.Data = b
+	}
+	if err := hub.Backplane.Publish(envelope); err != nil {
+		log.Printf("[websocket-server] publish envelope error: %v", err)
+	}
 }
@@ -0,0 +1,282 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/illa-family/builder-backend/pkg/backplane"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(hub *Hub, appID int, userID int) *Client {
+	client := &Client{
+		ID:           uuid.Must(uuid.NewV4(), nil),
+		MappedUserID: userID,
+		IsLoggedIn:   userID != 0,
+		Hub:          hub,
+		Send:         make(chan []byte, 256),
+		APPID:        appID,
+	}
+	room, _ := hub.JoinRoom(client)
+	settle(room)
+	return client
+}
+
+func ignoreMessage(room *Room, message *Message) error {
+	return nil
+}
+
+// settle handles the queued events of the room, as the room goroutine does.
+func settle(room *Room) {
+	for {
+		select {
+		case event, ok := <-room.Events:
+			if !ok {
+				return
+			}
+			room.Handle(event, ignoreMessage)
+		case event := <-room.Messages:
+			room.Handle(event, ignoreMessage)
+		default:
+			return
+		}
+	}
+}
+
+// drain hands the envelopes of the backplane to the hub, as the hub loop does.
+func drain(hub *Hub) {
+	for {
+		select {
+		case envelope := <-hub.Inbound:
+			hub.OnEnvelope(envelope)
+		default:
+			for _, room := range hub.Rooms {
+				settle(room)
+			}
+			return
+		}
+	}
+}
+
+func newTestHubs() (*Hub, *Hub) {
+	b := backplane.NewMemoryBackplane()
+	hub1, hub2 := NewHub(), NewHub()
+	hub1.SetBackplane("app", b)
+	hub2.SetBackplane("app", b)
+	sequencer := NewLocalSequencer()
+	hub1.SetSequencer(sequencer)
+	hub2.SetSequencer(sequencer)
+	return hub1, hub2
+}
+
+func TestBroadcastReachesOtherInstances(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	sender := newTestClient(hub1, 1, 10)
+	local := newTestClient(hub1, 1, 11)
+	remote := newTestClient(hub2, 1, 12)
+	otherApp := newTestClient(hub2, 2, 13)
+
+	message := &Message{ClientID: sender.ID, APPID: 1, Broadcast: &Broadcast{Type: "components/remote"}}
+	hub1.Rooms[1].BroadcastToOtherClients(message, sender)
+	drain(hub1)
+	drain(hub2)
+
+	assert.Len(t, local.Send, 1)
+	assert.Len(t, otherApp.Send, 0)
+	if assert.Len(t, remote.Send, 1) {
+		assert.Equal(t, <-local.Send, <-remote.Send)
+	}
+
+	// the sender gets the sequence of its broadcast, the next one of the app is numbered after it
+	if assert.Len(t, sender.Send, 1) {
+		var ack Feedback
+		assert.Nil(t, json.Unmarshal(<-sender.Send, &ack))
+		assert.Equal(t, ERROR_CODE_ACK, ack.ErrorCode)
+		assert.Equal(t, int64(1), ack.Sequence)
+	}
+	hub2.Rooms[1].BroadcastToOtherClients(&Message{ClientID: remote.ID, APPID: 1, Broadcast: &Broadcast{Type: "components/remote"}}, remote)
+	drain(hub1)
+	var feedback Feedback
+	assert.Nil(t, json.Unmarshal(<-sender.Send, &feedback))
+	assert.Equal(t, int64(2), feedback.Sequence)
+}
+
+func TestPresenceAcrossInstances(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	client1 := newTestClient(hub1, 1, 10)
+	client2 := newTestClient(hub2, 1, 11)
+	hub1.Rooms[1].Join(client1)
+	hub2.Rooms[1].Join(client2)
+	drain(hub1)
+	drain(hub2)
+
+	assert.Len(t, hub1.Presence.InApp(1), 2)
+	assert.Len(t, hub2.Presence.InApp(1), 2)
+	assert.Len(t, hub2.Presence.InApp(2), 0)
+	assert.Equal(t, []PresenceUser{{UserID: 10, Color: PresenceColor(10)}, {UserID: 11, Color: PresenceColor(11)}}, lastPresence(t, client2))
+
+	room, _ := hub1.LeaveRoom(client1)
+	settle(room)
+	drain(hub2)
+	assert.Equal(t, []Presence{{Node: hub2.Node, ClientID: client2.ID.String(), UserID: 11, Color: PresenceColor(11), APPID: 1}}, hub2.Presence.InApp(1))
+	assert.Equal(t, []PresenceUser{{UserID: 11, Color: PresenceColor(11)}}, lastPresence(t, client2))
+}
+
+// lastPresence returns the users in the last presence broadcast the client received.
+func lastPresence(t *testing.T, client *Client) []PresenceUser {
+	var users []PresenceUser
+	for len(client.Send) > 0 {
+		var feedback struct {
+			Broadcast struct {
+				Type    string         `json:"type"`
+				Payload []PresenceUser `json:"payload"`
+			} `json:"broadcast"`
+		}
+		if err := json.Unmarshal(<-client.Send, &feedback); err == nil && feedback.Broadcast.Type == BROADCAST_TYPE_PRESENCE {
+			users = feedback.Broadcast.Payload
+		}
+	}
+	return users
+}
+
+func TestHeartbeatSyncsNewInstance(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	entered := newTestClient(hub1, 1, 10)
+	hub1.Rooms[1].Join(entered)
+	newTestClient(hub1, 1, 0) // not entered yet
+	hub1.Heartbeat()
+	drain(hub1)
+
+	// the new instance announces itself and is answered at once
+	hub2.Heartbeat()
+	drain(hub1)
+	drain(hub2)
+	assert.Len(t, hub2.Presence.InApp(1), 1)
+}
+
+func TestPresenceOfSilentInstanceExpires(t *testing.T) {
+	table := NewPresenceTable()
+	now := time.Now()
+	table.now = func() time.Time { return now }
+	table.Join(Presence{Node: "node1", ClientID: "a", APPID: 1})
+	table.Replace("node2", []Presence{{ClientID: "b", APPID: 1}})
+
+	now = now.Add(PRESENCE_TTL / 2)
+	table.Replace("node2", []Presence{{ClientID: "b", APPID: 1}})
+	now = now.Add(PRESENCE_TTL * 3 / 4)
+	table.Expire()
+	assert.Equal(t, []Presence{{Node: "node2", ClientID: "b", APPID: 1}}, table.InApp(1))
+
+	now = now.Add(PRESENCE_TTL)
+	table.Expire()
+	assert.Equal(t, []Presence{}, table.InApp(1))
+}
+
+func TestLocksAcrossInstances(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	client1 := newTestClient(hub1, 1, 10)
+	client2 := newTestClient(hub2, 1, 11)
+
+	_, ok := hub1.Rooms[1].Lock(client1, []string{"button1", "input1"})
+	assert.True(t, ok)
+	drain(hub2)
+	held, ok := hub2.Rooms[1].Lock(client2, []string{"text1", "input1"})
+	assert.False(t, ok)
+	assert.Equal(t, "input1", held.DisplayName)
+	assert.Equal(t, client1.ID.String(), held.ClientID)
+	// nothing is taken when one of the components is held
+	_, ok = hub2.Locks.Holder(1, "text1")
+	assert.False(t, ok)
+
+	hub1.Rooms[1].Unlock(client1, []string{"button1"})
+	drain(hub2)
+	assert.Len(t, hub2.Locks.InApp(1), 1)
+
+	// the locks of a client are released when it leaves
+	room, _ := hub1.LeaveRoom(client1)
+	settle(room)
+	drain(hub2)
+	assert.Len(t, hub2.Locks.InApp(1), 0)
+}
+
+func TestEarlierLockWinsOnBothInstances(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	now := time.Now()
+	hub1.Locks.now = func() time.Time { return now }
+	hub2.Locks.now = func() time.Time { return now.Add(time.Millisecond) }
+	client1 := newTestClient(hub1, 1, 10)
+	client2 := newTestClient(hub2, 1, 11)
+
+	// both instances grant the lock before they hear from each other
+	_, ok1 := hub1.Rooms[1].Lock(client1, []string{"button1"})
+	_, ok2 := hub2.Rooms[1].Lock(client2, []string{"button1"})
+	assert.True(t, ok1 && ok2)
+	drain(hub1)
+	drain(hub2)
+
+	for _, hub := range []*Hub{hub1, hub2} {
+		held, ok := hub.Locks.Holder(1, "button1")
+		assert.True(t, ok)
+		assert.Equal(t, client1.ID.String(), held.ClientID)
+	}
+}
+
+func TestResumeOnAnotherInstance(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	sender := newTestClient(hub1, 1, 10)
+	for i := 0; i < 2; i++ {
+		hub1.Rooms[1].BroadcastToOtherClients(&Message{ClientID: sender.ID, APPID: 1, Broadcast: &Broadcast{Type: "components/remote"}}, sender)
+	}
+	// hub2 has no room of the app but keeps its broadcasts
+	drain(hub2)
+	assert.Empty(t, hub2.Rooms)
+
+	// the broadcasts queued to the room before the client resumed are not sent twice
+	resumed := newTestClient(hub2, 1, 11)
+	hub1.Rooms[1].BroadcastToOtherClients(&Message{ClientID: sender.ID, APPID: 1, Broadcast: &Broadcast{Type: "components/remote"}}, sender)
+	for envelope := range hub2.Inbound {
+		hub2.OnEnvelope(envelope)
+		break
+	}
+	assert.True(t, hub2.Rooms[1].Resume(resumed, &Message{}, 1))
+	settle(hub2.Rooms[1])
+	var sequences []int64
+	for len(resumed.Send) > 0 {
+		var feedback Feedback
+		assert.Nil(t, json.Unmarshal(<-resumed.Send, &feedback))
+		if feedback.Sequence != 0 {
+			sequences = append(sequences, feedback.Sequence)
+		}
+	}
+	assert.Equal(t, []int64{2, 3}, sequences)
+}
+
+func TestResyncForgetsReplayedBroadcasts(t *testing.T) {
+	hub1, hub2 := newTestHubs()
+	sender := newTestClient(hub1, 1, 10)
+	hub1.Rooms[1].BroadcastToOtherClients(&Message{ClientID: sender.ID, APPID: 1, Broadcast: &Broadcast{Type: "components/remote"}}, sender)
+	drain(hub2)
+	_, _, ok := hub2.Replay.Since(1, 0)
+	assert.True(t, ok)
+
+	// after the backplane lost envelopes, the resuming clients load the app again
+	hub2.OnEnvelope(&backplane.Envelope{Topic: "app", Kind: backplane.KIND_RESYNC})
+	_, _, ok = hub2.Replay.Since(1, 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,235 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// a lock is released when its client did not renew it in this interval, the clients renew the locks
+// they hold by acquiring them again.
+const LOCK_TIMEOUT = 30 * time.Second
+
+// Lock is a soft lock of a component, the other clients can not change the component while it is held.
+type Lock struct {
+	DisplayName string    `json:"displayName"`
+	Node        string    `json:"node"`
+	ClientID    string    `json:"clientID"`
+	UserID      int       `json:"userID"`
+	Nickname    string    `json:"nickname"`
+	Color       string    `json:"color"`
+	APPID       int       `json:"appID"`
+	AcquiredAt  time.Time `json:"acquiredAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// LockTable tracks the component locks of all server instances, it is safe for concurrent use.
+type LockTable struct {
+	mutex sync.RWMutex
+	apps  map[int]map[string]Lock
+	now   func() time.Time
+}
+
+func NewLockTable() *LockTable {
+	return &LockTable{
+		apps: make(map[int]map[string]Lock),
+		now:  time.Now,
+	}
+}
+
+// Holder returns the lock of the component if it was not expired.
+func (t *LockTable) Holder(appID int, displayName string) (Lock, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.holder(appID, displayName)
+}
+
+// Acquire takes or renews the lock for its client, it returns the lock of another client which holds it.
+func (t *LockTable) Acquire(lock Lock) (Lock, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	lock.AcquiredAt = now
+	if held, ok := t.holder(lock.APPID, lock.DisplayName); ok {
+		if held.Node != lock.Node || held.ClientID != lock.ClientID {
+			return held, false
+		}
+		lock.AcquiredAt = held.AcquiredAt
+	}
+	lock.ExpiresAt = now.Add(LOCK_TIMEOUT)
+	t.set(lock)
+	return lock, true
+}
+
+// Apply records the lock acquired on another server instance, it returns false when the lock was not
+// taken. Two instances may grant the same component at once, the earlier lock wins on both.
+func (t *LockTable) Apply(lock Lock) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if held, ok := t.holder(lock.APPID, lock.DisplayName); ok && (held.Node != lock.Node || held.ClientID != lock.ClientID) {
+		if held.AcquiredAt.Before(lock.AcquiredAt) || (held.AcquiredAt.Equal(lock.AcquiredAt) && held.ClientID < lock.ClientID) {
+			return false
+		}
+	}
+	t.set(lock)
+	return true
+}
+
+// Release drops the lock of the component if the client holds it.
+func (t *LockTable) Release(appID int, displayName, node, clientID string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	held, ok := t.apps[appID][displayName]
+	if !ok || held.Node != node || held.ClientID != clientID {
+		return false
+	}
+	t.delete(appID, displayName)
+	return true
+}
+
+// ReleaseClient drops all locks of the client, it returns the apps whose locks changed.
+func (t *LockTable) ReleaseClient(node, clientID string) []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	changed := map[int]bool{}
+	for appID, locks := range t.apps {
+		for displayName, lock := range locks {
+			if lock.Node == node && lock.ClientID == clientID {
+				t.delete(appID, displayName)
+				changed[appID] = true
+			}
+		}
+	}
+	return appIDs(changed)
+}
+
+// Expire drops the locks which were not renewed in LOCK_TIMEOUT, it returns the apps whose locks changed.
+func (t *LockTable) Expire() []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	changed := map[int]bool{}
+	for appID, locks := range t.apps {
+		for displayName, lock := range locks {
+			if !lock.ExpiresAt.After(now) {
+				t.delete(appID, displayName)
+				changed[appID] = true
+			}
+		}
+	}
+	return appIDs(changed)
+}
+
+// InApp returns the locks held in the app, sorted by displayName.
+func (t *LockTable) InApp(appID int) []Lock {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	now := t.now()
+	locks := []Lock{}
+	for _, lock := range t.apps[appID] {
+		if lock.ExpiresAt.After(now) {
+			locks = append(locks, lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].DisplayName < locks[j].DisplayName
+	})
+	return locks
+}
+
+func (t *LockTable) holder(appID int, displayName string) (Lock, bool) {
+	lock, ok := t.apps[appID][displayName]
+	if !ok || !lock.ExpiresAt.After(t.now()) {
+		return Lock{}, false
+	}
+	return lock, true
+}
+
+func (t *LockTable) set(lock Lock) {
+	locks, ok := t.apps[lock.APPID]
+	if !ok {
+		locks = make(map[string]Lock)
+		t.apps[lock.APPID] = locks
+	}
+	locks[lock.DisplayName] = lock
+}
+
+func (t *LockTable) delete(appID int, displayName string) {
+	delete(t.apps[appID], displayName)
+	if len(t.apps[appID]) == 0 {
+		delete(t.apps, appID)
+	}
+}
+
+// Lock takes the components for the client, nothing is taken when another client holds one of them.
+func (room *Room) Lock(client *Client, displayNames []string) (Lock, bool) {
+	for _, displayName := range displayNames {
+		if held, ok := room.LockedByOther(client, displayName); ok {
+			return held, false
+		}
+	}
+	for _, displayName := range displayNames {
+		lock, _ := room.Hub.Locks.Acquire(Lock{
+			DisplayName: displayName,
+			Node:        room.Hub.Node,
+			ClientID:    client.ID.String(),
+			UserID:      client.MappedUserID,
+			Nickname:    client.Nickname,
+			Color:       PresenceColor(client.MappedUserID),
+			APPID:       room.APPID,
+		})
+		room.Hub.publish(ENVELOPE_LOCK, room.APPID, client.ID, lock, false)
+	}
+	room.BroadcastLocks()
+	return Lock{}, true
+}
+
+// Unlock releases the components the client holds.
+func (room *Room) Unlock(client *Client, displayNames []string) {
+	released := false
+	for _, displayName := range displayNames {
+		if room.Hub.Locks.Release(room.APPID, displayName, room.Hub.Node, client.ID.String()) {
+			room.Hub.publish(ENVELOPE_UNLOCK, room.APPID, client.ID, Lock{DisplayName: displayName, APPID: room.APPID}, false)
+			released = true
+		}
+	}
+	if released {
+		room.BroadcastLocks()
+	}
+}
+
+// LockedByOther returns the lock of the component when a client other than the given one holds it.
+func (room *Room) LockedByOther(client *Client, displayName string) (Lock, bool) {
+	held, ok := room.Hub.Locks.Holder(room.APPID, displayName)
+	if !ok || (held.Node == room.Hub.Node && held.ClientID == client.ID.String()) {
+		return Lock{}, false
+	}
+	return held, true
+}
+
+// BroadcastLocks sends the locks held in the app to the entered clients in the room.
+func (room *Room) BroadcastLocks() {
+	feedback := Feedback{
+		ErrorCode: ERROR_CODE_BROADCAST,
+		Broadcast: &Broadcast{
+			Type:    BROADCAST_TYPE_LOCK,
+			Payload: room.Hub.Locks.InApp(room.APPID),
+		},
+	}
+	feedbyte, _ := feedback.Serialization()
+	room.Deliver(feedbyte, SLOW_CONSUMER_DISCONNECT)
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// every instance resends its presence in this interval, an instance which was not heard from
+// in PRESENCE_TTL is considered gone.
+const PRESENCE_HEARTBEAT_INTERVAL = 10 * time.Second
+const PRESENCE_TTL = 3 * PRESENCE_HEARTBEAT_INTERVAL
+
+// the colours of the users in the editor, a user has the same colour on every instance.
+var PRESENCE_COLORS = []string{
+	"#654aec", "#ff4757", "#ffa502", "#2ed573", "#1e90ff", "#ff6b81",
+	"#eccc68", "#7bed9f", "#70a1ff", "#5352ed", "#ff7f50", "#a4b0be",
+}
+
+func PresenceColor(userID int) string {
+	if userID < 0 {
+		userID = -userID
+	}
+	return PRESENCE_COLORS[userID%len(PRESENCE_COLORS)]
+}
+
+// Presence is a client which entered the room.
+type Presence struct {
+	Node     string `json:"node"`
+	ClientID string `json:"clientID"`
+	UserID   int    `json:"userID"`
+	Nickname string `json:"nickname"`
+	Color    string `json:"color"`
+	APPID    int    `json:"appID"`
+}
+
+// PresenceUser is a user editing the app, it is listed once for all of its clients.
+type PresenceUser struct {
+	UserID   int    `json:"userID"`
+	Nickname string `json:"nickname"`
+	Color    string `json:"color"`
+}
+
+type nodePresence struct {
+	seenAt  time.Time
+	clients map[string]Presence
+}
+
+// PresenceTable tracks the entered clients of all server instances, it is safe for concurrent use.
+type PresenceTable struct {
+	mutex sync.RWMutex
+	nodes map[string]*nodePresence
+	now   func() time.Time
+}
+
+func NewPresenceTable() *PresenceTable {
+	return &PresenceTable{
+		nodes: make(map[string]*nodePresence),
+		now:   time.Now,
+	}
+}
+
+func (t *PresenceTable) Join(presence Presence) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.touch(presence.Node).clients[presence.ClientID] = presence
+}
+
+func (t *PresenceTable) Leave(node, clientID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if nodePresence, ok := t.nodes[node]; ok {
+		delete(nodePresence.clients, clientID)
+	}
+}
+
+func (t *PresenceTable) Known(node string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	_, known := t.nodes[node]
+	return known
+}
+
+// Replace sets all clients of the node, it returns the apps whose clients changed.
+func (t *PresenceTable) Replace(node string, presences []Presence) []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	nodePresence := t.touch(node)
+	clients := make(map[string]Presence, len(presences))
+	changed := map[int]bool{}
+	for _, presence := range presences {
+		presence.Node = node
+		clients[presence.ClientID] = presence
+		if _, ok := nodePresence.clients[presence.ClientID]; !ok {
+			changed[presence.APPID] = true
+		}
+	}
+	for clientID, presence := range nodePresence.clients {
+		if _, ok := clients[clientID]; !ok {
+			changed[presence.APPID] = true
+		}
+	}
+	nodePresence.clients = clients
+	return appIDs(changed)
+}
+
+// Expire forgets the nodes which were not heard from in PRESENCE_TTL, it returns the apps whose
+// clients changed.
+func (t *PresenceTable) Expire() []int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	deadline := t.now().Add(-PRESENCE_TTL)
+	changed := map[int]bool{}
+	for node, nodePresence := range t.nodes {
+		if nodePresence.seenAt.Before(deadline) {
+			for _, presence := range nodePresence.clients {
+				changed[presence.APPID] = true
+			}
+			delete(t.nodes, node)
+		}
+	}
+	return appIDs(changed)
+}
+
+// OfNode returns the clients on the server instance.
+func (t *PresenceTable) OfNode(node string) []Presence {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	presences := []Presence{}
+	if nodePresence, ok := t.nodes[node]; ok {
+		for _, presence := range nodePresence.clients {
+			presences = append(presences, presence)
+		}
+	}
+	return presences
+}
+
+// InApp returns the clients in the room of the app on all server instances.
+func (t *PresenceTable) InApp(appID int) []Presence {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	presences := []Presence{}
+	for _, nodePresence := range t.nodes {
+		for _, presence := range nodePresence.clients {
+			if presence.APPID == appID {
+				presences = append(presences, presence)
+			}
+		}
+	}
+	sort.Slice(presences, func(i, j int) bool {
+		if presences[i].Node != presences[j].Node {
+			return presences[i].Node < presences[j].Node
+		}
+		return presences[i].ClientID < presences[j].ClientID
+	})
+	return presences
+}
+
+// UsersInApp returns the users editing the app on all server instances.
+func (t *PresenceTable) UsersInApp(appID int) []PresenceUser {
+	users := []PresenceUser{}
+	listed := map[int]bool{}
+	for _, presence := range t.InApp(appID) {
+		if listed[presence.UserID] {
+			continue
+		}
+		listed[presence.UserID] = true
+		users = append(users, PresenceUser{
+			UserID:   presence.UserID,
+			Nickname: presence.Nickname,
+			Color:    presence.Color,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+func appIDs(set map[int]bool) []int {
+	ids := make([]int, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (t *PresenceTable) touch(node string) *nodePresence {
+	presence, ok := t.nodes[node]
+	if !ok {
+		presence = &nodePresence{clients: make(map[string]Presence)}
+		t.nodes[node] = presence
+	}
+	presence.seenAt = t.now()
+	return presence
+}
@@ -39,6 +39,12 @@ const SIGNAL_MOVE_STATE = 6
 const SIGNAL_CREATE_OR_UPDATE_STATE = 7
 const SIGNAL_ONLY_BROADCAST = 8
 const SIGNAL_PUT_STATE = 9
+const SIGNAL_CURSOR = 10 // ephemeral cursor and selection, never persisted
+const SIGNAL_LOCK = 11   // soft lock of components by displayName
+const SIGNAL_UNLOCK = 12
+const SIGNAL_UNDO = 13 // revert the last change of the user in the app
+const SIGNAL_REDO = 14
+const SIGNAL_BATCH = 15 // ordered operations applied in one transaction
 
 const OPTION_BROADCAST_ROOM = 1 // 00000000000000000000000000000001; // use as signed int32 in typescript
 
@@ -54,6 +60,12 @@ const TARGET_RESOURCE = 7           // only for broadcast
 // for broadcast rewrite
 const BROADCAST_TYPE_SUFFIX = "/remote"
 
+// broadcasts sent by the server
+const BROADCAST_TYPE_PRESENCE = "presence/updatePresenceReducer" + BROADCAST_TYPE_SUFFIX
+const BROADCAST_TYPE_CURSOR = "presence/updateCursorReducer" + BROADCAST_TYPE_SUFFIX
+const BROADCAST_TYPE_LOCK = "presence/updateLockReducer" + BROADCAST_TYPE_SUFFIX
+const BROADCAST_TYPE_OPERATIONS = "operations/applyOperationsReducer" + BROADCAST_TYPE_SUFFIX
+
 type Broadcast struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
@@ -67,6 +79,12 @@ type Message struct {
 	Target    int           `json:"target"`
 	Payload   []interface{} `json:"payload"`
 	Broadcast *Broadcast    `json:"broadcast"`
+
+	// the revisions of the states after the signal was applied, filled by the server
+	Revisions map[string]int `json:"-"`
+
+	// sent back to the client in the ack of its broadcast
+	AckData interface{} `json:"-"`
 }
 
 func NewMessage(clientID uuid.UUID, appID int, rawMessage []byte) (*Message, error) {
@@ -80,6 +98,14 @@ func NewMessage(clientID uuid.UUID, appID int, rawMessage []byte) (*Message, err
 	return &message, nil
 }
 
+// SetRevision records the revision of the state named key after the signal was applied.
+func (m *Message) SetRevision(key string, revision int) {
+	if m.Revisions == nil {
+		m.Revisions = make(map[string]int)
+	}
+	m.Revisions[key] = revision
+}
+
 func (m *Message) RewriteBroadcast() {
 	m.Broadcast.Type = m.Broadcast.Type + BROADCAST_TYPE_SUFFIX
 }
@@ -0,0 +1,146 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the broadcasts an app keeps for the clients which reconnect, the replay fits into the queue of a client.
+const REPLAY_LOG_SIZE = 128
+
+// the broadcasts of an app are forgotten when nothing was broadcast in it for this interval.
+const REPLAY_LOG_TTL = time.Hour
+
+var errReloadRequired = errors.New("[websocket-server] the missed changes are not kept any more, please reload the app.")
+
+type replayEntry struct {
+	sequence int64
+	feedback []byte
+}
+
+type appReplay struct {
+	entries   []replayEntry
+	updatedAt time.Time
+}
+
+// ReplayLog keeps the recent numbered broadcasts of the apps, local and remote, so a client which lost
+// its connection gets what it missed instead of loading the app again. It is safe for concurrent use.
+type ReplayLog struct {
+	mutex sync.Mutex
+	apps  map[int]*appReplay
+	now   func() time.Time
+}
+
+func NewReplayLog() *ReplayLog {
+	return &ReplayLog{
+		apps: make(map[int]*appReplay),
+		now:  time.Now,
+	}
+}
+
+// Append records the broadcast, the broadcasts of the other instances may arrive out of order.
+func (l *ReplayLog) Append(appID int, sequence int64, feedback []byte) {
+	if sequence <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	app, ok := l.apps[appID]
+	if !ok {
+		app = &appReplay{}
+		l.apps[appID] = app
+	}
+	app.updatedAt = l.now()
+	i := sort.Search(len(app.entries), func(i int) bool { return app.entries[i].sequence >= sequence })
+	if i < len(app.entries) && app.entries[i].sequence == sequence {
+		return
+	}
+	app.entries = append(app.entries, replayEntry{})
+	copy(app.entries[i+1:], app.entries[i:])
+	app.entries[i] = replayEntry{sequence: sequence, feedback: feedback}
+	if len(app.entries) > REPLAY_LOG_SIZE {
+		app.entries = app.entries[len(app.entries)-REPLAY_LOG_SIZE:]
+	}
+}
+
+// Since returns the broadcasts after the sequence and the last sequence of the app, it returns false
+// when some of them are not kept any more and the client has to load the app again.
+func (l *ReplayLog) Since(appID int, sequence int64) ([][]byte, int64, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	app, ok := l.apps[appID]
+	if !ok || len(app.entries) == 0 {
+		return nil, 0, false
+	}
+	if app.entries[0].sequence > sequence+1 {
+		return nil, 0, false
+	}
+	last := app.entries[len(app.entries)-1].sequence
+	if sequence > last {
+		// the client is ahead of the log, this instance restarted or lost a broadcast
+		return nil, 0, false
+	}
+	var missed [][]byte
+	next := sequence + 1
+	for _, entry := range app.entries {
+		if entry.sequence < next {
+			continue
+		}
+		if entry.sequence != next {
+			return nil, 0, false
+		}
+		missed = append(missed, entry.feedback)
+		next++
+	}
+	return missed, last, true
+}
+
+// Reset forgets the broadcasts of all apps, the clients resuming after it load the apps again.
+func (l *ReplayLog) Reset() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.apps = make(map[int]*appReplay)
+}
+
+// Expire forgets the apps which had no broadcast in REPLAY_LOG_TTL.
+func (l *ReplayLog) Expire() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	for appID, app := range l.apps {
+		if now.Sub(app.updatedAt) > REPLAY_LOG_TTL {
+			delete(l.apps, appID)
+		}
+	}
+}
+
+// Resume sends the broadcasts the client missed since the sequence, or asks it to load the app again.
+// The remote broadcasts already queued to the room are not sent to the client twice.
+func (room *Room) Resume(client *Client, message *Message, sequence int64) bool {
+	missed, last, ok := room.Hub.Replay.Since(room.APPID, sequence)
+	if !ok {
+		client.FeedbackWithData(message, ERROR_CODE_RELOAD_REQUIRED, errReloadRequired, nil)
+		return false
+	}
+	for _, feedback := range missed {
+		client.Enqueue(feedback, SLOW_CONSUMER_DISCONNECT)
+	}
+	client.resumed = last
+	return true
+}
@@ -0,0 +1,309 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// the lifecycle events a room queues before the hub blocks on it, they are few and never dropped.
+const ROOM_EVENT_QUEUE_SIZE = 1024
+
+// the signals a room queues, the hub never blocks on them.
+const ROOM_MESSAGE_QUEUE_SIZE = 256
+
+const (
+	ROOM_EVENT_REGISTER = iota
+	ROOM_EVENT_UNREGISTER
+	ROOM_EVENT_AUTH_TIMEOUT
+	ROOM_EVENT_MESSAGE
+	ROOM_EVENT_REMOTE_BROADCAST
+	ROOM_EVENT_BROADCAST
+	ROOM_EVENT_PRESENCE
+	ROOM_EVENT_CURSOR_FLUSH
+	ROOM_EVENT_LOCKS
+)
+
+// RoomEvent is handled by the room goroutine in the order the hub queued it.
+type RoomEvent struct {
+	Kind    int
+	Client  *Client
+	Message *Message
+	Data    []byte
+	Policy  int
+
+	// the number of a remote broadcast, 0 for the droppable ones
+	Sequence int64
+}
+
+// Room holds the clients of one app, its events are handled by its own goroutine so a busy app
+// does not hold up the others.
+type Room struct {
+	APPID int
+	Hub   *Hub
+
+	// clients in the room, owned by the room goroutine
+	Clients map[uuid.UUID]*Client
+
+	// lifecycle and broadcast events, handled before the messages, closed by the hub after the last
+	// client left
+	Events chan *RoomEvent
+
+	// signals of the clients
+	Messages chan *RoomEvent
+
+	// registered clients, owned by the hub goroutine
+	members int
+}
+
+func NewRoom(hub *Hub, appID int) *Room {
+	return &Room{
+		APPID:    appID,
+		Hub:      hub,
+		Clients:  make(map[uuid.UUID]*Client),
+		Events:   make(chan *RoomEvent, ROOM_EVENT_QUEUE_SIZE),
+		Messages: make(chan *RoomEvent, ROOM_MESSAGE_QUEUE_SIZE),
+	}
+}
+
+// Run handles the events of the room until the hub closes it. The queued events are handled first,
+// so a message never overtakes the registration of its client.
+func (room *Room) Run(onMessage func(room *Room, message *Message) error) {
+	for {
+		select {
+		case event, ok := <-room.Events:
+			if !ok {
+				return
+			}
+			room.Handle(event, onMessage)
+			continue
+		default:
+		}
+		select {
+		case event, ok := <-room.Events:
+			if !ok {
+				return
+			}
+			room.Handle(event, onMessage)
+		case event := <-room.Messages:
+			room.Handle(event, onMessage)
+		}
+	}
+}
+
+// BroadcastToOtherClients sends the change of the client to the other clients of the app. The changes
+// of the states are numbered, the client gets the number of its change in an ack instead of the
+// broadcast. The shadows of a drag are not numbered, a client may miss them.
+func (room *Room) BroadcastToOtherClients(message *Message, currentClient *Client) {
+	room.broadcast(message, currentClient, false)
+}
+
+// BroadcastToAllClients sends the change made by the server for the client to all clients of the app,
+// the client does not know the change before it gets the broadcast.
+func (room *Room) BroadcastToAllClients(message *Message, currentClient *Client) {
+	room.broadcast(message, currentClient, true)
+}
+
+func (room *Room) broadcast(message *Message, currentClient *Client, echo bool) {
+	feedOtherClient := Feedback{
+		ErrorCode:    ERROR_CODE_BROADCAST,
+		ErrorMessage: "",
+		Broadcast:    message.Broadcast,
+		Data:         nil,
+		Revisions:    message.Revisions,
+	}
+	policy := SlowConsumerPolicy(message.Target)
+	if policy != SLOW_CONSUMER_DROP {
+		sequence, err := room.Hub.Sequencer.Next(room.APPID)
+		if err != nil {
+			log.Printf("[websocket-server] number the broadcast of app %d error: %v", room.APPID, err)
+		}
+		feedOtherClient.Sequence = sequence
+		if !echo {
+			ack := Feedback{ErrorCode: ERROR_CODE_ACK, Sequence: sequence, Revisions: message.Revisions, Data: message.AckData}
+			ackbyte, _ := ack.Serialization()
+			currentClient.Enqueue(ackbyte, SLOW_CONSUMER_DISCONNECT)
+		}
+	}
+	feedbyte, _ := feedOtherClient.Serialization()
+	if policy != SLOW_CONSUMER_DROP {
+		room.Hub.Replay.Append(room.APPID, feedOtherClient.Sequence, feedbyte)
+	}
+	for clientid, client := range room.Clients {
+		if clientid == currentClient.ID && !echo {
+			continue
+		}
+		client.Enqueue(feedbyte, policy)
+	}
+	room.Hub.publish(ENVELOPE_BROADCAST, room.APPID, currentClient.ID, json.RawMessage(feedbyte), policy == SLOW_CONSUMER_DROP)
+}
+
+// Handle applies the event to the room, the signals in the messages are handled by onMessage.
+func (room *Room) Handle(event *RoomEvent, onMessage func(room *Room, message *Message) error) {
+	switch event.Kind {
+	case ROOM_EVENT_REGISTER:
+		room.Clients[event.Client.ID] = event.Client
+	case ROOM_EVENT_UNREGISTER:
+		if _, ok := room.Clients[event.Client.ID]; ok {
+			KickClient(room, event.Client)
+		}
+	case ROOM_EVENT_AUTH_TIMEOUT:
+		if _, ok := room.Clients[event.Client.ID]; ok && !event.Client.IsLoggedIn {
+			KickClient(room, event.Client)
+		}
+	case ROOM_EVENT_MESSAGE:
+		onMessage(room, event.Message)
+	case ROOM_EVENT_REMOTE_BROADCAST:
+		room.deliverRemote(event)
+	case ROOM_EVENT_BROADCAST:
+		room.Deliver(event.Data, event.Policy)
+	case ROOM_EVENT_PRESENCE:
+		room.BroadcastPresence()
+	case ROOM_EVENT_CURSOR_FLUSH:
+		if _, ok := room.Clients[event.Client.ID]; ok {
+			room.flushCursor(event.Client)
+		}
+	case ROOM_EVENT_LOCKS:
+		room.BroadcastLocks()
+	}
+}
+
+// Deliver sends the feedback to every entered client in the room.
+func (room *Room) Deliver(feedbyte []byte, policy int) {
+	for _, client := range room.Clients {
+		if client.IsLoggedIn {
+			client.Enqueue(feedbyte, policy)
+		}
+	}
+}
+
+// deliverRemote sends the broadcast of another instance to the entered clients which did not get it
+// on resume.
+func (room *Room) deliverRemote(event *RoomEvent) {
+	for _, client := range room.Clients {
+		if client.IsLoggedIn && (event.Sequence == 0 || event.Sequence > client.resumed) {
+			client.Enqueue(event.Data, event.Policy)
+		}
+	}
+}
+
+// JoinRoom queues the client to the room of its app, the room is created on the first client
+// and the caller starts its goroutine.
+func (hub *Hub) JoinRoom(client *Client) (*Room, bool) {
+	hub.Clients[client.ID] = client
+	room, ok := hub.Rooms[client.APPID]
+	if !ok {
+		room = NewRoom(hub, client.APPID)
+		hub.Rooms[client.APPID] = room
+	}
+	room.members++
+	room.Events <- &RoomEvent{Kind: ROOM_EVENT_REGISTER, Client: client}
+	return room, !ok
+}
+
+// LeaveRoom queues the client out of its room, the room is closed after its last client left.
+func (hub *Hub) LeaveRoom(client *Client) (*Room, bool) {
+	if _, ok := hub.Clients[client.ID]; !ok {
+		return nil, false
+	}
+	delete(hub.Clients, client.ID)
+	room := hub.Rooms[client.APPID]
+	room.Events <- &RoomEvent{Kind: ROOM_EVENT_UNREGISTER, Client: client}
+	if room.members--; room.members > 0 {
+		return room, false
+	}
+	delete(hub.Rooms, client.APPID)
+	close(room.Events)
+	return room, true
+}
+
+// RouteToRoom queues the event to the room of the registered client.
+func (hub *Hub) RouteToRoom(clientID uuid.UUID, event *RoomEvent) bool {
+	client, ok := hub.Clients[clientID]
+	if !ok {
+		return false
+	}
+	hub.Rooms[client.APPID].Events <- event
+	return true
+}
+
+// RouteMessage queues the signal to the room of the client without blocking, the client is disconnected
+// when the room can not keep up with it.
+func (hub *Hub) RouteMessage(message *Message) bool {
+	client, ok := hub.Clients[message.ClientID]
+	if !ok {
+		return false
+	}
+	select {
+	case hub.Rooms[client.APPID].Messages <- &RoomEvent{Kind: ROOM_EVENT_MESSAGE, Message: message}:
+		return true
+	default:
+		log.Printf("[websocket-server] room %d is too busy for client %s, disconnect it", client.APPID, client.ID)
+		client.Disconnect()
+		return false
+	}
+}
+
+// Join records the entered client in the presence of all server instances and sends the users
+// in the room to its clients.
+func (room *Room) Join(client *Client) {
+	presence := Presence{
+		Node:     room.Hub.Node,
+		ClientID: client.ID.String(),
+		UserID:   client.MappedUserID,
+		Nickname: client.Nickname,
+		Color:    PresenceColor(client.MappedUserID),
+		APPID:    client.APPID,
+	}
+	room.Hub.Presence.Join(presence)
+	room.Hub.publish(ENVELOPE_JOIN, client.APPID, client.ID, presence, false)
+	room.BroadcastPresence()
+}
+
+// BroadcastPresence sends the users editing the app to the entered clients in the room.
+func (room *Room) BroadcastPresence() {
+	feedback := Feedback{
+		ErrorCode: ERROR_CODE_BROADCAST,
+		Broadcast: &Broadcast{
+			Type:    BROADCAST_TYPE_PRESENCE,
+			Payload: room.Hub.Presence.UsersInApp(room.APPID),
+		},
+	}
+	feedbyte, _ := feedback.Serialization()
+	for _, client := range room.Clients {
+		if client.IsLoggedIn {
+			client.Enqueue(feedbyte, SLOW_CONSUMER_DISCONNECT)
+		}
+	}
+}
+
+func KickClient(room *Room, client *Client) {
+	close(client.Send)
+	delete(room.Clients, client.ID)
+	if client.cursorTimer != nil {
+		client.cursorTimer.Stop()
+	}
+	if client.IsLoggedIn {
+		room.Hub.Presence.Leave(room.Hub.Node, client.ID.String())
+		room.Hub.publish(ENVELOPE_LEAVE, client.APPID, client.ID, nil, false)
+		room.BroadcastPresence()
+		if len(room.Hub.Locks.ReleaseClient(room.Hub.Node, client.ID.String())) > 0 {
+			room.BroadcastLocks()
+		}
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomLifecycle(t *testing.T) {
+	hub := NewHub()
+	client1 := &Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 1}
+	client2 := &Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 1}
+	client3 := &Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 2}
+
+	room1, created := hub.JoinRoom(client1)
+	assert.True(t, created)
+	_, created = hub.JoinRoom(client2)
+	assert.False(t, created)
+	room2, created := hub.JoinRoom(client3)
+	assert.True(t, created)
+	assert.NotEqual(t, room1, room2)
+	settle(room1)
+	settle(room2)
+	assert.Len(t, room1.Clients, 2)
+	assert.Len(t, room2.Clients, 1)
+
+	_, removed := hub.LeaveRoom(client1)
+	assert.False(t, removed)
+	_, removed = hub.LeaveRoom(client2)
+	assert.True(t, removed)
+	// leaving twice is a no-op
+	room, removed := hub.LeaveRoom(client2)
+	assert.Nil(t, room)
+	assert.False(t, removed)
+	settle(room1)
+	_, ok := <-room1.Events
+	assert.False(t, ok)
+	_, ok = <-client1.Send
+	assert.False(t, ok)
+	assert.NotContains(t, hub.Rooms, 1)
+	assert.Contains(t, hub.Rooms, 2)
+
+	// the room is created again for the next client
+	room, created = hub.JoinRoom(&Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 1})
+	assert.True(t, created)
+	assert.NotEqual(t, room1, room)
+}
+
+func TestAuthTimeoutKicksClientNotEntered(t *testing.T) {
+	hub := NewHub()
+	entered := newTestClient(hub, 1, 10)
+	guest := newTestClient(hub, 1, 0)
+	room := hub.Rooms[1]
+	assert.True(t, hub.RouteToRoom(entered.ID, &RoomEvent{Kind: ROOM_EVENT_AUTH_TIMEOUT, Client: entered}))
+	assert.True(t, hub.RouteToRoom(guest.ID, &RoomEvent{Kind: ROOM_EVENT_AUTH_TIMEOUT, Client: guest}))
+	settle(room)
+	assert.Contains(t, room.Clients, entered.ID)
+	assert.NotContains(t, room.Clients, guest.ID)
+	assert.False(t, hub.RouteToRoom(uuid.Must(uuid.NewV4(), nil), &RoomEvent{Kind: ROOM_EVENT_MESSAGE}))
+}
+
+func TestAuthDeadlineReportsClient(t *testing.T) {
+	wait := authWait
+	authWait = 10 * time.Millisecond
+	defer func() { authWait = wait }()
+
+	hub := NewHub()
+	client := &Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 1}
+	client.WatchAuthDeadline()
+	select {
+	case timedOut := <-hub.AuthTimeout:
+		assert.Equal(t, client, timedOut)
+	case <-time.After(time.Second):
+		t.Fatal("the client was not reported after the auth deadline")
+	}
+}
+
+// the clients are spread over apps of 10 editors each, the broadcast reaches the 9 others in the room.
+const benchmarkRoomSize = 10
+
+func newBenchmarkHub(connections int) (*Hub, *Client) {
+	hub := NewHub()
+	var sender *Client
+	for i := 0; i < connections; i++ {
+		client := &Client{
+			ID:         uuid.Must(uuid.NewV4(), nil),
+			IsLoggedIn: true,
+			Hub:        hub,
+			Send:       make(chan []byte, 1),
+			APPID:      i / benchmarkRoomSize,
+		}
+		room, _ := hub.JoinRoom(client)
+		settle(room)
+		if sender == nil {
+			sender = client
+		}
+	}
+	return hub, sender
+}
+
+func drainSend(room *Room) {
+	for _, client := range room.Clients {
+		select {
+		case <-client.Send:
+		default:
+		}
+	}
+}
+
+// BenchmarkBroadcastScanAllClients is the broadcast before the rooms, it scans every client of the hub.
+func BenchmarkBroadcastScanAllClients(b *testing.B) {
+	for _, connections := range []int{1000, 5000, 10000} {
+		b.Run(fmt.Sprintf("connections=%d", connections), func(b *testing.B) {
+			hub, sender := newBenchmarkHub(connections)
+			room := hub.Rooms[sender.APPID]
+			feedbyte := []byte(`{"errorCode":0,"errorMessage":"","broadcast":{"type":"components/updateComponentPropsReducer/remote"},"data":null}`)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for clientID, client := range hub.Clients {
+					if clientID == sender.ID || client.APPID != sender.APPID {
+						continue
+					}
+					client.Send <- feedbyte
+				}
+				drainSend(room)
+			}
+		})
+	}
+}
+
+func BenchmarkBroadcastRoom(b *testing.B) {
+	for _, connections := range []int{1000, 5000, 10000} {
+		b.Run(fmt.Sprintf("connections=%d", connections), func(b *testing.B) {
+			hub, sender := newBenchmarkHub(connections)
+			room := hub.Rooms[sender.APPID]
+			message := &Message{ClientID: sender.ID, APPID: sender.APPID, Broadcast: &Broadcast{Type: "components/updateComponentPropsReducer/remote"}}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				room.BroadcastToOtherClients(message, sender)
+				drainSend(room)
+			}
+		})
+	}
+}
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	hub := NewHub()
+	sender := newTestClient(hub, 1, 10)
+	slow := newTestClient(hub, 1, 11)
+	room := hub.Rooms[1]
+	for i := 0; i < CLIENT_QUEUE_SIZE; i++ {
+		assert.True(t, slow.Enqueue([]byte("{}"), SLOW_CONSUMER_DISCONNECT))
+	}
+
+	// the transient state is dropped and the broadcast does not block
+	room.BroadcastToOtherClients(&Message{Target: TARGET_DRAG_SHADOW, Broadcast: &Broadcast{}}, sender)
+	assert.Len(t, slow.Send, CLIENT_QUEUE_SIZE)
+	assert.False(t, slow.Enqueue([]byte("{}"), SLOW_CONSUMER_DROP))
+
+	// other states disconnect the client once, it is unregistered when its pumps stop
+	room.BroadcastToOtherClients(&Message{Target: TARGET_COMPONENTS, Broadcast: &Broadcast{}}, sender)
+	room.BroadcastToOtherClients(&Message{Target: TARGET_COMPONENTS, Broadcast: &Broadcast{}}, sender)
+	assert.Contains(t, room.Clients, slow.ID)
+}
+
+func TestRouteMessageDoesNotBlockOnBusyRoom(t *testing.T) {
+	hub := NewHub()
+	client := newTestClient(hub, 1, 10)
+	for i := 0; i < ROOM_MESSAGE_QUEUE_SIZE; i++ {
+		assert.True(t, hub.RouteMessage(&Message{ClientID: client.ID}))
+	}
+	assert.False(t, hub.RouteMessage(&Message{ClientID: client.ID}))
+	assert.False(t, hub.RouteMessage(&Message{ClientID: uuid.Must(uuid.NewV4(), nil)}))
+}
+
+func TestRoomHandlesEventsBeforeMessages(t *testing.T) {
+	hub := NewHub()
+	room := NewRoom(hub, 1)
+	client := &Client{ID: uuid.Must(uuid.NewV4(), nil), Hub: hub, Send: make(chan []byte, 1), APPID: 1}
+	// the message is queued first but the registration of its client is handled before it
+	room.Messages <- &RoomEvent{Kind: ROOM_EVENT_MESSAGE, Message: &Message{ClientID: client.ID}}
+	room.Events <- &RoomEvent{Kind: ROOM_EVENT_REGISTER, Client: client}
+	found := false
+	done := make(chan struct{})
+	go func() {
+		room.Run(func(room *Room, message *Message) error {
+			_, found = room.Clients[message.ClientID]
+			close(done)
+			return nil
+		})
+	}()
+	<-done
+	close(room.Events)
+	assert.True(t, found)
+}
+
+func TestPresenceListsUsersOnce(t *testing.T) {
+	hub := NewHub()
+	tab1 := newTestClient(hub, 1, 10)
+	tab1.Nickname = "alice"
+	tab2 := newTestClient(hub, 1, 10)
+	tab2.Nickname = "alice"
+	guest := newTestClient(hub, 1, 0)
+	room := hub.Rooms[1]
+	room.Join(tab1)
+	room.Join(tab2)
+
+	assert.Equal(t, []PresenceUser{{UserID: 10, Nickname: "alice", Color: PresenceColor(10)}}, lastPresence(t, tab1))
+	// the clients not entered yet do not see the editors
+	assert.Len(t, guest.Send, 0)
+
+	KickClient(room, tab1)
+	assert.Equal(t, []PresenceUser{{UserID: 10, Nickname: "alice", Color: PresenceColor(10)}}, lastPresence(t, tab2))
+	KickClient(room, tab2)
+	assert.Equal(t, []PresenceUser{}, hub.Presence.UsersInApp(1))
+}
+
+func TestCursorIsThrottled(t *testing.T) {
+	hub := NewHub()
+	sender := newTestClient(hub, 1, 10)
+	receiver := newTestClient(hub, 1, 11)
+	room := hub.Rooms[1]
+
+	room.Cursor(sender, map[string]interface{}{"x": 1})
+	room.Cursor(sender, map[string]interface{}{"x": 2})
+	room.Cursor(sender, map[string]interface{}{"x": 3})
+	assert.Equal(t, []interface{}{float64(1)}, cursorsOf(t, receiver))
+
+	// the latest update is relayed at the end of the interval
+	time.Sleep(CURSOR_THROTTLE_INTERVAL * 2)
+	settle(room)
+	assert.Equal(t, []interface{}{float64(3)}, cursorsOf(t, receiver))
+	assert.Len(t, sender.Send, 0)
+}
+
+func cursorsOf(t *testing.T, client *Client) []interface{} {
+	xs := []interface{}{}
+	for len(client.Send) > 0 {
+		var feedback struct {
+			Broadcast struct {
+				Type    string `json:"type"`
+				Payload Cursor `json:"payload"`
+			} `json:"broadcast"`
+		}
+		assert.Nil(t, json.Unmarshal(<-client.Send, &feedback))
+		assert.Equal(t, BROADCAST_TYPE_CURSOR, feedback.Broadcast.Type)
+		assert.Equal(t, 10, feedback.Broadcast.Payload.UserID)
+		xs = append(xs, feedback.Broadcast.Payload.Cursor.(map[string]interface{})["x"])
+	}
+	return xs
+}
+
+func TestLockIsRenewedAndExpires(t *testing.T) {
+	hub := NewHub()
+	now := time.Now()
+	hub.Locks.now = func() time.Time { return now }
+	holder := newTestClient(hub, 1, 10)
+	other := newTestClient(hub, 1, 11)
+	room := hub.Rooms[1]
+
+	_, ok := room.Lock(holder, []string{"button1"})
+	assert.True(t, ok)
+	acquiredAt := hub.Locks.InApp(1)[0].AcquiredAt
+	_, ok = room.LockedByOther(other, "button1")
+	assert.True(t, ok)
+	_, ok = room.LockedByOther(holder, "button1")
+	assert.False(t, ok)
+
+	now = now.Add(LOCK_TIMEOUT / 2)
+	_, ok = room.Lock(holder, []string{"button1"})
+	assert.True(t, ok)
+	assert.Equal(t, acquiredAt, hub.Locks.InApp(1)[0].AcquiredAt)
+
+	now = now.Add(LOCK_TIMEOUT)
+	assert.Equal(t, []int{1}, hub.Locks.Expire())
+	_, ok = room.Lock(other, []string{"button1"})
+	assert.True(t, ok)
+}
+
+func TestReplayLogKeepsGaplessBroadcasts(t *testing.T) {
+	replay := NewReplayLog()
+	now := time.Now()
+	replay.now = func() time.Time { return now }
+
+	_, _, ok := replay.Since(1, 0)
+	assert.False(t, ok, "an app without a log can not be resumed")
+
+	// the remote broadcasts may arrive out of order
+	for _, sequence := range []int64{1, 2, 4, 3} {
+		replay.Append(1, sequence, []byte(fmt.Sprint(sequence)))
+	}
+	missed, last, ok := replay.Since(1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), last)
+	assert.Equal(t, [][]byte{[]byte("2"), []byte("3"), []byte("4")}, missed)
+	missed, _, ok = replay.Since(1, 4)
+	assert.True(t, ok)
+	assert.Empty(t, missed)
+
+	// a broadcast still on its way is a gap
+	replay.Append(1, 6, []byte("6"))
+	_, _, ok = replay.Since(1, 4)
+	assert.False(t, ok)
+
+	// the oldest broadcasts are dropped
+	for sequence := int64(5); sequence <= REPLAY_LOG_SIZE+6; sequence++ {
+		replay.Append(1, sequence, []byte(fmt.Sprint(sequence)))
+	}
+	_, _, ok = replay.Since(1, 4)
+	assert.False(t, ok)
+	missed, _, ok = replay.Since(1, 6)
+	assert.True(t, ok)
+	assert.Len(t, missed, REPLAY_LOG_SIZE)
+
+	now = now.Add(REPLAY_LOG_TTL + time.Second)
+	replay.Expire()
+	_, _, ok = replay.Since(1, REPLAY_LOG_SIZE+6)
+	assert.False(t, ok)
+}
+
+func TestHistoryMovesStepsBetweenUndoAndRedo(t *testing.T) {
+	history := NewHistoryTable()
+	now := time.Now()
+	history.now = func() time.Time { return now }
+	step := func(name string) []Operation {
+		return []Operation{{Signal: SIGNAL_DELETE_STATE, Target: TARGET_COMPONENTS, Payload: []interface{}{name}}}
+	}
+
+	history.Record(1, 10, step("button1"))
+	history.Record(1, 10, step("button2"))
+	_, ok := history.Next(1, 11, false)
+	assert.False(t, ok, "the users have their own history")
+
+	next, ok := history.Next(1, 10, false)
+	assert.True(t, ok)
+	assert.Equal(t, step("button2"), next)
+	history.Applied(1, 10, false, step("redo button2"))
+	next, _ = history.Next(1, 10, true)
+	assert.Equal(t, step("redo button2"), next)
+
+	// a new change drops the steps to redo
+	history.Record(1, 10, step("button3"))
+	_, ok = history.Next(1, 10, true)
+	assert.False(t, ok)
+
+	// a step which could not be applied is dropped
+	history.Applied(1, 10, false, nil)
+	next, _ = history.Next(1, 10, false)
+	assert.Equal(t, step("button1"), next)
+	_, ok = history.Next(1, 10, true)
+	assert.False(t, ok)
+
+	for i := 0; i < HISTORY_SIZE+1; i++ {
+		history.Record(1, 10, step(fmt.Sprint(i)))
+	}
+	assert.Len(t, history.users[historyKey{1, 10}].undo, HISTORY_SIZE)
+
+	now = now.Add(HISTORY_TTL + time.Second)
+	history.Expire()
+	_, ok = history.Next(1, 10, false)
+	assert.False(t, ok)
+}
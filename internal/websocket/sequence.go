@@ -0,0 +1,40 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ws
+
+import "sync"
+
+// Sequencer numbers the broadcasts of an app, the numbers increase by one so the clients can tell
+// when they missed one. All server instances of an app have to share the sequencer.
+type Sequencer interface {
+	Next(appID int) (int64, error)
+}
+
+// LocalSequencer numbers the broadcasts in memory, it is only right for a server running alone.
+type LocalSequencer struct {
+	mutex     sync.Mutex
+	sequences map[int]int64
+}
+
+func NewLocalSequencer() *LocalSequencer {
+	return &LocalSequencer{sequences: make(map[int]int64)}
+}
+
+func (s *LocalSequencer) Next(appID int) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sequences[appID]++
+	return s.sequences[appID], nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/illa-family/builder-backend/pkg/db"
+)
+
+const (
+	PARAMETER_STRING  = "string"
+	PARAMETER_NUMBER  = "number"
+	PARAMETER_BOOLEAN = "boolean"
+)
+
+var ErrActionNotFound = errors.New("action not found")
+var ErrActionNotRunnable = errors.New("the action runs on the client")
+var ErrInvalidParameters = errors.New("invalid parameters")
+
+// a stored template refers to an input as {{params.name}}, every other expression is evaluated by the client
+// and can not be run by id.
+var parameterPattern = regexp.MustCompile(`{{\s*params\.([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+var expressionPattern = regexp.MustCompile(`{{.*?}}`)
+
+// the query of these actions is sent with bind arguments instead of the parameter values, each argument is
+// referred to by the placeholder of the database at its 1-based position.
+var sqlPlaceholders = map[string]func(position int) string{
+	"mysql":      questionMarkPlaceholder,
+	"mariadb":    questionMarkPlaceholder,
+	"postgresql": func(position int) string { return "$" + strconv.Itoa(position) },
+}
+
+func questionMarkPlaceholder(position int) string {
+	return "?"
+}
+
+// Parameter declares an input of the action.
+type Parameter struct {
+	Type     string      `json:"type" validate:"oneof=string number boolean"`
+	Required bool        `json:"required"`
+	Default  interface{} `json:"default,omitempty"`
+}
+
+func encodeParameters(parameters map[string]Parameter) db.JSONB {
+	encoded := db.JSONB{}
+	for name, parameter := range parameters {
+		encoded[name] = map[string]interface{}{
+			"type":     parameter.Type,
+			"required": parameter.Required,
+			"default":  parameter.Default,
+		}
+	}
+	return encoded
+}
+
+func decodeParameters(encoded db.JSONB) map[string]Parameter {
+	parameters := map[string]Parameter{}
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return parameters
+	}
+	_ = json.Unmarshal(b, &parameters)
+	return parameters
+}
+
+// ResolveParameters checks the values against the declared parameters and fills in the defaults.
+func ResolveParameters(declared map[string]Parameter, values map[string]interface{}) (map[string]interface{}, error) {
+	for name := range values {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("%w: %s is not declared", ErrInvalidParameters, name)
+		}
+	}
+	resolved := make(map[string]interface{}, len(declared))
+	for name, parameter := range declared {
+		value, ok := values[name]
+		if !ok || value == nil {
+			if parameter.Required && parameter.Default == nil {
+				return nil, fmt.Errorf("%w: %s is required", ErrInvalidParameters, name)
+			}
+			resolved[name] = parameter.Default
+			continue
+		}
+		valid := false
+		switch parameter.Type {
+		case PARAMETER_STRING:
+			_, valid = value.(string)
+		case PARAMETER_NUMBER:
+			_, valid = value.(float64)
+		case PARAMETER_BOOLEAN:
+			_, valid = value.(bool)
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %s must be a %s", ErrInvalidParameters, name, parameter.Type)
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
+
+// BindParameters returns a copy of the template with the parameter placeholders bound. The values of a SQL
+// query become bind arguments, and the values in a REST URL are escaped.
+func BindParameters(actionType string, template map[string]interface{}, values map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	bound := map[string]interface{}{}
+	if err := json.Unmarshal(b, &bound); err != nil {
+		return nil, err
+	}
+
+	if placeholderOf, ok := sqlPlaceholders[actionType]; ok {
+		for key, field := range bound {
+			query, ok := field.(string)
+			if !ok || !strings.EqualFold(key, "query") {
+				continue
+			}
+			args := []interface{}{}
+			var bindErr error
+			bound[key] = parameterPattern.ReplaceAllStringFunc(query, func(placeholder string) string {
+				name := parameterPattern.FindStringSubmatch(placeholder)[1]
+				value, ok := values[name]
+				if !ok {
+					bindErr = fmt.Errorf("%w: %s is not declared", ErrInvalidParameters, name)
+				}
+				args = append(args, value)
+				return placeholderOf(len(args))
+			})
+			if bindErr != nil {
+				return nil, bindErr
+			}
+			bound["args"] = args
+		}
+	}
+
+	var bindErr error
+	var bindValue func(key string, value interface{}) interface{}
+	bindValue = func(key string, value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			replaced := parameterPattern.ReplaceAllStringFunc(v, func(placeholder string) string {
+				name := parameterPattern.FindStringSubmatch(placeholder)[1]
+				value, ok := values[name]
+				if !ok {
+					bindErr = fmt.Errorf("%w: %s is not declared", ErrInvalidParameters, name)
+				}
+				formatted := ""
+				if value != nil {
+					formatted = fmt.Sprint(value)
+				}
+				if strings.EqualFold(key, "url") {
+					return url.PathEscape(formatted)
+				}
+				return formatted
+			})
+			if expressionPattern.MatchString(replaced) {
+				bindErr = fmt.Errorf("%w: %s is evaluated by the client, declare it as a parameter", ErrInvalidParameters,
+					expressionPattern.FindString(replaced))
+			}
+			return replaced
+		case map[string]interface{}:
+			for k, field := range v {
+				v[k] = bindValue(k, field)
+			}
+			return v
+		case []interface{}:
+			for i, field := range v {
+				v[i] = bindValue(key, field)
+			}
+			return v
+		default:
+			return v
+		}
+	}
+	for key, field := range bound {
+		if _, ok := sqlPlaceholders[actionType]; ok && key == "args" {
+			continue
+		}
+		bound[key] = bindValue(key, field)
+	}
+	if bindErr != nil {
+		return nil, bindErr
+	}
+	return bound, nil
+}
+
+// RunStoredAction runs the action of the app version as stored, only the declared parameters come from the client.
+func (impl *ActionServiceImpl) RunStoredAction(id, app, version int, values map[string]interface{}, environment string) (interface{}, error) {
+	action, err := impl.GetAction(id)
+	if err != nil || action.App != app || action.Version != version {
+		return nil, ErrActionNotFound
+	}
+	if action.Type == TRANSFORMER_ACTION {
+		return nil, ErrActionNotRunnable
+	}
+	resolved, err := ResolveParameters(action.Parameters, values)
+	if err != nil {
+		return nil, err
+	}
+	if action.Template, err = BindParameters(action.Type, action.Template, resolved); err != nil {
+		return nil, err
+	}
+	action.Environment = environment
+	return impl.RunAction(action)
+}
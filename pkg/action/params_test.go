@@ -0,0 +1,85 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package action
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveParameters(t *testing.T) {
+	declared := map[string]Parameter{
+		"id":     {Type: PARAMETER_NUMBER, Required: true},
+		"status": {Type: PARAMETER_STRING, Default: "open"},
+		"all":    {Type: PARAMETER_BOOLEAN},
+	}
+
+	resolved, err := ResolveParameters(declared, map[string]interface{}{"id": float64(7)})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"id": float64(7), "status": "open", "all": nil}, resolved)
+
+	_, err = ResolveParameters(declared, map[string]interface{}{"id": float64(7), "table": "users"})
+	assert.True(t, errors.Is(err, ErrInvalidParameters))
+
+	_, err = ResolveParameters(declared, map[string]interface{}{"status": "closed"})
+	assert.True(t, errors.Is(err, ErrInvalidParameters))
+
+	_, err = ResolveParameters(declared, map[string]interface{}{"id": "7 or 1=1"})
+	assert.True(t, errors.Is(err, ErrInvalidParameters))
+}
+
+func TestBindParametersSQL(t *testing.T) {
+	template := map[string]interface{}{
+		"mode":  "sql",
+		"query": "select * from orders where id = {{ params.id }} and status = {{params.status}}",
+	}
+	bound, err := BindParameters("mysql", template, map[string]interface{}{"id": float64(7), "status": "open' or '1'='1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "select * from orders where id = ? and status = ?", bound["query"])
+	assert.Equal(t, []interface{}{float64(7), "open' or '1'='1"}, bound["args"])
+	// the stored template is left untouched
+	assert.Contains(t, template["query"], "{{ params.id }}")
+}
+
+func TestBindParametersPostgreSQL(t *testing.T) {
+	template := map[string]interface{}{"query": "select * from orders where id = {{params.id}} and status = {{params.status}}"}
+	bound, err := BindParameters("postgresql", template, map[string]interface{}{"id": float64(7), "status": "open"})
+	assert.Nil(t, err)
+	assert.Equal(t, "select * from orders where id = $1 and status = $2", bound["query"])
+	assert.Equal(t, []interface{}{float64(7), "open"}, bound["args"])
+}
+
+func TestBindParametersREST(t *testing.T) {
+	template := map[string]interface{}{
+		"url":     "/orders/{{params.id}}",
+		"headers": []interface{}{map[string]interface{}{"key": "X-Status", "value": "{{params.status}}"}},
+	}
+	bound, err := BindParameters("restapi", template, map[string]interface{}{"id": "../admin", "status": "open"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/orders/..%2Fadmin", bound["url"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"key": "X-Status", "value": "open"}}, bound["headers"])
+}
+
+func TestBindParametersRejectsClientExpressions(t *testing.T) {
+	template := map[string]interface{}{"query": "select * from {{input1.value}}"}
+	_, err := BindParameters("postgresql", template, map[string]interface{}{})
+	assert.True(t, errors.Is(err, ErrInvalidParameters))
+
+	template = map[string]interface{}{"url": "/orders/{{params.id}}"}
+	_, err = BindParameters("restapi", template, map[string]interface{}{})
+	assert.True(t, errors.Is(err, ErrInvalidParameters))
+}
@@ -41,6 +41,7 @@ type ActionService interface {
 	GetAction(id int) (ActionDto, error)
 	FindActionsByAppVersion(app, version int) ([]ActionDto, error)
 	RunAction(action ActionDto) (interface{}, error)
+	RunStoredAction(id, app, version int, values map[string]interface{}, environment string) (interface{}, error)
 	ValidateActionOptions(actionType string, options map[string]interface{}) error
 }
 
@@ -52,26 +53,31 @@ type ActionDto struct {
 	DisplayName string                 `json:"displayName" validate:"required"`
 	Type        string                 `json:"actionType" validate:"oneof=transformer restapi graphql redis mysql mariadb postgresql mongodb"`
 	Template    map[string]interface{} `json:"content" validate:"required"`
+	Parameters  map[string]Parameter   `json:"parameters,omitempty" validate:"dive"`
 	Transformer map[string]interface{} `json:"transformer" validate:"required"`
 	TriggerMode string                 `json:"triggerMode" validate:"oneof=manually automate"`
 	CreatedAt   time.Time              `json:"createdAt,omitempty"`
 	CreatedBy   int                    `json:"createdBy,omitempty"`
 	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
 	UpdatedBy   int                    `json:"updatedBy,omitempty"`
+	Environment string                 `json:"-"` // the resource options of this environment are used to run the action
 }
 
 type ActionServiceImpl struct {
-	logger             *zap.SugaredLogger
-	actionRepository   repository.ActionRepository
-	resourceRepository repository.ResourceRepository
+	logger                        *zap.SugaredLogger
+	actionRepository              repository.ActionRepository
+	resourceRepository            repository.ResourceRepository
+	resourceEnvironmentRepository repository.ResourceEnvironmentRepository
 }
 
 func NewActionServiceImpl(logger *zap.SugaredLogger, actionRepository repository.ActionRepository,
-	resourceRepository repository.ResourceRepository) *ActionServiceImpl {
+	resourceRepository repository.ResourceRepository,
+	resourceEnvironmentRepository repository.ResourceEnvironmentRepository) *ActionServiceImpl {
 	return &ActionServiceImpl{
-		logger:             logger,
-		actionRepository:   actionRepository,
-		resourceRepository: resourceRepository,
+		logger:                        logger,
+		actionRepository:              actionRepository,
+		resourceRepository:            resourceRepository,
+		resourceEnvironmentRepository: resourceEnvironmentRepository,
 	}
 }
 
@@ -87,6 +93,7 @@ func (impl *ActionServiceImpl) CreateAction(action ActionDto) (ActionDto, error)
 		TriggerMode: action.TriggerMode,
 		Transformer: action.Transformer,
 		Template:    action.Template,
+		Parameters:  encodeParameters(action.Parameters),
 		CreatedAt:   action.CreatedAt,
 		CreatedBy:   action.CreatedBy,
 		UpdatedAt:   action.UpdatedAt,
@@ -117,6 +124,7 @@ func (impl *ActionServiceImpl) UpdateAction(action ActionDto) (ActionDto, error)
 		TriggerMode: action.TriggerMode,
 		Transformer: action.Transformer,
 		Template:    action.Template,
+		Parameters:  encodeParameters(action.Parameters),
 		UpdatedAt:   action.UpdatedAt,
 		UpdatedBy:   action.UpdatedBy,
 	}); err != nil {
@@ -132,12 +140,15 @@ func (impl *ActionServiceImpl) GetAction(id int) (ActionDto, error) {
 	}
 	resDto := ActionDto{
 		ID:          res.ID,
+		App:         res.App,
+		Version:     res.Version,
 		Resource:    res.Resource,
 		DisplayName: res.Name,
 		Type:        type_array[res.Type],
 		TriggerMode: res.TriggerMode,
 		Transformer: res.Transformer,
 		Template:    res.Template,
+		Parameters:  decodeParameters(res.Parameters),
 		CreatedBy:   res.CreatedBy,
 		CreatedAt:   res.CreatedAt,
 		UpdatedBy:   res.UpdatedBy,
@@ -162,6 +173,7 @@ func (impl *ActionServiceImpl) FindActionsByAppVersion(app, version int) ([]Acti
 			TriggerMode: value.TriggerMode,
 			Transformer: value.Transformer,
 			Template:    value.Template,
+			Parameters:  decodeParameters(value.Parameters),
 			CreatedBy:   value.CreatedBy,
 			CreatedAt:   value.CreatedAt,
 			UpdatedBy:   value.UpdatedBy,
@@ -179,6 +191,15 @@ func (impl *ActionServiceImpl) RunAction(action ActionDto) (interface{}, error)
 	if err != nil {
 		return nil, err
 	}
+	if action.Environment != "" {
+		env, err := impl.resourceEnvironmentRepository.RetrieveByResourceAndEnvironment(action.Resource, action.Environment)
+		if err != nil {
+			return nil, err
+		}
+		if env != nil {
+			rsc.Options = env.Override(rsc.Options)
+		}
+	}
 	actionFactory := Factory{Type: action.Type}
 	actionAssemblyLine := actionFactory.Build()
 	if actionAssemblyLine == nil {
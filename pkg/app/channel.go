@@ -0,0 +1,124 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+)
+
+type ChannelDto struct {
+	Channel   string    `json:"channel"`
+	Version   int       `json:"version"` // 0 when nothing was released to the channel
+	UpdatedBy int       `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+// ListChannels returns every channel of the app in promotion order.
+func (impl *AppServiceImpl) ListChannels(appID int) ([]ChannelDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil || app.ID == 0 {
+		return nil, ErrAppNotFound
+	}
+	appChannels, err := impl.appChannelRepository.RetrieveByApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]*repository.AppChannel, len(appChannels))
+	for _, appChannel := range appChannels {
+		stored[appChannel.Name] = appChannel
+	}
+	res := make([]ChannelDto, 0, len(repository.CHANNELS))
+	for _, name := range repository.CHANNELS {
+		channelDto := ChannelDto{Channel: name}
+		if name == repository.CHANNEL_PRODUCTION {
+			channelDto.Version = app.ReleaseVersion
+		} else if appChannel, hit := stored[name]; hit {
+			channelDto.Version = appChannel.Version
+			channelDto.UpdatedBy = appChannel.UpdatedBy
+			channelDto.UpdatedAt = appChannel.UpdatedAt
+		}
+		res = append(res, channelDto)
+	}
+	return res, nil
+}
+
+// ChannelVersion returns the released version the channel points to.
+func (impl *AppServiceImpl) ChannelVersion(appID int, channel string) (int, error) {
+	if !repository.IsValidChannel(channel) {
+		return 0, fmt.Errorf("unknown channel %s", channel)
+	}
+	channels, err := impl.ListChannels(appID)
+	if err != nil {
+		return 0, err
+	}
+	for _, channelDto := range channels {
+		if channelDto.Channel == channel && channelDto.Version != repository.APP_EDIT_VERSION {
+			return channelDto.Version, nil
+		}
+	}
+	return 0, fmt.Errorf("nothing was released to the %s channel", channel)
+}
+
+// PromoteChannel points a later channel to the version of an earlier one, e.g. staging to production.
+func (impl *AppServiceImpl) PromoteChannel(appID int, from, to string, userID int) (ChannelDto, error) {
+	if !repository.IsValidChannel(from) || !repository.IsValidChannel(to) {
+		return ChannelDto{}, fmt.Errorf("unknown channel %s or %s", from, to)
+	}
+	if repository.ChannelRank(to) <= repository.ChannelRank(from) {
+		return ChannelDto{}, errors.New("a release can only be promoted to a later channel")
+	}
+	version, err := impl.ChannelVersion(appID, from)
+	if err != nil {
+		return ChannelDto{}, err
+	}
+	err = impl.transaction(func(tx *AppServiceImpl) error {
+		return tx.pointChannel(appID, to, version, userID)
+	})
+	if err != nil {
+		return ChannelDto{}, err
+	}
+	return ChannelDto{Channel: to, Version: version, UpdatedBy: userID, UpdatedAt: time.Now().UTC()}, nil
+}
+
+// pointChannel moves the channel to the version, the production channel is the app release version.
+func (impl *AppServiceImpl) pointChannel(appID int, channel string, version, userID int) error {
+	if channel != repository.CHANNEL_PRODUCTION {
+		return impl.appChannelRepository.Upsert(&repository.AppChannel{
+			AppRefID:  appID,
+			Name:      channel,
+			Version:   version,
+			UpdatedBy: userID,
+			UpdatedAt: time.Now().UTC(),
+		})
+	}
+	app, err := impl.appRepository.RetrieveAppByIDForUpdate(appID)
+	if err != nil {
+		return err
+	}
+	if app == nil || app.ID == 0 {
+		return ErrAppNotFound
+	}
+	app.ReleaseVersion = version
+	app.UpdatedBy = userID
+	app.UpdatedAt = time.Now().UTC()
+	return impl.appRepository.Update(app)
+}
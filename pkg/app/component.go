@@ -44,6 +44,7 @@ type ComponentNode struct {
 	Z              int                    `json:"z"`
 	Props          map[string]interface{} `json:"props"`
 	PanelConfig    map[string]interface{} `json:"panelConfig"`
+	Revision       int                    `json:"revision,omitempty"`
 }
 
 func newComponentNodeFromJSON(cnodebyte []byte) (*ComponentNode, error) {
@@ -70,6 +71,7 @@ func buildComponentTree(treeState *repository.TreeState, treeStateMap map[int]*r
 	if cnode, err = newComponentNodeFromJSON([]byte(treeState.Content)); err != nil {
 		return nil, err
 	}
+	cnode.Revision = treeState.Revision
 	var treestateIDs []int
 	treestateIDs, err = treeState.ExportChildrenNodeRefIDs()
 	if err != nil {
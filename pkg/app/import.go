@@ -0,0 +1,217 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+)
+
+// ImportApp creates a new app whose edit version holds the editor data, in one transaction.
+// The resource ids of the actions must already point to resources of this deployment.
+func (impl *AppServiceImpl) ImportApp(name string, editor Editor, userID int) (AppDto, error) {
+	if editor.Components == nil {
+		return AppDto{}, errors.New("import app error: no component")
+	}
+	actionTypes := make(map[string]int, len(type_array))
+	for i, actionType := range type_array {
+		actionTypes[actionType] = i
+	}
+	for _, action := range editor.Actions {
+		if _, ok := actionTypes[action.Type]; !ok {
+			return AppDto{}, errors.New("import app error: unsupported action type " + action.Type)
+		}
+	}
+
+	now := time.Now().UTC()
+	app := AppDto{
+		Name:      name,
+		CreatedBy: userID,
+		CreatedAt: now,
+		UpdatedBy: userID,
+		UpdatedAt: now,
+	}
+	err := impl.transaction(func(tx *AppServiceImpl) error {
+		var err error
+		app.ID, err = tx.appRepository.Create(&repository.App{
+			Name:            app.Name,
+			ReleaseVersion:  app.ReleaseVersion,
+			MainlineVersion: app.MainlineVersion,
+			CreatedBy:       app.CreatedBy,
+			CreatedAt:       app.CreatedAt,
+			UpdatedBy:       app.UpdatedBy,
+			UpdatedAt:       app.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.importComponentTree(app.ID, userID, editor.Components, repository.TREE_STATE_SUMMIT_ID); err != nil {
+			return err
+		}
+		if err := tx.importKVStates(app.ID, userID, editor); err != nil {
+			return err
+		}
+		for _, displayName := range editor.DisplayNameState {
+			if err := tx.setstateRepository.Create(&repository.SetState{
+				StateType: repository.SET_STATE_TYPE_DISPLAY_NAME,
+				AppRefID:  app.ID,
+				Version:   repository.APP_EDIT_VERSION,
+				Value:     displayName,
+				CreatedAt: now,
+				CreatedBy: userID,
+				UpdatedAt: now,
+				UpdatedBy: userID,
+			}); err != nil {
+				return err
+			}
+		}
+		for _, action := range editor.Actions {
+			if _, err := tx.actionRepository.Create(&repository.Action{
+				App:         app.ID,
+				Version:     repository.APP_EDIT_VERSION,
+				Resource:    action.Resource,
+				Name:        action.DisplayName,
+				Type:        actionTypes[action.Type],
+				TriggerMode: action.TriggerMode,
+				Transformer: action.Transformer,
+				Template:    action.Template,
+				Parameters:  action.Parameters,
+				CreatedAt:   now,
+				CreatedBy:   userID,
+				UpdatedAt:   now,
+				UpdatedBy:   userID,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return AppDto{}, err
+	}
+	userRecord, _ := impl.userRepository.RetrieveByID(userID)
+	app.AppActivity.Modifier = userRecord.Nickname
+	app.AppActivity.ModifiedAt = app.UpdatedAt
+	return app, nil
+}
+
+// importComponentTree stores the node and its children depth first, the summit node is stored as `rootDsl`.
+func (impl *AppServiceImpl) importComponentTree(appID, userID int, node *ComponentNode, parentID int) (int, error) {
+	name := node.DisplayName
+	if parentID == repository.TREE_STATE_SUMMIT_ID {
+		name = repository.TREE_STATE_ROOTDSL_NAME
+	}
+	// the relations and the revision are kept in their own columns, not in the content
+	children := node.ChildrenNode
+	parentNode := node.ParentNode
+	revision := node.Revision
+	node.ChildrenNode = nil
+	node.ParentNode = ""
+	node.Revision = 0
+	content, err := json.Marshal(node)
+	node.ChildrenNode = children
+	node.ParentNode = parentNode
+	node.Revision = revision
+	if err != nil {
+		return 0, err
+	}
+
+	treestate := &repository.TreeState{
+		StateType:          repository.TREE_STATE_TYPE_COMPONENTS,
+		ParentNodeRefID:    parentID,
+		ChildrenNodeRefIDs: "[]",
+		AppRefID:           appID,
+		Version:            repository.APP_EDIT_VERSION,
+		Name:               name,
+		Content:            string(content),
+		CreatedAt:          time.Now().UTC(),
+		CreatedBy:          userID,
+		UpdatedAt:          time.Now().UTC(),
+		UpdatedBy:          userID,
+	}
+	id, err := impl.treestateRepository.Create(treestate)
+	if err != nil {
+		return 0, err
+	}
+	if len(children) == 0 {
+		return id, nil
+	}
+	childrenIDs := make([]int, 0, len(children))
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		childID, err := impl.importComponentTree(appID, userID, child, id)
+		if err != nil {
+			return 0, err
+		}
+		childrenIDs = append(childrenIDs, childID)
+	}
+	idsjsonb, err := json.Marshal(childrenIDs)
+	if err != nil {
+		return 0, err
+	}
+	treestate.ID = id
+	treestate.ChildrenNodeRefIDs = string(idsjsonb)
+	if err := impl.treestateRepository.Update(treestate); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (impl *AppServiceImpl) importKVStates(appID, userID int, editor Editor) error {
+	states := map[int]map[string]interface{}{
+		repository.KV_STATE_TYPE_DRAG_SHADOW:        editor.DragShadowState,
+		repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE: editor.DottedLineSquareState,
+	}
+	dependencies := map[string]interface{}{}
+	for key, value := range editor.DependenciesState {
+		dependencies[key] = value
+	}
+	states[repository.KV_STATE_TYPE_DEPENDENCIES] = dependencies
+
+	for stateType, values := range states {
+		for key, value := range values {
+			var serialized string
+			// some states are exported with their stored json text as value
+			if text, ok := value.(string); ok && json.Valid([]byte(text)) {
+				serialized = text
+			} else {
+				valuejsonb, err := json.Marshal(value)
+				if err != nil {
+					return err
+				}
+				serialized = string(valuejsonb)
+			}
+			if err := impl.kvstateRepository.Create(&repository.KVState{
+				StateType: stateType,
+				AppRefID:  appID,
+				Version:   repository.APP_EDIT_VERSION,
+				Key:       key,
+				Value:     serialized,
+				CreatedAt: time.Now().UTC(),
+				CreatedBy: userID,
+				UpdatedAt: time.Now().UTC(),
+				UpdatedBy: userID,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func importedEditor() Editor {
+	return Editor{
+		Components: &ComponentNode{DisplayName: "root", ChildrenNode: []*ComponentNode{
+			{DisplayName: "container1", ParentNode: "root", ChildrenNode: []*ComponentNode{
+				{DisplayName: "button1", ParentNode: "container1"},
+			}},
+		}},
+		DependenciesState:     map[string][]string{"button1": {"query1"}},
+		DottedLineSquareState: map[string]interface{}{"button1": "{\"h\":1}"},
+		DisplayNameState:      []string{"container1", "button1"},
+		Actions: []Action{
+			{DisplayName: "query1", Type: "restapi", Resource: 7, TriggerMode: "manually"},
+			{DisplayName: "transformer1", Type: "transformer", TriggerMode: "automate"},
+		},
+	}
+}
+
+func TestImportAppRebuildsComponentTree(t *testing.T) {
+	service, db, _ := newTestAppService(t)
+
+	res, err := service.ImportApp("imported", importedEditor(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "imported", db.apps[res.ID].Name)
+
+	names := map[int]string{}
+	byName := map[string]repository.TreeState{}
+	for id, treestate := range db.treestates {
+		if treestate.AppRefID == res.ID {
+			names[id] = treestate.Name
+			byName[treestate.Name] = treestate
+		}
+	}
+	assert.Len(t, byName, 3)
+	root := byName["rootDsl"]
+	assert.Equal(t, repository.TREE_STATE_SUMMIT_ID, root.ParentNodeRefID)
+	assert.Equal(t, "rootDsl", names[byName["container1"].ParentNodeRefID])
+	assert.Equal(t, "container1", names[byName["button1"].ParentNodeRefID])
+	assert.Equal(t, "button1", names[mustChildren(t, byName["container1"])[0]])
+	assert.NotContains(t, byName["button1"].Content, "parentNode\":\"container1")
+
+	for _, kvstate := range db.kvstates {
+		if kvstate.AppRefID == res.ID && kvstate.StateType == repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE {
+			assert.Equal(t, "{\"h\":1}", kvstate.Value)
+		}
+	}
+	types := map[string]int{}
+	for _, action := range db.actions {
+		if action.App == res.ID {
+			types[action.Name] = action.Type
+		}
+	}
+	assert.Equal(t, map[string]int{"query1": 1, "transformer1": 0}, types)
+}
+
+func mustChildren(t *testing.T, treestate repository.TreeState) []int {
+	var ids []int
+	assert.Nil(t, json.Unmarshal([]byte(treestate.ChildrenNodeRefIDs), &ids))
+	assert.NotEmpty(t, ids)
+	return ids
+}
+
+func TestImportAppRollsBackOnPartialFailure(t *testing.T) {
+	service, db, _ := newTestAppService(t)
+	before := db.clone()
+	db.failOn = "action.create"
+
+	_, err := service.ImportApp("imported", importedEditor(), 2)
+	assert.ErrorIs(t, err, errInjected)
+	assert.Equal(t, before.apps, db.apps)
+	assert.Equal(t, before.treestates, db.treestates)
+	assert.Equal(t, before.kvstates, db.kvstates)
+
+	editor := importedEditor()
+	editor.Actions[0].Type = "soap"
+	_, err = service.ImportApp("imported", editor, 2)
+	assert.NotNil(t, err)
+}
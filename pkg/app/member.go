@@ -0,0 +1,190 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/user"
+
+	"gorm.io/gorm"
+)
+
+// the roles of the users on an app, the owner is the creator of the app.
+const (
+	ROLE_OWNER  = "owner"
+	ROLE_EDITOR = "editor"
+	ROLE_VIEWER = "viewer"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+var ErrMemberNotFound = errors.New("the user is not found")
+var ErrOwnerRoleFixed = errors.New("the role of the owner can not be changed")
+
+var roleNames = map[int]string{
+	repository.APP_ROLE_VIEWER: ROLE_VIEWER,
+	repository.APP_ROLE_EDITOR: ROLE_EDITOR,
+}
+
+var roleValues = map[string]int{
+	ROLE_VIEWER: repository.APP_ROLE_VIEWER,
+	ROLE_EDITOR: repository.APP_ROLE_EDITOR,
+}
+
+type MemberDto struct {
+	UserID   int    `json:"userId"`
+	Nickname string `json:"nickname"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// IsAppEditableByUser checks if the app exists and the user can edit it, the owner and the editors of
+// the app can.
+func (impl *AppServiceImpl) IsAppEditableByUser(appID, userID int) (bool, error) {
+	role, err := impl.roleOf(appID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role == ROLE_OWNER || role == ROLE_EDITOR, nil
+}
+
+// IsAppViewableByUser checks if the app exists and the user can view it, every member of the app can.
+func (impl *AppServiceImpl) IsAppViewableByUser(appID, userID int) (bool, error) {
+	role, err := impl.roleOf(appID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role != "", nil
+}
+
+// IsAppOwnedByUser checks if the app exists and the user created it.
+func (impl *AppServiceImpl) IsAppOwnedByUser(appID, userID int) (bool, error) {
+	role, err := impl.roleOf(appID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role == ROLE_OWNER, nil
+}
+
+// roleOf returns the role of the user on the app, it is empty when the app does not exist or the user
+// is not a member of it.
+func (impl *AppServiceImpl) roleOf(appID, userID int) (string, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if app == nil || app.ID == 0 || userID == 0 {
+		return "", nil
+	}
+	if app.CreatedBy == userID {
+		return ROLE_OWNER, nil
+	}
+	member, err := impl.appMemberRepository.RetrieveByAppAndUser(appID, userID)
+	if err != nil {
+		return "", err
+	}
+	if member == nil {
+		return "", nil
+	}
+	return roleNames[member.Role], nil
+}
+
+// ListMembers returns the owner of the app and then the members in the order they were added.
+func (impl *AppServiceImpl) ListMembers(appID int) ([]MemberDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := impl.appMemberRepository.RetrieveByApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	memberDtos := make([]MemberDto, 0, len(members)+1)
+	if userRecord, err := impl.userRepository.RetrieveByID(app.CreatedBy); err == nil && userRecord.ID != 0 {
+		memberDtos = append(memberDtos, MemberDto{UserID: userRecord.ID, Nickname: userRecord.Nickname, Email: userRecord.Email, Role: ROLE_OWNER})
+	}
+	for _, member := range members {
+		userRecord, err := impl.userRepository.RetrieveByID(member.UserID)
+		if err != nil {
+			continue
+		}
+		memberDtos = append(memberDtos, MemberDto{UserID: userRecord.ID, Nickname: userRecord.Nickname, Email: userRecord.Email, Role: roleNames[member.Role]})
+	}
+	return memberDtos, nil
+}
+
+// SetMember grants the registered user of the email the role on the app, or changes the role the user
+// has. The invitation mail is sent to the new members.
+func (impl *AppServiceImpl) SetMember(appID int, email, role string, operatorID int) (MemberDto, error) {
+	roleValue, ok := roleValues[role]
+	if !ok {
+		return MemberDto{}, ErrInvalidRole
+	}
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return MemberDto{}, err
+	}
+	userRecord, err := impl.userRepository.FetchUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && userRecord.ID == 0) {
+		return MemberDto{}, ErrMemberNotFound
+	}
+	if err != nil {
+		return MemberDto{}, err
+	}
+	if userRecord.ID == app.CreatedBy {
+		return MemberDto{}, ErrOwnerRoleFixed
+	}
+	member, err := impl.appMemberRepository.RetrieveByAppAndUser(appID, userRecord.ID)
+	if err != nil {
+		return MemberDto{}, err
+	}
+	now := time.Now().UTC()
+	if err := impl.appMemberRepository.Upsert(&repository.AppMember{
+		AppRefID:  appID,
+		UserID:    userRecord.ID,
+		Role:      roleValue,
+		CreatedBy: operatorID,
+		CreatedAt: now,
+		UpdatedBy: operatorID,
+		UpdatedAt: now,
+	}); err != nil {
+		return MemberDto{}, err
+	}
+	if member == nil {
+		impl.notifyAppInvitation(app, userRecord, operatorID)
+	}
+	return MemberDto{UserID: userRecord.ID, Nickname: userRecord.Nickname, Email: userRecord.Email, Role: role}, nil
+}
+
+// notifyAppInvitation sends the invitation mail to the new member, the mail is delivered by the outbox.
+func (impl *AppServiceImpl) notifyAppInvitation(app *repository.App, invitee *repository.User, operatorID int) {
+	inviter, err := impl.userRepository.RetrieveByID(operatorID)
+	if err != nil || inviter.ID == 0 {
+		return
+	}
+	if err := impl.smtpServer.SendAppInvitation(invitee.Email, user.LanguageByIndex(invitee.Language), inviter.Nickname,
+		app.Name, app.ID); err != nil {
+		impl.logger.Errorw("send app invitation mail error", "err", err)
+	}
+}
+
+func (impl *AppServiceImpl) RemoveMember(appID, userID int) error {
+	return impl.appMemberRepository.Delete(appID, userID)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/smtp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryOutbox keeps the mails put into the outbox.
+type memoryOutbox struct {
+	repository.MailOutboxRepository
+	mails []*repository.MailOutbox
+}
+
+func (o *memoryOutbox) Create(mail *repository.MailOutbox) (int, error) {
+	o.mails = append(o.mails, mail)
+	return len(o.mails), nil
+}
+
+func TestEditPermissionFollowsRoles(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+	service.userRepository = memoryUsers{users: map[int]repository.User{
+		1: {ID: 1, Nickname: "owner", Email: "owner@example.com"},
+		2: {ID: 2, Nickname: "editor", Email: "editor@example.com"},
+		3: {ID: 3, Nickname: "viewer", Email: "viewer@example.com"},
+	}}
+	outbox := &memoryOutbox{}
+	service.smtpServer = smtp.NewSMTPServer(&smtp.Config{Username: "noreply@example.com", WebURL: "https://builder.example.com"}, outbox)
+	editable := func(userID int) bool {
+		ok, err := service.IsAppEditableByUser(appID, userID)
+		assert.Nil(t, err)
+		return ok
+	}
+
+	// only the creator of the app can edit it at first
+	assert.True(t, editable(1))
+	assert.False(t, editable(2))
+	assert.False(t, editable(3))
+	ok, err := service.IsAppEditableByUser(appID+100, 1)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = service.SetMember(appID, "editor@example.com", ROLE_EDITOR, 1)
+	assert.Nil(t, err)
+	_, err = service.SetMember(appID, "viewer@example.com", ROLE_VIEWER, 1)
+	assert.Nil(t, err)
+	assert.Len(t, outbox.mails, 2)
+	assert.True(t, editable(2))
+	assert.False(t, editable(3))
+	for userID, viewable := range map[int]bool{1: true, 2: true, 3: true, 4: false} {
+		ok, err := service.IsAppViewableByUser(appID, userID)
+		assert.Nil(t, err)
+		assert.Equal(t, viewable, ok, "user %d", userID)
+	}
+	owned, err := service.IsAppOwnedByUser(appID, 2)
+	assert.Nil(t, err)
+	assert.False(t, owned)
+
+	_, err = service.SetMember(appID, "owner@example.com", ROLE_VIEWER, 1)
+	assert.Equal(t, ErrOwnerRoleFixed, err)
+	_, err = service.SetMember(appID, "nobody@example.com", ROLE_EDITOR, 1)
+	assert.Equal(t, ErrMemberNotFound, err)
+	_, err = service.SetMember(appID, "viewer@example.com", ROLE_OWNER, 1)
+	assert.Equal(t, ErrInvalidRole, err)
+	members, err := service.ListMembers(appID)
+	assert.Nil(t, err)
+	assert.Equal(t, []MemberDto{
+		{UserID: 1, Nickname: "owner", Email: "owner@example.com", Role: ROLE_OWNER},
+		{UserID: 2, Nickname: "editor", Email: "editor@example.com", Role: ROLE_EDITOR},
+		{UserID: 3, Nickname: "viewer", Email: "viewer@example.com", Role: ROLE_VIEWER},
+	}, members)
+
+	// the members leave with the app
+	assert.Nil(t, service.RemoveMember(appID, 2))
+	assert.False(t, editable(2))
+	assert.Nil(t, service.DeleteApp(appID))
+	assert.Empty(t, db.appMembers)
+}
+
+func TestNewMembersAreInvited(t *testing.T) {
+	service, _, appID := newTestAppService(t)
+	service.userRepository = memoryUsers{users: map[int]repository.User{
+		1: {ID: 1, Nickname: "owner", Email: "owner@example.com"},
+		2: {ID: 2, Nickname: "editor", Email: "editor@example.com", Language: 2},
+	}}
+	outbox := &memoryOutbox{}
+	service.smtpServer = smtp.NewSMTPServer(&smtp.Config{Username: "noreply@example.com", WebURL: "https://builder.example.com"}, outbox)
+
+	_, err := service.SetMember(appID, "editor@example.com", ROLE_VIEWER, 1)
+	assert.Nil(t, err)
+	assert.Len(t, outbox.mails, 1)
+	assert.Equal(t, "editor@example.com", outbox.mails[0].Recipient)
+	assert.Equal(t, smtp.MAIL_APP_INVITATION, outbox.mails[0].Kind)
+	assert.Equal(t, repository.MAIL_STATUS_PENDING, outbox.mails[0].Status)
+
+	// changing the role of a member is not an invitation
+	_, err = service.SetMember(appID, "editor@example.com", ROLE_EDITOR, 1)
+	assert.Nil(t, err)
+	assert.Len(t, outbox.mails, 1)
+	assert.Nil(t, service.RemoveMember(appID, 2))
+	_, err = service.SetMember(appID, "editor@example.com", ROLE_EDITOR, 1)
+	assert.Nil(t, err)
+	assert.Len(t, outbox.mails, 2)
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/illa-family/builder-backend/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// a visitor of a password protected app signs in again after this duration.
+const PUBLIC_TOKEN_TTL = time.Hour * 12
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{2,63}$`)
+
+var ErrPublicAppNotFound = errors.New("public app not found")
+var ErrPublicPasswordRequired = errors.New("password required")
+var ErrSlugTaken = errors.New("slug is used by another app")
+var ErrInvalidPublishSetting = errors.New("invalid publish setting")
+
+type PublishDto struct {
+	AppID             int       `json:"appId"`
+	Slug              string    `json:"slug"`
+	Enabled           bool      `json:"enabled"`
+	Password          *string   `json:"password,omitempty"` // write only, keeps the password when absent and removes it when empty
+	PasswordProtected bool      `json:"passwordProtected"`
+	AllowEmbed        bool      `json:"allowEmbed"`
+	EmbedOrigins      []string  `json:"embedOrigins"`
+	UpdatedBy         int       `json:"updatedBy,omitempty"`
+	UpdatedAt         time.Time `json:"updatedAt,omitempty"`
+}
+
+func (publishd *PublishDto) ConstructByRecord(appPublish *repository.AppPublish) {
+	publishd.AppID = appPublish.AppRefID
+	publishd.Slug = appPublish.Slug
+	publishd.Enabled = appPublish.Enabled
+	publishd.PasswordProtected = appPublish.PasswordHash != ""
+	publishd.AllowEmbed = appPublish.AllowEmbed
+	publishd.EmbedOrigins = strings.Fields(appPublish.EmbedOrigins)
+	publishd.UpdatedBy = appPublish.UpdatedBy
+	publishd.UpdatedAt = appPublish.UpdatedAt
+}
+
+// PublicApp is the released version a public slug resolves to.
+type PublicApp struct {
+	AppID             int
+	Slug              string
+	Version           int
+	PasswordProtected bool
+	AllowEmbed        bool
+	EmbedOrigins      []string
+	passwordHash      string
+}
+
+type PublicAppClaims struct {
+	Slug     string `json:"slug"`
+	Password string `json:"pwd"` // digest of the password hash, a changed password invalidates the token
+	jwt.RegisteredClaims
+}
+
+func (impl *AppServiceImpl) GetPublishSetting(appID int) (PublishDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return PublishDto{}, err
+	}
+	if app == nil || app.ID == 0 {
+		return PublishDto{}, ErrAppNotFound
+	}
+	appPublish, err := impl.appPublishRepository.RetrieveByApp(appID)
+	if err != nil {
+		return PublishDto{}, err
+	}
+	res := PublishDto{AppID: appID, EmbedOrigins: []string{}}
+	if appPublish != nil {
+		res.ConstructByRecord(appPublish)
+	}
+	return res, nil
+}
+
+func (impl *AppServiceImpl) SetPublishSetting(publish PublishDto) (PublishDto, error) {
+	if !slugPattern.MatchString(publish.Slug) {
+		return PublishDto{}, fmt.Errorf("%w: the slug must be 3 to 64 lowercase letters, digits or dashes", ErrInvalidPublishSetting)
+	}
+	for _, origin := range publish.EmbedOrigins {
+		if !strings.HasPrefix(origin, "https://") && !strings.HasPrefix(origin, "http://") || strings.ContainsAny(origin, " ;,'\"") {
+			return PublishDto{}, fmt.Errorf("%w: invalid embed origin %s", ErrInvalidPublishSetting, origin)
+		}
+	}
+	app, err := impl.appRepository.RetrieveAppByID(publish.AppID)
+	if err != nil {
+		return PublishDto{}, err
+	}
+	if app == nil || app.ID == 0 {
+		return PublishDto{}, ErrAppNotFound
+	}
+	owner, err := impl.appPublishRepository.RetrieveBySlug(publish.Slug)
+	if err != nil {
+		return PublishDto{}, err
+	}
+	if owner != nil && owner.AppRefID != publish.AppID {
+		return PublishDto{}, ErrSlugTaken
+	}
+	stored, err := impl.appPublishRepository.RetrieveByApp(publish.AppID)
+	if err != nil {
+		return PublishDto{}, err
+	}
+
+	appPublish := &repository.AppPublish{
+		AppRefID:     publish.AppID,
+		Slug:         publish.Slug,
+		Enabled:      publish.Enabled,
+		AllowEmbed:   publish.AllowEmbed,
+		EmbedOrigins: strings.Join(publish.EmbedOrigins, " "),
+		CreatedBy:    publish.UpdatedBy,
+		CreatedAt:    time.Now().UTC(),
+		UpdatedBy:    publish.UpdatedBy,
+		UpdatedAt:    time.Now().UTC(),
+	}
+	if stored != nil {
+		appPublish.PasswordHash = stored.PasswordHash
+	}
+	if publish.Password != nil {
+		appPublish.PasswordHash = ""
+		if *publish.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(*publish.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return PublishDto{}, err
+			}
+			appPublish.PasswordHash = string(hash)
+		}
+	}
+	if err := impl.appPublishRepository.Upsert(appPublish); err != nil {
+		return PublishDto{}, err
+	}
+	res := PublishDto{}
+	res.ConstructByRecord(appPublish)
+	return res, nil
+}
+
+// ResolvePublicApp returns the released version of the enabled slug.
+func (impl *AppServiceImpl) ResolvePublicApp(slug string) (PublicApp, error) {
+	appPublish, err := impl.appPublishRepository.RetrieveBySlug(slug)
+	if err != nil {
+		return PublicApp{}, err
+	}
+	if appPublish == nil || !appPublish.Enabled {
+		return PublicApp{}, ErrPublicAppNotFound
+	}
+	app, err := impl.appRepository.RetrieveAppByID(appPublish.AppRefID)
+	if err != nil {
+		return PublicApp{}, err
+	}
+	if app == nil || app.ID == 0 || app.ReleaseVersion == repository.APP_EDIT_VERSION {
+		return PublicApp{}, ErrPublicAppNotFound
+	}
+	return PublicApp{
+		AppID:             app.ID,
+		Slug:              appPublish.Slug,
+		Version:           app.ReleaseVersion,
+		PasswordProtected: appPublish.PasswordHash != "",
+		AllowEmbed:        appPublish.AllowEmbed,
+		EmbedOrigins:      strings.Fields(appPublish.EmbedOrigins),
+		passwordHash:      appPublish.PasswordHash,
+	}, nil
+}
+
+// AuthorizePublicApp resolves the slug, the token is required when the app is password protected.
+func (impl *AppServiceImpl) AuthorizePublicApp(slug, token string) (PublicApp, error) {
+	publicApp, err := impl.ResolvePublicApp(slug)
+	if err != nil {
+		return PublicApp{}, err
+	}
+	if !publicApp.PasswordProtected {
+		return publicApp, nil
+	}
+	if token == "" {
+		return publicApp, ErrPublicPasswordRequired
+	}
+	claims := &PublicAppClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return publicKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !parsed.Valid || claims.Slug != slug || claims.Password != passwordDigest(publicApp.passwordHash) {
+		return publicApp, ErrPublicPasswordRequired
+	}
+	return publicApp, nil
+}
+
+// SignInPublicApp checks the password of the slug and returns the token for the visitor.
+func (impl *AppServiceImpl) SignInPublicApp(slug, password string) (string, error) {
+	publicApp, err := impl.ResolvePublicApp(slug)
+	if err != nil {
+		return "", err
+	}
+	if !publicApp.PasswordProtected {
+		return "", fmt.Errorf("%w: the app is not password protected", ErrInvalidPublishSetting)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(publicApp.passwordHash), []byte(password)); err != nil {
+		return "", ErrPublicPasswordRequired
+	}
+	claims := &PublicAppClaims{
+		Slug:     slug,
+		Password: passwordDigest(publicApp.passwordHash),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "ILLA",
+			ExpiresAt: &jwt.NumericDate{Time: time.Now().Add(PUBLIC_TOKEN_TTL)},
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(publicKey())
+}
+
+// GetPublicMegaData returns the released version without the modifier and the templates run on the server.
+func (impl *AppServiceImpl) GetPublicMegaData(publicApp PublicApp) (Editor, error) {
+	editor, err := impl.fetchEditor(publicApp.AppID, publicApp.Version)
+	if err != nil {
+		return Editor{}, err
+	}
+	editor.AppInfo = AppDto{
+		ID:              editor.AppInfo.ID,
+		Name:            editor.AppInfo.Name,
+		ReleaseVersion:  editor.AppInfo.ReleaseVersion,
+		MainlineVersion: editor.AppInfo.ReleaseVersion,
+		UpdatedAt:       editor.AppInfo.UpdatedAt,
+	}
+	for i := range editor.Actions {
+		editor.Actions[i].Resource = 0
+		editor.Actions[i].CreatedBy = 0
+		editor.Actions[i].UpdatedBy = 0
+		if editor.Actions[i].Type != type_array[0] {
+			editor.Actions[i].Template = map[string]interface{}{}
+		}
+	}
+	return editor, nil
+}
+
+func passwordDigest(passwordHash string) string {
+	digest := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(digest[:8])
+}
+
+// the public token is signed with a derived key so it can never be accepted as an access token.
+func publicKey() []byte {
+	return []byte(os.Getenv("ILLA_SECRET_KEY") + "/public-app")
+}
@@ -0,0 +1,86 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishSettingValidation(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+
+	_, err := service.SetPublishSetting(PublishDto{AppID: appID, Slug: "No Spaces"})
+	assert.ErrorIs(t, err, ErrInvalidPublishSetting)
+	_, err = service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders", EmbedOrigins: []string{"https://a.com; script-src *"}})
+	assert.ErrorIs(t, err, ErrInvalidPublishSetting)
+
+	res, err := service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders", Enabled: true, AllowEmbed: true,
+		EmbedOrigins: []string{"https://intranet.example.com"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://intranet.example.com", db.publishes[appID].EmbedOrigins)
+	assert.False(t, res.PasswordProtected)
+
+	otherID, _ := memoryApps{db: db}.Create(&repository.App{Name: "other"})
+	_, err = service.SetPublishSetting(PublishDto{AppID: otherID, Slug: "orders"})
+	assert.ErrorIs(t, err, ErrSlugTaken)
+}
+
+func TestPublicAppNeedsReleaseAndPassword(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+	password := "open sesame"
+	_, err := service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders", Enabled: true, Password: &password})
+	assert.Nil(t, err)
+	assert.NotContains(t, db.publishes[appID].PasswordHash, password)
+
+	// nothing was released yet
+	_, err = service.ResolvePublicApp("orders")
+	assert.ErrorIs(t, err, ErrPublicAppNotFound)
+	_, err = service.ReleaseApp(appID, 1, "", "")
+	assert.Nil(t, err)
+
+	_, err = service.AuthorizePublicApp("orders", "")
+	assert.ErrorIs(t, err, ErrPublicPasswordRequired)
+	_, err = service.SignInPublicApp("orders", "wrong")
+	assert.ErrorIs(t, err, ErrPublicPasswordRequired)
+	token, err := service.SignInPublicApp("orders", password)
+	assert.Nil(t, err)
+	publicApp, err := service.AuthorizePublicApp("orders", token)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, publicApp.Version)
+
+	// the slug is part of the token
+	_, err = service.AuthorizePublicApp("orders-2", token)
+	assert.NotNil(t, err)
+
+	// a new password signs every visitor out, the password is kept when absent
+	changed := "new password"
+	_, err = service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders", Enabled: true, Password: &changed})
+	assert.Nil(t, err)
+	_, err = service.AuthorizePublicApp("orders", token)
+	assert.ErrorIs(t, err, ErrPublicPasswordRequired)
+	res, err := service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders", Enabled: true})
+	assert.Nil(t, err)
+	assert.True(t, res.PasswordProtected)
+
+	// disabled apps are not public anymore
+	_, err = service.SetPublishSetting(PublishDto{AppID: appID, Slug: "orders"})
+	assert.Nil(t, err)
+	_, err = service.ResolvePublicApp("orders")
+	assert.ErrorIs(t, err, ErrPublicAppNotFound)
+}
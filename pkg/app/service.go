@@ -21,6 +21,8 @@ import (
 	"time"
 
 	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/smtp"
+	"github.com/illa-family/builder-backend/pkg/user"
 
 	"go.uber.org/zap"
 )
@@ -32,19 +34,47 @@ type AppService interface {
 	DeleteApp(appID int) error
 	GetAllApps() ([]AppDto, error)
 	DuplicateApp(appID, userID int, name string) (AppDto, error)
-	ReleaseApp(appID int) (int, error)
+	ReleaseApp(appID, userID int, notes, channel string) (int, error)
 	GetMegaData(appID, version int) (Editor, error)
+	IsAppEditableByUser(appID, userID int) (bool, error)
+	IsAppViewableByUser(appID, userID int) (bool, error)
+	IsAppOwnedByUser(appID, userID int) (bool, error)
+	ListMembers(appID int) ([]MemberDto, error)
+	SetMember(appID int, email, role string, operatorID int) (MemberDto, error)
+	RemoveMember(appID, userID int) error
+	ListVersions(appID int) ([]AppVersionDto, error)
+	DiffVersions(appID, from, to int) (VersionDiff, error)
+	RestoreEditVersion(appID, version, userID int) (AppDto, error)
+	SetReleaseVersion(appID, version, userID int) (AppDto, error)
+	ListChannels(appID int) ([]ChannelDto, error)
+	ChannelVersion(appID int, channel string) (int, error)
+	PromoteChannel(appID int, from, to string, userID int) (ChannelDto, error)
+	ImportApp(name string, editor Editor, userID int) (AppDto, error)
+	GetPublishSetting(appID int) (PublishDto, error)
+	SetPublishSetting(publish PublishDto) (PublishDto, error)
+	ResolvePublicApp(slug string) (PublicApp, error)
+	AuthorizePublicApp(slug, token string) (PublicApp, error)
+	SignInPublicApp(slug, password string) (string, error)
+	GetPublicMegaData(publicApp PublicApp) (Editor, error)
 }
 
 type AppServiceImpl struct {
-	logger              *zap.SugaredLogger
-	appRepository       repository.AppRepository
-	userRepository      repository.UserRepository
-	kvstateRepository   repository.KVStateRepository
-	treestateRepository repository.TreeStateRepository
-	setstateRepository  repository.SetStateRepository
-	actionRepository    repository.ActionRepository
-}
+	logger               *zap.SugaredLogger
+	appRepository        repository.AppRepository
+	userRepository       repository.UserRepository
+	kvstateRepository    repository.KVStateRepository
+	treestateRepository  repository.TreeStateRepository
+	setstateRepository   repository.SetStateRepository
+	actionRepository     repository.ActionRepository
+	appVersionRepository repository.AppVersionRepository
+	appChannelRepository repository.AppChannelRepository
+	appPublishRepository repository.AppPublishRepository
+	appMemberRepository  repository.AppMemberRepository
+	unitOfWork           repository.UnitOfWork
+	smtpServer           smtp.SMTPServer
+}
+
+var ErrAppNotFound = errors.New("app not found")
 
 var type_array = [8]string{"transformer", "restapi", "graphql", "redis", "mysql", "mariadb", "postgresql", "mongodb"}
 
@@ -111,6 +141,7 @@ type Action struct {
 	Type        string                 `json:"actionType" validate:"oneof=transformer restapi graphql redis mysql mariadb postgresql mongodb"`
 	Template    map[string]interface{} `json:"content" validate:"required"`
 	Transformer map[string]interface{} `json:"transformer" validate:"required"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
 	TriggerMode string                 `json:"triggerMode" validate:"oneof=manually automate"`
 	CreatedAt   time.Time              `json:"createdAt,omitempty"`
 	CreatedBy   int                    `json:"createdBy,omitempty"`
@@ -121,15 +152,23 @@ type Action struct {
 func NewAppServiceImpl(logger *zap.SugaredLogger, appRepository repository.AppRepository,
 	userRepository repository.UserRepository, kvstateRepository repository.KVStateRepository,
 	treestateRepository repository.TreeStateRepository, setstateRepository repository.SetStateRepository,
-	actionRepository repository.ActionRepository) *AppServiceImpl {
+	actionRepository repository.ActionRepository, appVersionRepository repository.AppVersionRepository,
+	appChannelRepository repository.AppChannelRepository, appPublishRepository repository.AppPublishRepository,
+	appMemberRepository repository.AppMemberRepository, unitOfWork repository.UnitOfWork, smtpServer smtp.SMTPServer) *AppServiceImpl {
 	return &AppServiceImpl{
-		logger:              logger,
-		appRepository:       appRepository,
-		userRepository:      userRepository,
-		kvstateRepository:   kvstateRepository,
-		treestateRepository: treestateRepository,
-		setstateRepository:  setstateRepository,
-		actionRepository:    actionRepository,
+		logger:               logger,
+		appRepository:        appRepository,
+		userRepository:       userRepository,
+		kvstateRepository:    kvstateRepository,
+		treestateRepository:  treestateRepository,
+		setstateRepository:   setstateRepository,
+		actionRepository:     actionRepository,
+		appVersionRepository: appVersionRepository,
+		appChannelRepository: appChannelRepository,
+		appPublishRepository: appPublishRepository,
+		appMemberRepository:  appMemberRepository,
+		unitOfWork:           unitOfWork,
+		smtpServer:           smtpServer,
 	}
 }
 
@@ -198,6 +237,23 @@ func (impl *AppServiceImpl) UpdateApp(app AppDto) (AppDto, error) {
 	return app, nil
 }
 
+// transaction runs fn with a copy of the service whose repositories are bound to one transaction.
+func (impl *AppServiceImpl) transaction(fn func(tx *AppServiceImpl) error) error {
+	return impl.unitOfWork.Do(func(repositories *repository.Repositories) error {
+		tx := *impl
+		tx.appRepository = repositories.App
+		tx.treestateRepository = repositories.TreeState
+		tx.kvstateRepository = repositories.KVState
+		tx.setstateRepository = repositories.SetState
+		tx.actionRepository = repositories.Action
+		tx.appVersionRepository = repositories.AppVersion
+		tx.appChannelRepository = repositories.AppChannel
+		tx.appPublishRepository = repositories.AppPublish
+		tx.appMemberRepository = repositories.AppMember
+		return fn(&tx)
+	})
+}
+
 func (impl *AppServiceImpl) FetchAppByID(appID int) (AppDto, error) {
 	app, err := impl.appRepository.RetrieveAppByID(appID)
 	if err != nil {
@@ -214,12 +270,34 @@ func (impl *AppServiceImpl) FetchAppByID(appID int) (AppDto, error) {
 	return res, nil
 }
 
-func (impl *AppServiceImpl) DeleteApp(appID int) error { // TODO: maybe need transaction
-	_ = impl.treestateRepository.DeleteAllTypeTreeStatesByApp(appID)
-	_ = impl.kvstateRepository.DeleteAllTypeKVStatesByApp(appID)
-	_ = impl.actionRepository.DeleteActionsByApp(appID)
-	_ = impl.setstateRepository.DeleteAllTypeSetStatesByApp(appID)
-	return impl.appRepository.Delete(appID)
+func (impl *AppServiceImpl) DeleteApp(appID int) error {
+	return impl.transaction(func(tx *AppServiceImpl) error {
+		if err := tx.treestateRepository.DeleteAllTypeTreeStatesByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.kvstateRepository.DeleteAllTypeKVStatesByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.actionRepository.DeleteActionsByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.setstateRepository.DeleteAllTypeSetStatesByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.appVersionRepository.DeleteByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.appChannelRepository.DeleteByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.appPublishRepository.DeleteByApp(appID); err != nil {
+			return err
+		}
+		if err := tx.appMemberRepository.DeleteByApp(appID); err != nil {
+			return err
+		}
+		return tx.appRepository.Delete(appID)
+	})
 }
 
 func (impl *AppServiceImpl) GetAllApps() ([]AppDto, error) {
@@ -251,20 +329,40 @@ func (impl *AppServiceImpl) DuplicateApp(appID, userID int, name string) (AppDto
 	if err != nil {
 		return AppDto{}, err
 	}
+	if appA == nil || appA.ID == 0 {
+		return AppDto{}, ErrAppNotFound
+	}
 	appA.ReleaseVersion = 0 // the draft version will always be 0, so the release version and mainline version are 0 by default when app init.
 	appA.MainlineVersion = 0
 	appA.CreatedAt = time.Now().UTC()
 	appA.UpdatedAt = time.Now().UTC()
 	appA.CreatedBy = userID
 	appA.UpdatedBy = userID
-	id, err := impl.appRepository.Create(&repository.App{
-		Name:            name,
-		ReleaseVersion:  appA.ReleaseVersion,
-		MainlineVersion: appA.MainlineVersion,
-		CreatedBy:       appA.CreatedBy,
-		CreatedAt:       appA.CreatedAt,
-		UpdatedBy:       appA.UpdatedBy,
-		UpdatedAt:       appA.UpdatedAt,
+	var id int
+	err = impl.transaction(func(tx *AppServiceImpl) error {
+		var err error
+		id, err = tx.appRepository.Create(&repository.App{
+			Name:            name,
+			ReleaseVersion:  appA.ReleaseVersion,
+			MainlineVersion: appA.MainlineVersion,
+			CreatedBy:       appA.CreatedBy,
+			CreatedAt:       appA.CreatedAt,
+			UpdatedBy:       appA.UpdatedBy,
+			UpdatedAt:       appA.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if err := tx.copyAllTreeState(appID, id, userID); err != nil {
+			return err
+		}
+		if err := tx.copyAllKVState(appID, id, userID); err != nil {
+			return err
+		}
+		if err := tx.copyAllSetState(appID, id, userID); err != nil {
+			return err
+		}
+		return tx.copyActions(appID, id, userID)
 	})
 	if err != nil {
 		return AppDto{}, err
@@ -284,10 +382,6 @@ func (impl *AppServiceImpl) DuplicateApp(appID, userID int, name string) (AppDto
 			ModifiedAt: userRecord.UpdatedAt,
 		},
 	}
-	_ = impl.copyAllTreeState(appID, appB.ID, userID)
-	_ = impl.copyAllKVState(appID, appB.ID, userID)
-	_ = impl.copyAllSetState(appID, appB.ID, userID)
-	_ = impl.copyActions(appID, appB.ID, userID)
 	return appB, nil
 }
 
@@ -404,114 +498,97 @@ func (impl *AppServiceImpl) copyActions(appA, appB, user int) error {
 	return nil
 }
 
-func (impl *AppServiceImpl) ReleaseApp(appID int) (int, error) {
-	app, err := impl.appRepository.RetrieveAppByID(appID)
-	if err != nil {
-		return -1, nil
+// ReleaseApp copies the edit version to a new version and points the channel to it, in one transaction.
+// An empty channel releases to production.
+func (impl *AppServiceImpl) ReleaseApp(appID, userID int, notes, channel string) (int, error) {
+	if channel == "" {
+		channel = repository.CHANNEL_PRODUCTION
 	}
-	app.MainlineVersion += 1
-	app.ReleaseVersion = app.MainlineVersion
-	_ = impl.releaseTreeStateByApp(AppDto{ID: appID, MainlineVersion: app.MainlineVersion})
-	_ = impl.releaseKVStateByApp(AppDto{ID: appID, MainlineVersion: app.MainlineVersion})
-	_ = impl.releaseSetStateByApp(AppDto{ID: appID, MainlineVersion: app.MainlineVersion})
-	_ = impl.releaseActionsByApp(AppDto{ID: appID, MainlineVersion: app.MainlineVersion})
-	if err := impl.appRepository.Update(app); err != nil {
-		return -1, nil
-	}
-
-	return app.ReleaseVersion, nil
-}
-
-func (impl *AppServiceImpl) releaseTreeStateByApp(app AppDto) error {
-	// get edit version K-V state from database
-	treestates, err := impl.treestateRepository.RetrieveAllTypeTreeStatesByApp(app.ID, repository.APP_EDIT_VERSION)
-	if err != nil {
-		return err
+	if !repository.IsValidChannel(channel) {
+		return -1, fmt.Errorf("unknown channel %s", channel)
 	}
-	indexIDMap := map[int]int{}
-	releaseIDMap := map[int]int{}
-	// set version as mainline version
-	for serial, _ := range treestates {
-		indexIDMap[serial] = treestates[serial].ID
-		treestates[serial].ID = 0
-		treestates[serial].Version = app.MainlineVersion
-	}
-	// and put them to the database as duplicate
-	for i, treestate := range treestates {
-		id, err := impl.treestateRepository.Create(treestate)
-		if err != nil {
+	var app *repository.App
+	err := impl.transaction(func(tx *AppServiceImpl) error {
+		var err error
+		if app, err = tx.appRepository.RetrieveAppByIDForUpdate(appID); err != nil {
 			return err
 		}
-		oldID := indexIDMap[i]
-		releaseIDMap[oldID] = id
-	}
-	for _, treestate := range treestates {
-		treestate.ChildrenNodeRefIDs = convertLink(treestate.ChildrenNodeRefIDs, releaseIDMap)
-		if err := impl.treestateRepository.Update(treestate); err != nil {
+		if app == nil || app.ID == 0 {
+			return ErrAppNotFound
+		}
+		app.MainlineVersion += 1
+		if channel == repository.CHANNEL_PRODUCTION {
+			app.ReleaseVersion = app.MainlineVersion
+		}
+		app.UpdatedBy = userID
+		app.UpdatedAt = time.Now().UTC()
+		release := AppDto{ID: appID, MainlineVersion: app.MainlineVersion}
+		if err := tx.releaseTreeStateByApp(release); err != nil {
 			return err
 		}
+		if err := tx.releaseKVStateByApp(release); err != nil {
+			return err
+		}
+		if err := tx.releaseSetStateByApp(release); err != nil {
+			return err
+		}
+		if err := tx.releaseActionsByApp(release); err != nil {
+			return err
+		}
+		if err := tx.appRepository.Update(app); err != nil {
+			return err
+		}
+		if channel != repository.CHANNEL_PRODUCTION {
+			if err := tx.pointChannel(appID, channel, app.MainlineVersion, userID); err != nil {
+				return err
+			}
+		}
+		_, err = tx.appVersionRepository.Create(&repository.AppVersion{
+			AppRefID:  appID,
+			Version:   app.MainlineVersion,
+			Notes:     notes,
+			CreatedBy: userID,
+			CreatedAt: time.Now().UTC(),
+		})
+		return err
+	})
+	if err != nil {
+		return -1, err
 	}
 
-	return nil
+	if channel == repository.CHANNEL_PRODUCTION {
+		impl.notifyAppDeployed(app)
+	}
+
+	return app.MainlineVersion, nil
 }
 
-func (impl *AppServiceImpl) releaseKVStateByApp(app AppDto) error {
-	// get edit version K-V state from database
-	kvstates, err := impl.kvstateRepository.RetrieveAllTypeKVStatesByApp(app.ID, repository.APP_EDIT_VERSION)
-	if err != nil {
-		return err
-	}
-	// set version as mainline version
-	for serial, _ := range kvstates {
-		kvstates[serial].ID = 0
-		kvstates[serial].Version = app.MainlineVersion
+// notifyAppDeployed sends the deployed mail to the app creator.
+func (impl *AppServiceImpl) notifyAppDeployed(app *repository.App) {
+	creator, err := impl.userRepository.RetrieveByID(app.CreatedBy)
+	if err != nil || creator.ID == 0 {
+		return
 	}
-	// and put them to the database as duplicate
-	for _, kvstate := range kvstates {
-		if err := impl.kvstateRepository.Create(kvstate); err != nil {
-			return err
-		}
+	if err := impl.smtpServer.SendAppDeployed(creator.Email, user.LanguageByIndex(creator.Language), creator.Nickname,
+		app.Name, app.ID, app.ReleaseVersion); err != nil {
+		impl.logger.Errorw("send app deployed mail error", "err", err)
 	}
-	return nil
+}
+
+func (impl *AppServiceImpl) releaseTreeStateByApp(app AppDto) error {
+	return impl.copyTreeStateByVersion(app.ID, repository.APP_EDIT_VERSION, app.MainlineVersion)
+}
+
+func (impl *AppServiceImpl) releaseKVStateByApp(app AppDto) error {
+	return impl.copyKVStateByVersion(app.ID, repository.APP_EDIT_VERSION, app.MainlineVersion)
 }
 
 func (impl *AppServiceImpl) releaseSetStateByApp(app AppDto) error {
-	setstates, err := impl.setstateRepository.RetrieveSetStatesByApp(app.ID, repository.SET_STATE_TYPE_DISPLAY_NAME, repository.APP_EDIT_VERSION)
-	if err != nil {
-		return err
-	}
-	// update some fields
-	for serial, _ := range setstates {
-		setstates[serial].ID = 0
-		setstates[serial].Version = app.MainlineVersion
-	}
-	// and put them to the database as duplicate
-	for _, setstate := range setstates {
-		if err := impl.setstateRepository.Create(setstate); err != nil {
-			return err
-		}
-	}
-	return nil
+	return impl.copySetStateByVersion(app.ID, repository.APP_EDIT_VERSION, app.MainlineVersion)
 }
 
 func (impl *AppServiceImpl) releaseActionsByApp(app AppDto) error {
-	// get edit version K-V state from database
-	actions, err := impl.actionRepository.RetrieveActionsByAppVersion(app.ID, repository.APP_EDIT_VERSION)
-	if err != nil {
-		return err
-	}
-	// set version as mainline version
-	for serial, _ := range actions {
-		actions[serial].ID = 0
-		actions[serial].Version = app.MainlineVersion
-	}
-	// and put them to the database as duplicate
-	for _, action := range actions {
-		if _, err := impl.actionRepository.Create(action); err != nil {
-			return err
-		}
-	}
-	return nil
+	return impl.copyActionsByVersion(app.ID, repository.APP_EDIT_VERSION, app.MainlineVersion)
 }
 
 func (impl *AppServiceImpl) GetMegaData(appID, version int) (Editor, error) {
@@ -568,6 +645,7 @@ func (impl *AppServiceImpl) formatActions(appID, version int) ([]Action, error)
 			Transformer: value.Transformer,
 			TriggerMode: value.TriggerMode,
 			Template:    value.Template,
+			Parameters:  value.Parameters,
 			CreatedBy:   value.CreatedBy,
 			CreatedAt:   value.CreatedAt,
 			UpdatedBy:   value.UpdatedBy,
@@ -0,0 +1,648 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/smtp"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var errInjected = errors.New("injected failure")
+
+// memoryDB is a tiny in-memory database, memoryUnitOfWork restores it when a transaction fails.
+type memoryDB struct {
+	nextID      int
+	apps        map[int]repository.App
+	treestates  map[int]repository.TreeState
+	kvstates    map[int]repository.KVState
+	setstates   map[int]repository.SetState
+	actions     map[int]repository.Action
+	appVersions map[int]repository.AppVersion
+	appChannels map[string]repository.AppChannel
+	publishes   map[int]repository.AppPublish
+	appMembers  map[[2]int]repository.AppMember
+	failOn      string
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{
+		apps:        map[int]repository.App{},
+		treestates:  map[int]repository.TreeState{},
+		kvstates:    map[int]repository.KVState{},
+		setstates:   map[int]repository.SetState{},
+		actions:     map[int]repository.Action{},
+		appVersions: map[int]repository.AppVersion{},
+		appChannels: map[string]repository.AppChannel{},
+		publishes:   map[int]repository.AppPublish{},
+		appMembers:  map[[2]int]repository.AppMember{},
+	}
+}
+
+func (db *memoryDB) clone() memoryDB {
+	c := *newMemoryDB()
+	c.nextID, c.failOn = db.nextID, db.failOn
+	for k, v := range db.apps {
+		c.apps[k] = v
+	}
+	for k, v := range db.treestates {
+		c.treestates[k] = v
+	}
+	for k, v := range db.kvstates {
+		c.kvstates[k] = v
+	}
+	for k, v := range db.setstates {
+		c.setstates[k] = v
+	}
+	for k, v := range db.actions {
+		c.actions[k] = v
+	}
+	for k, v := range db.appVersions {
+		c.appVersions[k] = v
+	}
+	for k, v := range db.appChannels {
+		c.appChannels[k] = v
+	}
+	for k, v := range db.publishes {
+		c.publishes[k] = v
+	}
+	for k, v := range db.appMembers {
+		c.appMembers[k] = v
+	}
+	return c
+}
+
+func (db *memoryDB) id(operation string) (int, error) {
+	if db.failOn == operation {
+		return 0, errInjected
+	}
+	db.nextID++
+	return db.nextID, nil
+}
+
+func (db *memoryDB) check(operation string) error {
+	if db.failOn == operation {
+		return errInjected
+	}
+	return nil
+}
+
+type memoryUnitOfWork struct {
+	db *memoryDB
+}
+
+func (uow memoryUnitOfWork) Do(fn func(repositories *repository.Repositories) error) error {
+	snapshot := uow.db.clone()
+	if err := fn(newMemoryRepositories(uow.db)); err != nil {
+		*uow.db = snapshot
+		return err
+	}
+	return nil
+}
+
+func newMemoryRepositories(db *memoryDB) *repository.Repositories {
+	return &repository.Repositories{
+		App:        memoryApps{db: db},
+		TreeState:  memoryTreeStates{db: db},
+		KVState:    memoryKVStates{db: db},
+		SetState:   memorySetStates{db: db},
+		Action:     memoryActions{db: db},
+		AppVersion: memoryAppVersions{db: db},
+		AppChannel: memoryAppChannels{db: db},
+		AppPublish: memoryAppPublishes{db: db},
+		AppMember:  memoryAppMembers{db: db},
+	}
+}
+
+type memoryApps struct {
+	repository.AppRepository
+	db *memoryDB
+}
+
+func (m memoryApps) Create(app *repository.App) (int, error) {
+	id, err := m.db.id("app.create")
+	if err != nil {
+		return 0, err
+	}
+	app.ID = id
+	m.db.apps[id] = *app
+	return id, nil
+}
+
+func (m memoryApps) Delete(appID int) error {
+	if err := m.db.check("app.delete"); err != nil {
+		return err
+	}
+	delete(m.db.apps, appID)
+	return nil
+}
+
+func (m memoryApps) Update(app *repository.App) error {
+	if err := m.db.check("app.update"); err != nil {
+		return err
+	}
+	m.db.apps[app.ID] = *app
+	return nil
+}
+
+func (m memoryApps) RetrieveAppByID(appID int) (*repository.App, error) {
+	app := m.db.apps[appID]
+	return &app, nil
+}
+
+func (m memoryApps) RetrieveAppByIDForUpdate(appID int) (*repository.App, error) {
+	return m.RetrieveAppByID(appID)
+}
+
+type memoryTreeStates struct {
+	repository.TreeStateRepository
+	db *memoryDB
+}
+
+func (m memoryTreeStates) Create(treestate *repository.TreeState) (int, error) {
+	id, err := m.db.id("treestate.create")
+	if err != nil {
+		return 0, err
+	}
+	treestate.ID = id
+	m.db.treestates[id] = *treestate
+	return id, nil
+}
+
+func (m memoryTreeStates) Update(treestate *repository.TreeState) error {
+	m.db.treestates[treestate.ID] = *treestate
+	return nil
+}
+
+func (m memoryTreeStates) RetrieveAllTypeTreeStatesByApp(apprefid int, version int) ([]*repository.TreeState, error) {
+	var res []*repository.TreeState
+	for _, v := range m.db.treestates {
+		if v.AppRefID == apprefid && v.Version == version {
+			treestate := v
+			res = append(res, &treestate)
+		}
+	}
+	return res, nil
+}
+
+func (m memoryTreeStates) DeleteAllTypeTreeStatesByApp(apprefid int) error {
+	for k, v := range m.db.treestates {
+		if v.AppRefID == apprefid {
+			delete(m.db.treestates, k)
+		}
+	}
+	return nil
+}
+
+type memoryKVStates struct {
+	repository.KVStateRepository
+	db *memoryDB
+}
+
+func (m memoryKVStates) Create(kvstate *repository.KVState) error {
+	id, err := m.db.id("kvstate.create")
+	if err != nil {
+		return err
+	}
+	kvstate.ID = id
+	m.db.kvstates[id] = *kvstate
+	return nil
+}
+
+func (m memoryKVStates) RetrieveAllTypeKVStatesByApp(apprefid int, version int) ([]*repository.KVState, error) {
+	var res []*repository.KVState
+	for _, v := range m.db.kvstates {
+		if v.AppRefID == apprefid && v.Version == version {
+			kvstate := v
+			res = append(res, &kvstate)
+		}
+	}
+	return res, nil
+}
+
+func (m memoryKVStates) DeleteAllTypeKVStatesByApp(apprefid int) error {
+	for k, v := range m.db.kvstates {
+		if v.AppRefID == apprefid {
+			delete(m.db.kvstates, k)
+		}
+	}
+	return nil
+}
+
+type memorySetStates struct {
+	repository.SetStateRepository
+	db *memoryDB
+}
+
+func (m memorySetStates) Create(setstate *repository.SetState) error {
+	id, err := m.db.id("setstate.create")
+	if err != nil {
+		return err
+	}
+	setstate.ID = id
+	m.db.setstates[id] = *setstate
+	return nil
+}
+
+func (m memorySetStates) RetrieveSetStatesByApp(apprefid int, statetype int, version int) ([]*repository.SetState, error) {
+	var res []*repository.SetState
+	for _, v := range m.db.setstates {
+		if v.AppRefID == apprefid && v.StateType == statetype && v.Version == version {
+			setstate := v
+			res = append(res, &setstate)
+		}
+	}
+	return res, nil
+}
+
+func (m memorySetStates) DeleteAllTypeSetStatesByApp(apprefid int) error {
+	for k, v := range m.db.setstates {
+		if v.AppRefID == apprefid {
+			delete(m.db.setstates, k)
+		}
+	}
+	return nil
+}
+
+type memoryActions struct {
+	repository.ActionRepository
+	db *memoryDB
+}
+
+func (m memoryActions) Create(action *repository.Action) (int, error) {
+	id, err := m.db.id("action.create")
+	if err != nil {
+		return 0, err
+	}
+	action.ID = id
+	m.db.actions[id] = *action
+	return id, nil
+}
+
+func (m memoryActions) RetrieveActionsByAppVersion(app, version int) ([]*repository.Action, error) {
+	var res []*repository.Action
+	for _, v := range m.db.actions {
+		if v.App == app && v.Version == version {
+			action := v
+			res = append(res, &action)
+		}
+	}
+	return res, nil
+}
+
+func (m memoryActions) DeleteActionsByApp(appID int) error {
+	if err := m.db.check("action.delete"); err != nil {
+		return err
+	}
+	for k, v := range m.db.actions {
+		if v.App == appID {
+			delete(m.db.actions, k)
+		}
+	}
+	return nil
+}
+
+type memoryAppVersions struct {
+	repository.AppVersionRepository
+	db *memoryDB
+}
+
+func (m memoryAppVersions) Create(appVersion *repository.AppVersion) (int, error) {
+	id, err := m.db.id("appversion.create")
+	if err != nil {
+		return 0, err
+	}
+	appVersion.ID = id
+	m.db.appVersions[id] = *appVersion
+	return id, nil
+}
+
+func (m memoryAppVersions) DeleteByApp(appID int) error {
+	for k, v := range m.db.appVersions {
+		if v.AppRefID == appID {
+			delete(m.db.appVersions, k)
+		}
+	}
+	return nil
+}
+
+type memoryAppChannels struct {
+	repository.AppChannelRepository
+	db *memoryDB
+}
+
+func (m memoryAppChannels) Upsert(appChannel *repository.AppChannel) error {
+	if err := m.db.check("appchannel.upsert"); err != nil {
+		return err
+	}
+	m.db.appChannels[strconv.Itoa(appChannel.AppRefID)+"/"+appChannel.Name] = *appChannel
+	return nil
+}
+
+func (m memoryAppChannels) RetrieveByApp(appID int) ([]*repository.AppChannel, error) {
+	var res []*repository.AppChannel
+	for _, v := range m.db.appChannels {
+		if v.AppRefID == appID {
+			appChannel := v
+			res = append(res, &appChannel)
+		}
+	}
+	return res, nil
+}
+
+func (m memoryAppChannels) DeleteByApp(appID int) error {
+	for k, v := range m.db.appChannels {
+		if v.AppRefID == appID {
+			delete(m.db.appChannels, k)
+		}
+	}
+	return nil
+}
+
+type memoryAppPublishes struct {
+	db *memoryDB
+}
+
+func (m memoryAppPublishes) Upsert(appPublish *repository.AppPublish) error {
+	if err := m.db.check("apppublish.upsert"); err != nil {
+		return err
+	}
+	m.db.publishes[appPublish.AppRefID] = *appPublish
+	return nil
+}
+
+func (m memoryAppPublishes) RetrieveByApp(appID int) (*repository.AppPublish, error) {
+	if v, ok := m.db.publishes[appID]; ok {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+func (m memoryAppPublishes) RetrieveBySlug(slug string) (*repository.AppPublish, error) {
+	for _, v := range m.db.publishes {
+		if v.Slug == slug {
+			appPublish := v
+			return &appPublish, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m memoryAppPublishes) DeleteByApp(appID int) error {
+	if err := m.db.check("apppublish.delete"); err != nil {
+		return err
+	}
+	delete(m.db.publishes, appID)
+	return nil
+}
+
+type memoryAppMembers struct {
+	db *memoryDB
+}
+
+func (m memoryAppMembers) Upsert(appMember *repository.AppMember) error {
+	if err := m.db.check("appmember.upsert"); err != nil {
+		return err
+	}
+	m.db.appMembers[[2]int{appMember.AppRefID, appMember.UserID}] = *appMember
+	return nil
+}
+
+func (m memoryAppMembers) RetrieveByAppAndUser(appID, userID int) (*repository.AppMember, error) {
+	if v, ok := m.db.appMembers[[2]int{appID, userID}]; ok {
+		return &v, nil
+	}
+	return nil, nil
+}
+
+func (m memoryAppMembers) RetrieveByApp(appID int) ([]*repository.AppMember, error) {
+	appMembers := []*repository.AppMember{}
+	for _, v := range m.db.appMembers {
+		if v.AppRefID == appID {
+			appMember := v
+			appMembers = append(appMembers, &appMember)
+		}
+	}
+	sort.Slice(appMembers, func(i, j int) bool { return appMembers[i].UserID < appMembers[j].UserID })
+	return appMembers, nil
+}
+
+func (m memoryAppMembers) Delete(appID, userID int) error {
+	delete(m.db.appMembers, [2]int{appID, userID})
+	return nil
+}
+
+func (m memoryAppMembers) DeleteByApp(appID int) error {
+	if err := m.db.check("appmember.delete"); err != nil {
+		return err
+	}
+	for k, v := range m.db.appMembers {
+		if v.AppRefID == appID {
+			delete(m.db.appMembers, k)
+		}
+	}
+	return nil
+}
+
+// memoryUsers are the registered users by id, the unknown ids are returned as an empty user.
+type memoryUsers struct {
+	repository.UserRepository
+	users map[int]repository.User
+}
+
+func (m memoryUsers) RetrieveByID(id int) (*repository.User, error) {
+	user := m.users[id]
+	return &user, nil
+}
+
+func (m memoryUsers) FetchUserByEmail(email string) (*repository.User, error) {
+	for _, user := range m.users {
+		if user.Email == email {
+			found := user
+			return &found, nil
+		}
+	}
+	return &repository.User{}, gorm.ErrRecordNotFound
+}
+
+// newTestAppService returns a service over an app which has a component tree, states and an action in the edit version.
+func newTestAppService(t *testing.T) (*AppServiceImpl, *memoryDB, int) {
+	db := newMemoryDB()
+	repositories := newMemoryRepositories(db)
+	appID, _ := repositories.App.Create(&repository.App{Name: "orders", CreatedBy: 1})
+	rootID, _ := repositories.TreeState.Create(&repository.TreeState{AppRefID: appID, Name: "rootDsl",
+		StateType: repository.TREE_STATE_TYPE_COMPONENTS, ChildrenNodeRefIDs: "[]"})
+	childID, _ := repositories.TreeState.Create(&repository.TreeState{AppRefID: appID, Name: "button1",
+		StateType: repository.TREE_STATE_TYPE_COMPONENTS, ParentNodeRefID: rootID, ChildrenNodeRefIDs: "[]"})
+	root := db.treestates[rootID]
+	root.ChildrenNodeRefIDs = "[" + strconv.Itoa(childID) + "]"
+	db.treestates[rootID] = root
+	assert.Nil(t, repositories.KVState.Create(&repository.KVState{AppRefID: appID, Key: "button1",
+		StateType: repository.KV_STATE_TYPE_DEPENDENCIES, Value: "[]"}))
+	assert.Nil(t, repositories.SetState.Create(&repository.SetState{AppRefID: appID, Value: "button1",
+		StateType: repository.SET_STATE_TYPE_DISPLAY_NAME}))
+	_, err := repositories.Action.Create(&repository.Action{App: appID, Name: "query1"})
+	assert.Nil(t, err)
+
+	service := NewAppServiceImpl(zap.NewNop().Sugar(), repositories.App, memoryUsers{}, repositories.KVState,
+		repositories.TreeState, repositories.SetState, repositories.Action, repositories.AppVersion,
+		repositories.AppChannel, repositories.AppPublish, repositories.AppMember, memoryUnitOfWork{db: db}, smtp.SMTPServer{})
+	return service, db, appID
+}
+
+func countVersion(db *memoryDB, version int) int {
+	count := 0
+	for _, v := range db.treestates {
+		if v.Version == version {
+			count++
+		}
+	}
+	for _, v := range db.kvstates {
+		if v.Version == version {
+			count++
+		}
+	}
+	for _, v := range db.setstates {
+		if v.Version == version {
+			count++
+		}
+	}
+	for _, v := range db.actions {
+		if v.Version == version {
+			count++
+		}
+	}
+	return count
+}
+
+func TestReleaseAppCopiesEditVersion(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+
+	version, err := service.ReleaseApp(appID, 1, "first release", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, 1, db.apps[appID].ReleaseVersion)
+	assert.Equal(t, 5, countVersion(db, 1))
+	assert.Len(t, db.appVersions, 1)
+
+	// the released tree is linked within the released version
+	for _, treestate := range db.treestates {
+		if treestate.Version == 1 && treestate.Name == "button1" {
+			assert.Equal(t, 1, db.treestates[treestate.ParentNodeRefID].Version)
+		}
+	}
+}
+
+func TestReleaseAppRollsBackOnPartialFailure(t *testing.T) {
+	for _, failOn := range []string{"kvstate.create", "action.create", "app.update", "appversion.create"} {
+		service, db, appID := newTestAppService(t)
+		before := db.clone()
+		db.failOn = failOn
+
+		version, err := service.ReleaseApp(appID, 1, "", "")
+		assert.ErrorIs(t, err, errInjected, failOn)
+		assert.Equal(t, -1, version)
+		assert.Equal(t, before.apps, db.apps, failOn)
+		assert.Equal(t, 0, countVersion(db, 1), failOn)
+		assert.Empty(t, db.appVersions, failOn)
+	}
+}
+
+func TestReleaseAppNotFound(t *testing.T) {
+	service, _, _ := newTestAppService(t)
+	_, err := service.ReleaseApp(404, 1, "", "")
+	assert.ErrorIs(t, err, ErrAppNotFound)
+}
+
+func TestDuplicateAppRollsBackOnPartialFailure(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+	db.failOn = "setstate.create"
+
+	_, err := service.DuplicateApp(appID, 1, "orders copy")
+	assert.ErrorIs(t, err, errInjected)
+	assert.Len(t, db.apps, 1)
+	assert.Len(t, db.treestates, 2)
+	assert.Len(t, db.kvstates, 1)
+
+	db.failOn = ""
+	res, err := service.DuplicateApp(appID, 1, "orders copy")
+	assert.Nil(t, err)
+	assert.Len(t, db.apps, 2)
+	assert.Len(t, db.treestates, 4)
+	assert.Equal(t, "orders copy", res.Name)
+}
+
+func TestDeleteAppRollsBackOnPartialFailure(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+	db.failOn = "action.delete"
+
+	assert.ErrorIs(t, service.DeleteApp(appID), errInjected)
+	assert.Len(t, db.apps, 1)
+	assert.Len(t, db.treestates, 2)
+	assert.Len(t, db.actions, 1)
+
+	db.failOn = ""
+	assert.Nil(t, service.DeleteApp(appID))
+	assert.Empty(t, db.apps)
+	assert.Empty(t, db.treestates)
+	assert.Empty(t, db.actions)
+}
+
+func TestReleaseToStagingAndPromote(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+
+	version, err := service.ReleaseApp(appID, 1, "", repository.CHANNEL_STAGING)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, 0, db.apps[appID].ReleaseVersion)
+	stagingVersion, err := service.ChannelVersion(appID, repository.CHANNEL_STAGING)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stagingVersion)
+	_, err = service.ChannelVersion(appID, repository.CHANNEL_PRODUCTION)
+	assert.NotNil(t, err)
+
+	_, err = service.PromoteChannel(appID, repository.CHANNEL_STAGING, repository.CHANNEL_DEV, 1)
+	assert.NotNil(t, err)
+	_, err = service.PromoteChannel(appID, repository.CHANNEL_DEV, repository.CHANNEL_STAGING, 1)
+	assert.NotNil(t, err)
+
+	promoted, err := service.PromoteChannel(appID, repository.CHANNEL_STAGING, repository.CHANNEL_PRODUCTION, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, promoted.Version)
+	assert.Equal(t, 1, db.apps[appID].ReleaseVersion)
+
+	channels, err := service.ListChannels(appID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"dev", "staging", "production"}, []string{channels[0].Channel, channels[1].Channel, channels[2].Channel})
+	assert.Equal(t, []int{0, 1, 1}, []int{channels[0].Version, channels[1].Version, channels[2].Version})
+}
+
+func TestReleaseToChannelRollsBackOnFailure(t *testing.T) {
+	service, db, appID := newTestAppService(t)
+	db.failOn = "appchannel.upsert"
+
+	_, err := service.ReleaseApp(appID, 1, "", repository.CHANNEL_STAGING)
+	assert.ErrorIs(t, err, errInjected)
+	assert.Equal(t, 0, db.apps[appID].MainlineVersion)
+	assert.Equal(t, 0, countVersion(db, 1))
+}
@@ -0,0 +1,408 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+)
+
+type AppVersionDto struct {
+	Version   int       `json:"version"`
+	Notes     string    `json:"notes"`
+	CreatedBy int       `json:"createdBy"`
+	Creator   string    `json:"creator"`
+	CreatedAt time.Time `json:"createdAt"`
+	IsLive    bool      `json:"isLive"` // the app release version points to this version
+}
+
+type SectionDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// VersionDiff lists the names changed in each part of the editor from `From` to `To`.
+type VersionDiff struct {
+	From                  int         `json:"from"`
+	To                    int         `json:"to"`
+	Components            SectionDiff `json:"components"`
+	DependenciesState     SectionDiff `json:"dependenciesState"`
+	DragShadowState       SectionDiff `json:"dragShadowState"`
+	DottedLineSquareState SectionDiff `json:"dottedLineSquareState"`
+	DisplayNameState      SectionDiff `json:"displayNameState"`
+	Actions               SectionDiff `json:"actions"`
+}
+
+// versionSnapshot maps the names of every part of the editor to their canonical json.
+type versionSnapshot struct {
+	components            map[string]string
+	dependenciesState     map[string]string
+	dragShadowState       map[string]string
+	dottedLineSquareState map[string]string
+	displayNameState      map[string]string
+	actions               map[string]string
+}
+
+func (impl *AppServiceImpl) ListVersions(appID int) ([]AppVersionDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return nil, err
+	}
+	appVersions, err := impl.appVersionRepository.RetrieveByApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[int]*repository.AppVersion, len(appVersions))
+	for _, appVersion := range appVersions {
+		recorded[appVersion.Version] = appVersion
+	}
+	creators := map[int]string{}
+	res := make([]AppVersionDto, 0, app.MainlineVersion)
+	// the versions released before the history was recorded have no author
+	for version := app.MainlineVersion; version > repository.APP_EDIT_VERSION; version-- {
+		appVersionDto := AppVersionDto{
+			Version: version,
+			IsLive:  version == app.ReleaseVersion,
+		}
+		if appVersion, hit := recorded[version]; hit {
+			appVersionDto.Notes = appVersion.Notes
+			appVersionDto.CreatedBy = appVersion.CreatedBy
+			appVersionDto.CreatedAt = appVersion.CreatedAt
+			if _, hit := creators[appVersion.CreatedBy]; !hit {
+				creator, _ := impl.userRepository.RetrieveByID(appVersion.CreatedBy)
+				if creator != nil {
+					creators[appVersion.CreatedBy] = creator.Nickname
+				}
+			}
+			appVersionDto.Creator = creators[appVersion.CreatedBy]
+		}
+		res = append(res, appVersionDto)
+	}
+	return res, nil
+}
+
+// DiffVersions compares two versions of the app, version 0 is the edit version.
+func (impl *AppServiceImpl) DiffVersions(appID, from, to int) (VersionDiff, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	if err := validateVersion(app, from); err != nil {
+		return VersionDiff{}, err
+	}
+	if err := validateVersion(app, to); err != nil {
+		return VersionDiff{}, err
+	}
+	before, err := impl.snapshotVersion(appID, from)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	after, err := impl.snapshotVersion(appID, to)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	return VersionDiff{
+		From:                  from,
+		To:                    to,
+		Components:            diffSection(before.components, after.components),
+		DependenciesState:     diffSection(before.dependenciesState, after.dependenciesState),
+		DragShadowState:       diffSection(before.dragShadowState, after.dragShadowState),
+		DottedLineSquareState: diffSection(before.dottedLineSquareState, after.dottedLineSquareState),
+		DisplayNameState:      diffSection(before.displayNameState, after.displayNameState),
+		Actions:               diffSection(before.actions, after.actions),
+	}, nil
+}
+
+// RestoreEditVersion replaces the edit version of the app with a copy of a released version.
+func (impl *AppServiceImpl) RestoreEditVersion(appID, version, userID int) (AppDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return AppDto{}, err
+	}
+	if version == repository.APP_EDIT_VERSION {
+		return AppDto{}, errors.New("can not restore the edit version from itself")
+	}
+	if err := validateVersion(app, version); err != nil {
+		return AppDto{}, err
+	}
+	err = impl.transaction(func(tx *AppServiceImpl) error {
+		if err := tx.treestateRepository.DeleteAllTypeTreeStatesByAppVersion(appID, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.kvstateRepository.DeleteAllTypeKVStatesByAppVersion(appID, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.setstateRepository.DeleteAllTypeSetStatesByAppVersion(appID, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.actionRepository.DeleteActionsByAppVersion(appID, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.copyTreeStateByVersion(appID, version, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.copyKVStateByVersion(appID, version, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.copySetStateByVersion(appID, version, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		if err := tx.copyActionsByVersion(appID, version, repository.APP_EDIT_VERSION); err != nil {
+			return err
+		}
+		app.UpdatedBy = userID
+		app.UpdatedAt = time.Now().UTC()
+		return tx.appRepository.Update(app)
+	})
+	if err != nil {
+		return AppDto{}, err
+	}
+	return impl.FetchAppByID(appID)
+}
+
+// SetReleaseVersion points the live release of the app to an earlier or later released version.
+func (impl *AppServiceImpl) SetReleaseVersion(appID, version, userID int) (AppDto, error) {
+	app, err := impl.appRepository.RetrieveAppByID(appID)
+	if err != nil {
+		return AppDto{}, err
+	}
+	if version == repository.APP_EDIT_VERSION {
+		return AppDto{}, errors.New("the edit version can not be released directly, deploy the app instead")
+	}
+	if err := validateVersion(app, version); err != nil {
+		return AppDto{}, err
+	}
+	app.ReleaseVersion = version
+	app.UpdatedBy = userID
+	app.UpdatedAt = time.Now().UTC()
+	if err := impl.appRepository.Update(app); err != nil {
+		return AppDto{}, err
+	}
+	return impl.FetchAppByID(appID)
+}
+
+func validateVersion(app *repository.App, version int) error {
+	if version < repository.APP_EDIT_VERSION || version > app.MainlineVersion {
+		return fmt.Errorf("version %d of app %d not exists", version, app.ID)
+	}
+	return nil
+}
+
+func (impl *AppServiceImpl) snapshotVersion(appID, version int) (*versionSnapshot, error) {
+	snapshot := &versionSnapshot{
+		components:       map[string]string{},
+		displayNameState: map[string]string{},
+		actions:          map[string]string{},
+	}
+
+	// components are compared with their parent name, the ids differ in every version
+	treestates, err := impl.treestateRepository.RetrieveTreeStatesByApp(appID, repository.TREE_STATE_TYPE_COMPONENTS, version)
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, treestate := range treestates {
+		names[treestate.ID] = treestate.Name
+	}
+	parents := map[string]string{}
+	for _, treestate := range treestates {
+		childrenIDs, _ := treestate.ExportChildrenNodeRefIDs()
+		for _, childID := range childrenIDs {
+			if childName, hit := names[childID]; hit {
+				parents[childName] = treestate.Name
+			}
+		}
+	}
+	for _, treestate := range treestates {
+		snapshot.components[treestate.Name] = canonicalJSON(map[string]interface{}{
+			"parentNode": parents[treestate.Name],
+			"content":    json.RawMessage(treestate.Content),
+		})
+	}
+
+	kvstateSections := map[int]*map[string]string{
+		repository.KV_STATE_TYPE_DEPENDENCIES:       &snapshot.dependenciesState,
+		repository.KV_STATE_TYPE_DRAG_SHADOW:        &snapshot.dragShadowState,
+		repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE: &snapshot.dottedLineSquareState,
+	}
+	for stateType, section := range kvstateSections {
+		kvstates, err := impl.kvstateRepository.RetrieveKVStatesByApp(appID, stateType, version)
+		if err != nil {
+			return nil, err
+		}
+		*section = make(map[string]string, len(kvstates))
+		for _, kvstate := range kvstates {
+			(*section)[kvstate.Key] = canonicalJSON(json.RawMessage(kvstate.Value))
+		}
+	}
+
+	setstates, err := impl.setstateRepository.RetrieveSetStatesByApp(appID, repository.SET_STATE_TYPE_DISPLAY_NAME, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, setstate := range setstates {
+		snapshot.displayNameState[setstate.Value] = ""
+	}
+
+	actions, err := impl.actionRepository.RetrieveActionsByAppVersion(appID, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		snapshot.actions[action.Name] = canonicalJSON(map[string]interface{}{
+			"resourceId":  action.Resource,
+			"actionType":  action.Type,
+			"triggerMode": action.TriggerMode,
+			"transformer": action.Transformer,
+			"content":     action.Template,
+		})
+	}
+	return snapshot, nil
+}
+
+// canonicalJSON re-encodes the value with sorted keys, so equal values compare equal.
+func canonicalJSON(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	var plain interface{}
+	if err := json.Unmarshal(b, &plain); err != nil {
+		return string(b)
+	}
+	b, _ = json.Marshal(plain)
+	return string(b)
+}
+
+func diffSection(before, after map[string]string) SectionDiff {
+	diff := SectionDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []string{},
+	}
+	for name, value := range after {
+		beforeValue, hit := before[name]
+		if !hit {
+			diff.Added = append(diff.Added, name)
+		} else if beforeValue != value {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range before {
+		if _, hit := after[name]; !hit {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// copyTreeStateByVersion duplicates the tree states of a version and relinks the copies.
+func (impl *AppServiceImpl) copyTreeStateByVersion(appID, from, to int) error {
+	treestates, err := impl.treestateRepository.RetrieveAllTypeTreeStatesByApp(appID, from)
+	if err != nil {
+		return err
+	}
+	indexIDMap := map[int]int{}
+	copyIDMap := map[int]int{}
+	for serial := range treestates {
+		indexIDMap[serial] = treestates[serial].ID
+		treestates[serial].ID = 0
+		treestates[serial].Version = to
+	}
+	for i, treestate := range treestates {
+		id, err := impl.treestateRepository.Create(treestate)
+		if err != nil {
+			return err
+		}
+		copyIDMap[indexIDMap[i]] = id
+	}
+	copies := map[int]*repository.TreeState{}
+	for _, treestate := range treestates {
+		treestate.ChildrenNodeRefIDs = convertLink(treestate.ChildrenNodeRefIDs, copyIDMap)
+		if parentID, hit := copyIDMap[treestate.ParentNodeRefID]; hit {
+			treestate.ParentNodeRefID = parentID
+		}
+		copies[treestate.ID] = treestate
+	}
+	// the children links are always right, take the parent from them
+	for _, treestate := range treestates {
+		childrenIDs, _ := treestate.ExportChildrenNodeRefIDs()
+		for _, childID := range childrenIDs {
+			if child, hit := copies[childID]; hit {
+				child.ParentNodeRefID = treestate.ID
+			}
+		}
+	}
+	for _, treestate := range treestates {
+		if err := impl.treestateRepository.Update(treestate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (impl *AppServiceImpl) copyKVStateByVersion(appID, from, to int) error {
+	kvstates, err := impl.kvstateRepository.RetrieveAllTypeKVStatesByApp(appID, from)
+	if err != nil {
+		return err
+	}
+	for _, kvstate := range kvstates {
+		kvstate.ID = 0
+		kvstate.Version = to
+		if err := impl.kvstateRepository.Create(kvstate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (impl *AppServiceImpl) copySetStateByVersion(appID, from, to int) error {
+	setstates, err := impl.setstateRepository.RetrieveSetStatesByApp(appID, repository.SET_STATE_TYPE_DISPLAY_NAME, from)
+	if err != nil {
+		return err
+	}
+	for _, setstate := range setstates {
+		setstate.ID = 0
+		setstate.Version = to
+		if err := impl.setstateRepository.Create(setstate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (impl *AppServiceImpl) copyActionsByVersion(appID, from, to int) error {
+	actions, err := impl.actionRepository.RetrieveActionsByAppVersion(appID, from)
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		action.ID = 0
+		action.Version = to
+		if _, err := impl.actionRepository.Create(action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
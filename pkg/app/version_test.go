@@ -0,0 +1,47 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSection(t *testing.T) {
+	before := map[string]string{"button1": `{"x":1}`, "input1": `{"x":2}`, "text1": `{}`}
+	after := map[string]string{"button1": `{"x":3}`, "input1": `{"x":2}`, "chart1": `{}`}
+	diff := diffSection(before, after)
+	assert.Equal(t, []string{"chart1"}, diff.Added)
+	assert.Equal(t, []string{"text1"}, diff.Removed)
+	assert.Equal(t, []string{"button1"}, diff.Changed)
+}
+
+func TestCanonicalJSONIgnoresKeyOrder(t *testing.T) {
+	a := canonicalJSON(json.RawMessage(`{"b": 1, "a": {"d": 2, "c": 3}}`))
+	b := canonicalJSON(json.RawMessage(`{"a":{"c":3,"d":2},"b":1}`))
+	assert.Equal(t, a, b)
+}
+
+func TestValidateVersion(t *testing.T) {
+	app := &repository.App{ID: 1, MainlineVersion: 3}
+	assert.Nil(t, validateVersion(app, repository.APP_EDIT_VERSION))
+	assert.Nil(t, validateVersion(app, 3))
+	assert.NotNil(t, validateVersion(app, 4))
+	assert.NotNil(t, validateVersion(app, -1))
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryAuditLogs struct {
+	records []*repository.AuditLog
+}
+
+func (m *memoryAuditLogs) Create(auditLog *repository.AuditLog) (int, error) {
+	auditLog.ID = len(m.records) + 1
+	m.records = append(m.records, auditLog)
+	return auditLog.ID, nil
+}
+
+func (m *memoryAuditLogs) RetrieveByFilter(filter *repository.AuditLogFilter) ([]*repository.AuditLog, int64, error) {
+	return m.records, int64(len(m.records)), nil
+}
+
+func TestRedactMasksSecrets(t *testing.T) {
+	redacted, err := Redact(map[string]interface{}{
+		"resourceName": "orders",
+		"content": map[string]interface{}{
+			"host":       "db.internal",
+			"password":   "hunter2",
+			"ssl":        map[string]interface{}{"privateKey": "-----BEGIN", "ca": ""},
+			"headers":    []interface{}{map[string]interface{}{"Authorization": "Bearer x"}},
+			"apiKey":     "",
+			"secretName": nil,
+		},
+	})
+	assert.Nil(t, err)
+	content := redacted.(map[string]interface{})["content"].(map[string]interface{})
+	assert.Equal(t, "db.internal", content["host"])
+	assert.Equal(t, REDACTED, content["password"])
+	assert.Equal(t, REDACTED, content["ssl"].(map[string]interface{})["privateKey"])
+	assert.Equal(t, REDACTED, content["headers"].([]interface{})[0].(map[string]interface{})["Authorization"])
+	assert.Equal(t, "", content["apiKey"])
+	assert.Nil(t, content["secretName"])
+}
+
+func TestRecordStoresRedactedDiff(t *testing.T) {
+	repo := &memoryAuditLogs{}
+	service := NewAuditServiceImpl(nil, repo)
+	err := service.Record(Entry{
+		ActorID:    7,
+		Source:     SOURCE_REST,
+		Operation:  OPERATION_UPDATE,
+		TargetType: TARGET_RESOURCE,
+		TargetID:   3,
+		Before:     map[string]interface{}{"resourceName": "a", "content": map[string]interface{}{"password": "old"}, "removed": 1},
+		After:      map[string]interface{}{"resourceName": "b", "content": map[string]interface{}{"password": "new"}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, repo.records, 1)
+	record := repo.records[0]
+	assert.Equal(t, "removed,resourceName", record.Changes)
+	assert.NotContains(t, *record.Before, "old")
+	assert.NotContains(t, *record.After, "new")
+
+	auditLogs, total, err := service.Query(repository.AuditLogFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []string{"removed", "resourceName"}, auditLogs[0].Changes)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteCSV(&buf, auditLogs))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, "removed;resourceName", rows[1][10])
+}
+
+func TestMissingSnapshotIsNull(t *testing.T) {
+	repo := &memoryAuditLogs{}
+	service := NewAuditServiceImpl(nil, repo)
+	assert.Nil(t, service.Record(Entry{ActorID: 7, Source: SOURCE_REST, Operation: OPERATION_CREATE, TargetType: TARGET_APP,
+		After: map[string]interface{}{"appName": "orders"}}))
+	assert.Nil(t, repo.records[0].Before)
+	assert.JSONEq(t, `{"appName":"orders"}`, *repo.records[0].After)
+
+	auditLogs, _, err := service.Query(repository.AuditLogFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, "null", string(auditLogs[0].Before))
+}
+
+func TestDiffWithoutSnapshot(t *testing.T) {
+	assert.Equal(t, []string{}, Diff(nil, map[string]interface{}{"a": 1}))
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var csvHeader = []string{"id", "created_at", "actor_id", "actor_ip", "source", "operation", "target_type", "target_id",
+	"target_name", "app_id", "changes", "before", "after"}
+
+// WriteCSV writes the audit logs for the compliance review.
+func WriteCSV(w io.Writer, auditLogs []AuditLogDto) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, auditLog := range auditLogs {
+		if err := writer.Write([]string{
+			strconv.Itoa(auditLog.ID),
+			auditLog.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.Itoa(auditLog.ActorID),
+			auditLog.ActorIP,
+			auditLog.Source,
+			auditLog.Operation,
+			auditLog.TargetType,
+			strconv.Itoa(auditLog.TargetID),
+			auditLog.TargetName,
+			strconv.Itoa(auditLog.AppID),
+			strings.Join(auditLog.Changes, ";"),
+			string(auditLog.Before),
+			string(auditLog.After),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
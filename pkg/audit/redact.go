@@ -0,0 +1,100 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const REDACTED = "******"
+
+// the value of a key containing one of these words is never written to the audit log.
+var sensitiveWords = []string{"password", "passwd", "secret", "token", "apikey", "api_key", "privatekey", "private_key",
+	"accesskey", "access_key", "credential", "authorization", "cookie", "digest"}
+
+// Redact converts the value to plain json values and masks the sensitive fields.
+func Redact(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var plain interface{}
+	if err := json.Unmarshal(b, &plain); err != nil {
+		return nil, err
+	}
+	return redactValue(plain), nil
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if IsSensitive(key) {
+				if field != nil && field != "" {
+					v[key] = REDACTED
+				}
+				continue
+			}
+			v[key] = redactValue(field)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// IsSensitive reports whether the values of the key are secrets.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range sensitiveWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the sorted top-level fields which differ between the snapshots.
+func Diff(before, after interface{}) []string {
+	beforeMap, _ := before.(map[string]interface{})
+	afterMap, _ := after.(map[string]interface{})
+	if beforeMap == nil || afterMap == nil {
+		return []string{}
+	}
+	changes := make([]string, 0)
+	for key, value := range afterMap {
+		if !reflect.DeepEqual(beforeMap[key], value) {
+			changes = append(changes, key)
+		}
+	}
+	for key := range beforeMap {
+		if _, ok := afterMap[key]; !ok {
+			changes = append(changes, key)
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestSnapshotsAreStoredAsJSONB runs against the database of ILLA_TEST_PG_DSN, the tables are created in
+// a schema of the transaction which is rolled back.
+func TestSnapshotsAreStoredAsJSONB(t *testing.T) {
+	dsn := os.Getenv("ILLA_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("ILLA_TEST_PG_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	tx := db.Begin()
+	defer tx.Rollback()
+	assert.Nil(t, tx.Exec("CREATE SCHEMA audit_log_test").Error)
+	assert.Nil(t, tx.Exec("SET LOCAL search_path TO audit_log_test").Error)
+	assert.Nil(t, tx.AutoMigrate(&repository.AuditLog{}))
+
+	logger := zap.NewNop().Sugar()
+	service := NewAuditServiceImpl(logger, repository.NewAuditLogRepositoryImpl(logger, tx))
+	resource := map[string]interface{}{"resourceName": "orders", "content": map[string]interface{}{"password": "hunter2"}}
+	assert.Nil(t, service.Record(Entry{ActorID: 1, Source: SOURCE_REST, Operation: OPERATION_CREATE, TargetType: TARGET_RESOURCE,
+		TargetID: 3, After: resource}))
+	assert.Nil(t, service.Record(Entry{ActorID: 1, Source: SOURCE_REST, Operation: OPERATION_DELETE, TargetType: TARGET_RESOURCE,
+		TargetID: 3, Before: resource}))
+	assert.Nil(t, service.Record(Entry{ActorID: 1, Source: SOURCE_REST, Operation: OPERATION_DEPLOY, TargetType: TARGET_APP,
+		TargetID: 5}))
+
+	var nulls int64
+	assert.Nil(t, tx.Model(&repository.AuditLog{}).Where("before IS NULL").Count(&nulls).Error)
+	assert.Equal(t, int64(2), nulls)
+	assert.Nil(t, tx.Model(&repository.AuditLog{}).Where("after IS NULL").Count(&nulls).Error)
+	assert.Equal(t, int64(2), nulls)
+
+	auditLogs, total, err := service.Query(repository.AuditLogFilter{TargetType: TARGET_RESOURCE})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, OPERATION_DELETE, auditLogs[0].Operation)
+	assert.JSONEq(t, `{"resourceName":"orders","content":{"password":"`+REDACTED+`"}}`, string(auditLogs[0].Before))
+	assert.Equal(t, "null", string(auditLogs[0].After))
+	assert.Equal(t, "null", string(auditLogs[1].Before))
+	assert.JSONEq(t, string(auditLogs[0].Before), string(auditLogs[1].After))
+}
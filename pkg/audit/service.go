@@ -0,0 +1,205 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+const (
+	SOURCE_REST      = "rest"
+	SOURCE_WEBSOCKET = "websocket"
+)
+
+const (
+	TARGET_APP        = "app"
+	TARGET_RESOURCE   = "resource"
+	TARGET_ACTION     = "action"
+	TARGET_TREE_STATE = "tree_state"
+	TARGET_KV_STATE   = "kv_state"
+	TARGET_SET_STATE  = "set_state"
+)
+
+const (
+	OPERATION_CREATE           = "create"
+	OPERATION_UPDATE           = "update"
+	OPERATION_DELETE           = "delete"
+	OPERATION_MOVE             = "move"
+	OPERATION_CREATE_OR_UPDATE = "create_or_update"
+	OPERATION_PUT              = "put"
+	OPERATION_DUPLICATE        = "duplicate"
+	OPERATION_DEPLOY           = "deploy"
+	OPERATION_ROLLBACK         = "rollback"
+	OPERATION_PROMOTE          = "promote"
+	OPERATION_IMPORT           = "import"
+	OPERATION_PUBLISH          = "publish"
+)
+
+const (
+	DEFAULT_QUERY_LIMIT = 100
+	MAX_QUERY_LIMIT     = 1000
+	MAX_EXPORT_LIMIT    = 10000
+)
+
+type AuditService interface {
+	Record(entry Entry) error
+	Query(filter repository.AuditLogFilter) ([]AuditLogDto, int64, error)
+}
+
+// Entry describes one change, Before and After are any json serializable value.
+type Entry struct {
+	ActorID    int
+	ActorIP    string
+	Source     string
+	Operation  string
+	TargetType string
+	TargetID   int
+	TargetName string
+	AppID      int
+	Before     interface{}
+	After      interface{}
+}
+
+type AuditLogDto struct {
+	ID         int             `json:"auditLogId"`
+	ActorID    int             `json:"actorId"`
+	ActorIP    string          `json:"actorIp"`
+	Source     string          `json:"source"`
+	Operation  string          `json:"operation"`
+	TargetType string          `json:"targetType"`
+	TargetID   int             `json:"targetId"`
+	TargetName string          `json:"targetName"`
+	AppID      int             `json:"appId"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Changes    []string        `json:"changes"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+func (dto *AuditLogDto) ConstructByRecord(record *repository.AuditLog) {
+	dto.ID = record.ID
+	dto.ActorID = record.ActorID
+	dto.ActorIP = record.ActorIP
+	dto.Source = record.Source
+	dto.Operation = record.Operation
+	dto.TargetType = record.TargetType
+	dto.TargetID = record.TargetID
+	dto.TargetName = record.TargetName
+	dto.AppID = record.AppID
+	dto.Before = rawJSON(record.Before)
+	dto.After = rawJSON(record.After)
+	dto.Changes = []string{}
+	if record.Changes != "" {
+		dto.Changes = strings.Split(record.Changes, ",")
+	}
+	dto.CreatedAt = record.CreatedAt
+}
+
+type AuditServiceImpl struct {
+	logger             *zap.SugaredLogger
+	auditLogRepository repository.AuditLogRepository
+}
+
+func NewAuditServiceImpl(logger *zap.SugaredLogger, auditLogRepository repository.AuditLogRepository) *AuditServiceImpl {
+	return &AuditServiceImpl{
+		logger:             logger,
+		auditLogRepository: auditLogRepository,
+	}
+}
+
+// Record redacts the secrets of the snapshots and appends the entry to the audit log.
+func (impl *AuditServiceImpl) Record(entry Entry) error {
+	before, err := Redact(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := Redact(entry.After)
+	if err != nil {
+		return err
+	}
+	beforeJSON, err := marshalSnapshot(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalSnapshot(after)
+	if err != nil {
+		return err
+	}
+	_, err = impl.auditLogRepository.Create(&repository.AuditLog{
+		ActorID:    entry.ActorID,
+		ActorIP:    entry.ActorIP,
+		Source:     entry.Source,
+		Operation:  entry.Operation,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		TargetName: entry.TargetName,
+		AppID:      entry.AppID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		Changes:    strings.Join(Diff(before, after), ","),
+		CreatedAt:  time.Now().UTC(),
+	})
+	return err
+}
+
+func (impl *AuditServiceImpl) Logger() *zap.SugaredLogger {
+	return impl.logger
+}
+
+func (impl *AuditServiceImpl) Query(filter repository.AuditLogFilter) ([]AuditLogDto, int64, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DEFAULT_QUERY_LIMIT
+	}
+	if filter.Limit > MAX_EXPORT_LIMIT {
+		filter.Limit = MAX_EXPORT_LIMIT
+	}
+	records, total, err := impl.auditLogRepository.RetrieveByFilter(&filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	auditLogDtos := make([]AuditLogDto, 0, len(records))
+	for _, record := range records {
+		auditLogDto := AuditLogDto{}
+		auditLogDto.ConstructByRecord(record)
+		auditLogDtos = append(auditLogDtos, auditLogDto)
+	}
+	return auditLogDtos, total, nil
+}
+
+// marshalSnapshot returns nil for nil, it is stored as NULL.
+func marshalSnapshot(snapshot interface{}) (*string, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+func rawJSON(s *string) json.RawMessage {
+	if s == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(*s)
+}
@@ -0,0 +1,201 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backplane
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/caarlos0/env"
+	"go.uber.org/zap"
+)
+
+const (
+	DRIVER_MEMORY   = "memory"
+	DRIVER_POSTGRES = "postgres"
+)
+
+// publishing never blocks, the droppable envelopes of a slow subscriber are dropped when its buffer
+// is full. When an envelope which can not be dropped does not fit, the subscriber gets no more
+// envelopes until a KIND_RESYNC one fits into its buffer.
+const SUBSCRIBER_BUFFER_SIZE = 1024
+
+// KIND_RESYNC tells the subscriber it lost the envelopes which can not be dropped, the state it built
+// from them has to be loaded again.
+const KIND_RESYNC = "resync"
+
+type Config struct {
+	Driver string `env:"ILLA_WS_BACKPLANE" envDefault:"memory"`
+}
+
+func GetConfig() (*Config, error) {
+	cfg := &Config{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+// Envelope is a message passed between the websocket server instances.
+type Envelope struct {
+	Topic    string          `json:"topic"`
+	Node     string          `json:"node"`
+	Kind     string          `json:"kind"`
+	APPID    int             `json:"appID"`
+	ClientID string          `json:"clientID,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	// a transient state, a subscriber which can not keep up may drop it
+	Droppable bool `json:"droppable,omitempty"`
+}
+
+// Backplane fans the envelopes out to the subscribers of the topic on every server instance,
+// including the publisher itself.
+type Backplane interface {
+	Publish(envelope *Envelope) error
+	Subscribe(topic string) <-chan *Envelope
+	Close() error
+}
+
+// NewBackplane returns the backplane of the configured driver, the postgres driver is needed to run
+// more than one websocket server instance.
+func NewBackplane(cfg *Config, logger *zap.SugaredLogger, dsn string, websocketEnvelopeRepository repository.WebsocketEnvelopeRepository) (Backplane, error) {
+	switch cfg.Driver {
+	case DRIVER_MEMORY:
+		return NewMemoryBackplane(), nil
+	case DRIVER_POSTGRES:
+		return NewPostgresBackplane(logger, dsn, websocketEnvelopeRepository), nil
+	default:
+		return nil, errors.New("unsupported websocket backplane: " + cfg.Driver)
+	}
+}
+
+// MemoryBackplane only reaches the subscribers in the same process, it is enough for a single instance.
+type MemoryBackplane struct {
+	topics *topics
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		topics: newTopics(),
+	}
+}
+
+func (b *MemoryBackplane) Publish(envelope *Envelope) error {
+	b.topics.deliver(envelope)
+	return nil
+}
+
+func (b *MemoryBackplane) Subscribe(topic string) <-chan *Envelope {
+	return b.topics.subscribe(topic)
+}
+
+func (b *MemoryBackplane) Close() error {
+	b.topics.close()
+	return nil
+}
+
+// topics keeps the subscribers of the backplane in this process.
+type topics struct {
+	mutex       sync.Mutex
+	subscribers map[string][]*subscriber
+	closed      bool
+}
+
+type subscriber struct {
+	envelopes chan *Envelope
+	// an envelope which can not be dropped was lost, the subscriber waits for KIND_RESYNC
+	lagging bool
+}
+
+func newTopics() *topics {
+	return &topics{
+		subscribers: make(map[string][]*subscriber),
+	}
+}
+
+func (t *topics) subscribe(topic string) <-chan *Envelope {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	envelopes := make(chan *Envelope, SUBSCRIBER_BUFFER_SIZE)
+	if t.closed {
+		close(envelopes)
+		return envelopes
+	}
+	t.subscribers[topic] = append(t.subscribers[topic], &subscriber{envelopes: envelopes})
+	return envelopes
+}
+
+// deliver returns the number of the subscribers which dropped the envelope and the number of the
+// subscribers which lost it and have to resync.
+func (t *topics) deliver(envelope *Envelope) (int, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	dropped, lost := 0, 0
+	for _, subscriber := range t.subscribers[envelope.Topic] {
+		if subscriber.lagging {
+			select {
+			case subscriber.envelopes <- &Envelope{Topic: envelope.Topic, Kind: KIND_RESYNC}:
+				subscriber.lagging = false
+			default:
+				lost++
+				continue
+			}
+		}
+		select {
+		case subscriber.envelopes <- envelope:
+		default:
+			if envelope.Droppable {
+				dropped++
+			} else {
+				subscriber.lagging = true
+				lost++
+			}
+		}
+	}
+	return dropped, lost
+}
+
+// resync tells every subscriber to resync, the subscribers which are full get KIND_RESYNC before
+// their next envelope.
+func (t *topics) resync() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for topic, subscribers := range t.subscribers {
+		for _, subscriber := range subscribers {
+			select {
+			case subscriber.envelopes <- &Envelope{Topic: topic, Kind: KIND_RESYNC}:
+				subscriber.lagging = false
+			default:
+				subscriber.lagging = true
+			}
+		}
+	}
+}
+
+func (t *topics) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	for topic, subscribers := range t.subscribers {
+		for _, subscriber := range subscribers {
+			close(subscriber.envelopes)
+		}
+		delete(t.subscribers, topic)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backplane
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBackplaneDeliversByTopic(t *testing.T) {
+	b := NewMemoryBackplane()
+	app1 := b.Subscribe("app")
+	app2 := b.Subscribe("app")
+	dashboard := b.Subscribe("dashboard")
+
+	assert.Nil(t, b.Publish(&Envelope{Topic: "app", Node: "node1", Kind: "broadcast", APPID: 3}))
+	assert.Equal(t, 3, (<-app1).APPID)
+	assert.Equal(t, 3, (<-app2).APPID)
+	assert.Len(t, dashboard, 0)
+}
+
+func TestMemoryBackplaneDropsWhenSubscriberIsFull(t *testing.T) {
+	b := NewMemoryBackplane()
+	subscriber := b.Subscribe("app")
+	for i := 0; i < SUBSCRIBER_BUFFER_SIZE+10; i++ {
+		assert.Nil(t, b.Publish(&Envelope{Topic: "app", APPID: i, Droppable: true}))
+	}
+	assert.Len(t, subscriber, SUBSCRIBER_BUFFER_SIZE)
+	assert.Equal(t, 0, (<-subscriber).APPID)
+}
+
+func TestMemoryBackplaneResyncsWhenEnvelopeIsLost(t *testing.T) {
+	b := NewMemoryBackplane()
+	subscriber := b.Subscribe("app")
+	for i := 0; i < SUBSCRIBER_BUFFER_SIZE+1; i++ {
+		assert.Nil(t, b.Publish(&Envelope{Topic: "app", APPID: i}))
+	}
+	for i := 0; i < SUBSCRIBER_BUFFER_SIZE; i++ {
+		assert.Equal(t, i, (<-subscriber).APPID)
+	}
+
+	// the lost envelope is followed by a resync, then the subscriber gets the envelopes again
+	assert.Nil(t, b.Publish(&Envelope{Topic: "app", APPID: 2000}))
+	assert.Equal(t, KIND_RESYNC, (<-subscriber).Kind)
+	assert.Equal(t, 2000, (<-subscriber).APPID)
+	assert.Len(t, subscriber, 0)
+}
+
+func TestTopicsResyncEverySubscriber(t *testing.T) {
+	topics := newTopics()
+	app := topics.subscribe("app")
+	full := topics.subscribe("dashboard")
+	for i := 0; i < SUBSCRIBER_BUFFER_SIZE; i++ {
+		topics.deliver(&Envelope{Topic: "dashboard", APPID: i})
+	}
+
+	topics.resync()
+	assert.Equal(t, &Envelope{Topic: "app", Kind: KIND_RESYNC}, <-app)
+	// the full subscriber gets the resync before its next envelope
+	for i := 0; i < SUBSCRIBER_BUFFER_SIZE; i++ {
+		assert.Equal(t, i, (<-full).APPID)
+	}
+	topics.deliver(&Envelope{Topic: "dashboard", APPID: 2000})
+	assert.Equal(t, KIND_RESYNC, (<-full).Kind)
+	assert.Equal(t, 2000, (<-full).APPID)
+}
+
+func TestMemoryBackplaneClose(t *testing.T) {
+	b := NewMemoryBackplane()
+	subscriber := b.Subscribe("app")
+	assert.Nil(t, b.Close())
+	_, ok := <-subscriber
+	assert.False(t, ok)
+	assert.Nil(t, b.Publish(&Envelope{Topic: "app"}))
+	_, ok = <-b.Subscribe("app")
+	assert.False(t, ok)
+}
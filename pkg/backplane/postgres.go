@@ -0,0 +1,218 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/jackc/pgconn"
+	"go.uber.org/zap"
+)
+
+// all topics share one channel, the topic is in the envelope.
+const NOTIFY_CHANNEL = "illa_websocket"
+
+// the NOTIFY payload must be shorter than 8000 bytes, a larger envelope is stored and the
+// notification refers to it by id.
+const (
+	MAX_NOTIFY_PAYLOAD        = 7900
+	ENVELOPE_REFERENCE_PREFIX = "ref:"
+	ENVELOPE_RETENTION        = time.Minute
+)
+
+const (
+	RECONNECT_MIN_WAIT = time.Second
+	RECONNECT_MAX_WAIT = time.Second * 30
+)
+
+// PostgresBackplane fans the envelopes out by LISTEN/NOTIFY of the database we already have.
+// The envelopes published while the listener reconnects are lost, so the subscribers resync after
+// every reconnect.
+type PostgresBackplane struct {
+	logger                      *zap.SugaredLogger
+	dsn                         string
+	websocketEnvelopeRepository repository.WebsocketEnvelopeRepository
+	topics                      *topics
+	notifications               chan string
+	cancel                      context.CancelFunc
+}
+
+func NewPostgresBackplane(logger *zap.SugaredLogger, dsn string, websocketEnvelopeRepository repository.WebsocketEnvelopeRepository) *PostgresBackplane {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &PostgresBackplane{
+		logger:                      logger,
+		dsn:                         dsn,
+		websocketEnvelopeRepository: websocketEnvelopeRepository,
+		topics:                      newTopics(),
+		notifications:               make(chan string, SUBSCRIBER_BUFFER_SIZE),
+		cancel:                      cancel,
+	}
+	go b.listen(ctx)
+	go b.dispatch(ctx)
+	go b.prune(ctx)
+	return b
+}
+
+func (b *PostgresBackplane) Publish(envelope *Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	if len(payload) <= MAX_NOTIFY_PAYLOAD {
+		return b.websocketEnvelopeRepository.Notify(NOTIFY_CHANNEL, string(payload))
+	}
+	id, err := b.websocketEnvelopeRepository.Create(&repository.WebsocketEnvelope{
+		Payload:   string(payload),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return b.websocketEnvelopeRepository.Notify(NOTIFY_CHANNEL, ENVELOPE_REFERENCE_PREFIX+strconv.Itoa(id))
+}
+
+func (b *PostgresBackplane) Subscribe(topic string) <-chan *Envelope {
+	return b.topics.subscribe(topic)
+}
+
+func (b *PostgresBackplane) Close() error {
+	b.cancel()
+	b.topics.close()
+	return nil
+}
+
+// listen keeps a dedicated connection listening, it reconnects with backoff when the connection is lost.
+func (b *PostgresBackplane) listen(ctx context.Context) {
+	wait := RECONNECT_MIN_WAIT
+	listened := false
+	for {
+		err := b.listenOnce(ctx, func() {
+			wait = RECONNECT_MIN_WAIT
+			if listened {
+				b.logger.Warnw("websocket backplane listener reconnected, subscribers will resync", "channel", NOTIFY_CHANNEL)
+				b.topics.resync()
+			}
+			listened = true
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		b.logger.Errorw("websocket backplane listener disconnected", "err", err, "retry", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if wait *= 2; wait > RECONNECT_MAX_WAIT {
+			wait = RECONNECT_MAX_WAIT
+		}
+	}
+}
+
+func (b *PostgresBackplane) listenOnce(ctx context.Context, onListen func()) error {
+	config, err := pgconn.ParseConfig(b.dsn)
+	if err != nil {
+		return err
+	}
+	// the handler must not query on the connection, the payload is decoded by dispatch
+	config.OnNotification = func(_ *pgconn.PgConn, notification *pgconn.Notification) {
+		select {
+		case b.notifications <- notification.Payload:
+		case <-ctx.Done():
+		}
+	}
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+NOTIFY_CHANNEL).ReadAll(); err != nil {
+		return err
+	}
+	onListen()
+	b.logger.Infow("websocket backplane listening", "channel", NOTIFY_CHANNEL)
+	for {
+		if err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *PostgresBackplane) dispatch(ctx context.Context) {
+	for {
+		select {
+		case payload := <-b.notifications:
+			envelope, err := b.decode(payload)
+			if err != nil {
+				b.logger.Errorw("decode websocket envelope error", "err", err)
+				continue
+			}
+			dropped, lost := b.topics.deliver(envelope)
+			if dropped > 0 {
+				b.logger.Warnw("websocket backplane subscriber is full, envelope dropped", "topic", envelope.Topic, "dropped", dropped)
+			}
+			if lost > 0 {
+				b.logger.Warnw("websocket backplane subscriber is full, envelope lost and subscriber will resync", "topic", envelope.Topic, "kind", envelope.Kind, "lost", lost)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *PostgresBackplane) decode(payload string) (*Envelope, error) {
+	if strings.HasPrefix(payload, ENVELOPE_REFERENCE_PREFIX) {
+		id, err := strconv.Atoi(strings.TrimPrefix(payload, ENVELOPE_REFERENCE_PREFIX))
+		if err != nil {
+			return nil, err
+		}
+		record, err := b.websocketEnvelopeRepository.RetrieveByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, errors.New("websocket envelope " + strconv.Itoa(id) + " was pruned")
+		}
+		payload = record.Payload
+	}
+	envelope := &Envelope{}
+	if err := json.Unmarshal([]byte(payload), envelope); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// prune deletes the stored envelopes after every instance had the chance to read them.
+func (b *PostgresBackplane) prune(ctx context.Context) {
+	ticker := time.NewTicker(ENVELOPE_RETENTION)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.websocketEnvelopeRepository.DeleteBefore(time.Now().UTC().Add(-ENVELOPE_RETENTION)); err != nil {
+				b.logger.Errorw("prune websocket envelopes error", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
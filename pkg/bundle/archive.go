@@ -0,0 +1,108 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const BUNDLE_FILE_NAME = "bundle.json"
+
+// MAX_BUNDLE_SIZE limits the uncompressed bundle read from an archive.
+const MAX_BUNDLE_SIZE = 32 << 20
+
+var zipMagic = []byte("PK\x03\x04")
+
+// Decode reads a json or zip bundle and migrates it to the current schema version.
+func Decode(data []byte) (*Bundle, error) {
+	if bytes.HasPrefix(data, zipMagic) {
+		var err error
+		if data, err = readArchive(data); err != nil {
+			return nil, err
+		}
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBundle, err.Error())
+	}
+	if err := Migrate(raw); err != nil {
+		return nil, err
+	}
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{}
+	if err := json.Unmarshal(migrated, bundle); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBundle, err.Error())
+	}
+	return bundle, nil
+}
+
+func readArchive(data []byte) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBundle, err.Error())
+	}
+	for _, file := range reader.File {
+		if file.Name != BUNDLE_FILE_NAME {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBundle, err.Error())
+		}
+		defer rc.Close()
+		content, err := ioutil.ReadAll(io.LimitReader(rc, MAX_BUNDLE_SIZE+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBundle, err.Error())
+		}
+		if len(content) > MAX_BUNDLE_SIZE {
+			return nil, fmt.Errorf("%w: %s is too large", ErrInvalidBundle, BUNDLE_FILE_NAME)
+		}
+		return content, nil
+	}
+	return nil, fmt.Errorf("%w: no %s in archive", ErrInvalidBundle, BUNDLE_FILE_NAME)
+}
+
+// EncodeZip packs the bundle as the `bundle.json` entry of a zip archive.
+func EncodeZip(bundle *Bundle) ([]byte, error) {
+	content, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	file, err := writer.CreateHeader(&zip.FileHeader{
+		Name:     BUNDLE_FILE_NAME,
+		Method:   zip.Deflate,
+		Modified: bundle.ExportedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,370 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/resource"
+	"github.com/illa-family/builder-backend/pkg/state"
+
+	"go.uber.org/zap"
+)
+
+// SCHEMA_VERSION is the bundle layout written by this server, older bundles are migrated on import.
+const SCHEMA_VERSION = 1
+
+const RESOURCE_REF_PREFIX = "resource_"
+
+var ErrInvalidBundle = errors.New("invalid bundle")
+var ErrUnsupportedSchema = errors.New("unsupported bundle schema version")
+var ErrResourceMismatch = errors.New("resource mapping mismatch")
+
+type Bundle struct {
+	SchemaVersion         int                    `json:"schemaVersion"`
+	ExportedAt            time.Time              `json:"exportedAt"`
+	App                   BundleApp              `json:"app"`
+	Resources             []BundleResource       `json:"resources"`
+	Actions               []BundleAction         `json:"actions"`
+	Components            *app.ComponentNode     `json:"components"`
+	DependenciesState     map[string][]string    `json:"dependenciesState"`
+	DragShadowState       map[string]interface{} `json:"dragShadowState"`
+	DottedLineSquareState map[string]interface{} `json:"dottedLineSquareState"`
+	DisplayNameState      []string               `json:"displayNameState"`
+}
+
+type BundleApp struct {
+	Name string `json:"name"`
+}
+
+// BundleResource is the placeholder of a resource used by the actions, its secrets are stripped.
+type BundleResource struct {
+	Ref     string                 `json:"ref"`
+	Name    string                 `json:"resourceName"`
+	Type    string                 `json:"resourceType"`
+	Options map[string]interface{} `json:"content"`
+}
+
+type BundleAction struct {
+	DisplayName string                 `json:"displayName"`
+	Type        string                 `json:"actionType"`
+	ResourceRef string                 `json:"resourceRef,omitempty"`
+	Template    map[string]interface{} `json:"content"`
+	Transformer map[string]interface{} `json:"transformer"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	TriggerMode string                 `json:"triggerMode"`
+}
+
+type ResourceMapping struct {
+	Ref        string `json:"ref"`
+	ResourceID int    `json:"resourceId"`
+	Created    bool   `json:"created"` // created from the placeholder, the stripped secrets must be filled in
+}
+
+type ImportResult struct {
+	App       app.AppDto        `json:"app"`
+	Resources []ResourceMapping `json:"resources"`
+}
+
+type BundleService interface {
+	Export(appID, version int) (*Bundle, error)
+	Import(bundle *Bundle, name string, resourceMap map[string]int, userID int) (ImportResult, error)
+}
+
+// ComponentTreeValidator checks the component tree of the bundle before the app is created from it.
+type ComponentTreeValidator interface {
+	ValidateComponentTree(appDto *app.AppDto, data interface{}) error
+}
+
+type BundleServiceImpl struct {
+	logger             *zap.SugaredLogger
+	appService         app.AppService
+	resourceService    resource.ResourceService
+	componentValidator ComponentTreeValidator
+}
+
+func NewBundleServiceImpl(logger *zap.SugaredLogger, appService app.AppService,
+	resourceService resource.ResourceService, componentValidator ComponentTreeValidator) *BundleServiceImpl {
+	return &BundleServiceImpl{
+		logger:             logger,
+		appService:         appService,
+		resourceService:    resourceService,
+		componentValidator: componentValidator,
+	}
+}
+
+func resourceRef(serial int) string {
+	return RESOURCE_REF_PREFIX + strconv.Itoa(serial)
+}
+
+func (impl *BundleServiceImpl) Export(appID, version int) (*Bundle, error) {
+	editor, err := impl.appService.GetMegaData(appID, version)
+	if err != nil {
+		return nil, err
+	}
+	if editor.AppInfo.ID == 0 {
+		return nil, app.ErrAppNotFound
+	}
+
+	bundle := &Bundle{
+		SchemaVersion:         SCHEMA_VERSION,
+		ExportedAt:            time.Now().UTC(),
+		App:                   BundleApp{Name: editor.AppInfo.Name},
+		Resources:             []BundleResource{},
+		Actions:               make([]BundleAction, 0, len(editor.Actions)),
+		Components:            editor.Components,
+		DependenciesState:     editor.DependenciesState,
+		DragShadowState:       editor.DragShadowState,
+		DottedLineSquareState: editor.DottedLineSquareState,
+		DisplayNameState:      editor.DisplayNameState,
+	}
+	refs := map[int]string{}
+	for _, action := range editor.Actions {
+		// the templates hold the headers and the bodies of the requests
+		template, err := StripSecrets(action.Template)
+		if err != nil {
+			return nil, err
+		}
+		bundleAction := BundleAction{
+			DisplayName: action.DisplayName,
+			Type:        action.Type,
+			Template:    template,
+			Transformer: action.Transformer,
+			Parameters:  action.Parameters,
+			TriggerMode: action.TriggerMode,
+		}
+		if action.Resource != 0 {
+			ref, ok := refs[action.Resource]
+			if !ok {
+				res, err := impl.resourceService.GetResource(action.Resource)
+				if err != nil {
+					return nil, fmt.Errorf("export resource %d of action %s: %w", action.Resource, action.DisplayName, err)
+				}
+				options, err := StripSecrets(res.Options)
+				if err != nil {
+					return nil, err
+				}
+				ref = resourceRef(len(refs) + 1)
+				refs[action.Resource] = ref
+				bundle.Resources = append(bundle.Resources, BundleResource{
+					Ref:     ref,
+					Name:    res.Name,
+					Type:    res.Type,
+					Options: options,
+				})
+			}
+			bundleAction.ResourceRef = ref
+		}
+		bundle.Actions = append(bundle.Actions, bundleAction)
+	}
+	return bundle, nil
+}
+
+// Import recreates the bundle as a new app. Each resource placeholder is resolved by the resource map, and is
+// created from the placeholder when the resource map does not name it.
+func (impl *BundleServiceImpl) Import(bundle *Bundle, name string, resourceMap map[string]int, userID int) (ImportResult, error) {
+	if err := bundle.Validate(); err != nil {
+		return ImportResult{}, err
+	}
+	if name == "" {
+		name = bundle.App.Name
+	}
+	if name == "" {
+		return ImportResult{}, fmt.Errorf("%w: missing app name", ErrInvalidBundle)
+	}
+	if err := impl.validateComponents(bundle.Components); err != nil {
+		return ImportResult{}, err
+	}
+
+	mappings, err := impl.resolveResources(bundle.Resources, resourceMap, userID)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	resourceIDs := make(map[string]int, len(mappings))
+	for _, mapping := range mappings {
+		resourceIDs[mapping.Ref] = mapping.ResourceID
+	}
+
+	editor := app.Editor{
+		Actions:               make([]app.Action, 0, len(bundle.Actions)),
+		Components:            bundle.Components,
+		DependenciesState:     bundle.DependenciesState,
+		DragShadowState:       bundle.DragShadowState,
+		DottedLineSquareState: bundle.DottedLineSquareState,
+		DisplayNameState:      bundle.DisplayNameState,
+	}
+	for _, action := range bundle.Actions {
+		editor.Actions = append(editor.Actions, app.Action{
+			Resource:    resourceIDs[action.ResourceRef],
+			DisplayName: action.DisplayName,
+			Type:        action.Type,
+			Template:    action.Template,
+			Transformer: action.Transformer,
+			Parameters:  action.Parameters,
+			TriggerMode: action.TriggerMode,
+		})
+	}
+	appDto, err := impl.appService.ImportApp(name, editor, userID)
+	if err != nil {
+		// the app was rolled back, do not leave the resources created for it behind
+		for _, mapping := range mappings {
+			if !mapping.Created {
+				continue
+			}
+			if err := impl.resourceService.DeleteResource(mapping.ResourceID); err != nil {
+				impl.logger.Errorw("delete imported resource failed", "resource", mapping.ResourceID, "error", err)
+			}
+		}
+		return ImportResult{}, err
+	}
+	return ImportResult{App: appDto, Resources: mappings}, nil
+}
+
+// validateComponents checks the component tree the same way the editor changes are checked. The summit
+// node is imported as the rootDsl of the new app, so only the fields and the names inside of the tree
+// are checked.
+func (impl *BundleServiceImpl) validateComponents(components *app.ComponentNode) error {
+	b, err := json.Marshal(components)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	data["displayName"] = repository.TREE_STATE_ROOTDSL_NAME
+	children, _ := data["childrenNode"].([]interface{})
+	for _, child := range children {
+		if item, ok := child.(map[string]interface{}); ok && item["parentNode"] == components.DisplayName {
+			item["parentNode"] = repository.TREE_STATE_ROOTDSL_NAME
+		}
+	}
+	err = impl.componentValidator.ValidateComponentTree(&app.AppDto{}, data)
+	var errs state.ComponentValidationErrors
+	if errors.As(err, &errs) {
+		return fmt.Errorf("%w: %s", ErrInvalidBundle, errs.Error())
+	}
+	return err
+}
+
+func (impl *BundleServiceImpl) resolveResources(placeholders []BundleResource, resourceMap map[string]int, userID int) ([]ResourceMapping, error) {
+	mappings := make([]ResourceMapping, 0, len(placeholders))
+	for _, placeholder := range placeholders {
+		if id, ok := resourceMap[placeholder.Ref]; ok {
+			res, err := impl.resourceService.GetResource(id)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s maps to unknown resource %d", ErrResourceMismatch, placeholder.Ref, id)
+			}
+			if res.Type != placeholder.Type {
+				return nil, fmt.Errorf("%w: %s needs a %s resource, resource %d is %s", ErrResourceMismatch, placeholder.Ref, placeholder.Type, id, res.Type)
+			}
+			mappings = append(mappings, ResourceMapping{Ref: placeholder.Ref, ResourceID: id})
+			continue
+		}
+
+		name := placeholder.Name
+		if name == "" {
+			name = placeholder.Ref
+		}
+		options := placeholder.Options
+		if options == nil {
+			options = map[string]interface{}{}
+		}
+		res, err := impl.resourceService.CreateResource(resource.ResourceDto{
+			Name:      name,
+			Type:      placeholder.Type,
+			Options:   options,
+			CreatedAt: time.Now().UTC(),
+			CreatedBy: userID,
+			UpdatedAt: time.Now().UTC(),
+			UpdatedBy: userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, ResourceMapping{Ref: placeholder.Ref, ResourceID: res.ID, Created: true})
+	}
+	return mappings, nil
+}
+
+// Validate checks the references inside of the bundle.
+func (bundle *Bundle) Validate() error {
+	if bundle.SchemaVersion != SCHEMA_VERSION {
+		return fmt.Errorf("%w: %d", ErrUnsupportedSchema, bundle.SchemaVersion)
+	}
+	if bundle.Components == nil {
+		return fmt.Errorf("%w: missing components", ErrInvalidBundle)
+	}
+	refs := make(map[string]bool, len(bundle.Resources))
+	for _, placeholder := range bundle.Resources {
+		if placeholder.Ref == "" || refs[placeholder.Ref] {
+			return fmt.Errorf("%w: empty or duplicated resource ref %q", ErrInvalidBundle, placeholder.Ref)
+		}
+		if placeholder.Type == "" {
+			return fmt.Errorf("%w: resource %s has no type", ErrInvalidBundle, placeholder.Ref)
+		}
+		refs[placeholder.Ref] = true
+	}
+	for _, action := range bundle.Actions {
+		if action.ResourceRef != "" && !refs[action.ResourceRef] {
+			return fmt.Errorf("%w: action %s refers to unknown resource %s", ErrInvalidBundle, action.DisplayName, action.ResourceRef)
+		}
+	}
+	return nil
+}
+
+// StripSecrets returns a copy of the options where the value of each sensitive field is emptied, the
+// sensitive fields are the sensitive keys and the key/value pairs like {"key": "Authorization", "value": ...}.
+func StripSecrets(options map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	stripped := map[string]interface{}{}
+	if err := json.Unmarshal(b, &stripped); err != nil {
+		return nil, err
+	}
+	stripValue(stripped)
+	return stripped, nil
+}
+
+func stripValue(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if key, ok := v["key"].(string); ok && audit.IsSensitive(key) {
+			if _, ok := v["value"]; ok {
+				v["value"] = ""
+			}
+		}
+		for key, field := range v {
+			if audit.IsSensitive(key) {
+				v[key] = ""
+				continue
+			}
+			stripValue(field)
+		}
+	case []interface{}:
+		for _, field := range v {
+			stripValue(field)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/resource"
+	"github.com/illa-family/builder-backend/pkg/state"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// rootComponent is the component tree of an empty app.
+const rootComponent = `{"displayName": "root", "type": "DOT_PANEL", "containerType": "EDITOR_DOT_PANEL", "x": -1, "y": -1, "w": 0, "h": 0}`
+
+// memoryApps serves one editor and keeps the imported one.
+type memoryApps struct {
+	app.AppService
+	editor   app.Editor
+	imported *app.Editor
+}
+
+func (m *memoryApps) GetMegaData(appID, version int) (app.Editor, error) {
+	return m.editor, nil
+}
+
+func (m *memoryApps) ImportApp(name string, editor app.Editor, userID int) (app.AppDto, error) {
+	m.imported = &editor
+	return app.AppDto{ID: 2, Name: name}, nil
+}
+
+// emptyTreeStates has no components, the imported app is new.
+type emptyTreeStates struct {
+	repository.TreeStateRepository
+}
+
+func (emptyTreeStates) RetrieveEditVersionByAppAndName(apprefid int, statetype int, name string) (*repository.TreeState, error) {
+	return nil, gorm.ErrRecordNotFound
+}
+
+func newComponentValidator() ComponentTreeValidator {
+	return state.NewTreeStateServiceImpl(zap.NewNop().Sugar(), emptyTreeStates{})
+}
+
+type memoryResources struct {
+	resource.ResourceService
+	resources map[int]resource.ResourceDto
+}
+
+func (m *memoryResources) GetResource(id int) (resource.ResourceDto, error) {
+	res, ok := m.resources[id]
+	if !ok {
+		return resource.ResourceDto{}, errors.New("resource not found")
+	}
+	return res, nil
+}
+
+func (m *memoryResources) CreateResource(res resource.ResourceDto) (resource.ResourceDto, error) {
+	res.ID = len(m.resources) + 100
+	m.resources[res.ID] = res
+	return res, nil
+}
+
+func TestDecodeMigratesMegaData(t *testing.T) {
+	legacy := `{
+		"appInfo": {"appId": 3, "appName": "orders"},
+		"actions": [
+			{"actionId": 1, "resourceId": 12, "displayName": "query1", "actionType": "mysql", "content": {}, "transformer": {}, "triggerMode": "manually"},
+			{"actionId": 2, "resourceId": 12, "displayName": "query2", "actionType": "mysql", "content": {}, "transformer": {}, "triggerMode": "manually"},
+			{"actionId": 3, "displayName": "transformer1", "actionType": "transformer", "content": {}, "transformer": {}, "triggerMode": "automate"}
+		],
+		"components": {"displayName": "root", "childrenNode": []},
+		"displayNameState": ["query1"]
+	}`
+	bundle, err := Decode([]byte(legacy))
+	assert.Nil(t, err)
+	assert.Equal(t, SCHEMA_VERSION, bundle.SchemaVersion)
+	assert.Equal(t, "orders", bundle.App.Name)
+	assert.Equal(t, []BundleResource{{Ref: "resource_1", Type: "mysql", Options: map[string]interface{}{}}}, bundle.Resources)
+	assert.Equal(t, "resource_1", bundle.Actions[0].ResourceRef)
+	assert.Equal(t, "resource_1", bundle.Actions[1].ResourceRef)
+	assert.Equal(t, "", bundle.Actions[2].ResourceRef)
+	assert.Nil(t, bundle.Validate())
+}
+
+func TestDecodeRejectsNewerSchema(t *testing.T) {
+	_, err := Decode([]byte(`{"schemaVersion": 99}`))
+	assert.ErrorIs(t, err, ErrUnsupportedSchema)
+	_, err = Decode([]byte(`not a bundle`))
+	assert.ErrorIs(t, err, ErrInvalidBundle)
+}
+
+func TestZipRoundTrip(t *testing.T) {
+	bundle, err := Decode([]byte(`{"schemaVersion": 1, "app": {"name": "orders"}, "components": ` + rootComponent + `,
+		"resources": [{"ref": "resource_1", "resourceName": "db", "resourceType": "mysql", "content": {"host": "db"}}],
+		"actions": [{"displayName": "query1", "actionType": "mysql", "resourceRef": "resource_1"}]}`))
+	assert.Nil(t, err)
+	archive, err := EncodeZip(bundle)
+	assert.Nil(t, err)
+	decoded, err := Decode(archive)
+	assert.Nil(t, err)
+	assert.Equal(t, bundle.App, decoded.App)
+	assert.Equal(t, bundle.Resources, decoded.Resources)
+	assert.Equal(t, bundle.Actions, decoded.Actions)
+
+	decoded.Actions[0].ResourceRef = "resource_2"
+	assert.ErrorIs(t, decoded.Validate(), ErrInvalidBundle)
+}
+
+func TestStripSecrets(t *testing.T) {
+	options := map[string]interface{}{
+		"host":     "db.internal",
+		"password": "hunter2",
+		"ssl":      map[string]interface{}{"clientKey": "k", "privateKey": "-----BEGIN"},
+		"headers":  []interface{}{map[string]interface{}{"Authorization": "Bearer x"}},
+		"urlParams": []interface{}{
+			map[string]interface{}{"key": "api_key", "value": "k"},
+			map[string]interface{}{"key": "page", "value": "1"},
+		},
+	}
+	stripped, err := StripSecrets(options)
+	assert.Nil(t, err)
+	assert.Equal(t, "db.internal", stripped["host"])
+	assert.Equal(t, "", stripped["password"])
+	assert.Equal(t, "", stripped["ssl"].(map[string]interface{})["privateKey"])
+	assert.Equal(t, "", stripped["headers"].([]interface{})[0].(map[string]interface{})["Authorization"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "api_key", "value": ""},
+		map[string]interface{}{"key": "page", "value": "1"},
+	}, stripped["urlParams"])
+	// the exported options are a copy
+	assert.Equal(t, "hunter2", options["password"])
+}
+
+func TestExportStripsHeaderArrays(t *testing.T) {
+	headers := []interface{}{
+		map[string]interface{}{"key": "Authorization", "value": "Bearer x"},
+		map[string]interface{}{"key": "X-Auth-Token", "value": "t"},
+		map[string]interface{}{"key": "Cookie", "value": "session=s"},
+		map[string]interface{}{"key": "Accept", "value": "application/json"},
+	}
+	stripped := []interface{}{
+		map[string]interface{}{"key": "Authorization", "value": ""},
+		map[string]interface{}{"key": "X-Auth-Token", "value": ""},
+		map[string]interface{}{"key": "Cookie", "value": ""},
+		map[string]interface{}{"key": "Accept", "value": "application/json"},
+	}
+	apps := &memoryApps{editor: app.Editor{
+		AppInfo: app.AppDto{ID: 1, Name: "orders"},
+		Actions: []app.Action{{
+			Resource:    12,
+			DisplayName: "restapi1",
+			Type:        "restapi",
+			Template:    map[string]interface{}{"url": "/orders", "headers": headers},
+			TriggerMode: "manually",
+		}},
+		Components: &app.ComponentNode{DisplayName: "root"},
+	}}
+	resources := &memoryResources{resources: map[int]resource.ResourceDto{
+		12: {ID: 12, Name: "api", Type: "restapi", Options: map[string]interface{}{"baseUrl": "https://api", "headers": headers}},
+	}}
+	service := NewBundleServiceImpl(zap.NewNop().Sugar(), apps, resources, newComponentValidator())
+
+	bundle, err := service.Export(1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, stripped, bundle.Resources[0].Options["headers"])
+	assert.Equal(t, stripped, bundle.Actions[0].Template["headers"])
+	assert.Equal(t, "/orders", bundle.Actions[0].Template["url"])
+	// the app is not changed by the export
+	assert.Equal(t, "Bearer x", apps.editor.Actions[0].Template["headers"].([]interface{})[0].(map[string]interface{})["value"])
+}
+
+func TestImportReusesMappedResourcesOnly(t *testing.T) {
+	bundle, err := Decode([]byte(`{"schemaVersion": 1, "app": {"name": "orders"}, "components": ` + rootComponent + `,
+		"resources": [
+			{"ref": "resource_1", "resourceName": "db", "resourceType": "mysql", "content": {"host": "db"}},
+			{"ref": "resource_2", "resourceName": "api", "resourceType": "restapi", "content": {"baseUrl": "https://api"}}
+		],
+		"actions": [
+			{"displayName": "query1", "actionType": "mysql", "resourceRef": "resource_1"},
+			{"displayName": "restapi1", "actionType": "restapi", "resourceRef": "resource_2"}
+		]}`))
+	assert.Nil(t, err)
+	apps := &memoryApps{}
+	// the resources with the same name and type are not reused without the mapping
+	resources := &memoryResources{resources: map[int]resource.ResourceDto{
+		12: {ID: 12, Name: "db", Type: "mysql"},
+		13: {ID: 13, Name: "api", Type: "restapi"},
+	}}
+	service := NewBundleServiceImpl(zap.NewNop().Sugar(), apps, resources, newComponentValidator())
+
+	result, err := service.Import(bundle, "", map[string]int{"resource_1": 12}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, ResourceMapping{Ref: "resource_1", ResourceID: 12}, result.Resources[0])
+	assert.True(t, result.Resources[1].Created)
+	assert.NotEqual(t, 13, result.Resources[1].ResourceID)
+	assert.Equal(t, "https://api", resources.resources[result.Resources[1].ResourceID].Options["baseUrl"])
+	assert.Equal(t, 12, apps.imported.Actions[0].Resource)
+	assert.Equal(t, result.Resources[1].ResourceID, apps.imported.Actions[1].Resource)
+
+	_, err = service.Import(bundle, "", map[string]int{"resource_1": 13}, 1)
+	assert.ErrorIs(t, err, ErrResourceMismatch)
+	_, err = service.Import(bundle, "", map[string]int{"resource_1": 99}, 1)
+	assert.ErrorIs(t, err, ErrResourceMismatch)
+}
+
+func TestImportValidatesComponents(t *testing.T) {
+	bundle, err := Decode([]byte(`{"schemaVersion": 1, "app": {"name": "orders"}, "components": {
+		"displayName": "root", "type": "DOT_PANEL", "containerType": "EDITOR_DOT_PANEL", "x": -1, "y": -1, "w": 0, "h": 0,
+		"childrenNode": [
+			{"displayName": "button1", "parentNode": "root", "type": "BUTTON_WIDGET", "containerType": "EDITOR_SCALE_SQUARE", "x": 0, "y": 0, "w": 6, "h": 5},
+			{"displayName": "button1", "parentNode": "root", "type": "BUTTON_WIDGET", "containerType": "EDITOR_SCALE_SQUARE", "x": 0, "y": 5, "w": 0, "h": 5}
+		]}}`))
+	assert.Nil(t, err)
+	apps := &memoryApps{}
+	service := NewBundleServiceImpl(zap.NewNop().Sugar(), apps, &memoryResources{}, newComponentValidator())
+
+	_, err = service.Import(bundle, "", nil, 1)
+	assert.ErrorIs(t, err, ErrInvalidBundle)
+	assert.Contains(t, err.Error(), "button1.displayName is used by another component in the app")
+	assert.Contains(t, err.Error(), "button1.w must be greater than 0")
+	assert.Nil(t, apps.imported)
+
+	bundle.Components.ChildrenNode = bundle.Components.ChildrenNode[:1]
+	_, err = service.Import(bundle, "", nil, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "button1", apps.imported.Components.ChildrenNode[0].DisplayName)
+}
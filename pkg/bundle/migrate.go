@@ -0,0 +1,86 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"fmt"
+)
+
+// migrations[n] upgrades a bundle of schema version n to n+1, so len(migrations) is SCHEMA_VERSION.
+var migrations = []func(raw map[string]interface{}) error{
+	migrateMegaData,
+}
+
+// Migrate upgrades the decoded bundle in place. A bundle without schema version is treated as version 0.
+func Migrate(raw map[string]interface{}) error {
+	version := 0
+	if v, ok := raw["schemaVersion"]; ok {
+		f, ok := v.(float64)
+		if !ok || f != float64(int(f)) || f < 0 {
+			return fmt.Errorf("%w: %v", ErrUnsupportedSchema, v)
+		}
+		version = int(f)
+	}
+	if version > SCHEMA_VERSION {
+		return fmt.Errorf("%w: %d is newer than %d", ErrUnsupportedSchema, version, SCHEMA_VERSION)
+	}
+	for ; version < SCHEMA_VERSION; version++ {
+		if err := migrations[version](raw); err != nil {
+			return err
+		}
+		raw["schemaVersion"] = float64(version + 1)
+	}
+	return nil
+}
+
+// migrateMegaData upgrades the editor mega data, saved before bundles existed, to schema version 1.
+// The resource ids of the actions are replaced by placeholders named after the action type.
+func migrateMegaData(raw map[string]interface{}) error {
+	appInfo, _ := raw["appInfo"].(map[string]interface{})
+	name, _ := appInfo["appName"].(string)
+	raw["app"] = map[string]interface{}{"name": name}
+	delete(raw, "appInfo")
+
+	resources := []interface{}{}
+	refs := map[int]string{}
+	actions, _ := raw["actions"].([]interface{})
+	for _, v := range actions {
+		action, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: malformed action", ErrInvalidBundle)
+		}
+		id, _ := action["resourceId"].(float64)
+		for _, field := range []string{"actionId", "resourceId", "createdAt", "createdBy", "updatedAt", "updatedBy"} {
+			delete(action, field)
+		}
+		if id == 0 {
+			continue
+		}
+		ref, ok := refs[int(id)]
+		if !ok {
+			ref = resourceRef(len(refs) + 1)
+			refs[int(id)] = ref
+			resources = append(resources, map[string]interface{}{
+				"ref":          ref,
+				"resourceName": "",
+				"resourceType": action["actionType"],
+				"content":      map[string]interface{}{},
+			})
+		}
+		action["resourceRef"] = ref
+	}
+	raw["resources"] = resources
+	return nil
+}
@@ -37,10 +37,15 @@ func GetConfig() (*Config, error) {
 	return cfg, err
 }
 
+// DSN returns the connection string of the database.
+func (cfg *Config) DSN() string {
+	return fmt.Sprintf("host='%s' user='%s' password='%s' dbname='%s' port='%s'",
+		cfg.Addr, cfg.User, cfg.Password, cfg.Database, cfg.Port)
+}
+
 func NewDbConnection(cfg *Config, logger *zap.SugaredLogger) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN: fmt.Sprintf("host='%s' user='%s' password='%s' dbname='%s' port='%s'",
-			cfg.Addr, cfg.User, cfg.Password, cfg.Database, cfg.Port),
+		DSN:                  cfg.DSN(),
 		PreferSimpleProtocol: true, // disables implicit prepared statement usage
 	}), &gorm.Config{})
 	sqlDB, err := db.DB()
@@ -0,0 +1,401 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const DISCOVERY_PATH = "/.well-known/openid-configuration"
+
+// the authorization request should be finished in this duration.
+const STATE_TOKEN_TTL = time.Minute * 10
+
+type Config struct {
+	Issuer               string `env:"ILLA_OIDC_ISSUER" envDefault:""`
+	ClientID             string `env:"ILLA_OIDC_CLIENT_ID" envDefault:""`
+	ClientSecret         string `env:"ILLA_OIDC_CLIENT_SECRET" envDefault:""`
+	RedirectURL          string `env:"ILLA_OIDC_REDIRECT_URL" envDefault:""`
+	Scopes               string `env:"ILLA_OIDC_SCOPES" envDefault:"openid email profile"`
+	RequireVerifiedEmail bool   `env:"ILLA_OIDC_REQUIRE_VERIFIED_EMAIL" envDefault:"true"`
+	Secret               string `env:"ILLA_SECRET_KEY"`
+}
+
+// Metadata is the OpenID Provider Metadata from the discovery document.
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type AuthorizationRequest struct {
+	AuthorizationURL string `json:"authorizationURL"`
+	StateToken       string `json:"stateToken"`
+}
+
+// StateClaims keeps the state, nonce and PKCE code verifier between the authorization request and the callback.
+type StateClaims struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+type IDTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+	AuthorizedBy  string `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type Provider struct {
+	config     *Config
+	httpClient *http.Client
+	mutex      sync.Mutex
+	metadata   *Metadata
+	keys       map[string]*rsa.PublicKey
+}
+
+func GetConfig() (*Config, error) {
+	cfg := &Config{}
+	err := env.Parse(cfg)
+	return cfg, err
+}
+
+func NewProvider(cfg *Config) *Provider {
+	return &Provider{
+		config:     cfg,
+		httpClient: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// Enabled reports if the OIDC sign in is configured.
+func (p *Provider) Enabled() bool {
+	return p.config.Issuer != "" && p.config.ClientID != "" && p.config.RedirectURL != ""
+}
+
+func (p *Provider) RequireVerifiedEmail() bool {
+	return p.config.RequireVerifiedEmail
+}
+
+// Discover fetches the provider metadata, the result is cached after the first success.
+func (p *Provider) Discover() (*Metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	if !p.Enabled() {
+		return nil, errors.New("OIDC sign in is not configured")
+	}
+	var metadata Metadata
+	if err := p.getJSON(strings.TrimSuffix(p.config.Issuer, "/")+DISCOVERY_PATH, &metadata); err != nil {
+		return nil, err
+	}
+	// the issuer in metadata must be identical to the configured one
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, errors.New("OIDC issuer mismatch: " + metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OIDC provider metadata incomplete")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// NewAuthorizationRequest builds the authorization URL with PKCE, the returned state token
+// should be sent back with the authorization code.
+func (p *Provider) NewAuthorizationRequest() (AuthorizationRequest, error) {
+	metadata, err := p.Discover()
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+	nonce, err := randomString(16)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+	codeVerifier, err := randomString(32)
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+
+	claims := &StateClaims{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "ILLA",
+			ExpiresAt: &jwt.NumericDate{
+				Time: time.Now().Add(STATE_TOKEN_TTL),
+			},
+		},
+	}
+	stateToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(p.config.Secret))
+	if err != nil {
+		return AuthorizationRequest{}, err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", p.config.Scopes)
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallengeS256(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return AuthorizationRequest{
+		AuthorizationURL: metadata.AuthorizationEndpoint + separator + query.Encode(),
+		StateToken:       stateToken,
+	}, nil
+}
+
+// Exchange redeems the authorization code and returns the validated ID token claims.
+func (p *Provider) Exchange(code, state, stateToken string) (*IDTokenClaims, error) {
+	metadata, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	stateClaims := &StateClaims{}
+	if _, err := jwt.ParseWithClaims(stateToken, stateClaims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(p.config.Secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()})); err != nil {
+		return nil, errors.New("invalid state token")
+	}
+	if stateClaims.State != state {
+		return nil, errors.New("OIDC state mismatch")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", stateClaims.CodeVerifier)
+	form.Set("client_id", p.config.ClientID)
+	useBasicAuth := p.config.ClientSecret != "" && !onlySupportsClientSecretPost(metadata.TokenEndpointAuthMethodsSupported)
+	if p.config.ClientSecret != "" && !useBasicAuth {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasicAuth {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("OIDC token response error: %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("OIDC token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("OIDC token response has no id_token")
+	}
+	return p.VerifyIDToken(token.IDToken, stateClaims.Nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of the ID token.
+func (p *Provider) VerifyIDToken(rawIDToken, nonce string) (*IDTokenClaims, error) {
+	metadata, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	claims := &IDTokenClaims{}
+	if _, err := jwt.ParseWithClaims(rawIDToken, claims, p.lookupKey, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"})); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, errors.New("ID token issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.New("ID token audience mismatch")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID {
+		return nil, errors.New("ID token authorized party mismatch")
+	}
+	if !claims.VerifyExpiresAt(time.Now(), true) {
+		return nil, errors.New("ID token expired")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func (p *Provider) lookupKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	// the provider may rotated keys, refetch once
+	if err := p.fetchKeys(); err != nil {
+		return nil, err
+	}
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("ID token signing key not found: " + kid)
+}
+
+func (p *Provider) cachedKey(kid string) *rsa.PublicKey {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *Provider) fetchKeys() error {
+	metadata, err := p.Discover()
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(metadata.JWKSURI, &jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *Provider) getJSON(target string, v interface{}) error {
+	resp, err := p.httpClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s failed: %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func (jwk jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func onlySupportsClientSecretPost(methods []string) bool {
+	if len(methods) == 0 {
+		return false
+	}
+	for _, method := range methods {
+		if method == "client_secret_basic" {
+			return false
+		}
+	}
+	return true
+}
+
+func codeChallengeS256(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
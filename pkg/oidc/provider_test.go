@@ -0,0 +1,194 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockIDP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	email     string
+	verified  bool
+}
+
+func newMockIDP(t *testing.T) *mockIDP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	idp := &mockIDP{key: key, email: "alice@example.com", verified: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DISCOVERY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(Metadata{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kty: "RSA",
+				Kid: "test-key",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		digest := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "test-code" || base64.RawURLEncoding.EncodeToString(digest[:]) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(tokenResponse{
+			AccessToken: "mock-access-token",
+			TokenType:   "Bearer",
+			IDToken:     idp.signIDToken(t, idp.nonce, "client"),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIDP) signIDToken(t *testing.T, nonce, audience string) string {
+	verified := idp.verified
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &IDTokenClaims{
+		Email:         idp.email,
+		EmailVerified: &verified,
+		Name:          "Alice",
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.server.URL,
+			Subject:   "alice",
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(idp.key)
+	assert.Nil(t, err)
+	return signed
+}
+
+func newTestProvider(idp *mockIDP) *Provider {
+	return NewProvider(&Config{
+		Issuer:               idp.server.URL,
+		ClientID:             "client",
+		ClientSecret:         "secret",
+		RedirectURL:          "http://localhost/callback",
+		Scopes:               "openid email profile",
+		RequireVerifiedEmail: true,
+		Secret:               "test-secret",
+	})
+}
+
+// authorize simulates the browser redirect, the IdP remembers the challenge and nonce of the request.
+func authorize(t *testing.T, provider *Provider, idp *mockIDP) (string, string) {
+	authorizationRequest, err := provider.NewAuthorizationRequest()
+	assert.Nil(t, err)
+	authorizationURL, err := url.Parse(authorizationRequest.AuthorizationURL)
+	assert.Nil(t, err)
+	query := authorizationURL.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Equal(t, "client", query.Get("client_id"))
+	idp.challenge = query.Get("code_challenge")
+	idp.nonce = query.Get("nonce")
+	return query.Get("state"), authorizationRequest.StateToken
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIDP(t)
+	defer idp.server.Close()
+	provider := newTestProvider(idp)
+
+	state, stateToken := authorize(t, provider, idp)
+	claims, err := provider.Exchange("test-code", state, stateToken)
+	assert.Nil(t, err)
+	if assert.NotNil(t, claims) {
+		assert.Equal(t, "alice@example.com", claims.Email)
+		assert.True(t, *claims.EmailVerified)
+	}
+}
+
+func TestExchangeStateMismatch(t *testing.T) {
+	idp := newMockIDP(t)
+	defer idp.server.Close()
+	provider := newTestProvider(idp)
+
+	_, stateToken := authorize(t, provider, idp)
+	_, err := provider.Exchange("test-code", "forged", stateToken)
+	assert.NotNil(t, err)
+}
+
+func TestExchangeInvalidCode(t *testing.T) {
+	idp := newMockIDP(t)
+	defer idp.server.Close()
+	provider := newTestProvider(idp)
+
+	state, stateToken := authorize(t, provider, idp)
+	_, err := provider.Exchange("wrong-code", state, stateToken)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newMockIDP(t)
+	defer idp.server.Close()
+	provider := newTestProvider(idp)
+
+	_, err := provider.VerifyIDToken(idp.signIDToken(t, "nonce", "client"), "nonce")
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(idp.signIDToken(t, "nonce", "client"), "other-nonce")
+	assert.NotNil(t, err)
+	_, err = provider.VerifyIDToken(idp.signIDToken(t, "nonce", "other-client"), "nonce")
+	assert.NotNil(t, err)
+
+	// token signed by an unknown key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, &IDTokenClaims{
+		Nonce: "nonce",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.server.URL,
+			Audience:  jwt.ClaimStrings{"client"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	forged.Header["kid"] = "test-key"
+	signed, err := forged.SignedString(otherKey)
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(signed, "nonce")
+	assert.NotNil(t, err)
+}
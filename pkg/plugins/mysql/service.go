@@ -93,7 +93,7 @@ func (m *MySQLConnector) Run(resourceOptions map[string]interface{}, actionOptio
 	}
 	// fetch data
 	if strings.HasPrefix(m.Action.Query, "SELECT") || strings.HasPrefix(m.Action.Query, "select") {
-		rows, err := db.Query(m.Action.Query)
+		rows, err := db.Query(m.Action.Query, m.Action.Args...)
 		if err != nil {
 			return queryResult, err
 		}
@@ -105,7 +105,7 @@ func (m *MySQLConnector) Run(resourceOptions map[string]interface{}, actionOptio
 		queryResult.Success = true
 		queryResult.Rows = mapRes
 	} else { // update, insert, delete data
-		execResult, err := db.Exec(m.Action.Query)
+		execResult, err := db.Exec(m.Action.Query, m.Action.Args...)
 		if err != nil {
 			return queryResult, err
 		}
@@ -44,4 +44,5 @@ type SSHOptions struct {
 type MySQLQuery struct {
 	Mode  string `validate:"required,oneof=gui sql"`
 	Query string
+	Args  []interface{} // bind arguments of the `?` placeholders in the query
 }
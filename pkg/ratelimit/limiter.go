@@ -0,0 +1,105 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"time"
+)
+
+// Limiter allows at most Limit requests of a key in every Window.
+type Limiter struct {
+	store  Store
+	name   string
+	limit  int
+	window time.Duration
+}
+
+func NewLimiter(store Store, name string, limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		store:  store,
+		name:   name,
+		limit:  limit,
+		window: window,
+	}
+}
+
+// Allow counts the request, returns false and the duration to wait when the limit exceeded.
+func (l *Limiter) Allow(key string) (bool, time.Duration, error) {
+	count, resetAt, err := l.store.Incr(l.name+":"+key, l.window)
+	if err != nil {
+		return false, 0, err
+	}
+	if count > l.limit {
+		return false, time.Until(resetAt), nil
+	}
+	return true, 0, nil
+}
+
+// AttemptCounter blocks a key after too many failures, e.g. the account lockout.
+type AttemptCounter struct {
+	store       Store
+	name        string
+	maxFailures int
+	window      time.Duration
+}
+
+func NewAttemptCounter(store Store, name string, maxFailures int, window time.Duration) *AttemptCounter {
+	return &AttemptCounter{
+		store:       store,
+		name:        name,
+		maxFailures: maxFailures,
+		window:      window,
+	}
+}
+
+// Blocked returns true and the duration to wait when the key reached the max failures.
+func (a *AttemptCounter) Blocked(key string) (bool, time.Duration, error) {
+	count, resetAt, err := a.store.Get(a.name + ":" + key)
+	if err != nil {
+		return false, 0, err
+	}
+	if count >= a.maxFailures {
+		return true, time.Until(resetAt), nil
+	}
+	return false, 0, nil
+}
+
+// Fail records a failure, the window starts from the first failure.
+func (a *AttemptCounter) Fail(key string) error {
+	_, _, err := a.store.Incr(a.name+":"+key, a.window)
+	return err
+}
+
+func (a *AttemptCounter) Reset(key string) error {
+	return a.store.Reset(a.name + ":" + key)
+}
+
+// RetryAfterSeconds formats the duration for the Retry-After header.
+func RetryAfterSeconds(d time.Duration) int {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// HashKey is used when the key is a secret, e.g. the verification token.
+func HashKey(secret string) string {
+	digest := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the request body is only peeked up to this size for the key.
+const MAX_PEEK_BODY_SIZE = 1 << 16
+
+// KeyFunc extracts the key of the request, the request is not limited when the key is empty.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP keys on the client IP, the forwarded headers are only honored if the engine trusts the proxy.
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser should be used after the auth middleware.
+func ByUser(c *gin.Context) string {
+	userID, ok := c.Get("userID")
+	if !ok {
+		return ""
+	}
+	id, ok := userID.(int)
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// ByJSONField reads the field of the json body, the body is restored for the handler.
+func ByJSONField(field string) KeyFunc {
+	return func(c *gin.Context) string {
+		if c.Request.Body == nil {
+			return ""
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, MAX_PEEK_BODY_SIZE))
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		if err != nil {
+			return ""
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ""
+		}
+		value, _ := payload[field].(string)
+		return NormalizeEmail(value)
+	}
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Limit rejects the request with 429 when the limiter of the key exceeded.
+func Limit(limiter *Limiter, keyFunc KeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := keyFunc(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+		allowed, retryAfter, err := limiter.Allow(key)
+		if err != nil {
+			// the store failure should not block the service
+			c.Next()
+			return
+		}
+		if !allowed {
+			AbortWithTooManyRequests(c, retryAfter)
+			return
+		}
+		c.Next()
+	}
+}
+
+func AbortWithTooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(RetryAfterSeconds(retryAfter)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"errorCode":    429,
+		"errorMessage": "too many requests, please try again later",
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEngine(store Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/signin", Limit(NewLimiter(store, "signin:email", 2, time.Minute), ByJSONField("email")),
+		func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			c.String(http.StatusOK, string(body))
+		})
+	return engine
+}
+
+func signIn(engine *gin.Engine, email string) *httptest.ResponseRecorder {
+	body := `{"email":"` + email + `","password":"secret"}`
+	req := httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestLimitByEmail(t *testing.T) {
+	engine := newTestEngine(NewMemoryStore())
+
+	for i := 0; i < 2; i++ {
+		recorder := signIn(engine, "alice@example.com")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		// the body is restored for the handler
+		assert.Contains(t, recorder.Body.String(), `"password":"secret"`)
+	}
+
+	// the email is normalized
+	recorder := signIn(engine, " Alice@Example.com")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+
+	// other emails are not affected
+	assert.Equal(t, http.StatusOK, signIn(engine, "bob@example.com").Code)
+}
+
+func TestLimitByIPIgnoresSpoofedHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	assert.Nil(t, engine.SetTrustedProxies([]string{"10.0.0.1"}))
+	engine.POST("/signin", Limit(NewLimiter(NewMemoryStore(), "signin:ip", 2, time.Minute), ByIP),
+		func(c *gin.Context) {
+			c.String(http.StatusOK, c.ClientIP())
+		})
+	signInFrom := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/signin", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// a new forwarded address on every request does not reset the limit of the client
+	for i := 0; i < 2; i++ {
+		recorder := signInFrom("192.0.2.7:40000", "198.51.100."+strconv.Itoa(i))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "192.0.2.7", recorder.Body.String())
+	}
+	assert.Equal(t, http.StatusTooManyRequests, signInFrom("192.0.2.7:40000", "198.51.100.9").Code)
+
+	// the trusted proxy forwards the address of the client
+	recorder := signInFrom("10.0.0.1:40000", "198.51.100.1")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "198.51.100.1", recorder.Body.String())
+}
+
+func TestMemoryStoreWindow(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	count, resetAt, err := store.Incr("key", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, now.Add(time.Minute), resetAt)
+	count, _, _ = store.Incr("key", time.Minute)
+	assert.Equal(t, 2, count)
+
+	// a new window starts after the counter expired
+	now = now.Add(time.Minute)
+	count, _, _ = store.Get("key")
+	assert.Equal(t, 0, count)
+	count, _, _ = store.Incr("key", time.Minute)
+	assert.Equal(t, 1, count)
+}
+
+func TestAttemptCounter(t *testing.T) {
+	counter := NewAttemptCounter(NewMemoryStore(), "signin", 3, time.Minute)
+	for i := 0; i < 3; i++ {
+		blocked, _, err := counter.Blocked("alice@example.com")
+		assert.Nil(t, err)
+		assert.False(t, blocked)
+		assert.Nil(t, counter.Fail("alice@example.com"))
+	}
+	blocked, retryAfter, err := counter.Blocked("alice@example.com")
+	assert.Nil(t, err)
+	assert.True(t, blocked)
+	assert.True(t, retryAfter > 0 && retryAfter <= time.Minute)
+
+	assert.Nil(t, counter.Reset("alice@example.com"))
+	blocked, _, _ = counter.Blocked("alice@example.com")
+	assert.False(t, blocked)
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store counts the hits of a key in a fixed window, it can be replaced by a shared store
+// when the server runs on multiple nodes.
+type Store interface {
+	// Incr increases the counter of the key, a new window starts when the counter is expired.
+	Incr(key string, window time.Duration) (int, time.Time, error)
+	// Get returns the counter of the key, zero when not found or expired.
+	Get(key string) (int, time.Time, error)
+	Reset(key string) error
+}
+
+// the expired counters are swept in this interval.
+const SWEEP_INTERVAL = time.Minute
+
+type counter struct {
+	count   int
+	resetAt time.Time
+}
+
+type MemoryStore struct {
+	mutex     sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Incr(key string, window time.Duration) (int, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	s.sweep(now)
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, c.resetAt, nil
+}
+
+func (s *MemoryStore) Get(key string) (int, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c, ok := s.counters[key]
+	if !ok || !s.now().Before(c.resetAt) {
+		return 0, time.Time{}, nil
+	}
+	return c.count, c.resetAt, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < SWEEP_INTERVAL {
+		return
+	}
+	s.lastSweep = now
+	for key, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+}
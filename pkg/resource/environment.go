@@ -0,0 +1,100 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+)
+
+type ResourceEnvironmentDto struct {
+	ResourceID  int                    `json:"resourceId"`
+	Environment string                 `json:"environment"`
+	Options     map[string]interface{} `json:"content" validate:"required"`
+	UpdatedAt   time.Time              `json:"updatedAt,omitempty"`
+	UpdatedBy   int                    `json:"updatedBy,omitempty"`
+}
+
+func (dto *ResourceEnvironmentDto) ConstructByRecord(env *repository.ResourceEnvironment) {
+	dto.ResourceID = env.ResourceRefID
+	dto.Environment = env.Environment
+	dto.Options = env.Options
+	dto.UpdatedAt = env.UpdatedAt
+	dto.UpdatedBy = env.UpdatedBy
+}
+
+var ErrEnvironmentNotFound = errors.New("resource environment not found")
+
+func (impl *ResourceServiceImpl) GetResourceEnvironments(resourceID int) ([]ResourceEnvironmentDto, error) {
+	envs, err := impl.resourceEnvironmentRepository.RetrieveByResource(resourceID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ResourceEnvironmentDto, 0, len(envs))
+	for _, env := range envs {
+		envDto := ResourceEnvironmentDto{}
+		envDto.ConstructByRecord(env)
+		res = append(res, envDto)
+	}
+	return res, nil
+}
+
+// SetResourceEnvironment overrides some options of the resource in the environment,
+// the options after the override must still be valid for the resource type.
+func (impl *ResourceServiceImpl) SetResourceEnvironment(env ResourceEnvironmentDto) (ResourceEnvironmentDto, error) {
+	if !repository.IsValidChannel(env.Environment) {
+		return ResourceEnvironmentDto{}, fmt.Errorf("unknown environment %s", env.Environment)
+	}
+	rsc, err := impl.resourceRepository.RetrieveByID(env.ResourceID)
+	if err != nil {
+		return ResourceEnvironmentDto{}, err
+	}
+	record := &repository.ResourceEnvironment{
+		ResourceRefID: env.ResourceID,
+		Environment:   env.Environment,
+		Options:       env.Options,
+		CreatedAt:     time.Now().UTC(),
+		CreatedBy:     env.UpdatedBy,
+		UpdatedAt:     time.Now().UTC(),
+		UpdatedBy:     env.UpdatedBy,
+	}
+	// the stored type is an index of type_array starting from 1
+	if rsc.Type < 1 || rsc.Type > len(type_array) {
+		return ResourceEnvironmentDto{}, fmt.Errorf("unknown resource type %d", rsc.Type)
+	}
+	if err := impl.ValidateResourceOptions(type_array[rsc.Type-1], record.Override(rsc.Options)); err != nil {
+		return ResourceEnvironmentDto{}, err
+	}
+	if err := impl.resourceEnvironmentRepository.Upsert(record); err != nil {
+		return ResourceEnvironmentDto{}, err
+	}
+	res := ResourceEnvironmentDto{}
+	res.ConstructByRecord(record)
+	return res, nil
+}
+
+func (impl *ResourceServiceImpl) DeleteResourceEnvironment(resourceID int, environment string) error {
+	deleted, err := impl.resourceEnvironmentRepository.Delete(resourceID, environment)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrEnvironmentNotFound
+	}
+	return nil
+}
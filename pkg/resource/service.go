@@ -43,6 +43,9 @@ type ResourceService interface {
 	FindAllResources() ([]ResourceDto, error)
 	TestConnection(resource ResourceDto) (bool, error)
 	ValidateResourceOptions(resourceType string, options map[string]interface{}) error
+	GetResourceEnvironments(resourceID int) ([]ResourceEnvironmentDto, error)
+	SetResourceEnvironment(env ResourceEnvironmentDto) (ResourceEnvironmentDto, error)
+	DeleteResourceEnvironment(resourceID int, environment string) error
 }
 
 type ResourceDto struct {
@@ -78,14 +81,17 @@ func (resourced *ResourceDto) ConstructByMap(data interface{}) {
 }
 
 type ResourceServiceImpl struct {
-	logger             *zap.SugaredLogger
-	resourceRepository repository.ResourceRepository
+	logger                        *zap.SugaredLogger
+	resourceRepository            repository.ResourceRepository
+	resourceEnvironmentRepository repository.ResourceEnvironmentRepository
 }
 
-func NewResourceServiceImpl(logger *zap.SugaredLogger, resourceRepository repository.ResourceRepository) *ResourceServiceImpl {
+func NewResourceServiceImpl(logger *zap.SugaredLogger, resourceRepository repository.ResourceRepository,
+	resourceEnvironmentRepository repository.ResourceEnvironmentRepository) *ResourceServiceImpl {
 	return &ResourceServiceImpl{
-		logger:             logger,
-		resourceRepository: resourceRepository,
+		logger:                        logger,
+		resourceRepository:            resourceRepository,
+		resourceEnvironmentRepository: resourceEnvironmentRepository,
 	}
 }
 
@@ -107,6 +113,9 @@ func (impl *ResourceServiceImpl) CreateResource(resource ResourceDto) (ResourceD
 }
 
 func (impl *ResourceServiceImpl) DeleteResource(id int) error {
+	if err := impl.resourceEnvironmentRepository.DeleteByResource(id); err != nil {
+		return err
+	}
 	if err := impl.resourceRepository.Delete(id); err != nil {
 		return err
 	}
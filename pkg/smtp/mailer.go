@@ -0,0 +1,136 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	TRANSPORT_SMTP = "smtp"
+	TRANSPORT_FILE = "file"
+	TRANSPORT_LOG  = "log"
+
+	SECURITY_TLS      = "tls"
+	SECURITY_STARTTLS = "starttls"
+	SECURITY_NONE     = "none"
+)
+
+// Mailer delivers an encoded message.
+type Mailer interface {
+	Send(from, to string, msg []byte) error
+}
+
+func NewMailer(cfg *Config, logger *zap.SugaredLogger) (Mailer, error) {
+	switch cfg.Transport {
+	case TRANSPORT_SMTP:
+		return &SMTPMailer{
+			Host:     cfg.Host,
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			Security: cfg.Security,
+		}, nil
+	case TRANSPORT_FILE:
+		return &FileMailer{Dir: cfg.Dir}, nil
+	case TRANSPORT_LOG:
+		return &LogMailer{logger: logger}, nil
+	default:
+		return nil, errors.New("unsupported mail transport: " + cfg.Transport)
+	}
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Security string
+}
+
+func (m *SMTPMailer) Send(from, to string, msg []byte) error {
+	addr := m.Host + ":" + m.Port
+	auth := smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	switch m.Security {
+	case SECURITY_TLS:
+		return SendMailUsingTLS(addr, auth, from, to, msg)
+	case SECURITY_STARTTLS:
+		return SendMailUsingSTARTTLS(addr, auth, from, to, msg)
+	case SECURITY_NONE:
+		return SendMail(addr, auth, from, to, msg)
+	default:
+		return errors.New("unsupported mail security: " + m.Security)
+	}
+}
+
+// FileMailer writes every message to an eml file, it is used for local development.
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(from, to string, msg []byte) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitizeFileName(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), msg, 0o644)
+}
+
+// LogMailer prints every message to the log, it is used for local development.
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+func (m *LogMailer) Send(from, to string, msg []byte) error {
+	m.logger.Infow("mail", "from", from, "to", to, "message", string(msg))
+	return nil
+}
+
+func sanitizeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// SendMailUsingSTARTTLS upgrades the plain connection, the mail is never sent without TLS.
+func SendMailUsingSTARTTLS(addr string, auth smtp.Auth, from string, to string, msg []byte) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		c.Close()
+		return errors.New("smtp server does not support STARTTLS")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+		c.Close()
+		return err
+	}
+	return send(c, auth, from, to, msg)
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	FromName string
+	From     string
+	To       string
+	Subject  string
+	Text     string
+	HTML     string
+	Date     time.Time
+}
+
+func NewMessage(fromName, from, to string, rendered RenderedMail) *Message {
+	return &Message{
+		FromName: fromName,
+		From:     from,
+		To:       to,
+		Subject:  rendered.Subject,
+		Text:     rendered.Text,
+		HTML:     rendered.HTML,
+		Date:     time.Now(),
+	}
+}
+
+// Bytes encodes the message as multipart/alternative, the headers are written in a fixed order.
+func (m *Message) Bytes() ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writePart(writer, "text/plain; charset=utf-8", m.Text); err != nil {
+		return nil, err
+	}
+	if err := writePart(writer, "text/html; charset=utf-8", m.HTML); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID(m.From)
+	if err != nil {
+		return nil, err
+	}
+	from := mail.Address{Name: m.FromName, Address: m.From}
+	headers := [][2]string{
+		{"From", from.String()},
+		{"To", m.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", m.Date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=\"" + writer.Boundary() + "\""},
+	}
+	var msg bytes.Buffer
+	for _, header := range headers {
+		msg.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func writePart(writer *multipart.Writer, contentType, content string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "illa.local"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+const (
+	OUTBOX_POLL_INTERVAL = time.Second * 2
+	OUTBOX_BATCH_SIZE    = 20
+	// a claimed mail is retried by another worker when not finished in this duration
+	OUTBOX_LEASE        = time.Minute
+	OUTBOX_MAX_ATTEMPTS = 8
+	OUTBOX_BASE_BACKOFF = time.Second * 30
+	OUTBOX_MAX_BACKOFF  = time.Hour
+)
+
+// OutboxWorker delivers the queued mails in background and retries the failures with backoff.
+type OutboxWorker struct {
+	logger           *zap.SugaredLogger
+	outboxRepository repository.MailOutboxRepository
+	mailer           Mailer
+	stop             chan struct{}
+}
+
+func NewOutboxWorker(logger *zap.SugaredLogger, outboxRepository repository.MailOutboxRepository, mailer Mailer) *OutboxWorker {
+	return &OutboxWorker{
+		logger:           logger,
+		outboxRepository: outboxRepository,
+		mailer:           mailer,
+		stop:             make(chan struct{}),
+	}
+}
+
+// Run polls the outbox until Stop is called.
+func (w *OutboxWorker) Run() {
+	ticker := time.NewTicker(OUTBOX_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.DeliverDue(time.Now().UTC())
+		}
+	}
+}
+
+func (w *OutboxWorker) Stop() {
+	close(w.stop)
+}
+
+// DeliverDue delivers one batch of the due mails, returns the number of delivered mails.
+func (w *OutboxWorker) DeliverDue(now time.Time) int {
+	mails, err := w.outboxRepository.RetrieveDue(now, OUTBOX_BATCH_SIZE)
+	if err != nil {
+		w.logger.Errorw("retrieve mail outbox error", "err", err)
+		return 0
+	}
+	delivered := 0
+	for _, mail := range mails {
+		claimed, err := w.outboxRepository.Claim(mail.ID, now, now.Add(OUTBOX_LEASE))
+		if err != nil {
+			w.logger.Errorw("claim mail error", "err", err, "mail", mail.ID)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if w.deliver(mail, now) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+func (w *OutboxWorker) deliver(mail *repository.MailOutbox, now time.Time) bool {
+	sendErr := w.mailer.Send(mail.Sender, mail.Recipient, []byte(mail.Message))
+	if sendErr == nil {
+		if err := w.outboxRepository.MarkSent(mail.ID, now); err != nil {
+			w.logger.Errorw("mark mail sent error", "err", err, "mail", mail.ID)
+		}
+		return true
+	}
+
+	attempts := mail.Attempts + 1
+	w.logger.Warnw("send mail error", "err", sendErr, "mail", mail.ID, "attempts", attempts)
+	if attempts >= OUTBOX_MAX_ATTEMPTS {
+		if err := w.outboxRepository.MarkFailed(mail.ID, attempts, sendErr.Error()); err != nil {
+			w.logger.Errorw("mark mail failed error", "err", err, "mail", mail.ID)
+		}
+		return false
+	}
+	if err := w.outboxRepository.MarkRetry(mail.ID, attempts, now.Add(Backoff(attempts)), sendErr.Error()); err != nil {
+		w.logger.Errorw("mark mail retry error", "err", err, "mail", mail.ID)
+	}
+	return false
+}
+
+// Backoff returns the delay before the next attempt, it doubles after every failure.
+func Backoff(attempts int) time.Duration {
+	backoff := OUTBOX_BASE_BACKOFF
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= OUTBOX_MAX_BACKOFF {
+			return OUTBOX_MAX_BACKOFF
+		}
+	}
+	return backoff
+}
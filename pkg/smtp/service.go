@@ -15,15 +15,18 @@
 package smtp
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"net"
 	"net/smtp"
 	"strings"
 	"time"
 
+	"github.com/illa-family/builder-backend/internal/repository"
+
 	"github.com/caarlos0/env"
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -38,17 +41,28 @@ type Config struct {
 	Password string `env:"ILLA_MAIL_PASSWORD" envDefault:"ESXNALKGBIAZCSYO"`
 	Host     string `env:"ILLA_MAIL_HOST" envDefault:"smtp.163.com"`
 	Port     string `env:"ILLA_MAIL_PORT" envDefault:"465"`
-	Secret   string `env:"ILLA_SECRET_KEY" envDefault:"ausNV5NJfVCrz3tPXtW2ZGGCpUuWFVQbikZ6d7FyOfpw9RcyLiNpqx4pJ6fSX9JXhMfmIupKKjQElURR"`
+	// tls for implicit TLS, starttls for upgrading a plain connection, none for a local relay
+	Security string `env:"ILLA_MAIL_SECURITY" envDefault:"tls"`
+	// smtp, or file and log for local development
+	Transport string `env:"ILLA_MAIL_TRANSPORT" envDefault:"smtp"`
+	Dir       string `env:"ILLA_MAIL_DIR" envDefault:"./mails"`
+	WebURL    string `env:"ILLA_WEB_URL" envDefault:"http://localhost:3000"`
+	Secret    string `env:"ILLA_SECRET_KEY" envDefault:"ausNV5NJfVCrz3tPXtW2ZGGCpUuWFVQbikZ6d7FyOfpw9RcyLiNpqx4pJ6fSX9JXhMfmIupKKjQElURR"`
 }
 
+// SMTPServer renders the mails and puts them into the outbox, the outbox worker delivers them.
 type SMTPServer struct {
-	From     string
-	Password string
-	Host     string
-	Port     string
-	Secret   string
+	From             string
+	WebURL           string
+	Secret           string
+	outboxRepository repository.MailOutboxRepository
 }
 
+// the verification code expires in this duration.
+const VERIFICATION_CODE_TTL = time.Minute * 5
+
+const MAIL_FROM_NAME = "ILLA Builder"
+
 type VCodeClaims struct {
 	Email string `json:"email"`
 	Code  string `json:"code"`
@@ -62,37 +76,27 @@ func GetConfig() (*Config, error) {
 	return cfg, err
 }
 
-func NewSMTPServer(cfg *Config) SMTPServer {
+func NewSMTPServer(cfg *Config, outboxRepository repository.MailOutboxRepository) SMTPServer {
 	return SMTPServer{
-		From:     cfg.Username,
-		Password: cfg.Password,
-		Host:     cfg.Host,
-		Port:     cfg.Port,
-		Secret:   cfg.Secret,
+		From:             cfg.Username,
+		WebURL:           strings.TrimSuffix(cfg.WebURL, "/"),
+		Secret:           cfg.Secret,
+		outboxRepository: outboxRepository,
 	}
 }
 
-func (s *SMTPServer) NewVerificationCode(email, usage string) (string, error) {
-	// Authentication.
-	auth := smtp.PlainAuth("", s.From, s.Password, s.Host)
-
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	vCode := fmt.Sprintf("%06v", rnd.Int31n(1000000))
-
-	header := make(map[string]string)
-	header["From"] = "illa-builder" + "<" + s.From + ">"
-	header["To"] = email
-	header["Subject"] = "[ILLA]: Verification Code"
-	body := "Verification Code: " + vCode
-	message := ""
-	for k, v := range header {
-		message += fmt.Sprintf("%s:%s\r\n", k, v)
+func (s *SMTPServer) NewVerificationCode(email, usage, language string) (string, error) {
+	// the code must not be predictable
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
 	}
-	message += "\r\n" + body
+	vCode := fmt.Sprintf("%06d", n.Int64())
 
-	// Sending email.
-	err := SendMailUsingTLS(s.Host+":"+s.Port, auth, s.From, email, []byte(message))
-	if err != nil {
+	if err := s.Send(email, MAIL_VERIFICATION, language, map[string]interface{}{
+		"Code":      vCode,
+		"ExpiresIn": int(VERIFICATION_CODE_TTL.Minutes()),
+	}); err != nil {
 		return "", err
 	}
 
@@ -103,7 +107,7 @@ func (s *SMTPServer) NewVerificationCode(email, usage string) (string, error) {
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer: "ILLA",
 			ExpiresAt: &jwt.NumericDate{
-				Time: time.Now().Add(time.Minute * 5),
+				Time: time.Now().Add(VERIFICATION_CODE_TTL),
 			},
 		},
 	}
@@ -135,11 +139,77 @@ func (s *SMTPServer) ValidateVerificationCode(codeToken, vCode, email, usage str
 	return true, nil
 }
 
+func (s *SMTPServer) SendWelcome(email, language, nickname string) error {
+	return s.Send(email, MAIL_WELCOME, language, map[string]interface{}{
+		"Nickname": nickname,
+	})
+}
+
+func (s *SMTPServer) SendPasswordChanged(email, language, nickname string, changedAt time.Time) error {
+	return s.Send(email, MAIL_PASSWORD_CHANGED, language, map[string]interface{}{
+		"Nickname":  nickname,
+		"ChangedAt": changedAt.UTC().Format("2006-01-02 15:04:05 MST"),
+	})
+}
+
+func (s *SMTPServer) SendAppInvitation(email, language, inviter, appName string, appID int) error {
+	return s.Send(email, MAIL_APP_INVITATION, language, map[string]interface{}{
+		"Inviter": inviter,
+		"AppName": appName,
+		"Link":    fmt.Sprintf("%s/app/%d", s.WebURL, appID),
+	})
+}
+
+func (s *SMTPServer) SendAppDeployed(email, language, nickname, appName string, appID, version int) error {
+	return s.Send(email, MAIL_APP_DEPLOYED, language, map[string]interface{}{
+		"Nickname": nickname,
+		"AppName":  appName,
+		"Version":  version,
+		"Link":     fmt.Sprintf("%s/deploy/app/%d", s.WebURL, appID),
+	})
+}
+
+// Send renders the mail in the language and puts it into the outbox.
+func (s *SMTPServer) Send(email, kind, language string, data interface{}) error {
+	rendered, err := Render(kind, language, data)
+	if err != nil {
+		return err
+	}
+	message, err := NewMessage(MAIL_FROM_NAME, s.From, email, rendered).Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = s.outboxRepository.Create(&repository.MailOutbox{
+		Sender:        s.From,
+		Recipient:     email,
+		Kind:          kind,
+		Message:       string(message),
+		Status:        repository.MAIL_STATUS_PENDING,
+		NextAttemptAt: time.Now().UTC(),
+		CreatedAt:     time.Now().UTC(),
+		UpdatedAt:     time.Now().UTC(),
+	})
+	return err
+}
+
+// SendMail delivers the mail without TLS, it is used for local relays.
+func SendMail(addr string, auth smtp.Auth, from string, to string, msg []byte) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	return send(c, auth, from, to, msg)
+}
+
 func SendMailUsingTLS(addr string, auth smtp.Auth, from string, to string, msg []byte) (err error) {
 	c, err := Dial(addr)
 	if err != nil {
 		return err
 	}
+	return send(c, auth, from, to, msg)
+}
+
+func send(c *smtp.Client, auth smtp.Auth, from string, to string, msg []byte) (err error) {
 	defer c.Close()
 	if auth != nil {
 		if ok, _ := c.Extension("AUTH"); ok {
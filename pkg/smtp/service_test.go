@@ -0,0 +1,303 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// startSink starts a local SMTP server which accepts one mail and sends the data to the channel.
+func startSink(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	received := make(chan string, 1)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP sink")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "DATA"):
+				reply("354 end data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				received <- data.String()
+				reply("250 OK")
+			case strings.HasPrefix(command, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func readParts(t *testing.T, raw string) (*mail.Message, map[string]string) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	assert.Nil(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		// the multipart reader decodes quoted-printable and removes the header
+		body, err := io.ReadAll(part)
+		assert.Nil(t, err)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	return msg, parts
+}
+
+// memoryOutbox is an in-memory MailOutboxRepository.
+type memoryOutbox struct {
+	mutex sync.Mutex
+	mails []*repository.MailOutbox
+}
+
+func (o *memoryOutbox) Create(mail *repository.MailOutbox) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	mail.ID = len(o.mails) + 1
+	o.mails = append(o.mails, mail)
+	return mail.ID, nil
+}
+
+func (o *memoryOutbox) RetrieveDue(now time.Time, limit int) ([]*repository.MailOutbox, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	due := make([]*repository.MailOutbox, 0)
+	for _, mail := range o.mails {
+		if mail.Status == repository.MAIL_STATUS_PENDING && !mail.NextAttemptAt.After(now) && len(due) < limit {
+			copied := *mail
+			due = append(due, &copied)
+		}
+	}
+	return due, nil
+}
+
+func (o *memoryOutbox) Claim(id int, now, leaseUntil time.Time) (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	mail := o.mails[id-1]
+	if mail.Status != repository.MAIL_STATUS_PENDING || mail.NextAttemptAt.After(now) {
+		return false, nil
+	}
+	mail.NextAttemptAt = leaseUntil
+	return true, nil
+}
+
+func (o *memoryOutbox) MarkSent(id int, sentAt time.Time) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.mails[id-1].Status = repository.MAIL_STATUS_SENT
+	o.mails[id-1].SentAt = &sentAt
+	return nil
+}
+
+func (o *memoryOutbox) MarkRetry(id, attempts int, nextAttemptAt time.Time, lastError string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.mails[id-1].Attempts = attempts
+	o.mails[id-1].NextAttemptAt = nextAttemptAt
+	o.mails[id-1].LastError = lastError
+	return nil
+}
+
+func (o *memoryOutbox) MarkFailed(id, attempts int, lastError string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.mails[id-1].Status = repository.MAIL_STATUS_FAILED
+	o.mails[id-1].Attempts = attempts
+	o.mails[id-1].LastError = lastError
+	return nil
+}
+
+type failingMailer struct{}
+
+func (m failingMailer) Send(from, to string, msg []byte) error {
+	return errors.New("connection refused")
+}
+
+func TestSendVerificationCode(t *testing.T) {
+	addr, received := startSink(t)
+	host, port, _ := net.SplitHostPort(addr)
+	cfg := &Config{
+		Username:  "noreply@illa.local",
+		Host:      host,
+		Port:      port,
+		Security:  SECURITY_NONE,
+		Transport: TRANSPORT_SMTP,
+		Secret:    "test-secret",
+	}
+	outbox := &memoryOutbox{}
+	server := NewSMTPServer(cfg, outbox)
+
+	// the mail is queued without connecting to the smtp server
+	vToken, err := server.NewVerificationCode("alice@example.com", USAGE_SIGNUP, "zh-CN")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, vToken)
+	assert.Len(t, outbox.mails, 1)
+
+	mailer, err := NewMailer(cfg, zap.NewNop().Sugar())
+	assert.Nil(t, err)
+	worker := NewOutboxWorker(zap.NewNop().Sugar(), outbox, mailer)
+	assert.Equal(t, 1, worker.DeliverDue(time.Now().UTC()))
+	assert.Equal(t, repository.MAIL_STATUS_SENT, outbox.mails[0].Status)
+
+	msg, parts := readParts(t, <-received)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, "[ILLA] 您的验证码", subject)
+	assert.Equal(t, "alice@example.com", msg.Header.Get("To"))
+	assert.Contains(t, msg.Header.Get("From"), "<noreply@illa.local>")
+	assert.NotEmpty(t, msg.Header.Get("Message-ID"))
+	assert.Contains(t, parts["text/plain"], "您的验证码是")
+	assert.Contains(t, parts["text/html"], "<strong")
+}
+
+func TestOutboxRetry(t *testing.T) {
+	outbox := &memoryOutbox{}
+	server := NewSMTPServer(&Config{Username: "noreply@illa.local"}, outbox)
+	assert.Nil(t, server.SendWelcome("alice@example.com", "en-US", "alice"))
+
+	worker := NewOutboxWorker(zap.NewNop().Sugar(), outbox, failingMailer{})
+	now := time.Now().UTC()
+	assert.Equal(t, 0, worker.DeliverDue(now))
+	assert.Equal(t, 1, outbox.mails[0].Attempts)
+	assert.Equal(t, now.Add(OUTBOX_BASE_BACKOFF), outbox.mails[0].NextAttemptAt)
+
+	// not due before the backoff elapsed
+	assert.Equal(t, 0, worker.DeliverDue(now.Add(time.Second)))
+	assert.Equal(t, 1, outbox.mails[0].Attempts)
+
+	for i := 1; i < OUTBOX_MAX_ATTEMPTS; i++ {
+		now = outbox.mails[0].NextAttemptAt
+		worker.DeliverDue(now)
+	}
+	assert.Equal(t, repository.MAIL_STATUS_FAILED, outbox.mails[0].Status)
+	assert.Equal(t, OUTBOX_MAX_ATTEMPTS, outbox.mails[0].Attempts)
+	assert.Equal(t, "connection refused", outbox.mails[0].LastError)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, OUTBOX_BASE_BACKOFF, Backoff(1))
+	assert.Equal(t, OUTBOX_BASE_BACKOFF*4, Backoff(3))
+	assert.Equal(t, OUTBOX_MAX_BACKOFF, Backoff(20))
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewMailer(&Config{Transport: TRANSPORT_FILE, Dir: dir}, zap.NewNop().Sugar())
+	assert.Nil(t, err)
+	assert.Nil(t, mailer.Send("noreply@illa.local", "alice@example.com", []byte("Subject: hi\r\n\r\nhello")))
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRender(t *testing.T) {
+	kinds := []string{MAIL_VERIFICATION, MAIL_WELCOME, MAIL_PASSWORD_CHANGED, MAIL_APP_INVITATION, MAIL_APP_DEPLOYED}
+	data := map[string]interface{}{
+		"Code":      "123456",
+		"ExpiresIn": 5,
+		"Nickname":  "<b>alice</b>",
+		"ChangedAt": "2022-07-01 00:00:00 UTC",
+		"Inviter":   "bob",
+		"AppName":   "dashboard",
+		"Version":   3,
+		"Link":      "http://localhost:3000/app/1",
+	}
+	for _, language := range []string{"en-US", "zh-CN"} {
+		for _, kind := range kinds {
+			rendered, err := Render(kind, language, data)
+			assert.Nil(t, err, kind+" "+language)
+			assert.NotEmpty(t, rendered.Subject)
+			assert.NotContains(t, rendered.Subject, "\n")
+			assert.NotEmpty(t, rendered.Text)
+			assert.NotContains(t, rendered.HTML, "<b>alice</b>")
+		}
+	}
+
+	// unknown language falls back to english
+	rendered, err := Render(MAIL_WELCOME, "fr-FR", data)
+	assert.Nil(t, err)
+	assert.Contains(t, rendered.Subject, "Welcome")
+}
+
+func TestMessageBytes(t *testing.T) {
+	rendered := RenderedMail{Subject: "Hello", Text: "line = one\n", HTML: "<p>line = one</p>"}
+	raw, err := NewMessage("ILLA Builder", "noreply@illa.local", "alice@example.com", rendered).Bytes()
+	assert.Nil(t, err)
+	headers := strings.SplitN(string(raw), "\r\n\r\n", 2)[0]
+	names := make([]string, 0)
+	for _, line := range strings.Split(headers, "\r\n") {
+		names = append(names, strings.SplitN(line, ":", 2)[0])
+	}
+	assert.Equal(t, []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}, names)
+	assert.Contains(t, string(raw), "line =3D one")
+
+	_, parts := readParts(t, string(raw))
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader("line =3D one")))
+	assert.Nil(t, err)
+	assert.Contains(t, parts["text/plain"], string(decoded))
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	MAIL_VERIFICATION     = "verification"
+	MAIL_WELCOME          = "welcome"
+	MAIL_PASSWORD_CHANGED = "password_changed"
+	MAIL_APP_INVITATION   = "app_invitation"
+	MAIL_APP_DEPLOYED     = "app_deployed"
+)
+
+// the mail falls back to this language when the user language has no templates.
+const DEFAULT_LANGUAGE = "en-US"
+
+//go:embed templates
+var templateFS embed.FS
+
+type RenderedMail struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Render renders the subject, plain text and html body of the mail in the language.
+func Render(kind, language string, data interface{}) (RenderedMail, error) {
+	if _, err := templateFS.Open("templates/" + language + "/" + kind + ".txt"); err != nil {
+		language = DEFAULT_LANGUAGE
+	}
+	textTmpl, err := texttemplate.ParseFS(templateFS, "templates/"+language+"/"+kind+".txt")
+	if err != nil {
+		return RenderedMail{}, err
+	}
+	htmlTmpl, err := htmltemplate.ParseFS(templateFS, "templates/"+language+"/"+kind+".html")
+	if err != nil {
+		return RenderedMail{}, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return RenderedMail{}, err
+	}
+	if err := textTmpl.ExecuteTemplate(&text, "text", data); err != nil {
+		return RenderedMail{}, err
+	}
+	if err := htmlTmpl.ExecuteTemplate(&html, "html", data); err != nil {
+		return RenderedMail{}, err
+	}
+	return RenderedMail{
+		// header injection is not possible with a single line subject
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
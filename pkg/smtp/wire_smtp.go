@@ -14,9 +14,17 @@
 
 package smtp
 
-import "github.com/google/wire"
+import (
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/google/wire"
+)
 
 var SMTPWireSet = wire.NewSet(
 	GetConfig,
+	repository.NewMailOutboxRepositoryImpl,
+	wire.Bind(new(repository.MailOutboxRepository), new(*repository.MailOutboxRepositoryImpl)),
 	NewSMTPServer,
+	NewMailer,
+	NewOutboxWorker,
 )
@@ -0,0 +1,380 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/app"
+
+	"gorm.io/gorm"
+)
+
+// the limits of the component trees, the depth counts the components from the rootDsl down.
+const (
+	COMPONENT_TREE_MAX_DEPTH = 32
+	COMPONENT_MAX_COORDINATE = 10000
+	COMPONENT_MAX_SIZE       = 10000
+)
+
+// containableTypes are the container types of the components each container type can hold.
+var containableTypes = map[string]map[string]bool{
+	repository.COMPONENT_CONTAINER_TYPE_DOT_PANEL:    {repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE: true},
+	repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE: {repository.COMPONENT_CONTAINER_TYPE_DOT_PANEL: true},
+}
+
+// ComponentValidationError is a field of a component which can not be stored.
+type ComponentValidationError struct {
+	DisplayName string `json:"displayName"`
+	Field       string `json:"field"`
+	Reason      string `json:"reason"`
+}
+
+// ComponentValidationErrors are all the problems found in a component change.
+type ComponentValidationErrors []*ComponentValidationError
+
+func (errs ComponentValidationErrors) Error() string {
+	reasons := make([]string, 0, len(errs))
+	for _, err := range errs {
+		reasons = append(reasons, fmt.Sprintf("%s.%s %s", err.DisplayName, err.Field, err.Reason))
+	}
+	return "[state] invalid component: " + strings.Join(reasons, ", ")
+}
+
+// componentValidator collects the problems of the components in a payload, the payload is checked
+// before repository.ConstructComponentNodeByMap takes the fields of the wrong type as zero values.
+type componentValidator struct {
+	errs  ComponentValidationErrors
+	names []string
+	seen  map[string]bool
+}
+
+func newComponentValidator() *componentValidator {
+	return &componentValidator{seen: make(map[string]bool)}
+}
+
+func (v *componentValidator) fail(displayName string, field string, reason string) {
+	v.errs = append(v.errs, &ComponentValidationError{DisplayName: displayName, Field: field, Reason: reason})
+}
+
+func (v *componentValidator) result() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// checkNode checks the component at depth in the payload, and its children when withChildren is set.
+// The parent is the component it is nested in, it is empty for the component the payload starts at.
+// It returns the number of levels of the tree the component starts.
+func (v *componentValidator) checkNode(data interface{}, parentName string, parentContainerType string, depth int, withChildren bool) int {
+	item, ok := data.(map[string]interface{})
+	if !ok && parentName == "" {
+		v.fail("", "", "the payload must be a component")
+		return 0
+	}
+	if !ok {
+		v.fail(parentName, "childrenNode", "must be a list of components")
+		return 0
+	}
+	displayName, _ := item["displayName"].(string)
+	v.checkFieldTypes(displayName, item)
+
+	// required fields
+	if displayName == "" {
+		v.fail(displayName, "displayName", "is required")
+	} else if v.seen[displayName] {
+		v.fail(displayName, "displayName", "is used by another component in the app")
+	} else {
+		v.seen[displayName] = true
+		v.names = append(v.names, displayName)
+	}
+	if componentType, _ := item["type"].(string); componentType == "" {
+		v.fail(displayName, "type", "is required")
+	}
+	containerType, _ := item["containerType"].(string)
+	if _, ok := containableTypes[containerType]; !ok {
+		v.fail(displayName, "containerType", "is not a known container type")
+	}
+	v.checkLayout(displayName, containerType, item)
+
+	// the component nested in another one
+	if parentName != "" {
+		if parentNode, _ := item["parentNode"].(string); parentNode != "" && parentNode != parentName {
+			v.fail(displayName, "parentNode", "does not match the component it is nested in")
+		}
+		v.checkContainment(displayName, parentContainerType, containerType)
+	}
+	if !withChildren {
+		return 1
+	}
+	children, _ := item["childrenNode"].([]interface{})
+	if len(children) != 0 && depth >= COMPONENT_TREE_MAX_DEPTH {
+		v.fail(displayName, "childrenNode", fmt.Sprintf("exceeds the max depth %d", COMPONENT_TREE_MAX_DEPTH))
+		return 1
+	}
+	height := 0
+	for _, child := range children {
+		if childHeight := v.checkNode(child, displayName, containerType, depth+1, true); childHeight > height {
+			height = childHeight
+		}
+	}
+	return height + 1
+}
+
+// checkFieldTypes checks the known fields have the type the editor sends them in.
+func (v *componentValidator) checkFieldTypes(displayName string, item map[string]interface{}) {
+	keys := make([]string, 0, len(item))
+	for key := range item {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := item[key]
+		switch key {
+		case "displayName", "parentNode", "showName", "type", "containerType":
+			if _, ok := value.(string); !ok {
+				v.fail(displayName, key, "must be a string")
+			}
+		case "error", "isDragging", "isResizing", "verticalResize":
+			if _, ok := value.(bool); !ok {
+				v.fail(displayName, key, "must be a boolean")
+			}
+		case "h", "w", "minH", "minW", "x", "y", "z":
+			if _, ok := value.(float64); !ok {
+				v.fail(displayName, key, "must be a number")
+			}
+		case "props", "panelConfig":
+			if _, ok := value.(map[string]interface{}); !ok && value != nil {
+				v.fail(displayName, key, "must be an object")
+			}
+		case "childrenNode":
+			if _, ok := value.([]interface{}); !ok && value != nil {
+				v.fail(displayName, key, "must be a list of components")
+			}
+		}
+	}
+}
+
+// checkLayout checks the position and the size of the component, a dot panel at (-1, -1) without a
+// size fills the component it is placed in.
+func (v *componentValidator) checkLayout(displayName string, containerType string, item map[string]interface{}) {
+	x, _ := item["x"].(float64)
+	y, _ := item["y"].(float64)
+	w, _ := item["w"].(float64)
+	h, _ := item["h"].(float64)
+	if containerType == repository.COMPONENT_CONTAINER_TYPE_DOT_PANEL && x == -1 && y == -1 && w == 0 && h == 0 {
+		return
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{{"x", x}, {"y", y}} {
+		if field.value < 0 || field.value > COMPONENT_MAX_COORDINATE {
+			v.fail(displayName, field.name, fmt.Sprintf("must be between 0 and %d", COMPONENT_MAX_COORDINATE))
+		}
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{{"w", w}, {"h", h}} {
+		if field.value <= 0 || field.value > COMPONENT_MAX_SIZE {
+			v.fail(displayName, field.name, fmt.Sprintf("must be greater than 0 and at most %d", COMPONENT_MAX_SIZE))
+		}
+	}
+}
+
+// checkContainment checks the component can be placed in a component of the parent container type.
+func (v *componentValidator) checkContainment(displayName string, parentContainerType string, containerType string) {
+	containable, ok := containableTypes[parentContainerType]
+	if !ok {
+		// the parent was reported already
+		return
+	}
+	if _, ok := containableTypes[containerType]; ok && !containable[containerType] {
+		v.fail(displayName, "containerType", fmt.Sprintf("%s can not be placed in %s", containerType, parentContainerType))
+	}
+}
+
+// ValidateComponentTree checks the component tree in the payload before it is created: the fields of
+// the components, their display names are not used in the app, and the parent the tree is attached to
+// exists and can hold it within COMPONENT_TREE_MAX_DEPTH.
+func (impl *TreeStateServiceImpl) ValidateComponentTree(appDto *app.AppDto, data interface{}) error {
+	v := newComponentValidator()
+	height := v.checkNode(data, "", "", 1, true)
+	if len(v.errs) != 0 {
+		return v.errs
+	}
+	for _, name := range v.names {
+		_, err := impl.treestateRepository.RetrieveEditVersionByAppAndName(appDto.ID, repository.TREE_STATE_TYPE_COMPONENTS, name)
+		if err == nil {
+			v.fail(name, "displayName", "is used by another component in the app")
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	// the rootDsl is the summit of the tree
+	cnode := repository.ConstructComponentNodeByMap(data)
+	if cnode.DisplayName == repository.TREE_STATE_ROOTDSL_NAME {
+		return v.result()
+	}
+	parentTreeState, err := impl.treestateRepository.RetrieveEditVersionByAppAndName(appDto.ID, repository.TREE_STATE_TYPE_COMPONENTS, parentNodeName(cnode.ParentNode))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		v.fail(cnode.DisplayName, "parentNode", "does not exist")
+		return v.result()
+	}
+	if err != nil {
+		return err
+	}
+	if err := impl.checkPlacement(v, cnode.DisplayName, cnode.ContainerType, parentTreeState, height); err != nil {
+		return err
+	}
+	return v.result()
+}
+
+// ValidateComponentUpdate checks the fields of the component in the payload before its content is
+// updated, the changed container type must fit the component it is placed in.
+func (impl *TreeStateServiceImpl) ValidateComponentUpdate(appDto *app.AppDto, data interface{}) error {
+	v := newComponentValidator()
+	v.checkNode(data, "", "", 1, false)
+	if len(v.errs) != 0 {
+		return v.errs
+	}
+	cnode := repository.ConstructComponentNodeByMap(data)
+	nowTreeState, err := impl.treestateRepository.RetrieveEditVersionByAppAndName(appDto.ID, repository.TREE_STATE_TYPE_COMPONENTS, cnode.DisplayName)
+	if err != nil || nowTreeState.ParentNodeRefID == repository.TREE_STATE_SUMMIT_ID {
+		// the missing state is reported by the update
+		return nil
+	}
+	parentTreeState, err := impl.treestateRepository.RetrieveByID(nowTreeState.ParentNodeRefID)
+	if err != nil {
+		return err
+	}
+	parentNode, err := parentTreeState.ExportContentAsComponentState()
+	if err != nil {
+		return err
+	}
+	v.checkContainment(cnode.DisplayName, parentNode.ContainerType, cnode.ContainerType)
+	return v.result()
+}
+
+// checkMove checks the component can be moved into the new parent: the parent is not the component
+// itself or one of its children, it can hold the component, and the tree stays within
+// COMPONENT_TREE_MAX_DEPTH.
+func (impl *TreeStateServiceImpl) checkMove(nowTreeState *repository.TreeState, newParentTreeState *repository.TreeState) error {
+	v := newComponentValidator()
+	ancestors, err := impl.ancestorsOf(newParentTreeState)
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range append(ancestors, newParentTreeState.ID) {
+		if ancestor == nowTreeState.ID {
+			v.fail(nowTreeState.Name, "parentNode", "can not be the component itself or one of its children")
+			return v.result()
+		}
+	}
+	cnode, err := nowTreeState.ExportContentAsComponentState()
+	if err != nil {
+		return err
+	}
+	height, err := impl.heightOf(nowTreeState, 1)
+	if err != nil {
+		return err
+	}
+	if err := impl.checkPlacement(v, nowTreeState.Name, cnode.ContainerType, newParentTreeState, height); err != nil {
+		return err
+	}
+	return v.result()
+}
+
+// checkPlacement checks the parent can hold the component of the container type, and the tree of the
+// height the component starts fits below it.
+func (impl *TreeStateServiceImpl) checkPlacement(v *componentValidator, displayName string, containerType string, parentTreeState *repository.TreeState, height int) error {
+	parentNode, err := parentTreeState.ExportContentAsComponentState()
+	if err != nil {
+		return err
+	}
+	v.checkContainment(displayName, parentNode.ContainerType, containerType)
+	ancestors, err := impl.ancestorsOf(parentTreeState)
+	if err != nil {
+		return err
+	}
+	// the rootDsl is at depth 0
+	if len(ancestors)+height > COMPONENT_TREE_MAX_DEPTH {
+		v.fail(displayName, "childrenNode", fmt.Sprintf("exceeds the max depth %d", COMPONENT_TREE_MAX_DEPTH))
+	}
+	return nil
+}
+
+// ancestorsOf returns the ids of the components the component is placed in, from its parent up to the
+// rootDsl.
+func (impl *TreeStateServiceImpl) ancestorsOf(treeState *repository.TreeState) ([]int, error) {
+	ancestors := []int{}
+	visited := map[int]bool{treeState.ID: true}
+	for treeState.ParentNodeRefID != repository.TREE_STATE_SUMMIT_ID {
+		if visited[treeState.ParentNodeRefID] {
+			return nil, ComponentValidationErrors{{DisplayName: treeState.Name, Field: "parentNode", Reason: "links of the stored components form a cycle"}}
+		}
+		parentTreeState, err := impl.treestateRepository.RetrieveByID(treeState.ParentNodeRefID)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, parentTreeState.ID)
+		visited[parentTreeState.ID] = true
+		treeState = parentTreeState
+	}
+	return ancestors, nil
+}
+
+// heightOf returns the number of levels of the stored tree the component starts, it stops counting
+// past COMPONENT_TREE_MAX_DEPTH.
+func (impl *TreeStateServiceImpl) heightOf(treeState *repository.TreeState, depth int) (int, error) {
+	if depth > COMPONENT_TREE_MAX_DEPTH {
+		return 1, nil
+	}
+	ids, err := treeState.ExportChildrenNodeRefIDs()
+	if err != nil {
+		return 0, err
+	}
+	height := 0
+	for _, id := range ids {
+		childTreeState, err := impl.treestateRepository.RetrieveByID(id)
+		if err != nil {
+			return 0, err
+		}
+		childHeight, err := impl.heightOf(childTreeState, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		if childHeight > height {
+			height = childHeight
+		}
+	}
+	return height + 1, nil
+}
+
+// parentNodeName returns the name the parent is stored by, the components without a parent and the
+// children of the root are placed in the rootDsl.
+func parentNodeName(parentNode string) string {
+	if parentNode == "" || parentNode == repository.TREE_STATE_SUMMIT_NAME {
+		return repository.TREE_STATE_ROOTDSL_NAME
+	}
+	return parentNode
+}
@@ -43,6 +43,7 @@ type KVStateDto struct {
 	Version   int       `json:"version"`
 	Key       string    `json:"key"`
 	Value     string    `json:"value"`
+	Revision  int       `json:"revision"`
 	CreatedAt time.Time `json:"created_at"`
 	CreatedBy int       `json:"created_by"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -50,7 +51,7 @@ type KVStateDto struct {
 }
 
 func NewKVStateDto() *KVStateDto {
-	return &KVStateDto{}
+	return &KVStateDto{Revision: ANY_REVISION}
 }
 
 type KVStateServiceImpl struct {
@@ -89,6 +90,7 @@ func (kvsd *KVStateDto) ConstructByKvState(kvState *repository.KVState) {
 	kvsd.Version = kvState.Version
 	kvsd.Key = kvState.Key
 	kvsd.Value = kvState.Value
+	kvsd.Revision = kvState.Revision
 	kvsd.CreatedAt = kvState.CreatedAt
 	kvsd.CreatedBy = kvState.CreatedBy
 	kvsd.UpdatedAt = kvState.UpdatedAt
@@ -129,6 +131,15 @@ func (kvsd *KVStateDto) ConstructWithValue(value string) {
 	kvsd.Value = value
 }
 
+func (kvsd *KVStateDto) ConstructWithRevision(revision int) {
+	kvsd.Revision = revision
+}
+
+// BasedOn tells whether the change in the dto was made on the stored revision.
+func (kvsd *KVStateDto) BasedOn(revision int) bool {
+	return kvsd.Revision == ANY_REVISION || kvsd.Revision == revision
+}
+
 func NewKVStateServiceImpl(logger *zap.SugaredLogger,
 	kvStateRepository repository.KVStateRepository) *KVStateServiceImpl {
 	return &KVStateServiceImpl{
@@ -137,6 +148,16 @@ func NewKVStateServiceImpl(logger *zap.SugaredLogger,
 	}
 }
 
+// WithRepository returns a copy of the service on the repository, which is bound to a transaction.
+func (impl *KVStateServiceImpl) WithRepository(kvStateRepository repository.KVStateRepository) *KVStateServiceImpl {
+	if impl == nil {
+		return nil
+	}
+	tx := *impl
+	tx.kvStateRepository = kvStateRepository
+	return &tx
+}
+
 func (impl *KVStateServiceImpl) CreateKVState(kvstate *KVStateDto) (*KVStateDto, error) {
 	// TODO: validate the version
 	validate := validator.New()
@@ -169,26 +190,30 @@ func (impl *KVStateServiceImpl) DeleteKVState(kvstateID int) error {
 	return nil
 }
 
+// UpdateKVState writes the k-v state if it is still at kvstate.Revision.
 func (impl *KVStateServiceImpl) UpdateKVState(kvstate KVStateDto) (KVStateDto, error) {
 	validate := validator.New()
 	if err := validate.Struct(kvstate); err != nil {
 		return KVStateDto{}, err
 	}
 	kvstate.UpdatedAt = time.Now().UTC()
-	if err := impl.kvStateRepository.Update(&repository.KVState{
+	kvStateForStorage := &repository.KVState{
 		ID:        kvstate.ID,
 		StateType: kvstate.StateType,
 		AppRefID:  kvstate.AppRefID,
 		Version:   kvstate.Version,
 		Key:       kvstate.Key,
 		Value:     kvstate.Value,
+		Revision:  kvstate.Revision,
 		CreatedAt: kvstate.CreatedAt,
 		CreatedBy: kvstate.CreatedBy,
 		UpdatedAt: kvstate.UpdatedAt,
 		UpdatedBy: kvstate.UpdatedBy,
-	}); err != nil {
+	}
+	if err := impl.kvStateRepository.Update(kvStateForStorage); err != nil {
 		return KVStateDto{}, err
 	}
+	kvstate.Revision = kvStateForStorage.Revision
 	return kvstate, nil
 }
 
@@ -204,6 +229,7 @@ func (impl *KVStateServiceImpl) GetKVStateByID(kvstateID int) (KVStateDto, error
 		Version:   res.Version,
 		Key:       res.Key,
 		Value:     res.Value,
+		Revision:  res.Revision,
 		CreatedAt: res.CreatedAt,
 		CreatedBy: res.CreatedBy,
 		UpdatedAt: res.UpdatedAt,
@@ -226,6 +252,7 @@ func (impl *KVStateServiceImpl) GetAllTypeKVStateByApp(app *app.AppDto, version
 			Version:   kvstate.Version,
 			Key:       kvstate.Key,
 			Value:     kvstate.Value,
+			Revision:  kvstate.Revision,
 			CreatedAt: kvstate.CreatedAt,
 			CreatedBy: kvstate.CreatedBy,
 			UpdatedAt: kvstate.UpdatedAt,
@@ -249,6 +276,7 @@ func (impl *KVStateServiceImpl) GetKVStateByApp(app *app.AppDto, statetype int,
 			Version:   kvstate.Version,
 			Key:       kvstate.Key,
 			Value:     kvstate.Value,
+			Revision:  kvstate.Revision,
 			CreatedAt: kvstate.CreatedAt,
 			CreatedBy: kvstate.CreatedBy,
 			UpdatedAt: kvstate.UpdatedAt,
@@ -297,6 +325,9 @@ func (impl *KVStateServiceImpl) DeleteKVStateByKey(kvStateDto *KVStateDto) error
 	if err != nil {
 		return err
 	}
+	if !kvStateDto.BasedOn(kvstate.Revision) {
+		return repository.ErrRevisionConflict
+	}
 	kvstateid := kvstate.ID
 
 	// delete by id
@@ -317,9 +348,11 @@ func (impl *KVStateServiceImpl) DeleteAllEditKVStateByStateType(kvStateDto *KVSt
 
 func (impl *KVStateServiceImpl) UpdateKVStateByID(kvStateDto *KVStateDto) error {
 	// update by id
-	if _, err := impl.UpdateKVState(*kvStateDto); err != nil {
+	updated, err := impl.UpdateKVState(*kvStateDto)
+	if err != nil {
 		return err
 	}
+	kvStateDto.Revision = updated.Revision
 	return nil
 }
 
@@ -329,11 +362,12 @@ func (impl *KVStateServiceImpl) UpdateKVStateByKey(kvStateDto *KVStateDto) error
 	if err != nil {
 		return err
 	}
+	if !kvStateDto.BasedOn(kvstate.Revision) {
+		return repository.ErrRevisionConflict
+	}
 	kvStateDto.ID = kvstate.ID
+	kvStateDto.Revision = kvstate.Revision
 
 	// update by id
-	if _, err := impl.UpdateKVState(*kvStateDto); err != nil {
-		return err
-	}
-	return nil
+	return impl.UpdateKVStateByID(kvStateDto)
 }
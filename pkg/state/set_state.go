@@ -41,6 +41,7 @@ type SetStateDto struct {
 	AppRefID  int       `json:"app_ref_id"`
 	Version   int       `json:"version"`
 	Value     string    `json:"value"`
+	Revision  int       `json:"revision"`
 	CreatedAt time.Time `json:"created_at"`
 	CreatedBy int       `json:"created_by"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -48,7 +49,7 @@ type SetStateDto struct {
 }
 
 func NewSetStateDto() *SetStateDto {
-	return &SetStateDto{}
+	return &SetStateDto{Revision: ANY_REVISION}
 }
 
 type SetStateServiceImpl struct {
@@ -62,6 +63,7 @@ func (setsd *SetStateDto) ConstructBySetState(setState *repository.SetState) {
 	setsd.AppRefID = setState.AppRefID
 	setsd.Version = setState.Version
 	setsd.Value = setState.Value
+	setsd.Revision = setState.Revision
 	setsd.CreatedAt = setState.CreatedAt
 	setsd.CreatedBy = setState.CreatedBy
 	setsd.UpdatedAt = setState.UpdatedAt
@@ -102,6 +104,15 @@ func (setsd *SetStateDto) ConstructWithValue(value string) {
 	setsd.Value = value
 }
 
+func (setsd *SetStateDto) ConstructWithRevision(revision int) {
+	setsd.Revision = revision
+}
+
+// BasedOn tells whether the change in the dto was made on the stored revision.
+func (setsd *SetStateDto) BasedOn(revision int) bool {
+	return setsd.Revision == ANY_REVISION || setsd.Revision == revision
+}
+
 func NewSetStateServiceImpl(logger *zap.SugaredLogger,
 	setStateRepository repository.SetStateRepository) *SetStateServiceImpl {
 	return &SetStateServiceImpl{
@@ -110,6 +121,16 @@ func NewSetStateServiceImpl(logger *zap.SugaredLogger,
 	}
 }
 
+// WithRepository returns a copy of the service on the repository, which is bound to a transaction.
+func (impl *SetStateServiceImpl) WithRepository(setStateRepository repository.SetStateRepository) *SetStateServiceImpl {
+	if impl == nil {
+		return nil
+	}
+	tx := *impl
+	tx.setStateRepository = setStateRepository
+	return &tx
+}
+
 func (impl *SetStateServiceImpl) CreateSetState(setState *SetStateDto) (*SetStateDto, error) {
 	// TODO: validate the version
 	validate := validator.New()
@@ -148,31 +169,44 @@ func (impl *SetStateServiceImpl) DeleteSetStateByValue(setStateDto *SetStateDto)
 		Version:   setStateDto.Version,
 		Value:     setStateDto.Value,
 	}
+	if setStateDto.Revision != ANY_REVISION {
+		inDBSetState, err := impl.setStateRepository.RetrieveByValue(setState)
+		if err != nil {
+			return err
+		}
+		if !setStateDto.BasedOn(inDBSetState.Revision) {
+			return repository.ErrRevisionConflict
+		}
+	}
 	if err := impl.setStateRepository.DeleteByValue(setState); err != nil {
 		return err
 	}
 	return nil
 }
 
+// UpdateSetState writes the set state if it is still at setState.Revision.
 func (impl *SetStateServiceImpl) UpdateSetState(setState *SetStateDto) (*SetStateDto, error) {
 	validate := validator.New()
 	if err := validate.Struct(setState); err != nil {
 		return nil, err
 	}
 	setState.UpdatedAt = time.Now().UTC()
-	if err := impl.setStateRepository.Update(&repository.SetState{
+	setStateForStorage := &repository.SetState{
 		ID:        setState.ID,
 		StateType: setState.StateType,
 		AppRefID:  setState.AppRefID,
 		Version:   setState.Version,
 		Value:     setState.Value,
+		Revision:  setState.Revision,
 		CreatedAt: setState.CreatedAt,
 		CreatedBy: setState.CreatedBy,
 		UpdatedAt: setState.UpdatedAt,
 		UpdatedBy: setState.UpdatedBy,
-	}); err != nil {
+	}
+	if err := impl.setStateRepository.Update(setStateForStorage); err != nil {
 		return nil, err
 	}
+	setState.Revision = setStateForStorage.Revision
 	return setState, nil
 }
 
@@ -218,6 +252,7 @@ func (impl *SetStateServiceImpl) GetSetStateByID(setStateID int) (SetStateDto, e
 		AppRefID:  setState.AppRefID,
 		Version:   setState.Version,
 		Value:     setState.Value,
+		Revision:  setState.Revision,
 		CreatedAt: setState.CreatedAt,
 		CreatedBy: setState.CreatedBy,
 		UpdatedAt: setState.UpdatedAt,
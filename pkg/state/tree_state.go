@@ -27,6 +27,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// ANY_REVISION marks a change which overwrites the state at any revision, the clients which do not
+// send the revision they changed make such changes.
+const ANY_REVISION = -1
+
 type TreeStateService interface {
 	CreateTreeState(treestate TreeStateDto) (TreeStateDto, error)
 	DeleteTreeState(treestateId int) error
@@ -47,6 +51,7 @@ type TreeStateDto struct {
 	Name               string    `json:"name"`
 	ParentNode         string    `json:"parentNode"`
 	Content            string    `json:"content"`
+	Revision           int       `json:"revision"`
 	CreatedAt          time.Time `json:"created_at"`
 	CreatedBy          int       `json:"created_by"`
 	UpdatedAt          time.Time `json:"updated_at"`
@@ -59,7 +64,7 @@ type TreeStateServiceImpl struct {
 }
 
 func NewTreeStateDto() *TreeStateDto {
-	return &TreeStateDto{}
+	return &TreeStateDto{Revision: ANY_REVISION}
 }
 
 func (tsd *TreeStateDto) ConstructByMap(data interface{}) {
@@ -102,6 +107,7 @@ func (tsd *TreeStateDto) ConstructByTreeState(treeState *repository.TreeState) e
 	tsd.Name = treeState.Name
 	tsd.ParentNode = ""
 	tsd.Content = treeState.Content
+	tsd.Revision = treeState.Revision
 	tsd.CreatedAt = treeState.CreatedAt
 	tsd.CreatedBy = treeState.CreatedBy
 	tsd.UpdatedBy = treeState.UpdatedBy
@@ -133,6 +139,15 @@ func (tsd *TreeStateDto) ConstructWithNewStateContent(ntsd *TreeStateDto) {
 	tsd.Content = ntsd.Content
 }
 
+func (tsd *TreeStateDto) ConstructWithRevision(revision int) {
+	tsd.Revision = revision
+}
+
+// BasedOn tells whether the change in the dto was made on the stored revision.
+func (tsd *TreeStateDto) BasedOn(revision int) bool {
+	return tsd.Revision == ANY_REVISION || tsd.Revision == revision
+}
+
 func NewTreeStateServiceImpl(logger *zap.SugaredLogger, treestateRepository repository.TreeStateRepository) *TreeStateServiceImpl {
 	return &TreeStateServiceImpl{
 		logger:              logger,
@@ -140,6 +155,16 @@ func NewTreeStateServiceImpl(logger *zap.SugaredLogger, treestateRepository repo
 	}
 }
 
+// WithRepository returns a copy of the service on the repository, which is bound to a transaction.
+func (impl *TreeStateServiceImpl) WithRepository(treestateRepository repository.TreeStateRepository) *TreeStateServiceImpl {
+	if impl == nil {
+		return nil
+	}
+	tx := *impl
+	tx.treestateRepository = treestateRepository
+	return &tx
+}
+
 func (impl *TreeStateServiceImpl) NewTreeStateByComponentState(appDto *app.AppDto, cnode *repository.ComponentNode) (*TreeStateDto, error) {
 	var cnodeserilized []byte
 	var err error
@@ -218,6 +243,7 @@ func (impl *TreeStateServiceImpl) UpdateTreeState(treestate *TreeStateDto) (*Tre
 		Version:            treestate.Version,
 		Name:               treestate.Name,
 		Content:            treestate.Content,
+		Revision:           treestate.Revision,
 		UpdatedAt:          treestate.UpdatedAt,
 		UpdatedBy:          treestate.UpdatedBy,
 	}
@@ -225,6 +251,7 @@ func (impl *TreeStateServiceImpl) UpdateTreeState(treestate *TreeStateDto) (*Tre
 	if err := impl.treestateRepository.Update(treeStateRepo); err != nil {
 		return nil, err
 	}
+	treestate.Revision = treeStateRepo.Revision
 	return treestate, nil
 }
 
@@ -246,6 +273,7 @@ func (impl *TreeStateServiceImpl) GetTreeStateByID(treestateID int) (TreeStateDt
 		Version:            res.Version,
 		Name:               res.Name,
 		Content:            res.Content,
+		Revision:           res.Revision,
 		CreatedAt:          res.CreatedAt,
 		CreatedBy:          res.CreatedBy,
 		UpdatedAt:          res.UpdatedAt,
@@ -274,6 +302,7 @@ func (impl *TreeStateServiceImpl) GetAllTypeTreeStateByApp(app *app.AppDto, vers
 			Version:            treestate.Version,
 			Name:               treestate.Name,
 			Content:            treestate.Content,
+			Revision:           treestate.Revision,
 			CreatedAt:          treestate.CreatedAt,
 			CreatedBy:          treestate.CreatedBy,
 			UpdatedAt:          treestate.UpdatedAt,
@@ -303,6 +332,7 @@ func (impl *TreeStateServiceImpl) GetTreeStateByApp(app *app.AppDto, statetype i
 			Version:            treestate.Version,
 			Name:               treestate.Name,
 			Content:            treestate.Content,
+			Revision:           treestate.Revision,
 			CreatedAt:          treestate.CreatedAt,
 			CreatedBy:          treestate.CreatedBy,
 			UpdatedAt:          treestate.UpdatedAt,
@@ -345,7 +375,7 @@ func (impl *TreeStateServiceImpl) GetTreeStateByName(currentNode *TreeStateDto)
 	return inDBTreeStateDto, nil
 }
 
-// @todo: add tree ref circle checker.
+// MoveTreeStateNode places the component in the new parent, the move is checked by checkMove.
 func (impl *TreeStateServiceImpl) MoveTreeStateNode(currentNode *TreeStateDto) error {
 	// prepare data
 	oldParentTreeState := &repository.TreeState{}
@@ -356,6 +386,9 @@ func (impl *TreeStateServiceImpl) MoveTreeStateNode(currentNode *TreeStateDto) e
 	if nowTreeState, err = impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, currentNode.Name); err != nil {
 		return err
 	}
+	if !currentNode.BasedOn(nowTreeState.Revision) {
+		return repository.ErrRevisionConflict
+	}
 
 	// get oldParentTreeState by id
 	if oldParentTreeState, err = impl.treestateRepository.RetrieveByID(nowTreeState.ParentNodeRefID); err != nil {
@@ -365,12 +398,15 @@ func (impl *TreeStateServiceImpl) MoveTreeStateNode(currentNode *TreeStateDto) e
 	// get newParentTreeState by name
 	switch currentNode.StateType {
 	case repository.TREE_STATE_TYPE_COMPONENTS:
-		if newParentTreeState, err = impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, currentNode.ParentNode); err != nil {
+		if newParentTreeState, err = impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, parentNodeName(currentNode.ParentNode)); err != nil {
 			return err
 		}
 	default:
 		return nil
 	}
+	if err := impl.checkMove(nowTreeState, newParentTreeState); err != nil {
+		return err
+	}
 
 	// fill into database
 	// update nowTreeState
@@ -378,6 +414,7 @@ func (impl *TreeStateServiceImpl) MoveTreeStateNode(currentNode *TreeStateDto) e
 	if err := impl.treestateRepository.Update(nowTreeState); err != nil {
 		return err
 	}
+	currentNode.Revision = nowTreeState.Revision
 
 	// add now TreeState id into new parent TreeState.ChildrenNodeRefIDs
 	newParentTreeState.AppendChildrenNodeRefIDs(nowTreeState.ID)
@@ -404,6 +441,9 @@ func (impl *TreeStateServiceImpl) DeleteTreeStateNodeRecursive(currentNode *Tree
 	if nowTreeState, err = impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, currentNode.Name); err != nil {
 		return err
 	}
+	if !currentNode.BasedOn(nowTreeState.Revision) {
+		return repository.ErrRevisionConflict
+	}
 	// unlink parentNode
 	// get parentNode
 	parentTreeState := &repository.TreeState{}
@@ -437,6 +477,52 @@ func (impl *TreeStateServiceImpl) DeleteTreeStateNodeRecursive(currentNode *Tree
 	return nil
 }
 
+// GetComponentTreeByName returns the component with its children, its parentNode is the name of the
+// node it is attached to.
+func (impl *TreeStateServiceImpl) GetComponentTreeByName(currentNode *TreeStateDto) (*repository.ComponentNode, error) {
+	nowTreeState, err := impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, currentNode.Name)
+	if err != nil {
+		return nil, err
+	}
+	childrenNodes := []*repository.TreeState{}
+	if err := impl.retrieveChildrenNodes(nowTreeState, &childrenNodes); err != nil {
+		return nil, err
+	}
+	treeStateMap := make(map[int]*repository.TreeState, len(childrenNodes))
+	for _, node := range childrenNodes {
+		treeStateMap[node.ID] = node
+	}
+	cnode, err := repository.BuildComponentTree(nowTreeState, treeStateMap, nil)
+	if err != nil {
+		return nil, err
+	}
+	if nowTreeState.ParentNodeRefID != 0 {
+		parentTreeState, err := impl.treestateRepository.RetrieveByID(nowTreeState.ParentNodeRefID)
+		if err != nil {
+			return nil, err
+		}
+		cnode.ParentNode = parentTreeState.Name
+	}
+	return cnode, nil
+}
+
+// GetDescendantNamesByName returns the names of the children of the node and of their children.
+func (impl *TreeStateServiceImpl) GetDescendantNamesByName(currentNode *TreeStateDto) ([]string, error) {
+	nowTreeState, err := impl.treestateRepository.RetrieveEditVersionByAppAndName(currentNode.AppRefID, currentNode.StateType, currentNode.Name)
+	if err != nil {
+		return nil, err
+	}
+	childrenNodes := []*repository.TreeState{}
+	if err := impl.retrieveChildrenNodes(nowTreeState, &childrenNodes); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(childrenNodes))
+	for _, node := range childrenNodes {
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
 func (impl *TreeStateServiceImpl) retrieveChildrenNodes(treeState *repository.TreeState, childrenNodes *[]*repository.TreeState) error {
 	// @todo: replace this RetrieveByID to a batch method
 	ids, err := treeState.ExportChildrenNodeRefIDs()
@@ -0,0 +1,117 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 with the parameters supported by common authenticator apps.
+const (
+	PERIOD      = 30
+	DIGITS      = 6
+	SECRET_SIZE = 20
+	// accept the codes of adjacent time steps for clock drift
+	SKEW = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, SECRET_SIZE)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth URI, it is usually rendered as a QR code.
+func ProvisioningURI(secret, issuer, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(DIGITS))
+	query.Set("period", fmt.Sprint(PERIOD))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// GenerateCode returns the code of the time step t belongs to.
+func GenerateCode(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/PERIOD), DIGITS), nil
+}
+
+// Validate checks the code against the current time step and its neighbours.
+func Validate(secret, code string, t time.Time) bool {
+	_, ok := ValidateStep(secret, code, t, 0)
+	return ok
+}
+
+// ValidateStep checks the code against the time steps around t which come after the step `after`,
+// it returns the matched time step. The code of an accepted step can not be accepted again.
+func ValidateStep(secret, code string, t time.Time, after int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != DIGITS {
+		return 0, false
+	}
+	counter := t.Unix() / PERIOD
+	for i := -SKEW; i <= SKEW; i++ {
+		step := counter + int64(i)
+		if step <= after {
+			continue
+		}
+		expected := hotp(key, uint64(step), DIGITS)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// hotp implements RFC 4226 with dynamic truncation.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// test vectors from RFC 4226 appendix D and RFC 6238 appendix B
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		assert.Equal(t, code, hotp(rfcKey, uint64(counter), 6))
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString(rfcKey)
+	cases := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, code := range cases {
+		generated, err := GenerateCode(secret, time.Unix(unix, 0))
+		assert.Nil(t, err)
+		// RFC 6238 uses 8 digits, the 6 digits code is the suffix
+		assert.Equal(t, code[2:], generated)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	now := time.Now()
+	code, err := GenerateCode(secret, now)
+	assert.Nil(t, err)
+	assert.True(t, Validate(secret, code, now))
+	assert.True(t, Validate(secret, code, now.Add(PERIOD*time.Second)))
+	assert.False(t, Validate(secret, code, now.Add(3*PERIOD*time.Second)))
+	assert.False(t, Validate(secret, "12345", now))
+	assert.False(t, Validate("not base32!", code, now))
+}
+
+func TestValidateStep(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+	now := time.Now()
+	code, err := GenerateCode(secret, now)
+	assert.Nil(t, err)
+	step, ok := ValidateStep(secret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/PERIOD, step)
+	// the code of an accepted step is rejected
+	_, ok = ValidateStep(secret, code, now, step)
+	assert.False(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("JBSWY3DPEHPK3PXP", "ILLA", "alice@example.com")
+	assert.Contains(t, uri, "otpauth://totp/ILLA:alice@example.com?")
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+}
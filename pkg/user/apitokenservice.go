@@ -0,0 +1,203 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// API_TOKEN_PREFIX makes the personal API token distinguishable from the session JWT.
+const API_TOKEN_PREFIX = "illa_pat_"
+
+// the last used time is only refreshed after this interval to reduce writes.
+const API_TOKEN_LAST_USED_INTERVAL = time.Minute * 5
+
+const (
+	SCOPE_READ   = "read"
+	SCOPE_WRITE  = "write"
+	SCOPE_DEPLOY = "deploy"
+	// reading the audit log, it is not implied by the other scopes
+	SCOPE_AUDIT = "audit"
+)
+
+type APITokenService interface {
+	CreateAPIToken(userID int, name string, scopes []string, expiresAt *time.Time) (APITokenDto, error)
+	ListAPITokens(userID int) ([]APITokenDto, error)
+	RevokeAPIToken(userID, id int) error
+	ValidateAPIToken(apiToken string) (int, []string, error)
+}
+
+type APITokenDto struct {
+	ID         int        `json:"tokenId"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	TokenHint  string     `json:"tokenHint"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (dto *APITokenDto) ConstructByRecord(record *repository.APIToken) {
+	dto.ID = record.ID
+	dto.Name = record.Name
+	dto.TokenHint = record.TokenHint
+	dto.Scopes = strings.Split(record.Scopes, ",")
+	dto.ExpiresAt = record.ExpiresAt
+	dto.LastUsedAt = record.LastUsedAt
+	dto.CreatedAt = record.CreatedAt
+}
+
+type APITokenServiceImpl struct {
+	logger             *zap.SugaredLogger
+	apiTokenRepository repository.APITokenRepository
+}
+
+func NewAPITokenServiceImpl(logger *zap.SugaredLogger, apiTokenRepository repository.APITokenRepository) *APITokenServiceImpl {
+	return &APITokenServiceImpl{
+		logger:             logger,
+		apiTokenRepository: apiTokenRepository,
+	}
+}
+
+// CreateAPIToken returns the plaintext token, it can not be retrieved again.
+func (impl *APITokenServiceImpl) CreateAPIToken(userID int, name string, scopes []string, expiresAt *time.Time) (APITokenDto, error) {
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return APITokenDto{}, errors.New("expiry time is in the past")
+	}
+	secret, err := generateRandomToken()
+	if err != nil {
+		return APITokenDto{}, err
+	}
+	apiToken := API_TOKEN_PREFIX + secret
+	record := &repository.APIToken{
+		UserID:      userID,
+		Name:        name,
+		TokenDigest: digestToken(apiToken),
+		TokenHint:   apiToken[:len(API_TOKEN_PREFIX)+4],
+		Scopes:      strings.Join(normalizeScopes(scopes), ","),
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if _, err := impl.apiTokenRepository.Create(record); err != nil {
+		return APITokenDto{}, err
+	}
+	apiTokenDto := APITokenDto{}
+	apiTokenDto.ConstructByRecord(record)
+	apiTokenDto.Token = apiToken
+	return apiTokenDto, nil
+}
+
+func (impl *APITokenServiceImpl) ListAPITokens(userID int) ([]APITokenDto, error) {
+	records, err := impl.apiTokenRepository.RetrieveActiveByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	apiTokenDtos := make([]APITokenDto, 0, len(records))
+	for _, record := range records {
+		apiTokenDto := APITokenDto{}
+		apiTokenDto.ConstructByRecord(record)
+		apiTokenDtos = append(apiTokenDtos, apiTokenDto)
+	}
+	return apiTokenDtos, nil
+}
+
+func (impl *APITokenServiceImpl) RevokeAPIToken(userID, id int) error {
+	revoked, err := impl.apiTokenRepository.RevokeByID(userID, id)
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return errors.New("no such API token")
+	}
+	return nil
+}
+
+// ValidateAPIToken returns the owner and scopes of the API token.
+func (impl *APITokenServiceImpl) ValidateAPIToken(apiToken string) (int, []string, error) {
+	if !IsAPIToken(apiToken) {
+		return 0, nil, errors.New("invalid API token")
+	}
+	record, err := impl.apiTokenRepository.RetrieveByDigest(digestToken(apiToken))
+	if err != nil || record.ID == 0 || record.Revoked {
+		return 0, nil, errors.New("invalid API token")
+	}
+	now := time.Now().UTC()
+	if record.ExpiresAt != nil && record.ExpiresAt.Before(now) {
+		return 0, nil, errors.New("API token expired")
+	}
+	if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > API_TOKEN_LAST_USED_INTERVAL {
+		if err := impl.apiTokenRepository.UpdateLastUsedAt(record.ID, now); err != nil {
+			impl.logger.Errorw("update API token last used time error", "err", err)
+		}
+	}
+	return record.UserID, strings.Split(record.Scopes, ","), nil
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, API_TOKEN_PREFIX)
+}
+
+// RequiredScope returns the scope an API token needs to call the route.
+func RequiredScope(method, fullPath string) string {
+	if strings.Contains(fullPath, "/v1/audit") {
+		return SCOPE_AUDIT
+	}
+	if strings.HasSuffix(fullPath, "/deploy") {
+		return SCOPE_DEPLOY
+	}
+	// changing what the visitors of an app see is a deployment as well
+	if method != http.MethodGet && (strings.HasSuffix(fullPath, "/release") || strings.HasSuffix(fullPath, "/promote") ||
+		strings.HasSuffix(fullPath, "/publish")) {
+		return SCOPE_DEPLOY
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return SCOPE_READ
+	default:
+		return SCOPE_WRITE
+	}
+}
+
+// HasScope reports if the granted scopes satisfy the required one, write scope implies read.
+func HasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scope == required || (scope == SCOPE_WRITE && required == SCOPE_READ) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeScopes(scopes []string) []string {
+	normalized := make([]string, 0, 4)
+	for _, scope := range []string{SCOPE_READ, SCOPE_WRITE, SCOPE_DEPLOY, SCOPE_AUDIT} {
+		for _, s := range scopes {
+			if s == scope {
+				normalized = append(normalized, scope)
+				break
+			}
+		}
+	}
+	return normalized
+}
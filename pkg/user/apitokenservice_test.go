@@ -0,0 +1,203 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type memoryAPITokens struct {
+	mu     sync.Mutex
+	tokens []*repository.APIToken
+}
+
+func (m *memoryAPITokens) Create(apiToken *repository.APIToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	apiToken.ID = len(m.tokens) + 1
+	record := *apiToken
+	m.tokens = append(m.tokens, &record)
+	return apiToken.ID, nil
+}
+
+func (m *memoryAPITokens) RetrieveByDigest(digest string) (*repository.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range m.tokens {
+		if token.TokenDigest == digest {
+			record := *token
+			return &record, nil
+		}
+	}
+	return &repository.APIToken{}, gorm.ErrRecordNotFound
+}
+
+func (m *memoryAPITokens) RetrieveActiveByUser(userID int) ([]*repository.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var tokens []*repository.APIToken
+	for _, token := range m.tokens {
+		if token.UserID == userID && !token.Revoked {
+			record := *token
+			tokens = append(tokens, &record)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *memoryAPITokens) RevokeByID(userID, id int) (int64, error) {
+	return m.revoke(func(token *repository.APIToken) bool { return token.UserID == userID && token.ID == id }), nil
+}
+
+func (m *memoryAPITokens) RevokeAllByUser(userID int) error {
+	m.revoke(func(token *repository.APIToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (m *memoryAPITokens) revoke(match func(token *repository.APIToken) bool) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked int64
+	for _, token := range m.tokens {
+		if match(token) && !token.Revoked {
+			token.Revoked = true
+			revoked++
+		}
+	}
+	return revoked
+}
+
+func (m *memoryAPITokens) UpdateLastUsedAt(id int, lastUsedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[id-1].LastUsedAt = &lastUsedAt
+	return nil
+}
+
+func TestAPITokenIsStoredAsDigest(t *testing.T) {
+	apiTokens := &memoryAPITokens{}
+	service := NewAPITokenServiceImpl(zap.NewNop().Sugar(), apiTokens)
+	created, err := service.CreateAPIToken(1, "ci", []string{SCOPE_DEPLOY, "admin", SCOPE_READ}, nil)
+	assert.Nil(t, err)
+	assert.True(t, IsAPIToken(created.Token))
+	assert.Equal(t, []string{SCOPE_READ, SCOPE_DEPLOY}, created.Scopes)
+
+	// only the digest and a short hint of the token are stored
+	record := apiTokens.tokens[0]
+	assert.Equal(t, digestToken(created.Token), record.TokenDigest)
+	assert.NotContains(t, record.TokenDigest, strings.TrimPrefix(created.Token, API_TOKEN_PREFIX))
+	assert.True(t, strings.HasPrefix(created.Token, record.TokenHint))
+	assert.Len(t, record.TokenHint, len(API_TOKEN_PREFIX)+4)
+	listed, err := service.ListAPITokens(1)
+	assert.Nil(t, err)
+	assert.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Token)
+
+	userID, scopes, err := service.ValidateAPIToken(created.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, userID)
+	assert.Equal(t, []string{SCOPE_READ, SCOPE_DEPLOY}, scopes)
+	assert.NotNil(t, record.LastUsedAt)
+
+	_, _, err = service.ValidateAPIToken(created.Token + "x")
+	assert.NotNil(t, err)
+	_, _, err = service.ValidateAPIToken(strings.TrimPrefix(created.Token, API_TOKEN_PREFIX))
+	assert.NotNil(t, err)
+}
+
+func TestRevokedOrExpiredAPITokenIsRejected(t *testing.T) {
+	apiTokens := &memoryAPITokens{}
+	service := NewAPITokenServiceImpl(zap.NewNop().Sugar(), apiTokens)
+	_, err := service.CreateAPIToken(1, "expired", []string{SCOPE_READ}, &time.Time{})
+	assert.NotNil(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, err := service.CreateAPIToken(1, "expiring", []string{SCOPE_READ}, &expiresAt)
+	assert.Nil(t, err)
+	revoking, err := service.CreateAPIToken(1, "revoking", []string{SCOPE_WRITE}, nil)
+	assert.Nil(t, err)
+
+	apiTokens.tokens[0].ExpiresAt = &time.Time{}
+	_, _, err = service.ValidateAPIToken(expiring.Token)
+	assert.NotNil(t, err)
+
+	// only the owner can revoke the token
+	assert.NotNil(t, service.RevokeAPIToken(2, revoking.ID))
+	_, _, err = service.ValidateAPIToken(revoking.Token)
+	assert.Nil(t, err)
+	assert.Nil(t, service.RevokeAPIToken(1, revoking.ID))
+	_, _, err = service.ValidateAPIToken(revoking.Token)
+	assert.NotNil(t, err)
+	assert.NotNil(t, service.RevokeAPIToken(1, revoking.ID))
+}
+
+func TestRevokeAllSessionsRevokesAPITokens(t *testing.T) {
+	service, _, apiTokens := newTestTokenService(t)
+	apiTokenService := NewAPITokenServiceImpl(zap.NewNop().Sugar(), apiTokens)
+	owned, err := apiTokenService.CreateAPIToken(1, "ci", []string{SCOPE_READ}, nil)
+	assert.Nil(t, err)
+	other, err := apiTokenService.CreateAPIToken(2, "ci", []string{SCOPE_READ}, nil)
+	assert.Nil(t, err)
+	tokens, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+
+	assert.Nil(t, service.RevokeAllSessions(1))
+	_, err = service.ValidateAccessToken(tokens.AccessToken)
+	assert.NotNil(t, err)
+	_, _, err = apiTokenService.ValidateAPIToken(owned.Token)
+	assert.NotNil(t, err)
+	_, _, err = apiTokenService.ValidateAPIToken(other.Token)
+	assert.Nil(t, err)
+}
+
+func TestRequiredScope(t *testing.T) {
+	for _, route := range []struct {
+		method   string
+		fullPath string
+		scope    string
+	}{
+		{http.MethodGet, "/api/v1/apps", SCOPE_READ},
+		{http.MethodHead, "/api/v1/apps/:app", SCOPE_READ},
+		{http.MethodPost, "/api/v1/apps", SCOPE_WRITE},
+		{http.MethodPut, "/api/v1/apps/:app", SCOPE_WRITE},
+		{http.MethodDelete, "/api/v1/apps/:app", SCOPE_WRITE},
+		{http.MethodPost, "/api/v1/apps/:app/deploy", SCOPE_DEPLOY},
+		{http.MethodGet, "/api/v1/apps/:app/deploy", SCOPE_DEPLOY},
+		{http.MethodPost, "/api/v1/apps/:app/versions/:version/release", SCOPE_DEPLOY},
+		{http.MethodGet, "/api/v1/apps/:app/versions/:version/release", SCOPE_READ},
+		{http.MethodPut, "/api/v1/apps/:app/channels/:channel/promote", SCOPE_DEPLOY},
+		{http.MethodPost, "/api/v1/apps/:app/publish", SCOPE_DEPLOY},
+		{http.MethodGet, "/api/v1/audit", SCOPE_AUDIT},
+	} {
+		assert.Equal(t, route.scope, RequiredScope(route.method, route.fullPath), "%s %s", route.method, route.fullPath)
+	}
+
+	assert.True(t, HasScope([]string{SCOPE_WRITE}, SCOPE_READ))
+	assert.False(t, HasScope([]string{SCOPE_READ}, SCOPE_WRITE))
+	assert.False(t, HasScope([]string{SCOPE_WRITE}, SCOPE_DEPLOY))
+	assert.True(t, HasScope([]string{SCOPE_READ, SCOPE_DEPLOY}, SCOPE_DEPLOY))
+	assert.False(t, HasScope([]string{SCOPE_READ, SCOPE_WRITE, SCOPE_DEPLOY}, SCOPE_AUDIT))
+	assert.Equal(t, []string{SCOPE_READ, SCOPE_AUDIT}, normalizeScopes([]string{SCOPE_AUDIT, SCOPE_READ, "admin"}))
+}
@@ -16,26 +16,63 @@ package user
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-func JWTAuth() gin.HandlerFunc {
+// JWTAuth accepts both the session access token and the personal API token.
+func JWTAuth(tokenService TokenService, apiTokenService APITokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		accessToken := c.Request.Header["Authorization"]
-		var token string
 		if len(accessToken) != 1 {
 			c.AbortWithStatus(http.StatusUnauthorized)
-		} else {
-			token = accessToken[0]
+			return
 		}
-		userID, extractErr := ExtractUserIDFromToken(token)
-		validAccessToken, validaAccessErr := ValidateAccessToken(token)
-
-		if validAccessToken && validaAccessErr == nil && extractErr == nil {
+		token := strings.TrimPrefix(accessToken[0], "Bearer ")
+		if IsAPIToken(token) {
+			userID, scopes, err := apiTokenService.ValidateAPIToken(token)
+			if err != nil {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			if !HasScope(scopes, RequiredScope(c.Request.Method, c.FullPath())) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
 			c.Set("userID", userID)
-		} else {
+			c.Set("apiToken", true)
+			c.Next()
+			return
+		}
+		userID, err := tokenService.ValidateAccessToken(token)
+		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
+
+// AdminOnly should be used after the auth middleware, it rejects the users who are not administrators.
+func AdminOnly(userService UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userDto, err := userService.GetUser(c.GetInt("userID"))
+		if err != nil || !userDto.IsAdmin {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// SessionOnly rejects the API token, account management needs a signed in user.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("apiToken") {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
 		}
 		c.Next()
 	}
@@ -0,0 +1,76 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// memoryUserService knows the administrators only.
+type memoryUserService struct {
+	UserService
+	admins map[int]bool
+}
+
+func (m memoryUserService) GetUser(id int) (UserDto, error) {
+	if id == 0 {
+		return UserDto{}, errors.New("no such user")
+	}
+	return UserDto{ID: id, IsAdmin: m.admins[id]}, nil
+}
+
+func TestAuditLogIsForAdministrators(t *testing.T) {
+	service, _, apiTokens := newTestTokenService(t)
+	apiTokenService := NewAPITokenServiceImpl(zap.NewNop().Sugar(), apiTokens)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/api/v1/audit", JWTAuth(service, apiTokenService), AdminOnly(memoryUserService{admins: map[int]bool{1: true}}),
+		func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+	find := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	session := func(userID int) string {
+		tokens, err := service.IssueTokens(userID)
+		assert.Nil(t, err)
+		return tokens.AccessToken
+	}
+	apiToken := func(userID int, scopes ...string) string {
+		created, err := apiTokenService.CreateAPIToken(userID, "audit", scopes, nil)
+		assert.Nil(t, err)
+		return created.Token
+	}
+
+	assert.Equal(t, http.StatusOK, find(session(1)))
+	assert.Equal(t, http.StatusForbidden, find(session(2)))
+	assert.Equal(t, http.StatusUnauthorized, find("invalid"))
+
+	// the API token needs the audit scope
+	assert.Equal(t, http.StatusOK, find(apiToken(1, SCOPE_AUDIT)))
+	assert.Equal(t, http.StatusForbidden, find(apiToken(1, SCOPE_READ, SCOPE_WRITE, SCOPE_DEPLOY)))
+	assert.Equal(t, http.StatusForbidden, find(apiToken(2, SCOPE_AUDIT)))
+}
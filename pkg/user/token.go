@@ -15,32 +15,43 @@
 package user
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	uuid "github.com/satori/go.uuid"
 )
 
+// access token is short-lived, use refresh token to get a new one.
+const ACCESS_TOKEN_TTL = time.Minute * 15
+
+// the second step of sign in should be finished in this duration.
+const CHALLENGE_TOKEN_TTL = time.Minute * 5
+
 type AuthClaims struct {
-	User   int    `json:"user"`
-	Random string `json:"rnd"`
+	User    int    `json:"user"`
+	Random  string `json:"rnd"`
+	Session string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func CreateAccessToken(id int) (string, error) {
+func CreateAccessToken(id int, sessionID string) (string, error) {
 
 	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 	vCode := fmt.Sprintf("%06v", rnd.Int31n(10000))
 
 	claims := &AuthClaims{
-		User:   id,
-		Random: vCode,
+		User:    id,
+		Random:  vCode,
+		Session: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:     uuid.Must(uuid.NewV4(), nil).String(),
 			Issuer: "ILLA",
 			ExpiresAt: &jwt.NumericDate{
-				Time: time.Now().Add(time.Hour * 24 * 7),
+				Time: time.Now().Add(ACCESS_TOKEN_TTL),
 			},
 		},
 	}
@@ -64,20 +75,68 @@ func ValidateAccessToken(accessToken string) (bool, error) {
 }
 
 func ExtractUserIDFromToken(accessToken string) (int, error) {
+	claims, err := ExtractClaimsFromToken(accessToken)
+	if err != nil {
+		return 0, err
+	}
+	return claims.User, nil
+}
+
+func ExtractClaimsFromToken(accessToken string) (*AuthClaims, error) {
 	authClaims := &AuthClaims{}
 	token, err := jwt.ParseWithClaims(accessToken, authClaims, func(token *jwt.Token) (interface{}, error) {
 		return []byte(os.Getenv("ILLA_SECRET_KEY")), nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(*AuthClaims)
 	if !(ok && token.Valid) {
-		return 0, err
+		return nil, errors.New("invalid access token")
+	}
+
+	return claims, nil
+}
+
+// ChallengeClaims proves the password was verified, it is signed with a derived key
+// so it can never be accepted as an access token.
+type ChallengeClaims struct {
+	Challenger int `json:"challenger"`
+	jwt.RegisteredClaims
+}
+
+func CreateChallengeToken(id int) (string, error) {
+	claims := &ChallengeClaims{
+		Challenger: id,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:     uuid.Must(uuid.NewV4(), nil).String(),
+			Issuer: "ILLA",
+			ExpiresAt: &jwt.NumericDate{
+				Time: time.Now().Add(CHALLENGE_TOKEN_TTL),
+			},
+		},
 	}
 
-	userID := claims.User
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString(challengeKey())
+}
+
+func ExtractUserIDFromChallengeToken(challengeToken string) (int, error) {
+	claims := &ChallengeClaims{}
+	token, err := jwt.ParseWithClaims(challengeToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return challengeKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return 0, err
+	}
+	if !token.Valid || claims.Challenger == 0 {
+		return 0, errors.New("invalid challenge token")
+	}
+	return claims.Challenger, nil
+}
 
-	return userID, nil
+func challengeKey() []byte {
+	return []byte(os.Getenv("ILLA_SECRET_KEY") + "/challenge")
 }
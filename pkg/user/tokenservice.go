@@ -0,0 +1,218 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	uuid "github.com/satori/go.uuid"
+
+	"go.uber.org/zap"
+)
+
+// a revoked refresh token is used again, the session it belongs to is revoked.
+var ErrRefreshTokenReused = errors.New("refresh token has been revoked")
+
+// refresh token is rotated on every refresh, the session expired if it is not refreshed in this duration.
+const REFRESH_TOKEN_TTL = time.Hour * 24 * 30
+
+type TokenService interface {
+	IssueTokens(userID int) (TokenPair, error)
+	RefreshTokens(refreshToken string) (TokenPair, error)
+	ValidateAccessToken(accessToken string) (int, error)
+	SignOut(accessToken, refreshToken string) error
+	RevokeAllSessions(userID int) error
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+type TokenServiceImpl struct {
+	logger                 *zap.SugaredLogger
+	refreshTokenRepository repository.RefreshTokenRepository
+	revokedTokenRepository repository.RevokedTokenRepository
+	apiTokenRepository     repository.APITokenRepository
+}
+
+func NewTokenServiceImpl(logger *zap.SugaredLogger, refreshTokenRepository repository.RefreshTokenRepository,
+	revokedTokenRepository repository.RevokedTokenRepository, apiTokenRepository repository.APITokenRepository) *TokenServiceImpl {
+	return &TokenServiceImpl{
+		logger:                 logger,
+		refreshTokenRepository: refreshTokenRepository,
+		revokedTokenRepository: revokedTokenRepository,
+		apiTokenRepository:     apiTokenRepository,
+	}
+}
+
+// IssueTokens starts a new session for the user.
+func (impl *TokenServiceImpl) IssueTokens(userID int) (TokenPair, error) {
+	// purge the expired revocation records by the way
+	if err := impl.revokedTokenRepository.DeleteExpired(); err != nil {
+		impl.logger.Errorw("purge revoked tokens error", "err", err)
+	}
+	return impl.issueTokensForSession(userID, uuid.Must(uuid.NewV4(), nil).String())
+}
+
+func (impl *TokenServiceImpl) issueTokensForSession(userID int, sessionID string) (TokenPair, error) {
+	accessToken, err := CreateAccessToken(userID, sessionID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := generateRandomToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if _, err := impl.refreshTokenRepository.Create(&repository.RefreshToken{
+		UserID:      userID,
+		SessionID:   sessionID,
+		TokenDigest: digestToken(refreshToken),
+		ExpiresAt:   time.Now().UTC().Add(REFRESH_TOKEN_TTL),
+		CreatedAt:   time.Now().UTC(),
+		UpdatedAt:   time.Now().UTC(),
+	}); err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshTokens rotates the refresh token, a reused refresh token means it was stolen,
+// so the whole session will be revoked.
+func (impl *TokenServiceImpl) RefreshTokens(refreshToken string) (TokenPair, error) {
+	record, err := impl.refreshTokenRepository.RetrieveByDigest(digestToken(refreshToken))
+	if err != nil || record.ID == 0 {
+		return TokenPair{}, errors.New("invalid refresh token")
+	}
+	if record.Revoked {
+		return TokenPair{}, impl.revokeReusedSession(record)
+	}
+	if record.ExpiresAt.Before(time.Now().UTC()) {
+		return TokenPair{}, errors.New("refresh token expired")
+	}
+	// only one of the concurrent refreshes revokes the token, the others are reuses
+	revoked, err := impl.refreshTokenRepository.RevokeByID(record.ID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if revoked != 1 {
+		return TokenPair{}, impl.revokeReusedSession(record)
+	}
+	return impl.issueTokensForSession(record.UserID, record.SessionID)
+}
+
+func (impl *TokenServiceImpl) revokeReusedSession(record *repository.RefreshToken) error {
+	if err := impl.revokeSession(record.UserID, record.SessionID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// ValidateAccessToken validates the access token and checks the revocation list, returns the user ID.
+func (impl *TokenServiceImpl) ValidateAccessToken(accessToken string) (int, error) {
+	claims, err := ExtractClaimsFromToken(accessToken)
+	if err != nil {
+		return 0, err
+	}
+	tokenIDs := make([]string, 0, 2)
+	if claims.ID != "" {
+		tokenIDs = append(tokenIDs, claims.ID)
+	}
+	if claims.Session != "" {
+		tokenIDs = append(tokenIDs, claims.Session)
+	}
+	revoked, err := impl.revokedTokenRepository.IsRevoked(tokenIDs)
+	if err != nil {
+		return 0, err
+	}
+	if revoked {
+		return 0, errors.New("access token has been revoked")
+	}
+	return claims.User, nil
+}
+
+// SignOut revokes the session of the access token, or of the refresh token if the access token is expired.
+func (impl *TokenServiceImpl) SignOut(accessToken, refreshToken string) error {
+	if claims, err := ExtractClaimsFromToken(accessToken); err == nil {
+		if claims.ID != "" {
+			if err := impl.revokedTokenRepository.Create(&repository.RevokedToken{
+				TokenID:   claims.ID,
+				UserID:    claims.User,
+				ExpiresAt: claims.ExpiresAt.Time.UTC(),
+				CreatedAt: time.Now().UTC(),
+			}); err != nil {
+				return err
+			}
+		}
+		if claims.Session != "" {
+			return impl.revokeSession(claims.User, claims.Session)
+		}
+		return nil
+	}
+	record, err := impl.refreshTokenRepository.RetrieveByDigest(digestToken(refreshToken))
+	if err != nil || record.ID == 0 {
+		return errors.New("invalid access token and refresh token")
+	}
+	return impl.revokeSession(record.UserID, record.SessionID)
+}
+
+// RevokeAllSessions signs out the user from everywhere, the API tokens are revoked as well.
+func (impl *TokenServiceImpl) RevokeAllSessions(userID int) error {
+	sessionIDs, err := impl.refreshTokenRepository.RetrieveActiveSessionsByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		if err := impl.revokeSession(userID, sessionID); err != nil {
+			return err
+		}
+	}
+	if err := impl.refreshTokenRepository.RevokeAllByUser(userID); err != nil {
+		return err
+	}
+	return impl.apiTokenRepository.RevokeAllByUser(userID)
+}
+
+func (impl *TokenServiceImpl) revokeSession(userID int, sessionID string) error {
+	if err := impl.refreshTokenRepository.RevokeBySession(sessionID); err != nil {
+		return err
+	}
+	// the access tokens of this session will expire in ACCESS_TOKEN_TTL
+	return impl.revokedTokenRepository.Create(&repository.RevokedToken{
+		TokenID:   sessionID,
+		UserID:    userID,
+		ExpiresAt: time.Now().UTC().Add(ACCESS_TOKEN_TTL),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+func generateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func digestToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,210 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// memoryRefreshTokens keeps the refresh tokens, beforeRevoke runs before a token is revoked by id.
+type memoryRefreshTokens struct {
+	mu           sync.Mutex
+	tokens       []repository.RefreshToken
+	beforeRevoke func(id int)
+}
+
+func (m *memoryRefreshTokens) Create(refreshToken *repository.RefreshToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refreshToken.ID = len(m.tokens) + 1
+	m.tokens = append(m.tokens, *refreshToken)
+	return refreshToken.ID, nil
+}
+
+func (m *memoryRefreshTokens) RetrieveByDigest(digest string) (*repository.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range m.tokens {
+		if token.TokenDigest == digest {
+			return &token, nil
+		}
+	}
+	return &repository.RefreshToken{}, gorm.ErrRecordNotFound
+}
+
+func (m *memoryRefreshTokens) RetrieveActiveSessionsByUser(userID int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sessionIDs []string
+	for _, token := range m.tokens {
+		if token.UserID == userID && !token.Revoked {
+			sessionIDs = append(sessionIDs, token.SessionID)
+		}
+	}
+	return sessionIDs, nil
+}
+
+func (m *memoryRefreshTokens) RevokeByID(id int) (int64, error) {
+	if m.beforeRevoke != nil {
+		m.beforeRevoke(id)
+	}
+	return m.revoke(func(token repository.RefreshToken) bool { return token.ID == id }), nil
+}
+
+func (m *memoryRefreshTokens) RevokeBySession(sessionID string) error {
+	m.revoke(func(token repository.RefreshToken) bool { return token.SessionID == sessionID })
+	return nil
+}
+
+func (m *memoryRefreshTokens) RevokeAllByUser(userID int) error {
+	m.revoke(func(token repository.RefreshToken) bool { return token.UserID == userID })
+	return nil
+}
+
+func (m *memoryRefreshTokens) revoke(match func(token repository.RefreshToken) bool) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked int64
+	for i := range m.tokens {
+		if match(m.tokens[i]) && !m.tokens[i].Revoked {
+			m.tokens[i].Revoked = true
+			revoked++
+		}
+	}
+	return revoked
+}
+
+type memoryRevokedTokens struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+}
+
+func (m *memoryRevokedTokens) Create(revokedToken *repository.RevokedToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[revokedToken.TokenID] = true
+	return nil
+}
+
+func (m *memoryRevokedTokens) IsRevoked(tokenIDs []string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tokenID := range tokenIDs {
+		if m.tokens[tokenID] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryRevokedTokens) DeleteExpired() error {
+	return nil
+}
+
+func newTestTokenService(t *testing.T) (*TokenServiceImpl, *memoryRefreshTokens, *memoryAPITokens) {
+	t.Setenv("ILLA_SECRET_KEY", "test-secret")
+	refreshTokens := &memoryRefreshTokens{}
+	apiTokens := &memoryAPITokens{}
+	return NewTokenServiceImpl(zap.NewNop().Sugar(), refreshTokens, &memoryRevokedTokens{tokens: map[string]bool{}}, apiTokens),
+		refreshTokens, apiTokens
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	service, _, _ := newTestTokenService(t)
+	first, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+
+	second, err := service.RefreshTokens(first.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	userID, err := service.ValidateAccessToken(second.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, userID)
+
+	third, err := service.RefreshTokens(second.RefreshToken)
+	assert.Nil(t, err)
+	assert.NotEqual(t, second.RefreshToken, third.RefreshToken)
+
+	_, err = service.RefreshTokens("unknown")
+	assert.NotNil(t, err)
+}
+
+func TestReusedRefreshTokenRevokesSession(t *testing.T) {
+	service, _, _ := newTestTokenService(t)
+	stolen, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+	rotated, err := service.RefreshTokens(stolen.RefreshToken)
+	assert.Nil(t, err)
+	other, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+
+	_, err = service.RefreshTokens(stolen.RefreshToken)
+	assert.Equal(t, ErrRefreshTokenReused, err)
+
+	// the whole session is revoked, the other sessions are not
+	_, err = service.RefreshTokens(rotated.RefreshToken)
+	assert.NotNil(t, err)
+	_, err = service.ValidateAccessToken(rotated.AccessToken)
+	assert.NotNil(t, err)
+	_, err = service.RefreshTokens(other.RefreshToken)
+	assert.Nil(t, err)
+}
+
+func TestConcurrentRefreshIsReuse(t *testing.T) {
+	service, refreshTokens, _ := newTestTokenService(t)
+	tokens, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+
+	// another refresh revokes the token after this one read it
+	refreshTokens.beforeRevoke = func(id int) {
+		refreshTokens.beforeRevoke = nil
+		_, err := refreshTokens.RevokeByID(id)
+		assert.Nil(t, err)
+	}
+	_, err = service.RefreshTokens(tokens.RefreshToken)
+	assert.Equal(t, ErrRefreshTokenReused, err)
+	_, err = service.ValidateAccessToken(tokens.AccessToken)
+	assert.NotNil(t, err)
+	sessionIDs, err := refreshTokens.RetrieveActiveSessionsByUser(1)
+	assert.Nil(t, err)
+	assert.Empty(t, sessionIDs)
+}
+
+func TestSignOutRevokesSession(t *testing.T) {
+	service, _, _ := newTestTokenService(t)
+	tokens, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+	other, err := service.IssueTokens(1)
+	assert.Nil(t, err)
+
+	assert.Nil(t, service.SignOut(tokens.AccessToken, ""))
+	_, err = service.ValidateAccessToken(tokens.AccessToken)
+	assert.NotNil(t, err)
+	_, err = service.RefreshTokens(tokens.RefreshToken)
+	assert.NotNil(t, err)
+
+	// an invalid access token falls back to the refresh token
+	assert.Nil(t, service.SignOut("expired", other.RefreshToken))
+	_, err = service.ValidateAccessToken(other.AccessToken)
+	assert.NotNil(t, err)
+	assert.NotNil(t, service.SignOut("expired", "unknown"))
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/totp"
+)
+
+const TOTP_ISSUER = "ILLA"
+
+const RECOVERY_CODE_COUNT = 10
+
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningURI"`
+}
+
+// EnrollTOTP generates a new secret, two-factor is enabled after the first code verified.
+func (impl *UserServiceImpl) EnrollTOTP(userID int) (TOTPEnrollment, error) {
+	userRecord, err := impl.userRepository.RetrieveByID(userID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if userRecord.TOTPEnabled {
+		return TOTPEnrollment{}, errors.New("two-factor authentication already enabled")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := impl.userRepository.UpdateTOTP(userID, secret, false, ""); err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, TOTP_ISSUER, userRecord.Email),
+	}, nil
+}
+
+// EnableTOTP verifies the code of the enrolled secret, returns the recovery codes in plaintext.
+func (impl *UserServiceImpl) EnableTOTP(userID int, code string) ([]string, error) {
+	userRecord, err := impl.userRepository.RetrieveByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if userRecord.TOTPEnabled {
+		return nil, errors.New("two-factor authentication already enabled")
+	}
+	if userRecord.TOTPSecret == "" {
+		return nil, errors.New("two-factor authentication not enrolled")
+	}
+	step, ok := totp.ValidateStep(userRecord.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, errors.New("invalid verification code")
+	}
+	recoveryCodes := make([]string, 0, RECOVERY_CODE_COUNT)
+	digests := make([]string, 0, RECOVERY_CODE_COUNT)
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		recoveryCode, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		digests = append(digests, digestToken(recoveryCode))
+	}
+	if err := impl.userRepository.UpdateTOTP(userID, userRecord.TOTPSecret, true, strings.Join(digests, ",")); err != nil {
+		return nil, err
+	}
+	// the code which enabled two-factor can not be used to sign in
+	if _, err := impl.userRepository.AcceptTOTPStep(userID, step); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+func (impl *UserServiceImpl) DisableTOTP(userID int, code string) error {
+	valid, err := impl.VerifySecondFactor(userID, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid verification code")
+	}
+	return impl.userRepository.UpdateTOTP(userID, "", false, "")
+}
+
+// VerifySecondFactor accepts a TOTP code of a time step after the last accepted one, or an unused
+// recovery code. The time step and the recovery code are consumed, so a code is accepted only once.
+func (impl *UserServiceImpl) VerifySecondFactor(userID int, code string) (bool, error) {
+	userRecord, err := impl.userRepository.RetrieveByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if !userRecord.TOTPEnabled {
+		return false, errors.New("two-factor authentication not enabled")
+	}
+	code = strings.ToLower(strings.ReplaceAll(code, " ", ""))
+	if step, ok := totp.ValidateStep(userRecord.TOTPSecret, code, time.Now(), userRecord.TOTPLastStep); ok {
+		accepted, err := impl.userRepository.AcceptTOTPStep(userID, step)
+		if err != nil {
+			return false, err
+		}
+		return accepted == 1, nil
+	}
+	return impl.consumeRecoveryCode(userRecord, digestToken(code))
+}
+
+// consumeRecoveryCode removes the recovery code from the codes of the user, the codes are replaced only
+// if no other request changed them since they were read.
+func (impl *UserServiceImpl) consumeRecoveryCode(userRecord *repository.User, digest string) (bool, error) {
+	for {
+		if userRecord.RecoveryCodes == "" {
+			return false, nil
+		}
+		digests := strings.Split(userRecord.RecoveryCodes, ",")
+		i := 0
+		for i < len(digests) && digests[i] != digest {
+			i++
+		}
+		if i == len(digests) {
+			return false, nil
+		}
+		remains := append(digests[:i:i], digests[i+1:]...)
+		consumed, err := impl.userRepository.ConsumeRecoveryCodes(userRecord.ID, userRecord.RecoveryCodes, strings.Join(remains, ","))
+		if err != nil {
+			return false, err
+		}
+		if consumed == 1 {
+			return true, nil
+		}
+		// another code was consumed meanwhile, check the code against the codes left
+		if userRecord, err = impl.userRepository.RetrieveByID(userRecord.ID); err != nil {
+			return false, err
+		}
+	}
+}
+
+// generateRecoveryCode returns a code like "k4xq2-m7rtp".
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return encoded[:5] + "-" + encoded[5:10], nil
+}
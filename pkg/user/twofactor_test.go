@@ -0,0 +1,137 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/totp"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// memoryUsers keeps one user, beforeConsume runs before the recovery codes are replaced.
+type memoryUsers struct {
+	repository.UserRepository
+	mu            sync.Mutex
+	user          repository.User
+	beforeConsume func()
+}
+
+func (m *memoryUsers) RetrieveByID(id int) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user := m.user
+	return &user, nil
+}
+
+func (m *memoryUsers) UpdateTOTP(id int, secret string, enabled bool, recoveryCodes string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.user.TOTPSecret, m.user.TOTPEnabled, m.user.RecoveryCodes, m.user.TOTPLastStep = secret, enabled, recoveryCodes, 0
+	return nil
+}
+
+func (m *memoryUsers) AcceptTOTPStep(id int, step int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.user.TOTPLastStep >= step {
+		return 0, nil
+	}
+	m.user.TOTPLastStep = step
+	return 1, nil
+}
+
+func (m *memoryUsers) ConsumeRecoveryCodes(id int, before, after string) (int64, error) {
+	if beforeConsume := m.beforeConsume; beforeConsume != nil {
+		m.beforeConsume = nil
+		beforeConsume()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.user.RecoveryCodes != before {
+		return 0, nil
+	}
+	m.user.RecoveryCodes = after
+	return 1, nil
+}
+
+func newTestTwoFactor(t *testing.T) (*UserServiceImpl, *memoryUsers, string, []string) {
+	users := &memoryUsers{user: repository.User{ID: 1, Email: "alice@example.com"}}
+	service := &UserServiceImpl{logger: zap.NewNop().Sugar(), userRepository: users}
+	enrollment, err := service.EnrollTOTP(1)
+	assert.Nil(t, err)
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	assert.Nil(t, err)
+	recoveryCodes, err := service.EnableTOTP(1, code)
+	assert.Nil(t, err)
+	return service, users, enrollment.Secret, recoveryCodes
+}
+
+func TestTOTPCodeIsAcceptedOnce(t *testing.T) {
+	service, users, secret, _ := newTestTwoFactor(t)
+	user, _ := users.RetrieveByID(1)
+	enabledAt := time.Unix(user.TOTPLastStep*totp.PERIOD, 0)
+
+	// the code which enabled two-factor was used already
+	code, err := totp.GenerateCode(secret, enabledAt)
+	assert.Nil(t, err)
+	valid, err := service.VerifySecondFactor(1, code)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	// the code of the next time step is accepted while it is valid, but only once
+	code, err = totp.GenerateCode(secret, enabledAt.Add(totp.PERIOD*time.Second))
+	assert.Nil(t, err)
+	valid, err = service.VerifySecondFactor(1, code)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	valid, err = service.VerifySecondFactor(1, code)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+}
+
+func TestRecoveryCodeIsConsumedOnce(t *testing.T) {
+	service, users, _, recoveryCodes := newTestTwoFactor(t)
+
+	// another request spends the same code between the read and the update
+	var concurrent bool
+	users.beforeConsume = func() {
+		var err error
+		concurrent, err = service.VerifySecondFactor(1, recoveryCodes[0])
+		assert.Nil(t, err)
+	}
+	valid, err := service.VerifySecondFactor(1, recoveryCodes[0])
+	assert.Nil(t, err)
+	assert.True(t, concurrent)
+	assert.False(t, valid)
+
+	// a different code spent meanwhile does not reject this one
+	users.beforeConsume = func() {
+		valid, err := service.VerifySecondFactor(1, recoveryCodes[1])
+		assert.Nil(t, err)
+		assert.True(t, valid)
+	}
+	valid, err = service.VerifySecondFactor(1, recoveryCodes[2])
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	user, _ := users.RetrieveByID(1)
+	assert.Len(t, strings.Split(user.RecoveryCodes, ","), RECOVERY_CODE_COUNT-3)
+}
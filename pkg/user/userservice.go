@@ -15,6 +15,10 @@
 package user
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/illa-family/builder-backend/internal/repository"
@@ -22,6 +26,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 var language_array = []string{"", "en-US", "zh-CN"}
@@ -35,8 +40,14 @@ type UserService interface {
 	UpdateUser(userDto UserDto) (UserDto, error)
 	FindUserByEmail(email string) (UserDto, error)
 	GetUser(id int) (UserDto, error)
-	GetToken(id int) (string, error)
-	GenerateVerificationCode(email, usage string) (string, error)
+	LinkOrProvisionUser(email, name string) (UserDto, error)
+	EnrollTOTP(userID int) (TOTPEnrollment, error)
+	EnableTOTP(userID int, code string) ([]string, error)
+	DisableTOTP(userID int, code string) error
+	VerifySecondFactor(userID int, code string) (bool, error)
+	SendWelcomeMail(userDto UserDto) error
+	SendPasswordChangedMail(userDto UserDto) error
+	GenerateVerificationCode(email, usage, language string) (string, error)
 	ValidateVerificationCode(vCode, vToken, email, usage string) (bool, error)
 }
 
@@ -47,6 +58,8 @@ type UserDto struct {
 	Email        string    `json:"email,omitempty"`
 	Language     string    `json:"language,omitempty"`
 	IsSubscribed bool      `json:"-"`
+	TOTPEnabled  bool      `json:"totpEnabled"`
+	IsAdmin      bool      `json:"isAdmin"`
 	CreatedAt    time.Time `json:"-"`
 	UpdatedAt    time.Time `json:"-"`
 }
@@ -113,6 +126,8 @@ func (impl *UserServiceImpl) FindUserByEmail(email string) (UserDto, error) {
 		Password:     userRecord.PasswordDigest,
 		Language:     language_array[userRecord.Language],
 		IsSubscribed: userRecord.IsSubscribed,
+		TOTPEnabled:  userRecord.TOTPEnabled,
+		IsAdmin:      userRecord.IsAdmin,
 	}
 	return userDto, nil
 }
@@ -129,22 +144,68 @@ func (impl *UserServiceImpl) GetUser(id int) (UserDto, error) {
 		Password:     userRecord.PasswordDigest,
 		Language:     language_array[userRecord.Language],
 		IsSubscribed: userRecord.IsSubscribed,
+		TOTPEnabled:  userRecord.TOTPEnabled,
+		IsAdmin:      userRecord.IsAdmin,
 		CreatedAt:    userRecord.CreatedAt,
 		UpdatedAt:    userRecord.UpdatedAt,
 	}
 	return userDto, nil
 }
 
-func (impl *UserServiceImpl) GetToken(id int) (string, error) {
-	accessToken, err := CreateAccessToken(id)
-	if err != nil {
-		return "", nil
+// LinkOrProvisionUser returns the user with the email, a new user will be created when not found.
+// the provisioned user has a random password, it can be reset by forget password.
+func (impl *UserServiceImpl) LinkOrProvisionUser(email, name string) (UserDto, error) {
+	userDto, err := impl.FindUserByEmail(email)
+	if err == nil && userDto.ID != 0 {
+		return userDto, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return UserDto{}, err
+	}
+
+	nickname := name
+	if nickname == "" {
+		nickname = strings.Split(email, "@")[0]
 	}
-	return accessToken, nil
+	if runes := []rune(nickname); len(runes) > 15 {
+		nickname = string(runes[:15])
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return UserDto{}, err
+	}
+	return impl.CreateUser(UserDto{
+		Nickname:  nickname,
+		Password:  base64.RawURLEncoding.EncodeToString(b),
+		Email:     email,
+		Language:  "en-US",
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	})
 }
 
-func (impl *UserServiceImpl) GenerateVerificationCode(email, usage string) (string, error) {
-	return impl.smtpServer.NewVerificationCode(email, usage)
+func (impl *UserServiceImpl) GenerateVerificationCode(email, usage, language string) (string, error) {
+	// the registered user receives mail in the language of the account
+	if userRecord, err := impl.userRepository.FetchUserByEmail(email); err == nil && userRecord.ID != 0 {
+		language = LanguageByIndex(userRecord.Language)
+	}
+	return impl.smtpServer.NewVerificationCode(email, usage, language)
+}
+
+func (impl *UserServiceImpl) SendWelcomeMail(userDto UserDto) error {
+	return impl.smtpServer.SendWelcome(userDto.Email, userDto.Language, userDto.Nickname)
+}
+
+func (impl *UserServiceImpl) SendPasswordChangedMail(userDto UserDto) error {
+	return impl.smtpServer.SendPasswordChanged(userDto.Email, userDto.Language, userDto.Nickname, time.Now())
+}
+
+// LanguageByIndex converts the language stored in database to the language tag.
+func LanguageByIndex(index int) string {
+	if index <= 0 || index >= len(language_array) {
+		return language_array[language_map[smtp.DEFAULT_LANGUAGE]]
+	}
+	return language_array[index]
 }
 
 func (impl *UserServiceImpl) ValidateVerificationCode(vCode, vToken, email, usage string) (bool, error) {
@@ -0,0 +1,110 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"encoding/json"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/state"
+)
+
+var auditOperations = map[int]string{
+	ws.SIGNAL_CREATE_STATE:           audit.OPERATION_CREATE,
+	ws.SIGNAL_DELETE_STATE:           audit.OPERATION_DELETE,
+	ws.SIGNAL_UPDATE_STATE:           audit.OPERATION_UPDATE,
+	ws.SIGNAL_MOVE_STATE:             audit.OPERATION_MOVE,
+	ws.SIGNAL_CREATE_OR_UPDATE_STATE: audit.OPERATION_CREATE_OR_UPDATE,
+	ws.SIGNAL_PUT_STATE:              audit.OPERATION_PUT,
+}
+
+var auditTargets = map[int]string{
+	ws.TARGET_COMPONENTS:         audit.TARGET_TREE_STATE,
+	ws.TARGET_DEPENDENCIES:       audit.TARGET_KV_STATE,
+	ws.TARGET_DRAG_SHADOW:        audit.TARGET_KV_STATE,
+	ws.TARGET_DOTTED_LINE_SQUARE: audit.TARGET_KV_STATE,
+	ws.TARGET_DISPLAY_NAME:       audit.TARGET_SET_STATE,
+}
+
+// AuditFilter runs the state signal and appends it to the audit log when it succeeded.
+// Signals for apps and resources only broadcast, the HTTP API records them.
+func AuditFilter(room *ws.Room, message *ws.Message, signal func(room *ws.Room, message *ws.Message) error) error {
+	currentClient := room.Clients[message.ClientID]
+	entry, isAudited := auditEntry(room, currentClient, ws.Operation{Signal: message.Signal, Target: message.Target, Payload: message.Payload})
+	if err := signal(room, message); err != nil {
+		return err
+	}
+	if isAudited {
+		recordAudit(room, entry, message.Payload)
+	}
+	return nil
+}
+
+// auditEntry starts the audit log entry of the operation with the stored components it changes.
+func auditEntry(room *ws.Room, client *ws.Client, op ws.Operation) (audit.Entry, bool) {
+	operation, isMutation := auditOperations[op.Signal]
+	targetType, isAudited := auditTargets[op.Target]
+	if room.Hub.AuditServiceImpl == nil || !isMutation || !isAudited {
+		return audit.Entry{}, false
+	}
+	entry := audit.Entry{
+		ActorID:    client.MappedUserID,
+		Source:     audit.SOURCE_WEBSOCKET,
+		Operation:  operation,
+		TargetType: targetType,
+		AppID:      client.APPID,
+	}
+	if op.Target == ws.TARGET_COMPONENTS {
+		entry.Before = componentsBefore(room, client.APPID, op.Payload)
+	}
+	return entry, true
+}
+
+// recordAudit appends the entry of the applied operation to the audit log.
+func recordAudit(room *ws.Room, entry audit.Entry, after interface{}) {
+	entry.After = after
+	if err := room.Hub.AuditServiceImpl.Record(entry); err != nil {
+		room.Hub.AuditServiceImpl.Logger().Errorw("record audit log error", "err", err, "app", entry.AppID, "operation", entry.Operation)
+	}
+}
+
+// componentsBefore snapshots the stored components named in the payload, keyed by displayName.
+func componentsBefore(room *ws.Room, appID int, payload []interface{}) map[string]interface{} {
+	before := make(map[string]interface{})
+	for _, v := range payload {
+		currentNode := state.NewTreeStateDto()
+		currentNode.Name = componentDisplayName(v)
+		if currentNode.Name == "" {
+			continue
+		}
+		currentNode.AppRefID = appID
+		currentNode.StateType = repository.TREE_STATE_TYPE_COMPONENTS
+		inDBTreeStateDto, err := room.Hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
+		if err != nil || inDBTreeStateDto == nil {
+			continue
+		}
+		var content interface{}
+		if err := json.Unmarshal([]byte(inDBTreeStateDto.Content), &content); err != nil {
+			content = inDBTreeStateDto.Content
+		}
+		before[currentNode.Name] = map[string]interface{}{
+			"parentNode": inDBTreeStateDto.ParentNode,
+			"content":    content,
+		}
+	}
+	return before
+}
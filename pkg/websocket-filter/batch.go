@@ -0,0 +1,124 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+)
+
+// the signals and targets a batch can carry.
+var batchSignals = map[int]bool{
+	ws.SIGNAL_CREATE_STATE: true,
+	ws.SIGNAL_DELETE_STATE: true,
+	ws.SIGNAL_UPDATE_STATE: true,
+	ws.SIGNAL_MOVE_STATE:   true,
+}
+
+var batchTargets = map[int]bool{
+	ws.TARGET_COMPONENTS:   true,
+	ws.TARGET_DEPENDENCIES: true,
+	ws.TARGET_DISPLAY_NAME: true,
+}
+
+// SignalBatch applies the operations in the payload in order and in one transaction, none of them is
+// applied when one fails. The other clients get the operations in one broadcast, the client gets them
+// with the revisions they left in the ack. The user undoes a batch as one step.
+func SignalBatch(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
+	ops, err := batchOperations(message.Payload)
+	if err != nil {
+		currentClient.Feedback(message, ws.ERROR_CODE_BATCH_FAILED, err)
+		return err
+	}
+	for _, op := range ops {
+		if held, ok := lockedByOther(room, currentClient, op); ok {
+			err := lockedError(held)
+			currentClient.Feedback(message, ws.ERROR_CODE_LOCKED, err)
+			return err
+		}
+	}
+
+	appDto := app.NewAppDto()
+	appDto.ConstructWithID(currentClient.APPID)
+	audited := make(map[int]audit.Entry)
+	for i, op := range ops {
+		if entry, isAudited := auditEntry(room, currentClient, op); isAudited {
+			audited[i] = entry
+		}
+	}
+	revisions := make(stateRevisions)
+	var inverse []ws.Operation
+	var inverseErr error
+	err = room.Hub.Transaction(func(tx *ws.Hub) error {
+		for _, op := range ops {
+			if revertibleTargets[op.Target] {
+				revert, err := inverseOperations(tx, appDto, op)
+				if err != nil {
+					inverseErr = err
+				}
+				inverse = append(revert, inverse...)
+			}
+			if err := applyOperation(tx, appDto, op, revisions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return feedbackOperationError(room, message, err, ws.ERROR_CODE_BATCH_FAILED)
+	}
+	if inverseErr != nil {
+		log.Printf("[websocket-server] the batch of user %d in app %d can not be undone: %v", currentClient.MappedUserID, currentClient.APPID, inverseErr)
+	} else {
+		room.Hub.History.Record(currentClient.APPID, currentClient.MappedUserID, withRevisions(inverse, revisions))
+	}
+	for i, op := range ops {
+		if entry, isAudited := audited[i]; isAudited {
+			recordAudit(room, entry, op.Payload)
+		}
+	}
+
+	message.Broadcast = &ws.Broadcast{Type: ws.BROADCAST_TYPE_OPERATIONS, Payload: ops}
+	message.AckData = ops
+	room.BroadcastToOtherClients(message, currentClient)
+	return nil
+}
+
+// batchOperations reads the operations of the batch from the payload.
+func batchOperations(payload []interface{}) ([]ws.Operation, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var ops []ws.Operation
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, errors.New("[websocket-server] websocket protocol syntax error.")
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("[websocket-server] the batch has no operation.")
+	}
+	for _, op := range ops {
+		if !batchSignals[op.Signal] || !batchTargets[op.Target] || len(op.Payload) == 0 {
+			return nil, errUnsupportedOperation
+		}
+	}
+	return ops, nil
+}
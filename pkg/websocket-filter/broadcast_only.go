@@ -18,12 +18,12 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalBroadcastOnly(hub *ws.Hub, message *ws.Message) error {
+func SignalBroadcastOnly(room *ws.Room, message *ws.Message) error {
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	message.RewriteBroadcast()
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 	return nil
 }
@@ -24,10 +24,10 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalCreateOrUpdateState(hub *ws.Hub, message *ws.Message) error {
+func SignalCreateOrUpdateState(room *ws.Room, message *ws.Message) error {
 
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	stateType := repository.STATE_TYPE_INVALIED
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
@@ -38,128 +38,53 @@ func SignalCreateOrUpdateState(hub *ws.Hub, message *ws.Message) error {
 	case ws.TARGET_NOTNING:
 		return nil
 
-	case ws.TARGET_COMPONENTS:
-		for _, v := range message.Payload {
-			// construct TreeStateDto
-			currentNode := state.NewTreeStateDto()
-			var inDBTreeStateDto *state.TreeStateDto
-			currentNode.ConstructByMap(v)                                        // set Name
-			currentNode.ConstructByApp(appDto)                                   // set AppRefID
-			currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS) // set StateType
-
-			// check if state already in database
-			inDBTreeStateDto, _ = hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
-			if inDBTreeStateDto == nil {
-				// current state did not in database, create
-				var componentTree *repository.ComponentNode
-				componentTree = repository.ConstructComponentNodeByMap(v)
-
-				if err := hub.TreeStateServiceImpl.CreateComponentTree(appDto, 0, componentTree); err != nil {
-					currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-					return err
-				}
-			} else {
-				// hit, update it
-				// construct update data
-				componentNode := repository.ConstructComponentNodeByMap(v)
-				serializedComponent, err := componentNode.SerializationForDatabase()
-				if err != nil {
-					currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-					return err
-				}
-				currentNode.ConstructWithContent(serializedComponent)
-				inDBTreeStateDto.ConstructWithNewStateContent(currentNode)
-				if _, err := hub.TreeStateServiceImpl.UpdateTreeState(inDBTreeStateDto); err != nil {
-					currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-					return err
-				}
-			}
+	case ws.TARGET_COMPONENTS, ws.TARGET_DEPENDENCIES, ws.TARGET_DISPLAY_NAME:
+		if err := applyMessage(room, appDto, message, ws.ERROR_CREATE_OR_UPDATE_STATE_FAILED); err != nil {
+			return err
 		}
 
-	case ws.TARGET_DEPENDENCIES:
-		// dependencies can not create or update by this method
-
 	case ws.TARGET_DRAG_SHADOW:
 		// create by displayName
 		fallthrough
 
 	case ws.TARGET_DOTTED_LINE_SQUARE:
 		// fill type
-		if message.Target == ws.TARGET_DEPENDENCIES {
-			stateType = repository.KV_STATE_TYPE_DEPENDENCIES
-		} else if message.Target == ws.TARGET_DRAG_SHADOW {
-			stateType = repository.KV_STATE_TYPE_DRAG_SHADOW
-		} else {
-			stateType = repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE
-		}
+		stateType = kvStateType(message.Target)
 		// resolve
 		for _, v := range message.Payload {
 			// construct KVStateDto
 			kvStateDto := state.NewKVStateDto()
 			var inDBkvStateDto *state.KVStateDto
+			revision := takeRevision(v)
 			kvStateDto.ConstructByMap(v)
 			kvStateDto.ConstructByApp(appDto)
 			kvStateDto.ConstructWithType(stateType)
 			fmt.Printf("[DUMP] kvStateDto: %v\n", kvStateDto)
 
-			inDBkvStateDto, _ = hub.KVStateServiceImpl.GetKVStateByKey(kvStateDto)
+			inDBkvStateDto, _ = room.Hub.KVStateServiceImpl.GetKVStateByKey(kvStateDto)
 			if inDBkvStateDto == nil {
 				// current state did not in database, create
-				if _, err := hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
+				if _, err := room.Hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
 					currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
 					return err
 				}
+				message.SetRevision(kvStateDto.Key, 0)
 			} else {
-				// hit, update it
+				// hit, update it on the revision the change was made on
+				kvStateDto.ConstructWithRevision(revision)
+				if !kvStateDto.BasedOn(inDBkvStateDto.Revision) {
+					return feedbackStateError(room, message, kvStateDto.Key, repository.ErrRevisionConflict, ws.ERROR_UPDATE_STATE_FAILED)
+				}
 				kvStateDto.ConstructWithID(inDBkvStateDto.ID)
-				if err := hub.KVStateServiceImpl.UpdateKVStateByID(kvStateDto); err != nil {
-					currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-					return err
+				kvStateDto.ConstructWithRevision(inDBkvStateDto.Revision)
+				if err := room.Hub.KVStateServiceImpl.UpdateKVStateByID(kvStateDto); err != nil {
+					return feedbackStateError(room, message, kvStateDto.Key, err, ws.ERROR_UPDATE_STATE_FAILED)
 				}
+				message.SetRevision(kvStateDto.Key, kvStateDto.Revision)
 			}
 
 		}
 
-	case ws.TARGET_DISPLAY_NAME:
-		stateType = repository.SET_STATE_TYPE_DISPLAY_NAME
-		for _, v := range message.Payload {
-			var err error
-			var displayName string
-			// resolve payload
-			displayName, err = repository.ResolveDisplayNameByPayload(v)
-			fmt.Printf("[DUMP] err: %v\n", err)
-
-			if err != nil {
-				currentClient.Feedback(message, ws.ERROR_CREATE_OR_UPDATE_STATE_FAILED, err)
-				return err
-			}
-			// create or update state
-			fmt.Printf("[DUMP] displayName: %v\n", displayName)
-			// checkout
-			setStateDto := state.NewSetStateDto()
-			var setStateDtoInDB *state.SetStateDto
-			setStateDto.ConstructWithValue(displayName)
-			setStateDto.ConstructWithType(stateType)
-			setStateDto.ConstructByApp(appDto)
-			setStateDto.ConstructWithEditVersion()
-			// lookup state
-			setStateDtoInDB, _ = hub.SetStateServiceImpl.GetByValue(setStateDto)
-			if setStateDtoInDB == nil {
-				// create
-				fmt.Printf("[DUMP] setStateDto: %v\n", setStateDto)
-				if _, err = hub.SetStateServiceImpl.CreateSetState(setStateDto); err != nil {
-					currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-					return err
-				}
-			} else {
-				// update
-				setStateDtoInDB.ConstructWithValue(setStateDto.Value)
-				if _, err = hub.SetStateServiceImpl.UpdateSetState(setStateDtoInDB); err != nil {
-					currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-					return err
-				}
-			}
-		}
 	case ws.TARGET_APPS:
 		// serve on HTTP API, this signal only for broadcast
 	case ws.TARGET_RESOURCE:
@@ -169,6 +94,6 @@ func SignalCreateOrUpdateState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 	return nil
 }
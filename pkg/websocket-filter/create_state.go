@@ -15,8 +15,6 @@
 package filter
 
 import (
-	"errors"
-
 	"github.com/illa-family/builder-backend/internal/repository"
 	"github.com/illa-family/builder-backend/pkg/app"
 	"github.com/illa-family/builder-backend/pkg/state"
@@ -24,9 +22,9 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalCreateState(hub *ws.Hub, message *ws.Message) error {
+func SignalCreateState(room *ws.Room, message *ws.Message) error {
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	stateType := repository.STATE_TYPE_INVALIED
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
@@ -36,39 +34,11 @@ func SignalCreateState(hub *ws.Hub, message *ws.Message) error {
 	switch message.Target {
 	case ws.TARGET_NOTNING:
 		return nil
-	case ws.TARGET_COMPONENTS:
-		// build component tree from json
-		for _, v := range message.Payload {
-			componentTree := repository.ConstructComponentNodeByMap(v)
-			if err := hub.TreeStateServiceImpl.CreateComponentTree(appDto, 0, componentTree); err != nil {
-				currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-				return err
-			}
+	case ws.TARGET_COMPONENTS, ws.TARGET_DEPENDENCIES, ws.TARGET_DISPLAY_NAME:
+		if err := applyMessage(room, appDto, message, ws.ERROR_CREATE_STATE_FAILED); err != nil {
+			return err
 		}
 
-	case ws.TARGET_DEPENDENCIES:
-		stateType = repository.KV_STATE_TYPE_DEPENDENCIES
-		// create k-v state
-		for _, v := range message.Payload {
-			subv, ok := v.(map[string]interface{})
-			if !ok {
-				err := errors.New("K-V State reflect failed, please check your input.")
-				return err
-			}
-			for key, depState := range subv {
-				// fill KVStateDto
-				kvStateDto := state.NewKVStateDto()
-				kvStateDto.ConstructWithKey(key)
-				kvStateDto.ConstructForDependenciesState(depState)
-				kvStateDto.ConstructByApp(appDto) // set AppRefID
-				kvStateDto.ConstructWithType(stateType)
-
-				if _, err := hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
-					currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-					return err
-				}
-			}
-		}
 	case ws.TARGET_DRAG_SHADOW:
 		fallthrough
 
@@ -87,28 +57,7 @@ func SignalCreateState(hub *ws.Hub, message *ws.Message) error {
 			kvStateDto.ConstructByApp(appDto) // set AppRefID
 			kvStateDto.ConstructWithType(stateType)
 
-			if _, err := hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-				return err
-			}
-		}
-
-	case ws.TARGET_DISPLAY_NAME:
-		stateType = repository.SET_STATE_TYPE_DISPLAY_NAME
-		// create set state
-		for _, v := range message.Payload {
-			// resolve payload
-			displayName, err := repository.ResolveDisplayNameByPayload(v)
-			if err != nil {
-				return err
-			}
-			// save state
-			setStateDto := state.NewSetStateDto()
-			setStateDto.ConstructByApp(appDto) // set AppRefID
-			setStateDto.ConstructWithValue(displayName)
-			setStateDto.ConstructWithType(stateType)
-			// create state
-			if _, err := hub.SetStateServiceImpl.CreateSetState(setStateDto); err != nil {
+			if _, err := room.Hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
 				currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
 				return err
 			}
@@ -123,6 +72,6 @@ func SignalCreateState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 	return nil
 }
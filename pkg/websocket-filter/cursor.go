@@ -0,0 +1,33 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"errors"
+
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+)
+
+// SignalCursor relays the cursor and selection to the other editors, it is throttled and never persisted.
+func SignalCursor(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
+	if len(message.Payload) == 0 {
+		err := errors.New("[websocket-server] websocket protocol syntax error.")
+		currentClient.Feedback(message, ws.ERROR_CODE_FAILED, err)
+		return err
+	}
+	room.Cursor(currentClient, message.Payload[0])
+	return nil
+}
@@ -22,10 +22,10 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalDeleteState(hub *ws.Hub, message *ws.Message) error {
+func SignalDeleteState(room *ws.Room, message *ws.Message) error {
 
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	stateType := repository.STATE_TYPE_INVALIED
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
@@ -35,17 +35,9 @@ func SignalDeleteState(hub *ws.Hub, message *ws.Message) error {
 	switch message.Target {
 	case ws.TARGET_NOTNING:
 		return nil
-	case ws.TARGET_COMPONENTS:
-		for _, v := range message.Payload {
-			currentNode := state.NewTreeStateDto()
-			currentNode.ConstructWithDisplayNameForDelete(v) // set Name
-			currentNode.ConstructByApp(appDto)               // set AppRefID
-			currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
-
-			if err := hub.TreeStateServiceImpl.DeleteTreeStateNodeRecursive(currentNode); err != nil {
-				currentClient.Feedback(message, ws.ERROR_DELETE_STATE_FAILED, err)
-				return err
-			}
+	case ws.TARGET_COMPONENTS, ws.TARGET_DISPLAY_NAME:
+		if err := applyMessage(room, appDto, message, ws.ERROR_DELETE_STATE_FAILED); err != nil {
+			return err
 		}
 
 	case ws.TARGET_DEPENDENCIES:
@@ -65,33 +57,16 @@ func SignalDeleteState(hub *ws.Hub, message *ws.Message) error {
 		for _, v := range message.Payload {
 			// fill KVStateDto
 			kvStateDto := state.NewKVStateDto()
-			kvStateDto.ConstructWithDisplayNameForDelete(v)
+			kvStateDto.ConstructWithRevision(takeRevision(v))
+			kvStateDto.ConstructWithDisplayNameForDelete(componentDisplayName(v))
 			kvStateDto.ConstructByApp(appDto) // set AppRefID
 			kvStateDto.ConstructWithType(stateType)
 
-			if err := hub.KVStateServiceImpl.DeleteKVStateByKey(kvStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_DELETE_STATE_FAILED, err)
-				return err
+			if err := room.Hub.KVStateServiceImpl.DeleteKVStateByKey(kvStateDto); err != nil {
+				return feedbackStateError(room, message, kvStateDto.Key, err, ws.ERROR_DELETE_STATE_FAILED)
 			}
 		}
 
-	case ws.TARGET_DISPLAY_NAME:
-		stateType = repository.SET_STATE_TYPE_DISPLAY_NAME
-		// delete set state
-		for _, v := range message.Payload {
-
-			// init
-			setStateDto := state.NewSetStateDto()
-			setStateDto.ConstructWithDisplayNameForDelete(v)
-			setStateDto.ConstructWithType(stateType)
-			setStateDto.ConstructByApp(appDto)
-			setStateDto.ConstructWithEditVersion()
-			// delete state
-			if err := hub.SetStateServiceImpl.DeleteSetStateByValue(setStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
-				return err
-			}
-		}
 	case ws.TARGET_APPS:
 		// serve on HTTP API, this signal only for broadcast
 	case ws.TARGET_RESOURCE:
@@ -101,6 +76,6 @@ func SignalDeleteState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 	return nil
 }
@@ -18,12 +18,11 @@ import (
 	"errors"
 
 	ws "github.com/illa-family/builder-backend/internal/websocket"
-	"github.com/illa-family/builder-backend/pkg/user"
 )
 
-func SignalEnter(hub *ws.Hub, message *ws.Message) error {
+func SignalEnter(room *ws.Room, message *ws.Message) error {
 	// init
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	var ok bool
 	if len(message.Payload) == 0 {
 		err := errors.New("[websocket-server] websocket protocol syntax error.")
@@ -38,25 +37,39 @@ func SignalEnter(hub *ws.Hub, message *ws.Message) error {
 	}
 	token, _ := authToken["authToken"].(string)
 
-	// convert authToken to uid
-	userID, extractErr := user.ExtractUserIDFromToken(token)
-	if extractErr != nil {
-		return extractErr
-	}
-	validAccessToken, validaAccessErr := user.ValidateAccessToken(token)
+	// convert authToken to uid, the revoked token will be rejected
+	userID, validaAccessErr := room.Hub.TokenServiceImpl.ValidateAccessToken(token)
 	if validaAccessErr != nil {
 		currentClient.Feedback(message, ws.ERROR_CODE_LOGIN_FAILED, validaAccessErr)
 		return validaAccessErr
 	}
-	if !validAccessToken {
-		err := errors.New("[websocket-server] access token invalied.")
-		currentClient.Feedback(message, ws.ERROR_CODE_LOGIN_FAILED, err)
-		return err
+	// check if user can edit current app, the dashboard room has no app
+	if currentClient.APPID != ws.DEAULT_APP_ID {
+		editable, err := room.Hub.AppServiceImpl.IsAppEditableByUser(currentClient.APPID, userID)
+		if err != nil {
+			currentClient.Feedback(message, ws.ERROR_CODE_PERMISSION_DENIED, err)
+			return err
+		}
+		if !editable {
+			err := errors.New("[websocket-server] you have no permission to edit this app.")
+			currentClient.Feedback(message, ws.ERROR_CODE_PERMISSION_DENIED, err)
+			return err
+		}
 	}
 	// assign logged in and mapped user id
 	currentClient.IsLoggedIn = true
 	currentClient.MappedUserID = userID
+	if room.Hub.UserServiceImpl != nil {
+		if userDto, err := room.Hub.UserServiceImpl.GetUser(userID); err == nil {
+			currentClient.Nickname = userDto.Nickname
+		}
+	}
 	currentClient.Feedback(message, ws.ERROR_CODE_LOGGEDIN, nil)
+	// the reconnected client sends the last broadcast it got without a gap and resumes from it
+	if lastSequence, ok := authToken["lastSequence"].(float64); ok {
+		room.Resume(currentClient, message, int64(lastSequence))
+	}
+	room.Join(currentClient)
 	return nil
 
 }
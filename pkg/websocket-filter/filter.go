@@ -15,69 +15,123 @@
 package filter
 
 import (
+	"errors"
+	"time"
+
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
 // @todo: the client should check userID, make sure do not broadcast to self.
+// Run routes the events of the hub to the rooms, the signals are handled by the goroutine of each room.
 func Run(hub *ws.Hub) {
+	heartbeat := time.NewTicker(ws.PRESENCE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	hub.Heartbeat()
 	for {
 		select {
 		// handle register event
 		case client := <-hub.Register:
-			hub.Clients[client.ID] = client
+			if room, created := hub.JoinRoom(client); created {
+				go RunRoom(room)
+			}
 		// handle unregister events
 		case client := <-hub.Unregister:
-			if _, ok := hub.Clients[client.ID]; ok {
-				delete(hub.Clients, client.ID)
-				close(client.Send)
-			}
+			hub.LeaveRoom(client)
+		// kick clients which did not enter before the deadline
+		case client := <-hub.AuthTimeout:
+			hub.RouteToRoom(client.ID, &ws.RoomEvent{Kind: ws.ROOM_EVENT_AUTH_TIMEOUT, Client: client})
 		// handle all hub broadcast events
 		case message := <-hub.Broadcast:
-			for _, client := range hub.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(hub.Clients, client.ID)
-				}
+			for _, room := range hub.Rooms {
+				room.Events <- &ws.RoomEvent{Kind: ws.ROOM_EVENT_BROADCAST, Data: message, Policy: ws.SLOW_CONSUMER_DISCONNECT}
 			}
 		// handle client on message event
 		case message := <-hub.OnMessage:
-			SignalFilter(hub, message)
+			hub.RouteMessage(message)
+		// handle the envelopes from the other server instances
+		case envelope, ok := <-hub.Inbound:
+			if !ok {
+				// the backplane was closed, the hub runs alone
+				hub.Inbound = nil
+				continue
+			}
+			hub.OnEnvelope(envelope)
+		case <-heartbeat.C:
+			hub.Heartbeat()
 		}
-
 	}
 }
 
-func SignalFilter(hub *ws.Hub, message *ws.Message) error {
+// RunRoom handles the events of the room until the hub closes it.
+func RunRoom(room *ws.Room) {
+	room.Run(SignalFilter)
+}
+
+func SignalFilter(room *ws.Room, message *ws.Message) error {
+	// the client may already left
+	currentClient, hit := room.Clients[message.ClientID]
+	if !hit {
+		return errors.New("[websocket-server] client not found.")
+	}
+	if err := AuthFilter(room, currentClient, message); err != nil {
+		return err
+	}
+	if err := LockFilter(room, currentClient, message); err != nil {
+		return err
+	}
 	switch message.Signal {
 	case ws.SIGNAL_PING:
-		return SignalPing(hub, message)
+		return SignalPing(room, message)
 	case ws.SIGNAL_ENTER:
-		return SignalEnter(hub, message)
+		return SignalEnter(room, message)
 	case ws.SIGNAL_LEAVE:
-		return SignalLeave(hub, message)
+		return SignalLeave(room, message)
 	case ws.SIGNAL_CREATE_STATE:
-		return SignalCreateState(hub, message)
+		return AuditFilter(room, message, HistoryFilter(SignalCreateState))
 	case ws.SIGNAL_DELETE_STATE:
-		return SignalDeleteState(hub, message)
+		return AuditFilter(room, message, HistoryFilter(SignalDeleteState))
 	case ws.SIGNAL_UPDATE_STATE:
-		return SignalUpdateState(hub, message)
+		return AuditFilter(room, message, HistoryFilter(SignalUpdateState))
 	case ws.SIGNAL_MOVE_STATE:
-		return SignalMoveState(hub, message)
+		return AuditFilter(room, message, HistoryFilter(SignalMoveState))
 	case ws.SIGNAL_CREATE_OR_UPDATE_STATE:
-		return SignalCreateOrUpdateState(hub, message)
+		return AuditFilter(room, message, HistoryFilter(SignalCreateOrUpdateState))
 	case ws.SIGNAL_ONLY_BROADCAST:
-		return SignalBroadcastOnly(hub, message)
+		return SignalBroadcastOnly(room, message)
 	case ws.SIGNAL_PUT_STATE:
-		return SignalPutState(hub, message)
+		return AuditFilter(room, message, SignalPutState)
+	case ws.SIGNAL_CURSOR:
+		return SignalCursor(room, message)
+	case ws.SIGNAL_LOCK:
+		return SignalLock(room, message)
+	case ws.SIGNAL_UNLOCK:
+		return SignalUnlock(room, message)
+	case ws.SIGNAL_UNDO:
+		return SignalUndo(room, message)
+	case ws.SIGNAL_REDO:
+		return SignalRedo(room, message)
+	case ws.SIGNAL_BATCH:
+		return SignalBatch(room, message)
 	default:
 		return nil
 
 	}
+}
+
+// AuthFilter rejects all signals except ping, enter and leave from the client which not entered yet.
+func AuthFilter(room *ws.Room, client *ws.Client, message *ws.Message) error {
+	switch message.Signal {
+	case ws.SIGNAL_PING, ws.SIGNAL_ENTER, ws.SIGNAL_LEAVE:
+		return nil
+	}
+	if !client.IsLoggedIn {
+		err := errors.New("[websocket-server] please enter the room first.")
+		client.Feedback(message, ws.ERROR_CODE_NEED_ENTER, err)
+		return err
+	}
 	return nil
 }
 
-func OptionFilter(hub *ws.Hub, client *ws.Client, message *ws.Message) error {
+func OptionFilter(room *ws.Room, client *ws.Client, message *ws.Message) error {
 	return nil
 }
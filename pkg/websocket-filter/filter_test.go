@@ -0,0 +1,1041 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/internal/util"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/backplane"
+	"github.com/illa-family/builder-backend/pkg/smtp"
+	"github.com/illa-family/builder-backend/pkg/state"
+	"github.com/illa-family/builder-backend/pkg/user"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+
+	gws "github.com/gorilla/websocket"
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+)
+
+// pipeListener serves the websocket connections over in-memory pipes.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "pipe", Net: "pipe"}
+}
+
+func (l *pipeListener) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type notRevokedTokens struct {
+	repository.RevokedTokenRepository
+}
+
+func (notRevokedTokens) IsRevoked(tokenIDs []string) (bool, error) {
+	return false, nil
+}
+
+// memoryKVStates keeps the edit version of the k-v states, the updates are checked against the
+// revision as the database does.
+type memoryKVStates struct {
+	repository.KVStateRepository
+	mutex  sync.Mutex
+	states map[string]*repository.KVState
+}
+
+func (m *memoryKVStates) RetrieveEditVersionByAppAndKey(apprefid int, statetype int, key string) (*repository.KVState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kvstate, ok := m.states[key]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	stored := *kvstate
+	return &stored, nil
+}
+
+func (m *memoryKVStates) Update(kvstate *repository.KVState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored, ok := m.states[kvstate.Key]
+	if !ok || stored.Revision != kvstate.Revision {
+		return repository.ErrRevisionConflict
+	}
+	kvstate.Revision++
+	updated := *kvstate
+	m.states[kvstate.Key] = &updated
+	return nil
+}
+
+// memoryAuditLogs keeps the appended audit logs.
+type memoryAuditLogs struct {
+	repository.AuditLogRepository
+	mutex sync.Mutex
+	logs  []repository.AuditLog
+}
+
+func (m *memoryAuditLogs) Create(auditLog *repository.AuditLog) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.logs = append(m.logs, *auditLog)
+	return len(m.logs), nil
+}
+
+func (m *memoryAuditLogs) operations() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	operations := make([]string, 0, len(m.logs))
+	for _, log := range m.logs {
+		operations = append(operations, log.Operation)
+	}
+	return operations
+}
+
+// memorySetStates keeps the display names by value, the values are unique as in the database.
+type memorySetStates struct {
+	repository.SetStateRepository
+	mutex  sync.Mutex
+	nextID int
+	states map[string]*repository.SetState
+}
+
+func (m *memorySetStates) Create(setState *repository.SetState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.states[setState.Value]; ok {
+		return errors.New("duplicate key value")
+	}
+	m.nextID++
+	setState.ID = m.nextID
+	created := *setState
+	m.states[setState.Value] = &created
+	return nil
+}
+
+func (m *memorySetStates) RetrieveByValue(setState *repository.SetState) (*repository.SetState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored, ok := m.states[setState.Value]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	retrieved := *stored
+	return &retrieved, nil
+}
+
+func (m *memorySetStates) DeleteByValue(setState *repository.SetState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.states, setState.Value)
+	return nil
+}
+
+func (m *memorySetStates) Update(setState *repository.SetState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for value, stored := range m.states {
+		if stored.ID != setState.ID {
+			continue
+		}
+		if stored.Revision != setState.Revision {
+			return repository.ErrRevisionConflict
+		}
+		setState.Revision++
+		updated := *setState
+		delete(m.states, value)
+		m.states[setState.Value] = &updated
+		return nil
+	}
+	return repository.ErrRevisionConflict
+}
+
+func (m *memorySetStates) values() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	values := make([]string, 0, len(m.states))
+	for value := range m.states {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// memoryUnitOfWork restores the display names when the transaction fails.
+type memoryUnitOfWork struct {
+	setStates *memorySetStates
+}
+
+func (uow memoryUnitOfWork) Do(fn func(repositories *repository.Repositories) error) error {
+	uow.setStates.mutex.Lock()
+	saved := make(map[string]*repository.SetState, len(uow.setStates.states))
+	for value, setState := range uow.setStates.states {
+		saved[value] = setState
+	}
+	uow.setStates.mutex.Unlock()
+	if err := fn(&repository.Repositories{SetState: uow.setStates}); err != nil {
+		uow.setStates.mutex.Lock()
+		uow.setStates.states = saved
+		uow.setStates.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryTreeStates keeps the edit version of the components by id, the updates are checked against
+// the revision as the database does.
+type memoryTreeStates struct {
+	repository.TreeStateRepository
+	mutex  sync.Mutex
+	states map[int]*repository.TreeState
+}
+
+func (m *memoryTreeStates) Create(treestate *repository.TreeState) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	treestate.ID = len(m.states) + 1
+	created := *treestate
+	m.states[treestate.ID] = &created
+	return treestate.ID, nil
+}
+
+func (m *memoryTreeStates) RetrieveByID(treestateID int) (*repository.TreeState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stored, ok := m.states[treestateID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	retrieved := *stored
+	return &retrieved, nil
+}
+
+func (m *memoryTreeStates) RetrieveEditVersionByAppAndName(apprefid int, statetype int, name string) (*repository.TreeState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, stored := range m.states {
+		if stored.Name == name {
+			retrieved := *stored
+			return &retrieved, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *memoryTreeStates) Update(treestate *repository.TreeState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if stored, ok := m.states[treestate.ID]; !ok || stored.Revision != treestate.Revision {
+		return repository.ErrRevisionConflict
+	}
+	treestate.Revision++
+	updated := *treestate
+	m.states[treestate.ID] = &updated
+	return nil
+}
+
+func (m *memoryTreeStates) parentOf(name string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, stored := range m.states {
+		if stored.Name == name {
+			if parent, ok := m.states[stored.ParentNodeRefID]; ok {
+				return parent.Name
+			}
+		}
+	}
+	return ""
+}
+
+// memoryApps are the apps by id.
+type memoryApps struct {
+	repository.AppRepository
+	apps map[int]*repository.App
+}
+
+func (m memoryApps) RetrieveAppByID(appID int) (*repository.App, error) {
+	app, ok := m.apps[appID]
+	if !ok {
+		return &repository.App{}, gorm.ErrRecordNotFound
+	}
+	return app, nil
+}
+
+// memoryAppMembers are the app members by app and user id.
+type memoryAppMembers struct {
+	repository.AppMemberRepository
+	members map[[2]int]*repository.AppMember
+}
+
+func (m memoryAppMembers) RetrieveByAppAndUser(appID, userID int) (*repository.AppMember, error) {
+	return m.members[[2]int{appID, userID}], nil
+}
+
+type testConn struct {
+	t       *testing.T
+	conn    *gws.Conn
+	pending []ws.Feedback
+}
+
+func (c *testConn) send(message map[string]interface{}) {
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	assert.Nil(c.t, c.conn.WriteJSON(message))
+}
+
+// next returns the next feedback but the presence broadcasts, which are sent on every enter.
+func (c *testConn) next() ws.Feedback {
+	for {
+		feedback := c.read()
+		if feedback.Broadcast == nil || feedback.Broadcast.Type != ws.BROADCAST_TYPE_PRESENCE {
+			return feedback
+		}
+	}
+}
+
+// nextPresence returns the users of the next presence broadcast.
+func (c *testConn) nextPresence() []ws.PresenceUser {
+	var users []ws.PresenceUser
+	c.nextBroadcast(ws.BROADCAST_TYPE_PRESENCE, &users)
+	return users
+}
+
+// nextBroadcast decodes the payload of the next broadcast of the type.
+func (c *testConn) nextBroadcast(broadcastType string, payload interface{}) {
+	for {
+		feedback := c.read()
+		if feedback.Broadcast != nil && feedback.Broadcast.Type == broadcastType {
+			b, _ := json.Marshal(feedback.Broadcast.Payload)
+			assert.Nil(c.t, json.Unmarshal(b, payload))
+			return
+		}
+	}
+}
+
+// read returns the next feedback, the server may batch several in one frame.
+func (c *testConn) read() ws.Feedback {
+	for len(c.pending) == 0 {
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("read feedback error: %v", err)
+		}
+		for _, line := range bytes.Split(frame, []byte{'\n'}) {
+			var feedback ws.Feedback
+			assert.Nil(c.t, json.Unmarshal(line, &feedback))
+			c.pending = append(c.pending, feedback)
+		}
+	}
+	feedback := c.pending[0]
+	c.pending = c.pending[1:]
+	return feedback
+}
+
+func (c *testConn) enter(userID int) ws.Feedback {
+	token, err := user.CreateAccessToken(userID, "session")
+	assert.Nil(c.t, err)
+	c.send(map[string]interface{}{"signal": ws.SIGNAL_ENTER, "payload": []interface{}{map[string]interface{}{"authToken": token}}})
+	return c.next()
+}
+
+// startDashboard runs a dashboard hub, the dashboard room has no app to check the permission of.
+func startDashboard(t *testing.T, configure ...func(hub *ws.Hub)) func() *testConn {
+	return startRoom(t, ws.DEAULT_APP_ID, configure...)
+}
+
+// startRoom runs a hub whose clients connect to the room of the app.
+func startRoom(t *testing.T, appID int, configure ...func(hub *ws.Hub)) func() *testConn {
+	t.Setenv("ILLA_SECRET_KEY", "websocket-filter-test")
+	hub := ws.NewHub()
+	hub.SetTokenServiceImpl(user.NewTokenServiceImpl(util.NewSugardLogger(), nil, notRevokedTokens{}, nil))
+	for _, c := range configure {
+		c(hub)
+	}
+	go Run(hub)
+
+	listener := newPipeListener()
+	upgrader := gws.Upgrader{}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := ws.NewClient(hub, conn, ws.DEAULT_INSTANCE_ID, appID)
+		hub.Register <- client
+		go client.WritePump()
+		go client.ReadPump()
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	dialer := &gws.Dialer{NetDialContext: listener.Dial}
+	return func() *testConn {
+		conn, _, err := dialer.Dial("ws://pipe/room/SELF_HOST/dashboard", nil)
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &testConn{t: t, conn: conn}
+	}
+}
+
+func broadcastOnly(broadcastType string) map[string]interface{} {
+	return map[string]interface{}{
+		"signal":    ws.SIGNAL_ONLY_BROADCAST,
+		"target":    ws.TARGET_APPS,
+		"option":    ws.OPTION_BROADCAST_ROOM,
+		"payload":   []interface{}{},
+		"broadcast": map[string]interface{}{"type": broadcastType, "payload": map[string]interface{}{}},
+	}
+}
+
+func TestSignalsRequireEnter(t *testing.T) {
+	dial := startDashboard(t)
+	conn := dial()
+
+	conn.send(map[string]interface{}{"signal": ws.SIGNAL_PING, "payload": []interface{}{}})
+	assert.Equal(t, ws.ERROR_CODE_PONG, conn.next().ErrorCode)
+
+	conn.send(broadcastOnly("apps/addDashboardAppReducer"))
+	assert.Equal(t, ws.ERROR_CODE_NEED_ENTER, conn.next().ErrorCode)
+
+	conn.send(map[string]interface{}{"signal": ws.SIGNAL_ENTER, "payload": []interface{}{map[string]interface{}{"authToken": "invalid"}}})
+	assert.Equal(t, ws.ERROR_CODE_LOGIN_FAILED, conn.next().ErrorCode)
+
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, conn.enter(1).ErrorCode)
+}
+
+func TestEnterRequiresEditPermission(t *testing.T) {
+	apps := memoryApps{apps: map[int]*repository.App{7: {ID: 7, Name: "orders", CreatedBy: 1}}}
+	members := memoryAppMembers{
+		members: map[[2]int]*repository.AppMember{
+			{7, 2}: {AppRefID: 7, UserID: 2, Role: repository.APP_ROLE_EDITOR},
+			{7, 3}: {AppRefID: 7, UserID: 3, Role: repository.APP_ROLE_VIEWER},
+		},
+	}
+	dial := startRoom(t, 7, func(hub *ws.Hub) {
+		hub.SetAppServiceImpl(app.NewAppServiceImpl(util.NewSugardLogger(), apps, nil, nil, nil, nil, nil, nil, nil, nil,
+			members, nil, smtp.SMTPServer{}))
+	})
+
+	// the owner and the editors enter, the viewers and the others can not
+	for userID, code := range map[int]int{1: ws.ERROR_CODE_LOGGEDIN, 2: ws.ERROR_CODE_LOGGEDIN, 3: ws.ERROR_CODE_PERMISSION_DENIED, 4: ws.ERROR_CODE_PERMISSION_DENIED} {
+		assert.Equal(t, code, dial().enter(userID).ErrorCode, "user %d", userID)
+	}
+
+	// the client which failed to enter can not change the states
+	viewer := dial()
+	assert.Equal(t, ws.ERROR_CODE_PERMISSION_DENIED, viewer.enter(3).ErrorCode)
+	viewer.send(map[string]interface{}{
+		"signal":    ws.SIGNAL_DELETE_STATE,
+		"target":    ws.TARGET_COMPONENTS,
+		"payload":   []interface{}{map[string]interface{}{"displayName": "button1"}},
+		"broadcast": map[string]interface{}{"type": "components/deleteComponentReducer", "payload": map[string]interface{}{}},
+	})
+	assert.Equal(t, ws.ERROR_CODE_NEED_ENTER, viewer.next().ErrorCode)
+}
+
+func TestBroadcastToOtherClients(t *testing.T) {
+	dial := startDashboard(t)
+	sender, receiver := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, sender.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, receiver.enter(2).ErrorCode)
+
+	sender.send(broadcastOnly("apps/addDashboardAppReducer"))
+	feedback := receiver.next()
+	assert.Equal(t, ws.ERROR_CODE_BROADCAST, feedback.ErrorCode)
+	assert.Equal(t, "apps/addDashboardAppReducer/remote", feedback.Broadcast.Type)
+	assert.Equal(t, int64(1), feedback.Sequence)
+
+	// the sender does not receive its own broadcast, only its sequence
+	ack := sender.next()
+	assert.Equal(t, ws.ERROR_CODE_ACK, ack.ErrorCode)
+	assert.Nil(t, ack.Broadcast)
+	assert.Equal(t, int64(1), ack.Sequence)
+	sender.send(broadcastOnly("apps/removeDashboardAppReducer"))
+	assert.Equal(t, int64(2), sender.next().Sequence)
+	assert.Equal(t, int64(2), receiver.next().Sequence)
+}
+
+func TestLeaveClosesConnection(t *testing.T) {
+	dial := startDashboard(t)
+	conn := dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, conn.enter(1).ErrorCode)
+	conn.send(map[string]interface{}{"signal": ws.SIGNAL_LEAVE, "payload": []interface{}{}})
+	conn.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.conn.ReadMessage()
+	assert.True(t, gws.IsCloseError(err, gws.CloseNoStatusReceived, gws.CloseNormalClosure), "unexpected error %v", err)
+}
+
+func TestSlowConsumerIsDisconnected(t *testing.T) {
+	dial := startDashboard(t)
+	sender, slow := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, sender.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, slow.enter(2).ErrorCode)
+
+	// the slow client stops reading, its queue fills up
+	for i := 0; i < ws.CLIENT_QUEUE_SIZE+64; i++ {
+		sender.send(broadcastOnly("apps/updateDashboardAppReducer"))
+		assert.Equal(t, ws.ERROR_CODE_ACK, sender.next().ErrorCode)
+	}
+	// the other clients are still served
+	sender.send(map[string]interface{}{"signal": ws.SIGNAL_PING, "payload": []interface{}{}})
+	assert.Equal(t, ws.ERROR_CODE_PONG, sender.next().ErrorCode)
+
+	// the slow client reads what was written before the disconnection, then the connection is gone
+	slow.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = slow.conn.ReadMessage()
+	}
+	netErr, isNetErr := err.(net.Error)
+	assert.False(t, isNetErr && netErr.Timeout(), "the slow client was not disconnected")
+}
+
+func TestBackplaneResyncDisconnectsClients(t *testing.T) {
+	b := backplane.NewMemoryBackplane()
+	dial := startDashboard(t, func(hub *ws.Hub) { hub.SetBackplane("app", b) })
+	conn := dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, conn.enter(1).ErrorCode)
+
+	assert.Nil(t, b.Publish(&backplane.Envelope{Topic: "app", Kind: backplane.KIND_RESYNC}))
+	conn.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = conn.conn.ReadMessage()
+	}
+	netErr, isNetErr := err.(net.Error)
+	assert.False(t, isNetErr && netErr.Timeout(), "the client was not disconnected")
+}
+
+func TestPresenceAndCursor(t *testing.T) {
+	dial := startDashboard(t)
+	first, second := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, first.enter(1).ErrorCode)
+	assert.Equal(t, []ws.PresenceUser{{UserID: 1, Color: ws.PresenceColor(1)}}, first.nextPresence())
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, second.enter(2).ErrorCode)
+	both := []ws.PresenceUser{{UserID: 1, Color: ws.PresenceColor(1)}, {UserID: 2, Color: ws.PresenceColor(2)}}
+	assert.Equal(t, both, first.nextPresence())
+	assert.Equal(t, both, second.nextPresence())
+
+	first.send(map[string]interface{}{"signal": ws.SIGNAL_CURSOR, "payload": []interface{}{map[string]interface{}{"x": 10, "y": 20}}})
+	feedback := second.next()
+	assert.Equal(t, ws.BROADCAST_TYPE_CURSOR, feedback.Broadcast.Type)
+	cursor := feedback.Broadcast.Payload.(map[string]interface{})
+	assert.Equal(t, float64(1), cursor["userID"])
+	assert.Equal(t, map[string]interface{}{"x": float64(10), "y": float64(20)}, cursor["cursor"])
+
+	first.send(map[string]interface{}{"signal": ws.SIGNAL_CURSOR, "payload": []interface{}{}})
+	assert.Equal(t, ws.ERROR_CODE_FAILED, first.next().ErrorCode)
+
+	second.send(map[string]interface{}{"signal": ws.SIGNAL_LEAVE, "payload": []interface{}{}})
+	assert.Equal(t, []ws.PresenceUser{{UserID: 1, Color: ws.PresenceColor(1)}}, first.nextPresence())
+}
+
+func TestLockedComponentsRejectChanges(t *testing.T) {
+	dial := startDashboard(t)
+	holder, other := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, holder.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, other.enter(2).ErrorCode)
+
+	holder.send(map[string]interface{}{"signal": ws.SIGNAL_LOCK, "payload": []interface{}{"button1"}})
+	var locks []ws.Lock
+	other.nextBroadcast(ws.BROADCAST_TYPE_LOCK, &locks)
+	if assert.Len(t, locks, 1) {
+		assert.Equal(t, "button1", locks[0].DisplayName)
+		assert.Equal(t, 1, locks[0].UserID)
+	}
+
+	other.send(map[string]interface{}{"signal": ws.SIGNAL_LOCK, "payload": []interface{}{"button1"}})
+	assert.Equal(t, ws.ERROR_CODE_LOCKED, other.next().ErrorCode)
+	other.send(map[string]interface{}{
+		"signal":    ws.SIGNAL_UPDATE_STATE,
+		"target":    ws.TARGET_COMPONENTS,
+		"payload":   []interface{}{map[string]interface{}{"displayName": "button1"}},
+		"broadcast": map[string]interface{}{"type": "components/updateComponentPropsReducer", "payload": map[string]interface{}{}},
+	})
+	assert.Equal(t, ws.ERROR_CODE_LOCKED, other.next().ErrorCode)
+
+	// the lock is released when its holder leaves
+	holder.send(map[string]interface{}{"signal": ws.SIGNAL_LEAVE, "payload": []interface{}{}})
+	other.nextBroadcast(ws.BROADCAST_TYPE_LOCK, &locks)
+	assert.Len(t, locks, 0)
+}
+
+func TestLockedDescendantsRejectChanges(t *testing.T) {
+	treeStates := &memoryTreeStates{states: map[int]*repository.TreeState{
+		1: {ID: 1, Name: repository.TREE_STATE_ROOTDSL_NAME, ChildrenNodeRefIDs: "[2]"},
+		2: {ID: 2, Name: "container1", ParentNodeRefID: 1, ChildrenNodeRefIDs: "[3]"},
+		3: {ID: 3, Name: "button1", ParentNodeRefID: 2, ChildrenNodeRefIDs: "[]"},
+	}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetTreeStateServiceImpl(state.NewTreeStateServiceImpl(util.NewSugardLogger(), treeStates))
+	})
+	holder, other := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, holder.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, other.enter(2).ErrorCode)
+	holder.send(map[string]interface{}{"signal": ws.SIGNAL_LOCK, "payload": []interface{}{"button1"}})
+	var locks []ws.Lock
+	other.nextBroadcast(ws.BROADCAST_TYPE_LOCK, &locks)
+
+	// deleting or moving the container changes the locked button in it
+	for _, signal := range []int{ws.SIGNAL_DELETE_STATE, ws.SIGNAL_MOVE_STATE} {
+		other.send(map[string]interface{}{
+			"signal":    signal,
+			"target":    ws.TARGET_COMPONENTS,
+			"payload":   []interface{}{map[string]interface{}{"displayName": "container1", "parentNode": "root"}},
+			"broadcast": map[string]interface{}{"type": "components/deleteComponentReducer", "payload": map[string]interface{}{}},
+		})
+		assert.Equal(t, ws.ERROR_CODE_LOCKED, other.next().ErrorCode, "signal %d", signal)
+	}
+
+	// so does renaming the locked button
+	other.send(map[string]interface{}{
+		"signal":    ws.SIGNAL_UPDATE_STATE,
+		"target":    ws.TARGET_DISPLAY_NAME,
+		"payload":   []interface{}{map[string]interface{}{"before": "button1", "after": "button2"}},
+		"broadcast": map[string]interface{}{"type": "displayName/updateDisplayNameReducer", "payload": map[string]interface{}{}},
+	})
+	assert.Equal(t, ws.ERROR_CODE_LOCKED, other.next().ErrorCode)
+	assert.Len(t, treeStates.states, 3)
+}
+
+func TestStaleUpdateIsRejectedWithStoredState(t *testing.T) {
+	kvstates := &memoryKVStates{states: map[string]*repository.KVState{
+		"button1": {ID: 1, Key: "button1", Value: `{"displayName":"button1","x":1}`},
+	}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetKVStateServiceImpl(state.NewKVStateServiceImpl(util.NewSugardLogger(), kvstates))
+	})
+	first, second := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, first.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, second.enter(2).ErrorCode)
+	update := func(x, revision int) map[string]interface{} {
+		return map[string]interface{}{
+			"signal":    ws.SIGNAL_UPDATE_STATE,
+			"target":    ws.TARGET_DOTTED_LINE_SQUARE,
+			"payload":   []interface{}{map[string]interface{}{"displayName": "button1", "x": x, "revision": revision}},
+			"broadcast": map[string]interface{}{"type": "dottedLineSquare/updateDottedLineSquareReducer", "payload": map[string]interface{}{}},
+		}
+	}
+
+	// both clients change the revision they loaded, the first change wins
+	first.send(update(2, 0))
+	feedback := second.next()
+	assert.Equal(t, ws.ERROR_CODE_BROADCAST, feedback.ErrorCode)
+	assert.Equal(t, map[string]int{"button1": 1}, feedback.Revisions)
+	second.send(update(3, 0))
+	feedback = second.next()
+	assert.Equal(t, ws.ERROR_CODE_REVISION_CONFLICT, feedback.ErrorCode)
+	assert.Equal(t, map[string]interface{}{
+		"target":   float64(ws.TARGET_DOTTED_LINE_SQUARE),
+		"key":      "button1",
+		"revision": float64(1),
+		"value":    map[string]interface{}{"displayName": "button1", "x": float64(2)},
+	}, feedback.Data)
+
+	// the change made again on the stored revision is applied
+	second.send(update(3, 1))
+	assert.Equal(t, map[string]int{"button1": 2}, first.next().Revisions)
+	assert.Equal(t, `{"displayName":"button1","x":3}`, kvstates.states["button1"].Value)
+
+	// the clients which do not send the revision overwrite the state
+	first.send(map[string]interface{}{
+		"signal":    ws.SIGNAL_UPDATE_STATE,
+		"target":    ws.TARGET_DOTTED_LINE_SQUARE,
+		"payload":   []interface{}{map[string]interface{}{"displayName": "button1", "x": 4}},
+		"broadcast": map[string]interface{}{"type": "dottedLineSquare/updateDottedLineSquareReducer", "payload": map[string]interface{}{}},
+	})
+	assert.Equal(t, map[string]int{"button1": 3}, second.next().Revisions)
+}
+
+func TestStaleDependenciesAreRejected(t *testing.T) {
+	kvstates := &memoryKVStates{states: map[string]*repository.KVState{
+		"input1": {ID: 1, Key: "input1", Value: `["text1"]`},
+	}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetKVStateServiceImpl(state.NewKVStateServiceImpl(util.NewSugardLogger(), kvstates))
+	})
+	first, second := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, first.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, second.enter(2).ErrorCode)
+	update := func(dependency interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"signal":    ws.SIGNAL_UPDATE_STATE,
+			"target":    ws.TARGET_DEPENDENCIES,
+			"payload":   []interface{}{map[string]interface{}{"input1": dependency}},
+			"broadcast": map[string]interface{}{"type": "dependencies/updateDependenciesReducer", "payload": map[string]interface{}{}},
+		}
+	}
+
+	// both clients change the revision they loaded, the first change wins
+	first.send(update(map[string]interface{}{"value": []interface{}{"text2"}, "revision": 0}))
+	assert.Equal(t, map[string]int{"input1": 1}, first.next().Revisions)
+	assert.Equal(t, map[string]int{"input1": 1}, second.next().Revisions)
+	second.send(update(map[string]interface{}{"value": []interface{}{"text3"}, "revision": 0}))
+	feedback := second.next()
+	assert.Equal(t, ws.ERROR_CODE_REVISION_CONFLICT, feedback.ErrorCode)
+	assert.Equal(t, map[string]interface{}{
+		"target":   float64(ws.TARGET_DEPENDENCIES),
+		"key":      "input1",
+		"revision": float64(1),
+		"value":    []interface{}{"text2"},
+	}, feedback.Data)
+
+	// the change made again on the stored revision is applied
+	second.send(update(map[string]interface{}{"value": []interface{}{"text3"}, "revision": 1}))
+	assert.Equal(t, map[string]int{"input1": 2}, second.next().Revisions)
+	assert.Equal(t, map[string]int{"input1": 2}, first.next().Revisions)
+
+	// the clients which send the dependencies only overwrite the state
+	first.send(update([]interface{}{"text4"}))
+	assert.Equal(t, map[string]int{"input1": 3}, first.next().Revisions)
+	assert.Equal(t, map[string]int{"input1": 3}, second.next().Revisions)
+	stored, _ := kvstates.RetrieveEditVersionByAppAndKey(0, repository.KV_STATE_TYPE_DEPENDENCIES, "input1")
+	assert.Equal(t, `["text4"]`, stored.Value)
+}
+
+func TestResumeReplaysMissedBroadcasts(t *testing.T) {
+	dial := startDashboard(t)
+	sender := dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, sender.enter(1).ErrorCode)
+	for _, broadcastType := range []string{"apps/addDashboardAppReducer", "apps/updateDashboardAppReducer", "apps/removeDashboardAppReducer"} {
+		sender.send(broadcastOnly(broadcastType))
+		assert.Equal(t, ws.ERROR_CODE_ACK, sender.next().ErrorCode)
+	}
+
+	// the client which got the first broadcast before its connection dropped gets the others
+	token, err := user.CreateAccessToken(2, "session")
+	assert.Nil(t, err)
+	resumed := dial()
+	resumed.send(map[string]interface{}{"signal": ws.SIGNAL_ENTER, "payload": []interface{}{map[string]interface{}{"authToken": token, "lastSequence": 1}}})
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, resumed.next().ErrorCode)
+	feedback := resumed.next()
+	assert.Equal(t, int64(2), feedback.Sequence)
+	assert.Equal(t, "apps/updateDashboardAppReducer/remote", feedback.Broadcast.Type)
+	assert.Equal(t, int64(3), resumed.next().Sequence)
+
+	// then the live broadcasts follow
+	sender.send(broadcastOnly("apps/addDashboardAppReducer"))
+	assert.Equal(t, int64(4), resumed.next().Sequence)
+
+	// the client which missed more than the room keeps loads the app again
+	for i := 0; i < ws.REPLAY_LOG_SIZE; i++ {
+		sender.send(broadcastOnly("apps/updateDashboardAppReducer"))
+		assert.Equal(t, ws.ERROR_CODE_ACK, sender.next().ErrorCode)
+		resumed.next()
+	}
+	late := dial()
+	late.send(map[string]interface{}{"signal": ws.SIGNAL_ENTER, "payload": []interface{}{map[string]interface{}{"authToken": token, "lastSequence": 1}}})
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, late.next().ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_RELOAD_REQUIRED, late.next().ErrorCode)
+}
+
+func TestUndoAndRedoDisplayNames(t *testing.T) {
+	setStates := &memorySetStates{states: map[string]*repository.SetState{}}
+	auditLogs := &memoryAuditLogs{}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetSetStateServiceImpl(state.NewSetStateServiceImpl(util.NewSugardLogger(), setStates))
+		hub.SetUnitOfWork(memoryUnitOfWork{setStates: setStates})
+		hub.SetAuditServiceImpl(audit.NewAuditServiceImpl(util.NewSugardLogger(), auditLogs))
+	})
+	editor, collaborator := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, editor.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, collaborator.enter(2).ErrorCode)
+	change := func(conn *testConn, signal int, payload ...interface{}) {
+		conn.send(map[string]interface{}{
+			"signal":    signal,
+			"target":    ws.TARGET_DISPLAY_NAME,
+			"payload":   payload,
+			"broadcast": map[string]interface{}{"type": "displayName/changeReducer", "payload": map[string]interface{}{}},
+		})
+		assert.Equal(t, ws.ERROR_CODE_ACK, conn.next().ErrorCode)
+	}
+	history := func(conn *testConn, signal int) {
+		conn.send(map[string]interface{}{"signal": signal, "payload": []interface{}{}})
+	}
+	var operations []ws.Operation
+
+	change(editor, ws.SIGNAL_CREATE_STATE, "input1", "button1")
+	collaborator.next()
+	change(editor, ws.SIGNAL_UPDATE_STATE, map[string]interface{}{"before": "button1", "after": "button2"})
+	collaborator.next()
+	assert.Equal(t, []string{"button2", "input1"}, setStates.values())
+
+	// the undo is broadcast to the editor too, its client did not make the change
+	history(editor, ws.SIGNAL_UNDO)
+	editor.nextBroadcast(ws.BROADCAST_TYPE_OPERATIONS, &operations)
+	assert.Equal(t, []ws.Operation{{
+		Signal:  ws.SIGNAL_UPDATE_STATE,
+		Target:  ws.TARGET_DISPLAY_NAME,
+		Payload: []interface{}{map[string]interface{}{"before": "button2", "after": "button1", "revision": float64(2)}},
+	}}, operations)
+	collaborator.nextBroadcast(ws.BROADCAST_TYPE_OPERATIONS, &operations)
+	assert.Equal(t, []string{"button1", "input1"}, setStates.values())
+	history(editor, ws.SIGNAL_REDO)
+	editor.nextBroadcast(ws.BROADCAST_TYPE_OPERATIONS, &operations)
+	collaborator.next()
+	assert.Equal(t, []string{"button2", "input1"}, setStates.values())
+
+	// the undo and the redo are audited as the changes they apply
+	assert.Equal(t, []string{audit.OPERATION_CREATE, audit.OPERATION_UPDATE, audit.OPERATION_UPDATE, audit.OPERATION_UPDATE}, auditLogs.operations())
+	assert.Equal(t, `[{"after":"button2","before":"button1","revision":3}]`, *auditLogs.logs[3].After)
+
+	// the users undo their own changes only
+	history(collaborator, ws.SIGNAL_UNDO)
+	assert.Equal(t, ws.ERROR_CODE_UNDO_FAILED, collaborator.next().ErrorCode)
+
+	// the rename of a collaborator is not overwritten, the step is dropped
+	change(collaborator, ws.SIGNAL_UPDATE_STATE, map[string]interface{}{"before": "button2", "after": "button3"})
+	editor.next()
+	history(editor, ws.SIGNAL_UNDO)
+	feedback := editor.next()
+	assert.Equal(t, ws.ERROR_CODE_REVISION_CONFLICT, feedback.ErrorCode)
+	assert.Equal(t, []string{"button3", "input1"}, setStates.values())
+
+	// the step which failed half way is rolled back as a whole
+	change(editor, ws.SIGNAL_DELETE_STATE, "input1", "button3")
+	collaborator.next()
+	change(collaborator, ws.SIGNAL_CREATE_STATE, "input1")
+	editor.next()
+	history(editor, ws.SIGNAL_UNDO)
+	assert.Equal(t, ws.ERROR_CODE_UNDO_FAILED, editor.next().ErrorCode)
+	assert.Equal(t, []string{"input1"}, setStates.values())
+}
+
+func TestBatchIsAppliedAtomically(t *testing.T) {
+	setStates := &memorySetStates{states: map[string]*repository.SetState{}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetSetStateServiceImpl(state.NewSetStateServiceImpl(util.NewSugardLogger(), setStates))
+		hub.SetUnitOfWork(memoryUnitOfWork{setStates: setStates})
+	})
+	editor, collaborator := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, editor.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, collaborator.enter(2).ErrorCode)
+	batch := func(operations ...map[string]interface{}) {
+		payload := make([]interface{}, 0, len(operations))
+		for _, op := range operations {
+			payload = append(payload, op)
+		}
+		editor.send(map[string]interface{}{"signal": ws.SIGNAL_BATCH, "payload": payload})
+	}
+	operation := func(signal int, payload ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"signal": signal, "target": ws.TARGET_DISPLAY_NAME, "payload": payload}
+	}
+
+	batch(
+		operation(ws.SIGNAL_CREATE_STATE, "input1"),
+		operation(ws.SIGNAL_UPDATE_STATE, map[string]interface{}{"before": "input1", "after": "input2"}),
+		operation(ws.SIGNAL_CREATE_STATE, "button1"),
+	)
+	// the client gets the revisions the operations left, the others get all operations at once
+	ack := editor.next()
+	assert.Equal(t, ws.ERROR_CODE_ACK, ack.ErrorCode)
+	applied, _ := json.Marshal(ack.Data)
+	assert.JSONEq(t, `[
+		{"signal":3,"target":5,"payload":["input1"]},
+		{"signal":5,"target":5,"payload":[{"before":"input1","after":"input2","revision":1}]},
+		{"signal":3,"target":5,"payload":["button1"]}
+	]`, string(applied))
+	var operations []ws.Operation
+	collaborator.nextBroadcast(ws.BROADCAST_TYPE_OPERATIONS, &operations)
+	assert.Len(t, operations, 3)
+	assert.Equal(t, []string{"button1", "input2"}, setStates.values())
+
+	// nothing is applied when an operation fails
+	batch(operation(ws.SIGNAL_CREATE_STATE, "button2"), operation(ws.SIGNAL_CREATE_STATE, "button1"))
+	assert.Equal(t, ws.ERROR_CODE_BATCH_FAILED, editor.next().ErrorCode)
+	assert.Equal(t, []string{"button1", "input2"}, setStates.values())
+	batch(operation(ws.SIGNAL_MOVE_STATE, "button1"))
+	assert.Equal(t, ws.ERROR_CODE_BATCH_FAILED, editor.next().ErrorCode)
+
+	// the batch is undone as one step
+	editor.send(map[string]interface{}{"signal": ws.SIGNAL_UNDO, "payload": []interface{}{}})
+	editor.nextBroadcast(ws.BROADCAST_TYPE_OPERATIONS, &operations)
+	assert.Len(t, operations, 3)
+	assert.Empty(t, setStates.values())
+}
+
+func TestInvalidComponentsAreRejected(t *testing.T) {
+	treeStates := &memoryTreeStates{states: map[int]*repository.TreeState{
+		1: {ID: 1, Name: repository.TREE_STATE_ROOTDSL_NAME, ChildrenNodeRefIDs: "[]", Content: `{"displayName":"root","type":"DOT_PANEL","containerType":"EDITOR_DOT_PANEL","x":-1,"y":-1}`},
+	}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetTreeStateServiceImpl(state.NewTreeStateServiceImpl(util.NewSugardLogger(), treeStates))
+	})
+	editor, collaborator := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, editor.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, collaborator.enter(2).ErrorCode)
+	send := func(signal int, component map[string]interface{}) {
+		editor.send(map[string]interface{}{
+			"signal":    signal,
+			"target":    ws.TARGET_COMPONENTS,
+			"payload":   []interface{}{component},
+			"broadcast": map[string]interface{}{"type": "components/addComponentReducer", "payload": map[string]interface{}{}},
+		})
+	}
+	component := func(displayName, containerType string, children ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"displayName": displayName, "type": "CONTAINER_WIDGET", "containerType": containerType,
+			"x": 2, "y": 4, "w": 10, "h": 5, "childrenNode": children,
+		}
+	}
+	validationErrors := func() []state.ComponentValidationError {
+		feedback := editor.next()
+		assert.Equal(t, ws.ERROR_CODE_VALIDATION_FAILED, feedback.ErrorCode)
+		b, _ := json.Marshal(feedback.Data)
+		var errs []state.ComponentValidationError
+		assert.Nil(t, json.Unmarshal(b, &errs))
+		return errs
+	}
+
+	// the fields are checked before the component is stored
+	invalid := component("button1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE)
+	invalid["x"] = "2"
+	invalid["w"] = 0
+	delete(invalid, "type")
+	send(ws.SIGNAL_CREATE_STATE, invalid)
+	assert.Equal(t, []state.ComponentValidationError{
+		{DisplayName: "button1", Field: "x", Reason: "must be a number"},
+		{DisplayName: "button1", Field: "type", Reason: "is required"},
+		{DisplayName: "button1", Field: "w", Reason: "must be greater than 0 and at most 10000"},
+	}, validationErrors())
+
+	// a component is laid out on a canvas, the canvas fills the component it is placed in
+	canvas := component("canvas1", repository.COMPONENT_CONTAINER_TYPE_DOT_PANEL)
+	canvas["x"], canvas["y"], canvas["w"], canvas["h"] = -1, -1, 0, 0
+	send(ws.SIGNAL_CREATE_STATE, component("container1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE,
+		component("button1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE)))
+	assert.Equal(t, []state.ComponentValidationError{
+		{DisplayName: "button1", Field: "containerType", Reason: "EDITOR_SCALE_SQUARE can not be placed in EDITOR_SCALE_SQUARE"},
+	}, validationErrors())
+	send(ws.SIGNAL_CREATE_STATE, component("container1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE, canvas))
+	assert.Equal(t, ws.ERROR_CODE_ACK, editor.next().ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_BROADCAST, collaborator.next().ErrorCode)
+	assert.Equal(t, "container1", treeStates.parentOf("canvas1"))
+
+	// the display names are unique in the app
+	send(ws.SIGNAL_CREATE_STATE, component("canvas1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE))
+	assert.Equal(t, []state.ComponentValidationError{
+		{DisplayName: "canvas1", Field: "displayName", Reason: "is used by another component in the app"},
+	}, validationErrors())
+
+	// the parent exists, and a component is not moved into its own children
+	button := component("button1", repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE)
+	button["parentNode"] = "canvas2"
+	send(ws.SIGNAL_CREATE_STATE, button)
+	assert.Equal(t, []state.ComponentValidationError{
+		{DisplayName: "button1", Field: "parentNode", Reason: "does not exist"},
+	}, validationErrors())
+	send(ws.SIGNAL_MOVE_STATE, map[string]interface{}{"displayName": "container1", "parentNode": "canvas1"})
+	assert.Equal(t, []state.ComponentValidationError{
+		{DisplayName: "container1", Field: "parentNode", Reason: "can not be the component itself or one of its children"},
+	}, validationErrors())
+	assert.Equal(t, repository.TREE_STATE_ROOTDSL_NAME, treeStates.parentOf("container1"))
+}
+
+func TestCreateOrUpdateStates(t *testing.T) {
+	treeStates := &memoryTreeStates{states: map[int]*repository.TreeState{
+		1: {ID: 1, Name: repository.TREE_STATE_ROOTDSL_NAME, ChildrenNodeRefIDs: "[]", Content: `{"displayName":"root","type":"DOT_PANEL","containerType":"EDITOR_DOT_PANEL","x":-1,"y":-1}`},
+	}}
+	setStates := &memorySetStates{states: map[string]*repository.SetState{}}
+	dial := startDashboard(t, func(hub *ws.Hub) {
+		hub.SetTreeStateServiceImpl(state.NewTreeStateServiceImpl(util.NewSugardLogger(), treeStates))
+		hub.SetSetStateServiceImpl(state.NewSetStateServiceImpl(util.NewSugardLogger(), setStates))
+	})
+	editor, collaborator := dial(), dial()
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, editor.enter(1).ErrorCode)
+	assert.Equal(t, ws.ERROR_CODE_LOGGEDIN, collaborator.enter(2).ErrorCode)
+	send := func(target int, item interface{}) ws.Feedback {
+		editor.send(map[string]interface{}{
+			"signal":    ws.SIGNAL_CREATE_OR_UPDATE_STATE,
+			"target":    target,
+			"payload":   []interface{}{item},
+			"broadcast": map[string]interface{}{"type": "components/updateComponentReducer", "payload": map[string]interface{}{}},
+		})
+		return editor.next()
+	}
+	button := func(x, revision int) map[string]interface{} {
+		return map[string]interface{}{
+			"displayName": "button1", "type": "BUTTON_WIDGET", "containerType": repository.COMPONENT_CONTAINER_TYPE_SCALE_SQUARE,
+			"x": x, "y": 4, "w": 10, "h": 5, "revision": revision,
+		}
+	}
+
+	// the missing component is created, then the stored one is updated on its revision
+	feedback := send(ws.TARGET_COMPONENTS, button(2, 0))
+	assert.Equal(t, ws.ERROR_CODE_ACK, feedback.ErrorCode)
+	assert.Equal(t, map[string]int{"button1": 0}, feedback.Revisions)
+	collaborator.next()
+	assert.Equal(t, repository.TREE_STATE_ROOTDSL_NAME, treeStates.parentOf("button1"))
+	feedback = send(ws.TARGET_COMPONENTS, button(3, 0))
+	assert.Equal(t, ws.ERROR_CODE_ACK, feedback.ErrorCode)
+	assert.Equal(t, map[string]int{"button1": 1}, feedback.Revisions)
+	collaborator.next()
+	assert.Equal(t, ws.ERROR_CODE_REVISION_CONFLICT, send(ws.TARGET_COMPONENTS, button(4, 0)).ErrorCode)
+
+	// the stored display name is kept
+	for revision := 0; revision < 2; revision++ {
+		feedback = send(ws.TARGET_DISPLAY_NAME, "input1")
+		assert.Equal(t, ws.ERROR_CODE_ACK, feedback.ErrorCode)
+		assert.Equal(t, map[string]int{"input1": revision}, feedback.Revisions)
+		collaborator.next()
+	}
+	assert.Equal(t, []string{"input1"}, setStates.values())
+}
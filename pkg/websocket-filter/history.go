@@ -0,0 +1,132 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"errors"
+	"log"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/audit"
+	"github.com/illa-family/builder-backend/pkg/state"
+)
+
+// HistoryFilter runs the state signal and records the step which reverts it for the user, the step is
+// read from the states before the signal changes them.
+func HistoryFilter(signal func(room *ws.Room, message *ws.Message) error) func(room *ws.Room, message *ws.Message) error {
+	return func(room *ws.Room, message *ws.Message) error {
+		if !revertibleSignals[message.Signal] || !revertibleTargets[message.Target] {
+			return signal(room, message)
+		}
+		currentClient := room.Clients[message.ClientID]
+		appDto := app.NewAppDto()
+		appDto.ConstructWithID(currentClient.APPID)
+		op := ws.Operation{Signal: message.Signal, Target: message.Target, Payload: message.Payload}
+		inverse, inverseErr := inverseOperations(room.Hub, appDto, op)
+		if err := signal(room, message); err != nil {
+			return err
+		}
+		if inverseErr != nil {
+			log.Printf("[websocket-server] the change of user %d in app %d can not be undone: %v", currentClient.MappedUserID, currentClient.APPID, inverseErr)
+			return nil
+		}
+		revisions := stateRevisions{message.Target: message.Revisions}
+		room.Hub.History.Record(currentClient.APPID, currentClient.MappedUserID, withRevisions(inverse, revisions))
+		return nil
+	}
+}
+
+// SignalUndo reverts the last change of the user in the app.
+func SignalUndo(room *ws.Room, message *ws.Message) error {
+	return applyHistory(room, message, false)
+}
+
+// SignalRedo applies the last change the user reverted again.
+func SignalRedo(room *ws.Room, message *ws.Message) error {
+	return applyHistory(room, message, true)
+}
+
+// applyHistory applies the next step of the history of the user in one transaction and broadcasts its
+// operations to all clients of the app. A step which conflicts with the changes of the collaborators
+// is dropped instead of overwriting them.
+func applyHistory(room *ws.Room, message *ws.Message, redo bool) error {
+	currentClient := room.Clients[message.ClientID]
+	step, ok := room.Hub.History.Next(currentClient.APPID, currentClient.MappedUserID, redo)
+	if !ok {
+		err := errors.New("[websocket-server] there is nothing to undo.")
+		if redo {
+			err = errors.New("[websocket-server] there is nothing to redo.")
+		}
+		currentClient.Feedback(message, ws.ERROR_CODE_UNDO_FAILED, err)
+		return err
+	}
+	ops, err := cloneOperations(step)
+	if err != nil {
+		currentClient.Feedback(message, ws.ERROR_CODE_UNDO_FAILED, err)
+		return err
+	}
+	for _, op := range ops {
+		if held, ok := lockedByOther(room, currentClient, op); ok {
+			err := lockedError(held)
+			currentClient.Feedback(message, ws.ERROR_CODE_LOCKED, err)
+			return err
+		}
+	}
+
+	appDto := app.NewAppDto()
+	appDto.ConstructWithID(currentClient.APPID)
+	audited := make(map[int]audit.Entry)
+	for i, op := range ops {
+		if entry, isAudited := auditEntry(room, currentClient, op); isAudited {
+			audited[i] = entry
+		}
+	}
+	revisions := make(stateRevisions)
+	var inverse []ws.Operation
+	err = room.Hub.Transaction(func(tx *ws.Hub) error {
+		for _, op := range ops {
+			revert, err := inverseOperations(tx, appDto, op)
+			if err != nil {
+				return err
+			}
+			if err := applyOperation(tx, appDto, op, revisions); err != nil {
+				return err
+			}
+			inverse = append(revert, inverse...)
+		}
+		return nil
+	})
+	if err != nil {
+		// the step can not be applied on the changes of the collaborators
+		var invalid state.ComponentValidationErrors
+		if errors.Is(err, repository.ErrRevisionConflict) || errors.As(err, &invalid) {
+			room.Hub.History.Applied(currentClient.APPID, currentClient.MappedUserID, redo, nil)
+		}
+		return feedbackOperationError(room, message, err, ws.ERROR_CODE_UNDO_FAILED)
+	}
+	room.Hub.History.Applied(currentClient.APPID, currentClient.MappedUserID, redo, withRevisions(inverse, revisions))
+	for i, op := range ops {
+		if entry, isAudited := audited[i]; isAudited {
+			recordAudit(room, entry, op.Payload)
+		}
+	}
+
+	// the client of the user does not know the change either, the revisions are in the operations
+	message.Broadcast = &ws.Broadcast{Type: ws.BROADCAST_TYPE_OPERATIONS, Payload: ops}
+	room.BroadcastToAllClients(message, currentClient)
+	return nil
+}
@@ -18,8 +18,8 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalLeave(hub *ws.Hub, message *ws.Message) error {
-	currentClient := hub.Clients[message.ClientID]
-	ws.KickClient(hub, currentClient)
+func SignalLeave(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
+	ws.KickClient(room, currentClient)
 	return nil
 }
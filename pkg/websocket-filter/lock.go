@@ -0,0 +1,165 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/state"
+
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+)
+
+// the signals which change the components, they are rejected on the components locked by other clients.
+var lockedSignals = map[int]bool{
+	ws.SIGNAL_DELETE_STATE:           true,
+	ws.SIGNAL_UPDATE_STATE:           true,
+	ws.SIGNAL_MOVE_STATE:             true,
+	ws.SIGNAL_CREATE_OR_UPDATE_STATE: true,
+}
+
+// SignalLock takes the components named in the payload, all of them or none.
+func SignalLock(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
+	displayNames, err := lockDisplayNames(message.Payload)
+	if err != nil {
+		currentClient.Feedback(message, ws.ERROR_CODE_FAILED, err)
+		return err
+	}
+	if held, ok := room.Lock(currentClient, displayNames); !ok {
+		err := lockedError(held)
+		currentClient.Feedback(message, ws.ERROR_CODE_LOCKED, err)
+		return err
+	}
+	return nil
+}
+
+// SignalUnlock releases the components named in the payload.
+func SignalUnlock(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
+	displayNames, err := lockDisplayNames(message.Payload)
+	if err != nil {
+		currentClient.Feedback(message, ws.ERROR_CODE_FAILED, err)
+		return err
+	}
+	room.Unlock(currentClient, displayNames)
+	return nil
+}
+
+// LockFilter rejects the changes of the components which are locked by other clients.
+func LockFilter(room *ws.Room, client *ws.Client, message *ws.Message) error {
+	op := ws.Operation{Signal: message.Signal, Target: message.Target, Payload: message.Payload}
+	if held, ok := lockedByOther(room, client, op); ok {
+		err := lockedError(held)
+		client.Feedback(message, ws.ERROR_CODE_LOCKED, err)
+		return err
+	}
+	return nil
+}
+
+// lockedByOther returns the lock of another client on a component the operation changes.
+func lockedByOther(room *ws.Room, client *ws.Client, op ws.Operation) (ws.Lock, bool) {
+	if !lockedSignals[op.Signal] {
+		return ws.Lock{}, false
+	}
+	for _, displayName := range changedDisplayNames(room, op) {
+		if held, ok := room.LockedByOther(client, displayName); ok {
+			return held, true
+		}
+	}
+	return ws.Lock{}, false
+}
+
+// changedDisplayNames returns the components the operation changes, a deleted component is deleted
+// with its descendants and a moved one carries them.
+func changedDisplayNames(room *ws.Room, op ws.Operation) []string {
+	displayNames := []string{}
+	switch op.Target {
+	case ws.TARGET_COMPONENTS:
+		for _, v := range op.Payload {
+			displayName := componentDisplayName(v)
+			displayNames = append(displayNames, displayName)
+			if op.Signal == ws.SIGNAL_DELETE_STATE || op.Signal == ws.SIGNAL_MOVE_STATE {
+				displayNames = append(displayNames, descendantDisplayNames(room, displayName)...)
+			}
+		}
+	case ws.TARGET_DISPLAY_NAME:
+		// a rename changes the displayName of the component
+		if op.Signal != ws.SIGNAL_UPDATE_STATE {
+			break
+		}
+		for _, v := range op.Payload {
+			if rename, ok := v.(map[string]interface{}); ok {
+				before, _ := rename["before"].(string)
+				displayNames = append(displayNames, before)
+			}
+		}
+	}
+	return displayNames
+}
+
+// descendantDisplayNames returns the descendants of the component, the change itself reports the
+// components which can not be found.
+func descendantDisplayNames(room *ws.Room, displayName string) []string {
+	if room.Hub.TreeStateServiceImpl == nil || displayName == "" || len(room.Hub.Locks.InApp(room.APPID)) == 0 {
+		return nil
+	}
+	appDto := app.NewAppDto()
+	appDto.ConstructWithID(room.APPID)
+	currentNode := state.NewTreeStateDto()
+	currentNode.ConstructWithDisplayNameForDelete(displayName)
+	currentNode.ConstructByApp(appDto)
+	currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+	descendants, err := room.Hub.TreeStateServiceImpl.GetDescendantNamesByName(currentNode)
+	if err != nil {
+		return nil
+	}
+	return descendants
+}
+
+// componentDisplayName returns the displayName of a component in the payload, the component is
+// given by its name or by its node.
+func componentDisplayName(v interface{}) string {
+	switch component := v.(type) {
+	case string:
+		return component
+	case map[string]interface{}:
+		displayName, _ := component["displayName"].(string)
+		return displayName
+	}
+	return ""
+}
+
+func lockDisplayNames(payload []interface{}) ([]string, error) {
+	displayNames := make([]string, 0, len(payload))
+	for _, v := range payload {
+		displayName := componentDisplayName(v)
+		if displayName == "" {
+			return nil, errors.New("[websocket-server] websocket protocol syntax error.")
+		}
+		displayNames = append(displayNames, displayName)
+	}
+	if len(displayNames) == 0 {
+		return nil, errors.New("[websocket-server] websocket protocol syntax error.")
+	}
+	return displayNames, nil
+}
+
+func lockedError(held ws.Lock) error {
+	return fmt.Errorf("[websocket-server] component %s is locked by user %d.", held.DisplayName, held.UserID)
+}
@@ -17,16 +17,14 @@ package filter
 import (
 	"errors"
 
-	"github.com/illa-family/builder-backend/internal/repository"
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 	"github.com/illa-family/builder-backend/pkg/app"
-	"github.com/illa-family/builder-backend/pkg/state"
 )
 
-func SignalMoveState(hub *ws.Hub, message *ws.Message) error {
+func SignalMoveState(room *ws.Room, message *ws.Message) error {
 
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
 	message.RewriteBroadcast()
@@ -36,16 +34,8 @@ func SignalMoveState(hub *ws.Hub, message *ws.Message) error {
 	case ws.TARGET_NOTNING:
 		return nil
 	case ws.TARGET_COMPONENTS:
-		for _, v := range message.Payload {
-			currentNode := state.NewTreeStateDto()
-			currentNode.ConstructByMap(v) // set Name
-			currentNode.ConstructByApp(appDto)
-			currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
-
-			if err := hub.TreeStateServiceImpl.MoveTreeStateNode(currentNode); err != nil {
-				currentClient.Feedback(message, ws.ERROR_MOVE_STATE_FAILED, err)
-				return err
-			}
+		if err := applyMessage(room, appDto, message, ws.ERROR_MOVE_STATE_FAILED); err != nil {
+			return err
 		}
 
 	case ws.TARGET_DEPENDENCIES:
@@ -69,7 +59,7 @@ func SignalMoveState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 
 	return nil
 }
@@ -0,0 +1,504 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+	"github.com/illa-family/builder-backend/pkg/app"
+	"github.com/illa-family/builder-backend/pkg/state"
+	"gorm.io/gorm"
+)
+
+var errUnsupportedOperation = errors.New("[websocket-server] the operation is not supported.")
+
+// the signals and targets whose changes can be undone, the other states are transient or derived.
+var revertibleSignals = map[int]bool{
+	ws.SIGNAL_CREATE_STATE:           true,
+	ws.SIGNAL_DELETE_STATE:           true,
+	ws.SIGNAL_UPDATE_STATE:           true,
+	ws.SIGNAL_MOVE_STATE:             true,
+	ws.SIGNAL_CREATE_OR_UPDATE_STATE: true,
+}
+
+var revertibleTargets = map[int]bool{
+	ws.TARGET_COMPONENTS:   true,
+	ws.TARGET_DISPLAY_NAME: true,
+}
+
+// stateRevisions are the revisions of the changed states by target, a component and its display name
+// share the key.
+type stateRevisions map[int]map[string]int
+
+func (r stateRevisions) get(target int, key string) (int, bool) {
+	revision, ok := r[target][key]
+	return revision, ok
+}
+
+func (r stateRevisions) set(target int, key string, revision int) {
+	if r[target] == nil {
+		r[target] = make(map[string]int)
+	}
+	r[target][key] = revision
+}
+
+func (r stateRevisions) drop(target int, key string) {
+	delete(r[target], key)
+}
+
+// setItemRevision tells the clients the revision the payload item left its state at.
+func setItemRevision(v interface{}, revision int) {
+	if item, ok := v.(map[string]interface{}); ok {
+		item["revision"] = revision
+	}
+}
+
+// operationError is a failed operation, it names the state the operation failed on.
+type operationError struct {
+	target int
+	key    string
+	err    error
+}
+
+func (e *operationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *operationError) Unwrap() error {
+	return e.err
+}
+
+// applyOperation changes the states of the app as the signal of the operation does and records the
+// revisions of the changed states, in the revisions and in the payload. A state changed by an earlier
+// operation of the same step is checked against the revision that operation left. The create or update
+// signal updates the stored states and creates the others.
+func applyOperation(hub *ws.Hub, appDto *app.AppDto, op ws.Operation, revisions stateRevisions) error {
+	fail := func(key string, err error) error {
+		// the state the operation was made on was deleted or renamed meanwhile
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = repository.ErrRevisionConflict
+		}
+		return &operationError{target: op.Target, key: key, err: err}
+	}
+	revisionOf := func(v interface{}, key string) int {
+		revision := takeRevision(v)
+		if stored, ok := revisions.get(op.Target, key); ok {
+			return stored
+		}
+		return revision
+	}
+	for _, v := range op.Payload {
+		signal := op.Signal
+		switch op.Target {
+		case ws.TARGET_COMPONENTS:
+			displayName := componentDisplayName(v)
+			if signal == ws.SIGNAL_CREATE_OR_UPDATE_STATE {
+				signal = createOrUpdate(stateExists(hub, appDto, op.Target, displayName))
+			}
+			switch signal {
+			case ws.SIGNAL_CREATE_STATE:
+				if err := hub.TreeStateServiceImpl.ValidateComponentTree(appDto, v); err != nil {
+					return fail(displayName, err)
+				}
+				componentTree := repository.ConstructComponentNodeByMap(v)
+				if err := hub.TreeStateServiceImpl.CreateComponentTree(appDto, 0, componentTree); err != nil {
+					return fail(displayName, err)
+				}
+				createdComponents(componentTree, revisions)
+				setItemRevision(v, 0)
+			case ws.SIGNAL_DELETE_STATE:
+				currentNode := state.NewTreeStateDto()
+				currentNode.ConstructWithRevision(revisionOf(v, displayName))
+				currentNode.ConstructWithDisplayNameForDelete(displayName)
+				currentNode.ConstructByApp(appDto)
+				currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+				if err := hub.TreeStateServiceImpl.DeleteTreeStateNodeRecursive(currentNode); err != nil {
+					return fail(displayName, err)
+				}
+				revisions.drop(op.Target, displayName)
+			case ws.SIGNAL_UPDATE_STATE:
+				currentNode := state.NewTreeStateDto()
+				currentNode.ConstructWithRevision(revisionOf(v, displayName))
+				if err := hub.TreeStateServiceImpl.ValidateComponentUpdate(appDto, v); err != nil {
+					return fail(displayName, err)
+				}
+				serializedComponent, err := repository.ConstructComponentNodeByMap(v).SerializationForDatabase()
+				if err != nil {
+					return fail(displayName, err)
+				}
+				currentNode.ConstructByMap(v)
+				currentNode.ConstructWithContent(serializedComponent)
+				currentNode.ConstructByApp(appDto)
+				currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+				inDBTreeStateDto, err := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
+				if err != nil {
+					return fail(displayName, err)
+				}
+				if !currentNode.BasedOn(inDBTreeStateDto.Revision) {
+					return fail(displayName, repository.ErrRevisionConflict)
+				}
+				inDBTreeStateDto.ConstructWithNewStateContent(currentNode)
+				if _, err := hub.TreeStateServiceImpl.UpdateTreeState(inDBTreeStateDto); err != nil {
+					return fail(displayName, err)
+				}
+				revisions.set(op.Target, displayName, inDBTreeStateDto.Revision)
+				setItemRevision(v, inDBTreeStateDto.Revision)
+			case ws.SIGNAL_MOVE_STATE:
+				currentNode := state.NewTreeStateDto()
+				currentNode.ConstructWithRevision(revisionOf(v, displayName))
+				currentNode.ConstructByMap(v)
+				currentNode.ConstructByApp(appDto)
+				currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+				if err := hub.TreeStateServiceImpl.MoveTreeStateNode(currentNode); err != nil {
+					return fail(displayName, err)
+				}
+				revisions.set(op.Target, displayName, currentNode.Revision)
+				setItemRevision(v, currentNode.Revision)
+			default:
+				return fail(displayName, errUnsupportedOperation)
+			}
+		case ws.TARGET_DISPLAY_NAME:
+			if signal == ws.SIGNAL_CREATE_OR_UPDATE_STATE {
+				displayName, err := repository.ResolveDisplayNameByPayload(v)
+				if err != nil {
+					return fail("", err)
+				}
+				// the stored display name is updated to itself
+				if signal = createOrUpdate(stateExists(hub, appDto, op.Target, displayName)); signal == ws.SIGNAL_UPDATE_STATE {
+					v = map[string]interface{}{"before": displayName, "after": displayName}
+				}
+			}
+			switch signal {
+			case ws.SIGNAL_CREATE_STATE:
+				displayName, err := repository.ResolveDisplayNameByPayload(v)
+				if err != nil {
+					return fail("", err)
+				}
+				setStateDto := state.NewSetStateDto()
+				setStateDto.ConstructByApp(appDto)
+				setStateDto.ConstructWithValue(displayName)
+				setStateDto.ConstructWithType(repository.SET_STATE_TYPE_DISPLAY_NAME)
+				if _, err := hub.SetStateServiceImpl.CreateSetState(setStateDto); err != nil {
+					return fail(displayName, err)
+				}
+				revisions.set(op.Target, displayName, 0)
+			case ws.SIGNAL_DELETE_STATE:
+				displayName := componentDisplayName(v)
+				setStateDto := state.NewSetStateDto()
+				setStateDto.ConstructWithRevision(revisionOf(v, displayName))
+				setStateDto.ConstructWithDisplayNameForDelete(displayName)
+				setStateDto.ConstructWithType(repository.SET_STATE_TYPE_DISPLAY_NAME)
+				setStateDto.ConstructByApp(appDto)
+				setStateDto.ConstructWithEditVersion()
+				if err := hub.SetStateServiceImpl.DeleteSetStateByValue(setStateDto); err != nil {
+					return fail(displayName, err)
+				}
+				revisions.drop(op.Target, displayName)
+			case ws.SIGNAL_UPDATE_STATE:
+				item, _ := v.(map[string]interface{})
+				before, _ := item["before"].(string)
+				revision := revisionOf(v, before)
+				dnsfu, err := repository.ConstructDisplayNameStateForUpdateByPayload(v)
+				if err != nil {
+					return fail(before, err)
+				}
+				beforeSetStateDto := state.NewSetStateDto()
+				beforeSetStateDto.ConstructWithValueBeforeUpdate(dnsfu)
+				beforeSetStateDto.ConstructWithType(repository.SET_STATE_TYPE_DISPLAY_NAME)
+				beforeSetStateDto.ConstructByApp(appDto)
+				beforeSetStateDto.ConstructWithEditVersion()
+				beforeSetStateDto.ConstructWithRevision(revision)
+				inDBSetStateDto, err := hub.SetStateServiceImpl.GetByValue(beforeSetStateDto)
+				if err != nil {
+					return fail(before, err)
+				}
+				if !beforeSetStateDto.BasedOn(inDBSetStateDto.Revision) {
+					return fail(before, repository.ErrRevisionConflict)
+				}
+				inDBSetStateDto.ConstructWithValueAfterUpdate(dnsfu)
+				if _, err := hub.SetStateServiceImpl.UpdateSetState(inDBSetStateDto); err != nil {
+					return fail(before, err)
+				}
+				revisions.drop(op.Target, before)
+				revisions.set(op.Target, dnsfu.After, inDBSetStateDto.Revision)
+				setItemRevision(v, inDBSetStateDto.Revision)
+			default:
+				return fail("", errUnsupportedOperation)
+			}
+		case ws.TARGET_DEPENDENCIES:
+			dependencies, ok := v.(map[string]interface{})
+			if !ok {
+				return fail("", errors.New("K-V State reflect failed, please check your input."))
+			}
+			for key, dependency := range dependencies {
+				depState, revision := takeDependencies(dependency)
+				kvStateDto := state.NewKVStateDto()
+				kvStateDto.ConstructWithKey(key)
+				kvStateDto.ConstructForDependenciesState(depState)
+				kvStateDto.ConstructByApp(appDto)
+				kvStateDto.ConstructWithType(repository.KV_STATE_TYPE_DEPENDENCIES)
+				keySignal := signal
+				if keySignal == ws.SIGNAL_CREATE_OR_UPDATE_STATE {
+					keySignal = createOrUpdate(stateExists(hub, appDto, op.Target, key))
+				}
+				switch keySignal {
+				case ws.SIGNAL_CREATE_STATE:
+					if _, err := hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
+						return fail(key, err)
+					}
+					revisions.set(op.Target, key, 0)
+				case ws.SIGNAL_UPDATE_STATE:
+					if stored, ok := revisions.get(op.Target, key); ok {
+						revision = stored
+					}
+					kvStateDto.ConstructWithRevision(revision)
+					if err := hub.KVStateServiceImpl.UpdateKVStateByKey(kvStateDto); err != nil {
+						return fail(key, err)
+					}
+					revisions.set(op.Target, key, kvStateDto.Revision)
+				default:
+					return fail(key, errUnsupportedOperation)
+				}
+			}
+		default:
+			return fail("", errUnsupportedOperation)
+		}
+	}
+	return nil
+}
+
+// createOrUpdate returns the signal the create or update signal changes a state with.
+func createOrUpdate(exists bool) int {
+	if exists {
+		return ws.SIGNAL_UPDATE_STATE
+	}
+	return ws.SIGNAL_CREATE_STATE
+}
+
+// stateExists tells whether the state of the target named key is stored in the edit version of the app.
+func stateExists(hub *ws.Hub, appDto *app.AppDto, target int, key string) bool {
+	switch target {
+	case ws.TARGET_COMPONENTS:
+		currentNode := state.NewTreeStateDto()
+		currentNode.Name = key
+		currentNode.ConstructByApp(appDto)
+		currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+		inDBTreeStateDto, _ := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
+		return inDBTreeStateDto != nil
+	case ws.TARGET_DEPENDENCIES:
+		kvStateDto := state.NewKVStateDto()
+		kvStateDto.ConstructWithKey(key)
+		kvStateDto.ConstructByApp(appDto)
+		kvStateDto.ConstructWithType(repository.KV_STATE_TYPE_DEPENDENCIES)
+		inDBKVStateDto, _ := hub.KVStateServiceImpl.GetKVStateByKey(kvStateDto)
+		return inDBKVStateDto != nil
+	case ws.TARGET_DISPLAY_NAME:
+		setStateDto := state.NewSetStateDto()
+		setStateDto.ConstructWithValue(key)
+		setStateDto.ConstructWithType(repository.SET_STATE_TYPE_DISPLAY_NAME)
+		setStateDto.ConstructByApp(appDto)
+		setStateDto.ConstructWithEditVersion()
+		inDBSetStateDto, _ := hub.SetStateServiceImpl.GetByValue(setStateDto)
+		return inDBSetStateDto != nil
+	}
+	return false
+}
+
+// applyMessage applies the change of a single state signal as its operation, the revisions the states
+// were left at are sent with the message.
+func applyMessage(room *ws.Room, appDto *app.AppDto, message *ws.Message, failedCode int) error {
+	op := ws.Operation{Signal: message.Signal, Target: message.Target, Payload: message.Payload}
+	revisions := make(stateRevisions)
+	if err := applyOperation(room.Hub, appDto, op, revisions); err != nil {
+		return feedbackOperationError(room, message, err, failedCode)
+	}
+	for key, revision := range revisions[message.Target] {
+		message.SetRevision(key, revision)
+	}
+	return nil
+}
+
+// createdComponents records the revision of the created components and their children.
+func createdComponents(componentTree *repository.ComponentNode, revisions stateRevisions) {
+	revisions.set(ws.TARGET_COMPONENTS, componentTree.DisplayName, 0)
+	for _, child := range componentTree.ChildrenNode {
+		createdComponents(child, revisions)
+	}
+}
+
+// inverseOperations returns the operations which revert the operation, they are read from the states
+// before it is applied. Their revisions are filled by withRevisions after it was applied.
+func inverseOperations(hub *ws.Hub, appDto *app.AppDto, op ws.Operation) ([]ws.Operation, error) {
+	if !revertibleSignals[op.Signal] || !revertibleTargets[op.Target] {
+		return nil, errUnsupportedOperation
+	}
+	inverse := make([]ws.Operation, 0, len(op.Payload))
+	for _, v := range op.Payload {
+		var revert ws.Operation
+		var err error
+		if op.Target == ws.TARGET_COMPONENTS {
+			revert, err = revertComponent(hub, appDto, op.Signal, v)
+		} else {
+			revert, err = revertDisplayName(op.Signal, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		inverse = append([]ws.Operation{revert}, inverse...)
+	}
+	return inverse, nil
+}
+
+func revertComponent(hub *ws.Hub, appDto *app.AppDto, signal int, v interface{}) (ws.Operation, error) {
+	currentNode := state.NewTreeStateDto()
+	currentNode.Name = componentDisplayName(v)
+	currentNode.ConstructByApp(appDto)
+	currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
+	revert := ws.Operation{Target: ws.TARGET_COMPONENTS}
+	if signal == ws.SIGNAL_CREATE_OR_UPDATE_STATE {
+		signal = ws.SIGNAL_UPDATE_STATE
+		if inDBTreeStateDto, _ := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode); inDBTreeStateDto == nil {
+			signal = ws.SIGNAL_CREATE_STATE
+		}
+	}
+	switch signal {
+	case ws.SIGNAL_CREATE_STATE:
+		revert.Signal = ws.SIGNAL_DELETE_STATE
+		revert.Payload = []interface{}{map[string]interface{}{"displayName": currentNode.Name, "revision": 0}}
+	case ws.SIGNAL_DELETE_STATE:
+		componentTree, err := hub.TreeStateServiceImpl.GetComponentTreeByName(currentNode)
+		if err != nil {
+			return revert, err
+		}
+		item, err := toPayloadItem(componentTree)
+		if err != nil {
+			return revert, err
+		}
+		revert.Signal = ws.SIGNAL_CREATE_STATE
+		revert.Payload = []interface{}{item}
+	case ws.SIGNAL_UPDATE_STATE:
+		inDBTreeStateDto, err := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
+		if err != nil {
+			return revert, err
+		}
+		item := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(inDBTreeStateDto.Content), &item); err != nil {
+			return revert, err
+		}
+		item["displayName"] = currentNode.Name
+		item["revision"] = 0
+		revert.Signal = ws.SIGNAL_UPDATE_STATE
+		revert.Payload = []interface{}{item}
+	case ws.SIGNAL_MOVE_STATE:
+		inDBTreeStateDto, err := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
+		if err != nil {
+			return revert, err
+		}
+		parentTreeStateDto, err := hub.TreeStateServiceImpl.GetTreeStateByID(inDBTreeStateDto.ParentNodeRefID)
+		if err != nil {
+			return revert, err
+		}
+		revert.Signal = ws.SIGNAL_MOVE_STATE
+		revert.Payload = []interface{}{map[string]interface{}{"displayName": currentNode.Name, "parentNode": parentTreeStateDto.Name, "revision": 0}}
+	}
+	return revert, nil
+}
+
+func revertDisplayName(signal int, v interface{}) (ws.Operation, error) {
+	revert := ws.Operation{Target: ws.TARGET_DISPLAY_NAME}
+	switch signal {
+	case ws.SIGNAL_CREATE_STATE:
+		displayName, err := repository.ResolveDisplayNameByPayload(v)
+		if err != nil {
+			return revert, err
+		}
+		revert.Signal = ws.SIGNAL_DELETE_STATE
+		revert.Payload = []interface{}{map[string]interface{}{"displayName": displayName, "revision": 0}}
+	case ws.SIGNAL_DELETE_STATE:
+		revert.Signal = ws.SIGNAL_CREATE_STATE
+		revert.Payload = []interface{}{componentDisplayName(v)}
+	case ws.SIGNAL_UPDATE_STATE:
+		dnsfu, err := repository.ConstructDisplayNameStateForUpdateByPayload(v)
+		if err != nil {
+			return revert, err
+		}
+		revert.Signal = ws.SIGNAL_UPDATE_STATE
+		revert.Payload = []interface{}{map[string]interface{}{"before": dnsfu.After, "after": dnsfu.Before, "revision": 0}}
+	default:
+		return revert, errUnsupportedOperation
+	}
+	return revert, nil
+}
+
+// withRevisions fills the revisions the states were left at into the operations which revert them.
+func withRevisions(inverse []ws.Operation, revisions stateRevisions) []ws.Operation {
+	for _, op := range inverse {
+		for _, v := range op.Payload {
+			item, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := item["revision"]; !ok {
+				continue
+			}
+			key, isRename := item["before"].(string)
+			if !isRename {
+				key = componentDisplayName(item)
+			}
+			if revision, ok := revisions.get(op.Target, key); ok {
+				item["revision"] = revision
+			}
+		}
+	}
+	return inverse
+}
+
+// feedbackOperationError sends the stored state when the operation failed for a revision conflict,
+// and failedCode for the other errors.
+func feedbackOperationError(room *ws.Room, message *ws.Message, err error, failedCode int) error {
+	var opErr *operationError
+	if !errors.As(err, &opErr) {
+		room.Clients[message.ClientID].Feedback(message, failedCode, err)
+		return err
+	}
+	failed := *message
+	failed.Target = opErr.target
+	return feedbackStateError(room, &failed, opErr.key, err, failedCode)
+}
+
+// toPayloadItem converts the state to the form the clients send it in.
+func toPayloadItem(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var item interface{}
+	err = json.Unmarshal(b, &item)
+	return item, err
+}
+
+// cloneOperations copies the operations, applying them takes the revisions out of their payloads.
+func cloneOperations(ops []ws.Operation) ([]ws.Operation, error) {
+	b, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	var cloned []ws.Operation
+	err = json.Unmarshal(b, &cloned)
+	return cloned, err
+}
@@ -18,8 +18,8 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalPing(hub *ws.Hub, message *ws.Message) error {
-	currentClient := hub.Clients[message.ClientID]
+func SignalPing(room *ws.Room, message *ws.Message) error {
+	currentClient := room.Clients[message.ClientID]
 	currentClient.Feedback(message, ws.ERROR_CODE_PONG, nil)
 	return nil
 }
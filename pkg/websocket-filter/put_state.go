@@ -24,9 +24,9 @@ import (
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 )
 
-func SignalPutState(hub *ws.Hub, message *ws.Message) error {
+func SignalPutState(room *ws.Room, message *ws.Message) error {
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	stateType := repository.STATE_TYPE_INVALIED
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
@@ -45,7 +45,7 @@ func SignalPutState(hub *ws.Hub, message *ws.Message) error {
 		kvStateDto := state.NewKVStateDto()
 		kvStateDto.ConstructByApp(appDto) // set AppRefID
 		kvStateDto.ConstructWithType(stateType)
-		if err := hub.KVStateServiceImpl.DeleteAllEditKVStateByStateType(kvStateDto); err != nil {
+		if err := room.Hub.KVStateServiceImpl.DeleteAllEditKVStateByStateType(kvStateDto); err != nil {
 			return err
 		}
 		// create k-v state
@@ -63,7 +63,7 @@ func SignalPutState(hub *ws.Hub, message *ws.Message) error {
 				kvStateDto.ConstructByApp(appDto) // set AppRefID
 				kvStateDto.ConstructWithType(stateType)
 
-				if _, err := hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
+				if _, err := room.Hub.KVStateServiceImpl.CreateKVState(kvStateDto); err != nil {
 					currentClient.Feedback(message, ws.ERROR_CREATE_STATE_FAILED, err)
 					return err
 				}
@@ -87,6 +87,6 @@ func SignalPutState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 	return nil
 }
@@ -0,0 +1,120 @@
+// Copyright 2022 The ILLA Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/illa-family/builder-backend/internal/repository"
+	ws "github.com/illa-family/builder-backend/internal/websocket"
+	"github.com/illa-family/builder-backend/pkg/state"
+)
+
+// stateConflict comes with ERROR_CODE_REVISION_CONFLICT, it carries the stored state so the client
+// can make its change again on it. Revision and Value are empty when the state was deleted.
+type stateConflict struct {
+	Target   int         `json:"target"`
+	Key      string      `json:"key"`
+	Revision int         `json:"revision"`
+	Value    interface{} `json:"value"`
+}
+
+// takeRevision removes the revision the change was made on from the payload item and returns it,
+// the changes of the clients which do not send it overwrite the state.
+func takeRevision(v interface{}) int {
+	item, ok := v.(map[string]interface{})
+	if !ok {
+		return state.ANY_REVISION
+	}
+	revision, ok := item["revision"].(float64)
+	delete(item, "revision")
+	if !ok {
+		return state.ANY_REVISION
+	}
+	return int(revision)
+}
+
+// takeDependencies returns the dependencies of a key in the dependencies payload and the revision they
+// were changed on. A key is sent either as its dependencies, or as {"value": dependencies, "revision": n}
+// by the clients which send the revision.
+func takeDependencies(v interface{}) (interface{}, int) {
+	item, ok := v.(map[string]interface{})
+	if !ok {
+		return v, state.ANY_REVISION
+	}
+	return item["value"], takeRevision(item)
+}
+
+// kvStateType returns the state type of the k-v target.
+func kvStateType(target int) int {
+	switch target {
+	case ws.TARGET_DEPENDENCIES:
+		return repository.KV_STATE_TYPE_DEPENDENCIES
+	case ws.TARGET_DRAG_SHADOW:
+		return repository.KV_STATE_TYPE_DRAG_SHADOW
+	case ws.TARGET_DOTTED_LINE_SQUARE:
+		return repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE
+	}
+	return repository.STATE_TYPE_INVALIED
+}
+
+// feedbackStateError sends the stored state when the change failed for a revision conflict, the
+// problems found when the component was invalid, and failedCode for the other errors.
+func feedbackStateError(room *ws.Room, message *ws.Message, key string, err error, failedCode int) error {
+	currentClient := room.Clients[message.ClientID]
+	var invalid state.ComponentValidationErrors
+	if errors.As(err, &invalid) {
+		currentClient.FeedbackWithData(message, ws.ERROR_CODE_VALIDATION_FAILED, err, invalid)
+		return err
+	}
+	if !errors.Is(err, repository.ErrRevisionConflict) {
+		currentClient.Feedback(message, failedCode, err)
+		return err
+	}
+	conflict := stateConflict{Target: message.Target, Key: key}
+	switch message.Target {
+	case ws.TARGET_COMPONENTS:
+		treeStateDto := state.NewTreeStateDto()
+		treeStateDto.Name = key
+		treeStateDto.AppRefID = currentClient.APPID
+		treeStateDto.StateType = repository.TREE_STATE_TYPE_COMPONENTS
+		if inDBTreeStateDto, _ := room.Hub.TreeStateServiceImpl.GetTreeStateByName(treeStateDto); inDBTreeStateDto != nil {
+			conflict.Revision = inDBTreeStateDto.Revision
+			conflict.Value = json.RawMessage(inDBTreeStateDto.Content)
+		}
+	case ws.TARGET_DEPENDENCIES, ws.TARGET_DRAG_SHADOW, ws.TARGET_DOTTED_LINE_SQUARE:
+		kvStateDto := state.NewKVStateDto()
+		kvStateDto.Key = key
+		kvStateDto.AppRefID = currentClient.APPID
+		kvStateDto.StateType = kvStateType(message.Target)
+		if inDBKVStateDto, _ := room.Hub.KVStateServiceImpl.GetKVStateByKey(kvStateDto); inDBKVStateDto != nil {
+			conflict.Revision = inDBKVStateDto.Revision
+			conflict.Value = json.RawMessage(inDBKVStateDto.Value)
+		}
+	case ws.TARGET_DISPLAY_NAME:
+		setStateDto := state.NewSetStateDto()
+		setStateDto.Value = key
+		setStateDto.AppRefID = currentClient.APPID
+		setStateDto.StateType = repository.SET_STATE_TYPE_DISPLAY_NAME
+		setStateDto.ConstructWithEditVersion()
+		if inDBSetStateDto, _ := room.Hub.SetStateServiceImpl.GetByValue(setStateDto); inDBSetStateDto != nil {
+			conflict.Revision = inDBSetStateDto.Revision
+			conflict.Value = inDBSetStateDto.Value
+		}
+	}
+	currentClient.FeedbackWithData(message, ws.ERROR_CODE_REVISION_CONFLICT, err, conflict)
+	return err
+}
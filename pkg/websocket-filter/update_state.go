@@ -15,19 +15,16 @@
 package filter
 
 import (
-	"errors"
-	"fmt"
-
 	"github.com/illa-family/builder-backend/internal/repository"
 	ws "github.com/illa-family/builder-backend/internal/websocket"
 	"github.com/illa-family/builder-backend/pkg/app"
 	"github.com/illa-family/builder-backend/pkg/state"
 )
 
-func SignalUpdateState(hub *ws.Hub, message *ws.Message) error {
+func SignalUpdateState(room *ws.Room, message *ws.Message) error {
 
 	// deserialize message
-	currentClient := hub.Clients[message.ClientID]
+	currentClient := room.Clients[message.ClientID]
 	stateType := repository.STATE_TYPE_INVALIED
 	appDto := app.NewAppDto()
 	appDto.ConstructWithID(currentClient.APPID)
@@ -37,63 +34,9 @@ func SignalUpdateState(hub *ws.Hub, message *ws.Message) error {
 	switch message.Target {
 	case ws.TARGET_NOTNING:
 		return nil
-	case ws.TARGET_COMPONENTS:
-		for _, v := range message.Payload {
-			// update
-			// construct update data
-			currentNode := state.NewTreeStateDto()
-			componentNode := repository.ConstructComponentNodeByMap(v)
-			serializedComponent, err := componentNode.SerializationForDatabase()
-			if err != nil {
-				return err
-			}
-			currentNode.ConstructByMap(v) // set Name
-			currentNode.ConstructWithContent(serializedComponent)
-			currentNode.ConstructByApp(appDto) // set AppRefID
-			currentNode.ConstructWithType(repository.TREE_STATE_TYPE_COMPONENTS)
-
-			// get state by name
-			inDBTreeStateDto, err := hub.TreeStateServiceImpl.GetTreeStateByName(currentNode)
-			if err != nil {
-				currentClient.Feedback(message, ws.ERROR_CREATE_OR_UPDATE_STATE_FAILED, err)
-				return err
-			}
-			if inDBTreeStateDto == nil {
-				err := errors.New("[websocket-server] target state not exists, can not update.")
-				currentClient.Feedback(message, ws.ERROR_CREATE_OR_UPDATE_STATE_FAILED, err)
-				return nil
-			}
-
-			// update content
-			inDBTreeStateDto.ConstructWithNewStateContent(currentNode)
-			if _, err := hub.TreeStateServiceImpl.UpdateTreeState(inDBTreeStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-				return err
-			}
-		}
-
-	case ws.TARGET_DEPENDENCIES:
-		stateType = repository.KV_STATE_TYPE_DEPENDENCIES
-		// update k-v state
-		for _, v := range message.Payload {
-			subv, ok := v.(map[string]interface{})
-			if !ok {
-				err := errors.New("K-V State reflect failed, please check your input.")
-				return err
-			}
-			for key, depState := range subv {
-				// fill KVStateDto
-				kvStateDto := state.NewKVStateDto()
-				kvStateDto.ConstructWithKey(key)
-				kvStateDto.ConstructForDependenciesState(depState)
-				kvStateDto.ConstructByApp(appDto) // set AppRefID
-				kvStateDto.ConstructWithType(stateType)
-
-				if err := hub.KVStateServiceImpl.UpdateKVStateByKey(kvStateDto); err != nil {
-					currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-					return err
-				}
-			}
+	case ws.TARGET_COMPONENTS, ws.TARGET_DEPENDENCIES, ws.TARGET_DISPLAY_NAME:
+		if err := applyMessage(room, appDto, message, ws.ERROR_UPDATE_STATE_FAILED); err != nil {
+			return err
 		}
 
 	case ws.TARGET_DRAG_SHADOW:
@@ -101,53 +44,21 @@ func SignalUpdateState(hub *ws.Hub, message *ws.Message) error {
 
 	case ws.TARGET_DOTTED_LINE_SQUARE:
 		// fill type
-		if message.Target == ws.TARGET_DEPENDENCIES {
-			stateType = repository.KV_STATE_TYPE_DEPENDENCIES
-		} else if message.Target == ws.TARGET_DRAG_SHADOW {
-			stateType = repository.KV_STATE_TYPE_DRAG_SHADOW
-		} else {
-			stateType = repository.KV_STATE_TYPE_DOTTED_LINE_SQUARE
-		}
+		stateType = kvStateType(message.Target)
 		// update K-V State
 		for _, v := range message.Payload {
 			// fill KVStateDto
 			kvStateDto := state.NewKVStateDto()
+			kvStateDto.ConstructWithRevision(takeRevision(v))
 			kvStateDto.ConstructByMap(v)
 			kvStateDto.ConstructByApp(appDto)
 			kvStateDto.ConstructWithType(stateType)
 
 			// update
-			if err := hub.KVStateServiceImpl.UpdateKVStateByKey(kvStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-				return err
-			}
-		}
-
-	case ws.TARGET_DISPLAY_NAME:
-		stateType = repository.SET_STATE_TYPE_DISPLAY_NAME
-		for _, v := range message.Payload {
-			// resolve payload
-			dnsfu, err := repository.ConstructDisplayNameStateForUpdateByPayload(v)
-			fmt.Printf("[DUMP] dnsfu %v\n", dnsfu)
-			if err != nil {
-				return err
-			}
-			// init state dto
-			beforeSetStateDto := state.NewSetStateDto()
-			afterSetStateDto := state.NewSetStateDto()
-			beforeSetStateDto.ConstructWithValueBeforeUpdate(dnsfu)
-			beforeSetStateDto.ConstructWithType(stateType)
-			beforeSetStateDto.ConstructByApp(appDto)
-			beforeSetStateDto.ConstructWithEditVersion()
-			afterSetStateDto.ConstructWithValueAfterUpdate(dnsfu)
-			fmt.Printf("[DUMP] beforeSetStateDto %v\n", beforeSetStateDto)
-			fmt.Printf("[DUMP] afterSetStateDto %v\n", afterSetStateDto)
-
-			// update state
-			if err := hub.SetStateServiceImpl.UpdateSetStateByValue(beforeSetStateDto, afterSetStateDto); err != nil {
-				currentClient.Feedback(message, ws.ERROR_UPDATE_STATE_FAILED, err)
-				return err
+			if err := room.Hub.KVStateServiceImpl.UpdateKVStateByKey(kvStateDto); err != nil {
+				return feedbackStateError(room, message, kvStateDto.Key, err, ws.ERROR_UPDATE_STATE_FAILED)
 			}
+			message.SetRevision(kvStateDto.Key, kvStateDto.Revision)
 		}
 
 	case ws.TARGET_APPS:
@@ -159,7 +70,7 @@ func SignalUpdateState(hub *ws.Hub, message *ws.Message) error {
 	// the currentClient does not need feedback when operation success
 
 	// feedback otherClient
-	hub.BroadcastToOtherClients(message, currentClient)
+	room.BroadcastToOtherClients(message, currentClient)
 
 	return nil
 }